@@ -0,0 +1,17 @@
+//go:build sqscli
+
+package main
+
+import (
+	"os"
+
+	"github.com/PayU/fluentBit-sqs-plugin/pkg/sqsout"
+)
+
+// main runs the standalone mode, see sqsout.RunStandalone:
+//
+//	go build -tags sqscli -o sqs-out-cli .
+//	echo '{"log":"hello"}' | ./sqs-out-cli -p QueueUrl=https://... -p QueueRegion=us-east-1
+func main() {
+	os.Exit(sqsout.RunStandalone(os.Args[1:], os.Stdin))
+}
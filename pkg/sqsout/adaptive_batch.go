@@ -0,0 +1,81 @@
+package sqsout
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// defaultAdaptiveLatency is the send latency above which an adaptive batch
+// shrinks when AdaptiveLatency is not set
+const defaultAdaptiveLatency = time.Second
+
+// adaptiveBatch adjusts the number of messages per batch to the health of the
+// destination: the size is halved when a send fails, is throttled or is
+// slower than the latency threshold, and grows back by one message per
+// healthy send up to BatchSize
+type adaptiveBatch struct {
+	max         int64
+	latency     time.Duration
+	current     atomic.Int64
+	shrinkCount atomic.Int64
+}
+
+func newAdaptiveBatch(enabledString, latencyString string, batchSize int) (*adaptiveBatch, error) {
+	switch strings.ToLower(enabledString) {
+	case "", "false", "off":
+		if latencyString != "" {
+			return nil, errors.New("AdaptiveLatency requires AdaptiveBatchSize to be enabled")
+		}
+		return nil, nil
+	case "true", "on":
+	default:
+		return nil, fmt.Errorf("AdaptiveBatchSize should be true or false. got %q", enabledString)
+	}
+
+	latency := defaultAdaptiveLatency
+	if latencyString != "" {
+		var err error
+		latency, err = time.ParseDuration(latencyString)
+		if err != nil || latency <= 0 {
+			return nil, errors.New("AdaptiveLatency should be a positive duration, e.g. 500ms")
+		}
+	}
+
+	batch := &adaptiveBatch{max: int64(batchSize), latency: latency}
+	batch.current.Store(int64(batchSize))
+	return batch, nil
+}
+
+// size is the current number of messages per batch
+func (a *adaptiveBatch) size() int64 {
+	return a.current.Load()
+}
+
+// observe adjusts the batch size to the outcome of a send
+func (a *adaptiveBatch) observe(latency time.Duration, failed bool) {
+	for {
+		current := a.current.Load()
+
+		next := current + 1
+		if failed || latency > a.latency {
+			next = current / 2
+		}
+		if next < 1 {
+			next = 1
+		}
+		if next > a.max {
+			next = a.max
+		}
+
+		if next == current || a.current.CompareAndSwap(current, next) {
+			if next < current {
+				a.shrinkCount.Add(1)
+				writeDebugLog(fmt.Sprintf("batch size shrunk to %d (latency %v, failed %v)", next, latency, failed))
+			}
+			return
+		}
+	}
+}
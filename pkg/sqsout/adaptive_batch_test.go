@@ -0,0 +1,103 @@
+package sqsout
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewAdaptiveBatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		enabled     string
+		latency     string
+		wantNil     bool
+		wantLatency time.Duration
+		wantErr     bool
+	}{
+		{name: "disabled by default", wantNil: true},
+		{name: "disabled explicitly", enabled: "false", wantNil: true},
+		{name: "enabled with default latency", enabled: "true", wantLatency: time.Second},
+		{name: "enabled with latency", enabled: "On", latency: "250ms", wantLatency: 250 * time.Millisecond},
+		{name: "latency without adaptive batches", latency: "250ms", wantErr: true},
+		{name: "invalid flag", enabled: "sometimes", wantErr: true},
+		{name: "invalid latency", enabled: "true", latency: "fast", wantErr: true},
+		{name: "negative latency", enabled: "true", latency: "-1s", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batch, err := newAdaptiveBatch(tt.enabled, tt.latency, 10)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newAdaptiveBatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (batch == nil) != tt.wantNil {
+				t.Fatalf("newAdaptiveBatch() = %v, wantNil %v", batch, tt.wantNil)
+			}
+			if batch != nil && (batch.latency != tt.wantLatency || batch.size() != 10) {
+				t.Errorf("expected latency %v and size 10, got %v and %d", tt.wantLatency, batch.latency, batch.size())
+			}
+		})
+	}
+}
+
+func TestAdaptiveBatchObserve(t *testing.T) {
+	batch, _ := newAdaptiveBatch("true", "100ms", 10)
+
+	steps := []struct {
+		latency time.Duration
+		failed  bool
+		want    int64
+	}{
+		{10 * time.Millisecond, false, 10},
+		{10 * time.Millisecond, true, 5},
+		{200 * time.Millisecond, false, 2},
+		{10 * time.Millisecond, true, 1},
+		{10 * time.Millisecond, true, 1},
+		{10 * time.Millisecond, false, 2},
+		{10 * time.Millisecond, false, 3},
+	}
+
+	for i, step := range steps {
+		batch.observe(step.latency, step.failed)
+		if got := batch.size(); got != step.want {
+			t.Errorf("step %d: expected size %d, got %d", i, step.want, got)
+		}
+	}
+
+	if batch.shrinkCount.Load() != 3 {
+		t.Errorf("expected 3 shrinks, got %d", batch.shrinkCount.Load())
+	}
+}
+
+func TestAdaptiveBatchInFlush(t *testing.T) {
+	resetGlobals()
+	fake := &recordingSQS{err: errors.New("throttled")}
+	adaptive, _ := newAdaptiveBatch("true", "", 4)
+	sqsConf := &sqsConfig{
+		queueURL:      "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS:         fake,
+		batchSize:     4,
+		adaptiveBatch: adaptive,
+	}
+
+	timestamp := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	if err := flushRecords(sqsConf, "app.log", sliceIterator(timestamp, messageRecords(4)...)); err == nil {
+		t.Fatal("expected the send error")
+	}
+
+	fake.err = nil
+	if err := flushRecords(sqsConf, "app.log", sliceIterator(timestamp, messageRecords(2)...)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(fake.batches) != 2 || len(fake.batches[1].Entries) != 2 {
+		t.Fatalf("expected a second batch of 2 messages after the failure, got %d batches", len(fake.batches))
+	}
+	if adaptive.size() != 3 {
+		t.Errorf("expected the batch to grow back to 3, got %d", adaptive.size())
+	}
+}
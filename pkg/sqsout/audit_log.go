@@ -0,0 +1,101 @@
+package sqsout
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// auditEntry is a line of the audit log, one per batch sent to the main
+// queue
+type auditEntry struct {
+	Time       string         `json:"time"`
+	QueueURL   string         `json:"queue_url"`
+	BatchID    string         `json:"batch_id"`
+	Entries    int            `json:"entries"`
+	Bytes      int64          `json:"bytes"`
+	MessageIDs []string       `json:"message_ids"`
+	Failures   []auditFailure `json:"failures,omitempty"`
+	// Error is set when the request itself failed, every entry failed then
+	Error string `json:"error,omitempty"`
+}
+
+// auditFailure is an entry the queue did not accept
+type auditFailure struct {
+	ID   string `json:"id"`
+	Code string `json:"code"`
+}
+
+// auditLog appends a JSON line per batch to a local file, as a record of
+// the delivery of every batch for compliance. it is safe for concurrent use
+type auditLog struct {
+	mu     sync.Mutex
+	writer io.WriteCloser
+}
+
+// openAuditLog opens the audit file for appending, creating it when needed
+func openAuditLog(path string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open AuditLogFile: %v", err)
+	}
+	return &auditLog{writer: file}, nil
+}
+
+// record writes the audit line of a batch. output is nil when the request
+// failed with err
+func (a *auditLog) record(now time.Time, queueURL, batchID string, records []*types.SendMessageBatchRequestEntry, output *sqs.SendMessageBatchOutput, err error) {
+	entry := auditEntry{
+		Time:       now.UTC().Format(time.RFC3339Nano),
+		QueueURL:   queueURL,
+		BatchID:    batchID,
+		Entries:    len(records),
+		Bytes:      batchBytes(records),
+		MessageIDs: []string{},
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+		code := awsErrorCode(err)
+		if code == "" {
+			code = "RequestError"
+		}
+		for _, record := range records {
+			entry.Failures = append(entry.Failures, auditFailure{ID: aws.ToString(record.Id), Code: code})
+		}
+	} else {
+		for _, successful := range output.Successful {
+			entry.MessageIDs = append(entry.MessageIDs, aws.ToString(successful.MessageId))
+		}
+		for _, failed := range output.Failed {
+			entry.Failures = append(entry.Failures, auditFailure{ID: aws.ToString(failed.Id), Code: aws.ToString(failed.Code)})
+		}
+	}
+
+	line, marshalErr := marshalJSON(entry)
+	if marshalErr != nil {
+		writeErrorLog(fmt.Errorf("failed to create the audit log line of batch %s: %v", batchID, marshalErr))
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, writeErr := io.WriteString(a.writer, line+"\n"); writeErr != nil {
+		writeErrorLog(fmt.Errorf("failed to write the audit log line of batch %s: %v", batchID, writeErr))
+	}
+}
+
+// stop closes the audit file when fluent bit stops
+func (a *auditLog) stop() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.writer.Close(); err != nil {
+		writeErrorLog(fmt.Errorf("failed to close AuditLogFile: %v", err))
+	}
+}
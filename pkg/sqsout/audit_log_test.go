@@ -0,0 +1,93 @@
+package sqsout
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestOpenAuditLog(t *testing.T) {
+	if _, err := openAuditLog(filepath.Join(t.TempDir(), "missing", "audit.log")); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+}
+
+func TestSendBatchToSqsWritesAuditLog(t *testing.T) {
+	resetGlobals()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := openAuditLog(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sqsConf := &sqsConfig{
+		queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		auditLog: audit,
+	}
+	records := []*types.SendMessageBatchRequestEntry{
+		{Id: aws.String("msg-1"), MessageBody: aws.String("abc")},
+		{Id: aws.String("msg-2"), MessageBody: aws.String("de")},
+	}
+
+	sqsConf.mySQS = &fakeSQS{output: &sqs.SendMessageBatchOutput{
+		Successful: []types.SendMessageBatchResultEntry{{Id: aws.String("msg-1"), MessageId: aws.String("sqs-id-1")}},
+		Failed:     []types.BatchResultErrorEntry{{Id: aws.String("msg-2"), Code: aws.String("InternalError")}},
+	}}
+	captureStdout(func() { sendBatchToSqs(sqsConf, records) })
+
+	sqsConf.mySQS = &fakeSQS{err: &smithy.GenericAPIError{Code: "AccessDenied", Message: "not allowed"}}
+	captureStdout(func() { sendBatchToSqs(sqsConf, records) })
+
+	audit.stop()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected an audit line per batch, got %q", content)
+	}
+
+	want := []auditEntry{
+		{
+			QueueURL:   sqsConf.queueURL,
+			BatchID:    "1",
+			Entries:    2,
+			Bytes:      5,
+			MessageIDs: []string{"sqs-id-1"},
+			Failures:   []auditFailure{{ID: "msg-2", Code: "InternalError"}},
+		},
+		{
+			QueueURL:   sqsConf.queueURL,
+			BatchID:    "2",
+			Entries:    2,
+			Bytes:      5,
+			MessageIDs: []string{},
+			Failures:   []auditFailure{{ID: "msg-1", Code: "AccessDenied"}, {ID: "msg-2", Code: "AccessDenied"}},
+			Error:      "api error AccessDenied: not allowed",
+		},
+	}
+
+	for i, line := range lines {
+		var got auditEntry
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("audit line is not JSON: %q", line)
+		}
+		if got.Time == "" {
+			t.Errorf("expected a time in %q", line)
+		}
+		got.Time = ""
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("audit line %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+}
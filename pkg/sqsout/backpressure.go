@@ -0,0 +1,40 @@
+package sqsout
+
+import "fmt"
+
+// chunkAdmission is the decision taken on a chunk before it is decoded
+type chunkAdmission int
+
+const (
+	admitChunk chunkAdmission = iota
+	dropChunk
+	retryChunk
+)
+
+// checkAdmission applies backpressure before a chunk is decoded. when the
+// memory budget is exhausted or the sender workers have no room left for new
+// batches, the chunk is handed back to fluent bit with a retry, so its
+// scheduler paces the inputs instead of the flush callback blocking.
+// backpressure is checked per chunk only: once a chunk is admitted all of its
+// records are processed, otherwise a retry would send them twice
+func checkAdmission(sqsConf *sqsConfig, tag string) chunkAdmission {
+	if sqsConf.memBuf != nil && !sqsConf.memBuf.admit() {
+		if sqsConf.memBuf.dropOverflow {
+			writeWarnLog(fmt.Sprintf("MemBufLimit reached with %d bytes pending. dropping chunk of tag %s", sqsConf.memBuf.pending.Load(), tag))
+			sqsConf.stats.countDroppedChunk(dropOverflow)
+			return dropChunk
+		}
+		writeWarnLog(fmt.Sprintf("MemBufLimit reached with %d bytes pending. asking fluent bit to retry the chunk of tag %s", sqsConf.memBuf.pending.Load(), tag))
+		sqsConf.stats.chunksRetried.Add(1)
+		return retryChunk
+	}
+
+	if sqsConf.senders != nil && sqsConf.senders.saturated() {
+		sqsConf.senders.retriedChunks.Add(1)
+		writeWarnLog(fmt.Sprintf("all %d in flight batches are taken. asking fluent bit to retry the chunk of tag %s", cap(sqsConf.senders.inFlight), tag))
+		sqsConf.stats.chunksRetried.Add(1)
+		return retryChunk
+	}
+
+	return admitChunk
+}
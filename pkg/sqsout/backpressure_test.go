@@ -0,0 +1,64 @@
+package sqsout
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckAdmission(t *testing.T) {
+	queueURL := "https://sqs.us-east-1.amazonaws.com/123456789/test-queue"
+
+	t.Run("admits chunks without limits", func(t *testing.T) {
+		if got := checkAdmission(&sqsConfig{queueURL: queueURL}, "app.log"); got != admitChunk {
+			t.Errorf("expected admitChunk, got %v", got)
+		}
+	})
+
+	t.Run("retries chunks over the memory budget", func(t *testing.T) {
+		sqsConf := &sqsConfig{queueURL: queueURL, memBuf: &memBufLimit{limit: 10}}
+		sqsConf.memBuf.add(10)
+
+		var got chunkAdmission
+		captureStdout(func() { got = checkAdmission(sqsConf, "app.log") })
+		if got != retryChunk {
+			t.Errorf("expected retryChunk, got %v", got)
+		}
+	})
+
+	t.Run("drops chunks over the memory budget with the drop policy", func(t *testing.T) {
+		sqsConf := &sqsConfig{queueURL: queueURL, memBuf: &memBufLimit{limit: 10, dropOverflow: true}}
+		sqsConf.memBuf.add(10)
+
+		var got chunkAdmission
+		captureStdout(func() { got = checkAdmission(sqsConf, "app.log") })
+		if got != dropChunk {
+			t.Errorf("expected dropChunk, got %v", got)
+		}
+	})
+
+	t.Run("retries chunks while the sender workers are saturated", func(t *testing.T) {
+		resetGlobals()
+		fake := &concurrentFakeSQS{delay: 50 * time.Millisecond}
+		sqsConf := &sqsConfig{queueURL: queueURL, mySQS: fake}
+		sqsConf.senders = newSenderPool(sqsConf, 1, 1)
+		defer stopSenderPools()
+
+		if got := checkAdmission(sqsConf, "app.log"); got != admitChunk {
+			t.Fatalf("expected admitChunk before any batch, got %v", got)
+		}
+
+		sqsConf.senders.submit(testBatch(1))
+
+		var got chunkAdmission
+		output := captureStdout(func() { got = checkAdmission(sqsConf, "app.log") })
+		if got != retryChunk {
+			t.Errorf("expected retryChunk, got %v", got)
+		}
+		if sqsConf.senders.retriedChunks.Load() != 1 {
+			t.Errorf("expected 1 retried chunk, got %d", sqsConf.senders.retriedChunks.Load())
+		}
+		if output == "" {
+			t.Error("expected a warning")
+		}
+	})
+}
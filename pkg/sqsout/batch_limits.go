@@ -0,0 +1,45 @@
+package sqsout
+
+import (
+	"errors"
+	"strconv"
+)
+
+// defaultMaxBatchEntries is the number of entries of a SendMessageBatch call
+// on AWS
+const defaultMaxBatchEntries = 10
+
+// parseMaxBatchEntries parses MaxBatchEntries, the ceiling of BatchSize for
+// the SQS-compatible services accepting larger batches
+func parseMaxBatchEntries(value string) (int, error) {
+	if value == "" {
+		return defaultMaxBatchEntries, nil
+	}
+	maxEntries, err := strconv.Atoi(value)
+	if err != nil || maxEntries < 1 || maxEntries > 1000 {
+		return 0, errors.New("MaxBatchEntries should be integer value between 1 and 1000")
+	}
+	return maxEntries, nil
+}
+
+// parseMaxBatchPayloadBytes parses MaxBatchPayloadBytes, the size limit of
+// the message bodies of a batch
+func parseMaxBatchPayloadBytes(value string) (int64, error) {
+	if value == "" {
+		return maxMessageBytes, nil
+	}
+	maxBytes, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || maxBytes < 1024 {
+		return 0, errors.New("MaxBatchPayloadBytes should be an integer value of at least 1024")
+	}
+	return maxBytes, nil
+}
+
+// batchPayloadLimit is the size limit of the message bodies of a batch, a
+// batch going over it is sent before the record is added
+func (sqsConf *sqsConfig) batchPayloadLimit() int64 {
+	if sqsConf.maxBatchBytes > 0 {
+		return sqsConf.maxBatchBytes
+	}
+	return maxMessageBytes
+}
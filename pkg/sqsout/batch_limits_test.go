@@ -0,0 +1,84 @@
+package sqsout
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMaxBatchEntries(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"", 10, false},
+		{"100", 100, false},
+		{"0", 0, true},
+		{"1001", 0, true},
+		{"many", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseMaxBatchEntries(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseMaxBatchEntries(%q) = %d, %v, want %d, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseMaxBatchPayloadBytes(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{"", maxMessageBytes, false},
+		{"1048576", 1048576, false},
+		{"512", 0, true},
+		{"1MB", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseMaxBatchPayloadBytes(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseMaxBatchPayloadBytes(%q) = %d, %v, want %d, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestBatchPayloadLimit(t *testing.T) {
+	resetGlobals()
+	fake := &recordingSQS{}
+	sqsConf := &sqsConfig{
+		queueURL:      "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS:         fake,
+		batchSize:     10,
+		maxBatchBytes: 2048,
+	}
+
+	records := make([]map[interface{}]interface{}, 5)
+	for i := range records {
+		records[i] = map[interface{}]interface{}{"log": strings.Repeat("x", 800)}
+	}
+	records = append(records, map[interface{}]interface{}{"log": strings.Repeat("x", 4000)})
+
+	err := flushRecords(sqsConf, "app", sliceIterator(time.Now(), records...))
+	if err == nil {
+		err = flushPendingBatches(sqsConf)
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var sizes []int
+	for _, batch := range fake.batches {
+		sizes = append(sizes, len(batch.Entries))
+	}
+	if len(sizes) != 3 || sizes[0] != 2 || sizes[1] != 2 || sizes[2] != 1 {
+		t.Errorf("expected batches of 2, 2 and 1 messages within 2048 bytes, got %v", sizes)
+	}
+	if got := sqsConf.stats.recordsDropped.Load(); got != 1 {
+		t.Errorf("expected the record over the payload limit to be dropped, got %d dropped", got)
+	}
+}
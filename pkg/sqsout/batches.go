@@ -0,0 +1,56 @@
+package sqsout
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// tagBatch is the pending batch of a single tag. records of a tag carry over
+// between flushes until the batch is full
+type tagBatch struct {
+	mu            sync.Mutex
+	records       []*types.SendMessageBatchRequestEntry
+	messageNumber int64
+	// payload is the size of the message bodies of the records
+	payload int64
+	// nextGroup is the message group the next balanced batch starts with
+	nextGroup    int
+	sentBatches  atomic.Int64
+	sentMessages atomic.Int64
+}
+
+// batchSet holds an independent batch per tag, so concurrent flushes of
+// different tags don't contend on a single lock
+type batchSet struct {
+	batches sync.Map
+}
+
+// get returns the batch of the tag, creating it on first use
+func (s *batchSet) get(tag string) *tagBatch {
+	if batch, ok := s.batches.Load(tag); ok {
+		return batch.(*tagBatch)
+	}
+	batch, _ := s.batches.LoadOrStore(tag, &tagBatch{})
+	return batch.(*tagBatch)
+}
+
+// each calls f for every batch
+func (s *batchSet) each(f func(tag string, batch *tagBatch)) {
+	s.batches.Range(func(key, value interface{}) bool {
+		f(key.(string), value.(*tagBatch))
+		return true
+	})
+}
+
+// pending returns the number of records waiting in the batches
+func (s *batchSet) pending() int {
+	var pending int
+	s.each(func(_ string, batch *tagBatch) {
+		batch.mu.Lock()
+		pending += len(batch.records)
+		batch.mu.Unlock()
+	})
+	return pending
+}
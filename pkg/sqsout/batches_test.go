@@ -0,0 +1,95 @@
+package sqsout
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatchSet(t *testing.T) {
+	var set batchSet
+
+	first := set.get("app.log")
+	if set.get("app.log") != first {
+		t.Error("expected the same batch for the same tag")
+	}
+	if set.get("other.log") == first {
+		t.Error("expected independent batches for different tags")
+	}
+
+	tags := map[string]bool{}
+	set.each(func(tag string, _ *tagBatch) { tags[tag] = true })
+	if len(tags) != 2 || !tags["app.log"] || !tags["other.log"] {
+		t.Errorf("unexpected tags %v", tags)
+	}
+}
+
+func TestPerTagBatching(t *testing.T) {
+	timestamp := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+
+	t.Run("tags are batched independently", func(t *testing.T) {
+		resetGlobals()
+		fake := &recordingSQS{}
+		sqsConf := &sqsConfig{
+			queueURL:           "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+			mySQS:              fake,
+			batchSize:          2,
+			pluginTagAttribute: "tag",
+		}
+
+		for _, tag := range []string{"a.log", "b.log", "a.log"} {
+			if err := flushRecords(sqsConf, tag, sliceIterator(timestamp, messageRecords(1)...)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+
+		if len(fake.batches) != 1 {
+			t.Fatalf("expected 1 batch, got %d", len(fake.batches))
+		}
+		for _, entry := range fake.batches[0].Entries {
+			if *entry.MessageAttributes["tag"].StringValue != "a.log" {
+				t.Errorf("expected only a.log messages in the batch, got %s", *entry.MessageAttributes["tag"].StringValue)
+			}
+		}
+
+		a, b := sqsConf.batches.get("a.log"), sqsConf.batches.get("b.log")
+		if a.sentBatches.Load() != 1 || a.sentMessages.Load() != 2 || len(a.records) != 0 {
+			t.Errorf("unexpected a.log batch: sent=%d messages=%d pending=%d", a.sentBatches.Load(), a.sentMessages.Load(), len(a.records))
+		}
+		if b.sentBatches.Load() != 0 || len(b.records) != 1 {
+			t.Errorf("unexpected b.log batch: sent=%d pending=%d", b.sentBatches.Load(), len(b.records))
+		}
+	})
+
+	t.Run("concurrent flushes of different tags", func(t *testing.T) {
+		resetGlobals()
+		fake := &recordingSQS{}
+		sqsConf := &sqsConfig{
+			queueURL:  "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+			mySQS:     fake,
+			batchSize: 10,
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(tag string) {
+				defer wg.Done()
+				if err := flushRecords(sqsConf, tag, sliceIterator(timestamp, messageRecords(25)...)); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}(fmt.Sprintf("tag-%d.log", i))
+		}
+		wg.Wait()
+
+		if len(fake.batches) != 16 {
+			t.Errorf("expected 2 batches per tag, got %d batches", len(fake.batches))
+		}
+		sqsConf.batches.each(func(tag string, batch *tagBatch) {
+			if len(batch.records) != 5 {
+				t.Errorf("expected 5 pending records for %s, got %d", tag, len(batch.records))
+			}
+		})
+	})
+}
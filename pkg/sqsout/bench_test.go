@@ -0,0 +1,181 @@
+package sqsout
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/ugorji/go/codec"
+)
+
+// discardSQS implements sqsClient interface and drops every batch
+type discardSQS struct{}
+
+func (discardSQS) SendMessageBatch(ctx context.Context, input *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	return &sqs.SendMessageBatchOutput{}, nil
+}
+
+const benchRecordsPerChunk = 100
+
+// benchRecordShapes are representative records, as strings so they can be
+// encoded into a chunk the way fluent bit does
+var benchRecordShapes = []struct {
+	name   string
+	record map[string]interface{}
+}{
+	{
+		name: "flat",
+		record: map[string]interface{}{
+			"log":    "2024-01-15T10:30:00.000Z INFO request handled method=GET path=/api/orders status=200 duration=12ms",
+			"stream": "stdout",
+			"level":  "info",
+			"host":   "ip-10-0-1-23",
+			"pid":    4242,
+		},
+	},
+	{
+		name: "kubernetes",
+		record: map[string]interface{}{
+			"log":    "2024-01-15T10:30:00.000Z INFO request handled method=GET path=/api/orders status=200 duration=12ms",
+			"stream": "stdout",
+			"time":   "2024-01-15T10:30:00.000000000Z",
+			"kubernetes": map[string]interface{}{
+				"pod_name":       "orders-api-7d9f8b6c5d-x2k4p",
+				"namespace_name": "orders",
+				"pod_id":         "6f1c2a3b-4d5e-6f70-8192-a3b4c5d6e7f8",
+				"host":           "ip-10-0-1-23.eu-central-1.compute.internal",
+				"container_name": "orders-api",
+				"docker_id":      "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+				"container_hash": "registry.example.com/orders-api@sha256:0123456789abcdef",
+				"labels": map[string]interface{}{
+					"app":               "orders-api",
+					"pod-template-hash": "7d9f8b6c5d",
+					"team":              "checkout",
+				},
+				"annotations": map[string]interface{}{
+					"prometheus.io/scrape": "true",
+					"prometheus.io/port":   "9090",
+				},
+			},
+		},
+	},
+	{
+		name: "large",
+		record: map[string]interface{}{
+			"log":    strings.Repeat("java.lang.IllegalStateException: order was already paid\n\tat com.example.Orders.pay(Orders.java:42)\n", 160),
+			"stream": "stderr",
+			"level":  "error",
+		},
+	},
+}
+
+// benchChunk encodes the record as a chunk of fluent bit entries
+func benchChunk(b *testing.B, record map[string]interface{}) []byte {
+	handle := new(codec.MsgpackHandle)
+	handle.WriteExt = true
+
+	entries := make([]interface{}, benchRecordsPerChunk)
+	for i := range entries {
+		entries[i] = []interface{}{uint64(1705314600), record}
+	}
+
+	var chunk []byte
+	enc := codec.NewEncoderBytes(&chunk, handle)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			b.Fatalf("unexpected error encoding chunk: %v", err)
+		}
+	}
+	return chunk
+}
+
+// benchRecords decodes a chunk the way the flush callback does
+func benchRecords(b *testing.B, chunk []byte) []map[interface{}]interface{} {
+	next := newChunkIterator(chunk)
+
+	var records []map[interface{}]interface{}
+	for {
+		_, record, ok := next()
+		if !ok {
+			return records
+		}
+		records = append(records, record)
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	for _, shape := range benchRecordShapes {
+		b.Run(shape.name, func(b *testing.B) {
+			chunk := benchChunk(b, shape.record)
+			b.SetBytes(int64(len(chunk)))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				// the flush callback copies the chunk out of fluent bit memory first
+				next := newChunkIterator(append([]byte(nil), chunk...))
+				for _, _, ok := next(); ok; _, _, ok = next() {
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkEncodeRecord(b *testing.B) {
+	timestamp := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+
+	for _, shape := range benchRecordShapes {
+		record := benchRecords(b, benchChunk(b, shape.record))[0]
+
+		for _, encoderName := range []string{"fast", "standard"} {
+			encoder, err := newRecordEncoder(encoderName, true)
+			if err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+
+			b.Run(shape.name+"/"+encoderName, func(b *testing.B) {
+				b.ReportAllocs()
+
+				for i := 0; i < b.N; i++ {
+					if _, err := encodeRecord(encoder, timestamp, "app.log", record); err != nil {
+						b.Fatalf("unexpected error: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkFlush covers the whole hot path of a flush: decoding the chunk,
+// serializing the records and assembling the batches
+func BenchmarkFlush(b *testing.B) {
+	defer resetGlobals()
+
+	for _, shape := range benchRecordShapes {
+		b.Run(shape.name, func(b *testing.B) {
+			resetGlobals()
+			sqsOutLogLevel = 2
+			sqsConf := &sqsConfig{
+				queueURL:           "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+				mySQS:              discardSQS{},
+				batchSize:          10,
+				pluginTagAttribute: "tag",
+			}
+
+			chunk := benchChunk(b, shape.record)
+			b.SetBytes(int64(len(chunk)))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				// the flush callback copies the chunk out of fluent bit memory first
+				next := newChunkIterator(append([]byte(nil), chunk...))
+				if err := flushRecords(sqsConf, "app.log", next); err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package sqsout
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// compatibilityMode relaxes the checks of the plugin known to fail on the
+// SQS emulators, so local development runs with the production configuration
+type compatibilityMode string
+
+const (
+	compatibilityAWS        compatibilityMode = "aws"
+	compatibilityElasticMQ  compatibilityMode = "elasticmq"
+	compatibilityLocalStack compatibilityMode = "localstack"
+)
+
+// emulatorRegion is the QueueRegion of the emulators when it isn't set, they
+// accept any region
+const emulatorRegion = "us-east-1"
+
+func parseCompatibilityMode(value string) (compatibilityMode, error) {
+	switch mode := compatibilityMode(strings.ToLower(value)); mode {
+	case "":
+		return compatibilityAWS, nil
+	case compatibilityAWS, compatibilityElasticMQ, compatibilityLocalStack:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("CompatibilityMode should be one of: aws, elasticmq, localstack. got %q", value)
+	}
+}
+
+// emulator returns true for the modes of the SQS emulators
+func (m compatibilityMode) emulator() bool {
+	return m == compatibilityElasticMQ || m == compatibilityLocalStack
+}
+
+// sqsOptions are the options of the SQS clients. the emulators don't always
+// return the MD5 digests of the messages the sdk verifies
+func (m compatibilityMode) sqsOptions() []func(*sqs.Options) {
+	if !m.emulator() {
+		return nil
+	}
+	return []func(*sqs.Options){func(o *sqs.Options) {
+		o.DisableMessageChecksumValidation = true
+	}}
+}
+
+// supportsSystemAttributes returns false when the queues reject the
+// MessageSystemAttributes of the messages, ElasticMQ has none of them
+func (m compatibilityMode) supportsSystemAttributes() bool {
+	return m != compatibilityElasticMQ
+}
+
+// validateQueueURL checks the url of an SQS queue set with key, the
+// emulators serve their queues at any url
+func (m compatibilityMode) validateQueueURL(key, queueURL string) error {
+	if m.emulator() {
+		return nil
+	}
+	u, err := url.Parse(queueURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Count(strings.Trim(u.Path, "/"), "/") != 1 {
+		return fmt.Errorf("%s %q is not a queue url, expected https://<endpoint>/<account>/<queue>", key, queueURL)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package sqsout
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+func TestParseCompatibilityMode(t *testing.T) {
+	tests := []struct {
+		value        string
+		want         compatibilityMode
+		wantEmulator bool
+		wantErr      bool
+	}{
+		{"", compatibilityAWS, false, false},
+		{"aws", compatibilityAWS, false, false},
+		{"ElasticMQ", compatibilityElasticMQ, true, false},
+		{"localstack", compatibilityLocalStack, true, false},
+		{"moto", "", false, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseCompatibilityMode(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want || got.emulator() != tt.wantEmulator {
+			t.Errorf("parseCompatibilityMode(%q) = %q, %v, want %q, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCompatibilityModeChecks(t *testing.T) {
+	tests := []struct {
+		mode            compatibilityMode
+		queueURL        string
+		wantURLErr      bool
+		wantNoChecksums bool
+		wantSystemAttrs bool
+	}{
+		{compatibilityAWS, "https://sqs.us-east-1.amazonaws.com/123456789/test-queue", false, false, true},
+		{compatibilityAWS, "http://localhost:9324/queue/test-queue", false, false, true},
+		{compatibilityAWS, "test-queue", true, false, true},
+		{compatibilityAWS, "https://sqs.us-east-1.amazonaws.com/test-queue", true, false, true},
+		{compatibilityElasticMQ, "http://localhost:9324/test-queue", false, true, false},
+		{compatibilityLocalStack, "test-queue", false, true, true},
+	}
+
+	for _, tt := range tests {
+		if err := tt.mode.validateQueueURL("QueueUrl", tt.queueURL); (err != nil) != tt.wantURLErr {
+			t.Errorf("%s: validateQueueURL(%q) error = %v, wantErr %v", tt.mode, tt.queueURL, err, tt.wantURLErr)
+		}
+
+		var options sqs.Options
+		for _, option := range tt.mode.sqsOptions() {
+			option(&options)
+		}
+		if options.DisableMessageChecksumValidation != tt.wantNoChecksums {
+			t.Errorf("%s: DisableMessageChecksumValidation = %v, want %v", tt.mode, options.DisableMessageChecksumValidation, tt.wantNoChecksums)
+		}
+		if got := tt.mode.supportsSystemAttributes(); got != tt.wantSystemAttrs {
+			t.Errorf("%s: supportsSystemAttributes() = %v, want %v", tt.mode, got, tt.wantSystemAttrs)
+		}
+	}
+}
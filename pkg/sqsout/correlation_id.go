@@ -0,0 +1,68 @@
+package sqsout
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultCorrelationIDKey is the field and message attribute of the
+// correlation id when CorrelationIdKey is not set
+const defaultCorrelationIDKey = "correlation_id"
+
+// attributeNamePattern matches the names SQS accepts for message attributes
+var attributeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,256}$`)
+
+// parseCorrelationIDKey returns the field and message attribute of the
+// correlation id, empty when AddCorrelationId is not enabled
+func parseCorrelationIDKey(addCorrelationIDString, key string) (string, error) {
+	addCorrelationID, err := parseBool("AddCorrelationId", addCorrelationIDString)
+	if err != nil {
+		return "", err
+	}
+	if !addCorrelationID {
+		if key != "" {
+			return "", errors.New("CorrelationIdKey requires AddCorrelationId to be enabled")
+		}
+		return "", nil
+	}
+
+	if key == "" {
+		key = defaultCorrelationIDKey
+	}
+	if !attributeNamePattern.MatchString(key) || strings.HasPrefix(strings.ToLower(key), "aws.") || strings.HasPrefix(strings.ToLower(key), "amazon.") {
+		return "", fmt.Errorf("CorrelationIdKey %q is not a valid message attribute name", key)
+	}
+	return key, nil
+}
+
+// correlationIDEnricher gives every record a correlation id in the key
+// field. a record which already has one, e.g. from an upstream system, keeps
+// it so the event can be traced across systems
+func correlationIDEnricher(key string) Processor {
+	return ProcessorFunc(func(r *Record) bool {
+		if value, ok := r.Fields[key]; !ok || value == nil {
+			r.Fields[key] = newUUIDv7(time.Now())
+		}
+		return true
+	})
+}
+
+// newUUIDv7 returns a time ordered (version 7) UUID, the ids of the records
+// sort by the time they were sent
+func newUUIDv7(now time.Time) string {
+	var u [16]byte
+	// the entropy source of the system is not expected to fail, the ids
+	// still differ by their timestamp
+	_, _ = rand.Read(u[6:])
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(now.UnixMilli()))
+	copy(u[:6], ms[2:])
+	u[6] = u[6]&0x0f | 0x70
+	u[8] = u[8]&0x3f | 0x80
+	return formatUUID(u)
+}
@@ -0,0 +1,98 @@
+package sqsout
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestParseCorrelationIDKey(t *testing.T) {
+	tests := []struct {
+		add     string
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{"", "", "", false},
+		{"false", "trace_id", "", true},
+		{"true", "", "correlation_id", false},
+		{"true", "trace.id", "trace.id", false},
+		{"true", "trace id", "", true},
+		{"true", "AWS.TraceId", "", true},
+		{"maybe", "", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseCorrelationIDKey(tt.add, tt.key)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseCorrelationIDKey(%q, %q) = %q, %v, want %q, wantErr %v", tt.add, tt.key, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNewUUIDv7(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	now := time.UnixMilli(1705314600000)
+
+	first, second := newUUIDv7(now), newUUIDv7(now.Add(time.Millisecond))
+	if !uuid.MatchString(first) {
+		t.Errorf("expected a version 7 UUID, got %s", first)
+	}
+	if first[:13] != "018d0cab-c440" {
+		t.Errorf("expected the UUID to start with the timestamp, got %s", first)
+	}
+	if first >= second {
+		t.Errorf("expected the UUIDs to sort by time, got %s then %s", first, second)
+	}
+}
+
+func TestCorrelationIDEnricher(t *testing.T) {
+	enricher := correlationIDEnricher("correlation_id")
+
+	record := map[interface{}]interface{}{"log": "hello"}
+	enricher.Process(&Record{Fields: record})
+	if id, ok := record["correlation_id"].(string); !ok || len(id) != 36 {
+		t.Errorf("expected a correlation id, got %v", record["correlation_id"])
+	}
+
+	record = map[interface{}]interface{}{"correlation_id": []byte("upstream-id")}
+	enricher.Process(&Record{Fields: record})
+	if id, ok := record["correlation_id"].([]byte); !ok || string(id) != "upstream-id" {
+		t.Errorf("expected the upstream correlation id to be kept, got %v", record["correlation_id"])
+	}
+}
+
+func TestCorrelationIDAttribute(t *testing.T) {
+	resetGlobals()
+	fake := &recordingSQS{}
+	sqsConf := &sqsConfig{
+		queueURL:         "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS:            fake,
+		batchSize:        2,
+		correlationIDKey: "correlation_id",
+		processors:       processorChain(nil).add(correlationIDEnricher("correlation_id")),
+	}
+
+	if err := flushRecords(sqsConf, "app.log", sliceIterator(time.Now(), messageRecords(2)...)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ids := map[string]bool{}
+	for _, entry := range fake.batches[0].Entries {
+		var body map[string]interface{}
+		if err := json.Unmarshal([]byte(aws.ToString(entry.MessageBody)), &body); err != nil {
+			t.Fatalf("invalid body: %v", err)
+		}
+		attribute := aws.ToString(entry.MessageAttributes["correlation_id"].StringValue)
+		if attribute == "" || body["correlation_id"] != attribute {
+			t.Errorf("expected the correlation id in the body and the attribute, got %v and %q", body["correlation_id"], attribute)
+		}
+		ids[attribute] = true
+	}
+	if len(ids) != 2 {
+		t.Errorf("expected a correlation id per record, got %v", ids)
+	}
+}
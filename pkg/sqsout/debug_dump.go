@@ -0,0 +1,121 @@
+package sqsout
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// defaultDebugDumpMaxFiles is how many dump files are kept when
+// DebugDumpMaxFiles is not set
+const defaultDebugDumpMaxFiles = 100
+
+// debugDumpPrefix and debugDumpSuffix frame the names of the dump files, the
+// files of previous runs are found by them
+const (
+	debugDumpPrefix = "batch-"
+	debugDumpSuffix = ".ndjson"
+)
+
+// debugDump writes the message bodies of the outgoing batches to files, one
+// body per line and one file per batch, so the messages the consumers get
+// can be inspected. only the newest maxFiles files are kept
+type debugDump struct {
+	dir      string
+	percent  float64
+	maxFiles int
+	mu       sync.Mutex
+	// files are the dump files in the directory, oldest first
+	files []string
+}
+
+// newDebugDump returns nil when DebugDumpDir is not set
+func newDebugDump(dir, percentString, maxFilesString string) (*debugDump, error) {
+	if dir == "" {
+		if percentString != "" || maxFilesString != "" {
+			return nil, errors.New("DebugDumpPercent and DebugDumpMaxFiles require DebugDumpDir to be set")
+		}
+		return nil, nil
+	}
+
+	percent := 100.0
+	if percentString != "" {
+		var err error
+		percent, err = strconv.ParseFloat(percentString, 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return nil, errors.New("DebugDumpPercent should be a number between 0 and 100")
+		}
+	}
+
+	maxFiles := defaultDebugDumpMaxFiles
+	if maxFilesString != "" {
+		var err error
+		maxFiles, err = strconv.Atoi(maxFilesString)
+		if err != nil || maxFiles < 1 {
+			return nil, errors.New("DebugDumpMaxFiles should be a positive integer")
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create DebugDumpDir: %v", err)
+	}
+
+	// the files of previous runs count towards the limit. the names start
+	// with the time, so they sort oldest first
+	files, err := filepath.Glob(filepath.Join(dir, debugDumpPrefix+"*"+debugDumpSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list DebugDumpDir: %v", err)
+	}
+	sort.Strings(files)
+
+	return &debugDump{dir: dir, percent: percent, maxFiles: maxFiles, files: files}, nil
+}
+
+// sampled returns true when the batch should be dumped
+func (d *debugDump) sampled() bool {
+	if d.percent >= 100 {
+		return true
+	}
+	return randFloat64()*100 < d.percent
+}
+
+// write dumps the bodies of a batch. errors are logged only, the dump must
+// never fail a send
+func (d *debugDump) write(now time.Time, queueURL, batchID string, records []*types.SendMessageBatchRequestEntry) {
+	if !d.sampled() {
+		return
+	}
+
+	var b strings.Builder
+	for _, entry := range records {
+		b.WriteString(aws.ToString(entry.MessageBody))
+		b.WriteByte('\n')
+	}
+
+	name := fmt.Sprintf("%s%s-%s-%s%s", debugDumpPrefix, now.UTC().Format("20060102T150405.000000000Z"), queueName(queueURL), batchID, debugDumpSuffix)
+	path := filepath.Join(d.dir, name)
+	if err := os.WriteFile(path, []byte(b.String()), 0600); err != nil {
+		writeErrorLog(fmt.Errorf("failed to write the debug dump of batch %s: %v", batchID, err))
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.files = append(d.files, path)
+	for len(d.files) > d.maxFiles {
+		if err := os.Remove(d.files[0]); err != nil && !os.IsNotExist(err) {
+			writeErrorLog(fmt.Errorf("failed to remove the debug dump %s: %v", d.files[0], err))
+		}
+		d.files = d.files[1:]
+	}
+}
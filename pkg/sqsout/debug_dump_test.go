@@ -0,0 +1,127 @@
+package sqsout
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestNewDebugDump(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		dir          string
+		percent      string
+		maxFiles     string
+		wantNil      bool
+		wantPercent  float64
+		wantMaxFiles int
+		wantErr      bool
+	}{
+		{dir: "", wantNil: true},
+		{dir: dir, wantPercent: 100, wantMaxFiles: defaultDebugDumpMaxFiles},
+		{dir: dir, percent: "10", maxFiles: "5", wantPercent: 10, wantMaxFiles: 5},
+		{dir: "", percent: "10", wantErr: true},
+		{dir: "", maxFiles: "5", wantErr: true},
+		{dir: dir, percent: "101", wantErr: true},
+		{dir: dir, maxFiles: "0", wantErr: true},
+		{dir: dir, maxFiles: "many", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := newDebugDump(tt.dir, tt.percent, tt.maxFiles)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newDebugDump(%q, %q, %q) error = %v, wantErr %v", tt.dir, tt.percent, tt.maxFiles, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if (got == nil) != tt.wantNil {
+			t.Errorf("newDebugDump(%q, %q, %q) = %v, wantNil %v", tt.dir, tt.percent, tt.maxFiles, got, tt.wantNil)
+			continue
+		}
+		if got != nil && (got.percent != tt.wantPercent || got.maxFiles != tt.wantMaxFiles) {
+			t.Errorf("newDebugDump(%q, %q, %q) = percent %v maxFiles %d, want %v %d", tt.dir, tt.percent, tt.maxFiles, got.percent, got.maxFiles, tt.wantPercent, tt.wantMaxFiles)
+		}
+	}
+}
+
+func TestSendBatchToSqsWritesDebugDump(t *testing.T) {
+	resetGlobals()
+	dir := filepath.Join(t.TempDir(), "dumps")
+
+	// a file of a previous run counts towards the limit
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	previous := filepath.Join(dir, debugDumpPrefix+"20000101T000000.000000000Z-test-queue-1"+debugDumpSuffix)
+	if err := os.WriteFile(previous, []byte("{}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	dump, err := newDebugDump(dir, "", "2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sqsConf := &sqsConfig{
+		queueURL:  "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS:     &fakeSQS{output: &sqs.SendMessageBatchOutput{}},
+		debugDump: dump,
+	}
+
+	for i := 0; i < 2; i++ {
+		records := []*types.SendMessageBatchRequestEntry{
+			{Id: aws.String("msg-1"), MessageBody: aws.String(`{"log":"first"}`)},
+			{Id: aws.String("msg-2"), MessageBody: aws.String(`{"log":"second"}`)},
+		}
+		if err := sendBatchToSqs(sqsConf, records); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 2 {
+		t.Fatalf("expected the 2 newest dumps to be kept, got %v", files)
+	}
+	if _, err := os.Stat(previous); !os.IsNotExist(err) {
+		t.Errorf("expected the oldest dump to be removed")
+	}
+	if !strings.HasSuffix(files[1], "-test-queue-2"+debugDumpSuffix) {
+		t.Errorf("unexpected dump file name: %s", files[1])
+	}
+
+	content, err := os.ReadFile(files[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(content), "{\"log\":\"first\"}\n{\"log\":\"second\"}\n"; got != want {
+		t.Errorf("dump = %q, want %q", got, want)
+	}
+}
+
+func TestDebugDumpSampling(t *testing.T) {
+	defer func(f func() float64) { randFloat64 = f }(randFloat64)
+
+	dump, err := newDebugDump(t.TempDir(), "25", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, tt := range []struct {
+		random float64
+		want   bool
+	}{{0.1, true}, {0.3, false}} {
+		randFloat64 = func() float64 { return tt.random }
+		if got := dump.sampled(); got != tt.want {
+			t.Errorf("sampled() with random %v = %v, want %v", tt.random, got, tt.want)
+		}
+	}
+}
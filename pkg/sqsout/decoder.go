@@ -0,0 +1,289 @@
+package sqsout
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// chunkDecoder decodes the msgpack entries of a fluent bit chunk. unlike the
+// generic decoder of fluent-bit-go, which copies every string and binary field
+// out of the chunk and goes through reflection, the byte slices of the decoded
+// records point into the chunk. they are only converted when a record is
+// serialized, so the chunk must not be modified while its records are in use
+type chunkDecoder struct {
+	data []byte
+	pos  int
+}
+
+var errTruncatedChunk = errors.New("msgpack: truncated chunk")
+
+// newChunkIterator iterates the records of a chunk, converting their
+// timestamps. iteration stops at the first malformed entry
+func newChunkIterator(chunk []byte) RecordIterator {
+	dec := &chunkDecoder{data: chunk}
+
+	return func() (time.Time, map[interface{}]interface{}, bool) {
+		if dec.pos >= len(dec.data) {
+			return time.Time{}, nil, false
+		}
+
+		ts, record, err := dec.nextEntry()
+		if err != nil {
+			writeErrorLog(fmt.Errorf("unable to decode record at offset %d of the chunk: %v", dec.pos, err))
+			return time.Time{}, nil, false
+		}
+
+		var timeStamp time.Time
+		switch t := ts.(type) {
+		case time.Time:
+			timeStamp = t
+		case uint64:
+			timeStamp = time.Unix(int64(t), 0)
+		default:
+			writeInfoLog("given time is not in a known format, defaulting to now")
+			timeStamp = time.Now()
+		}
+
+		return timeStamp, record, true
+	}
+}
+
+// nextEntry decodes a [timestamp, record] entry. entries in the fluent bit v2
+// format, [[timestamp, metadata], record], are supported too
+func (d *chunkDecoder) nextEntry() (interface{}, map[interface{}]interface{}, error) {
+	entry, err := d.decode()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fields, ok := entry.([]interface{})
+	if !ok || len(fields) != 2 {
+		return nil, nil, errors.New("entry is not a [timestamp, record] array")
+	}
+
+	ts := fields[0]
+	if header, ok := ts.([]interface{}); ok {
+		if len(header) < 2 {
+			return nil, nil, errors.New("entry header is not a [timestamp, metadata] array")
+		}
+		ts = header[0]
+	}
+
+	record, ok := fields[1].(map[interface{}]interface{})
+	if !ok {
+		return nil, nil, errors.New("entry record is not a map")
+	}
+
+	return ts, record, nil
+}
+
+// decode decodes the next value with the same types the fluent-bit-go
+// decoder produces: strings and binaries as byte slices (map keys as
+// strings), fixed and signed integers as int64, unsigned ones as uint64 and
+// floats as float64
+func (d *chunkDecoder) decode() (interface{}, error) {
+	if d.pos >= len(d.data) {
+		return nil, errTruncatedChunk
+	}
+
+	b := d.data[d.pos]
+	d.pos++
+
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xf0 == 0x80:
+		return d.decodeMap(int(b & 0x0f))
+	case b&0xf0 == 0x90:
+		return d.decodeArray(int(b & 0x0f))
+	case b&0xe0 == 0xa0:
+		return d.read(int(b & 0x1f))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xd9:
+		n, err := d.readUint(1)
+		if err != nil {
+			return nil, err
+		}
+		return d.read(int(n))
+	case 0xc5, 0xda:
+		n, err := d.readUint(2)
+		if err != nil {
+			return nil, err
+		}
+		return d.read(int(n))
+	case 0xc6, 0xdb:
+		n, err := d.readUint(4)
+		if err != nil {
+			return nil, err
+		}
+		return d.read(int(n))
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.readUint(1 << (b - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeExt(int(n))
+	case 0xca:
+		n, err := d.readUint(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(uint32(n))), nil
+	case 0xcb:
+		n, err := d.readUint(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(n), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return d.readUint(1 << (b - 0xcc))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		n, err := d.readUint(size)
+		if err != nil {
+			return nil, err
+		}
+		// sign extend
+		shift := uint(64 - 8*size)
+		return int64(n<<shift) >> shift, nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.decodeExt(1 << (b - 0xd4))
+	case 0xdc:
+		n, err := d.readUint(2)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(int(n))
+	case 0xdd:
+		n, err := d.readUint(4)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(int(n))
+	case 0xde:
+		n, err := d.readUint(2)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(int(n))
+	case 0xdf:
+		n, err := d.readUint(4)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(int(n))
+	}
+
+	return nil, fmt.Errorf("msgpack: unknown type 0x%x", b)
+}
+
+func (d *chunkDecoder) decodeMap(n int) (interface{}, error) {
+	if n > len(d.data)-d.pos {
+		return nil, errTruncatedChunk
+	}
+
+	m := make(map[interface{}]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		switch k := key.(type) {
+		case []byte:
+			key = string(k)
+		case []interface{}, map[interface{}]interface{}:
+			// msgpack allows them, but they can't be the keys of a Go map
+			return nil, errors.New("msgpack: map key is an array or a map")
+		}
+
+		value, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+
+		m[key] = value
+	}
+
+	return m, nil
+}
+
+func (d *chunkDecoder) decodeArray(n int) (interface{}, error) {
+	if n > len(d.data)-d.pos {
+		return nil, errTruncatedChunk
+	}
+
+	items := make([]interface{}, n)
+	for i := range items {
+		item, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		items[i] = item
+	}
+
+	return items, nil
+}
+
+// decodeExt decodes an extension value. the event time extension of fluent
+// bit is converted to time.Time, others are returned as raw bytes
+func (d *chunkDecoder) decodeExt(size int) (interface{}, error) {
+	extType, err := d.readUint(1)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := d.read(size)
+	if err != nil {
+		return nil, err
+	}
+
+	if extType == 0 && size == 8 {
+		sec := binary.BigEndian.Uint32(data)
+		nsec := binary.BigEndian.Uint32(data[4:])
+		return time.Unix(int64(sec), int64(nsec)), nil
+	}
+
+	return data, nil
+}
+
+// read returns the next n bytes of the chunk without copying them
+func (d *chunkDecoder) read(n int) ([]byte, error) {
+	if n > len(d.data)-d.pos {
+		return nil, errTruncatedChunk
+	}
+
+	b := d.data[d.pos : d.pos+n : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *chunkDecoder) readUint(size int) (uint64, error) {
+	b, err := d.read(size)
+	if err != nil {
+		return 0, err
+	}
+
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	default:
+		return binary.BigEndian.Uint64(b), nil
+	}
+}
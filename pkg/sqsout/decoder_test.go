@@ -0,0 +1,170 @@
+package sqsout
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/fluent/fluent-bit-go/output"
+	"github.com/ugorji/go/codec"
+)
+
+// encodeMsgpack encodes the values one after the other the way fluent bit
+// writes the entries of a chunk
+func encodeMsgpack(t testing.TB, values ...interface{}) []byte {
+	handle := new(codec.MsgpackHandle)
+	handle.WriteExt = true
+
+	var out []byte
+	enc := codec.NewEncoderBytes(&out, handle)
+	for _, value := range values {
+		if err := enc.Encode(value); err != nil {
+			t.Fatalf("unexpected error encoding msgpack: %v", err)
+		}
+	}
+	return out
+}
+
+// eventTime encodes a fluent bit event time extension
+func eventTime(ts time.Time) []byte {
+	b := []byte{0xd7, 0x00, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[2:], uint32(ts.Unix()))
+	binary.BigEndian.PutUint32(b[6:], uint32(ts.Nanosecond()))
+	return b
+}
+
+func TestChunkDecoderMatchesFluentBitDecoder(t *testing.T) {
+	record := map[string]interface{}{
+		"log":      "hello world",
+		"empty":    "",
+		"long":     strings.Repeat("x", 70000),
+		"binary":   []byte{0, 1, 2, 255},
+		"small":    7,
+		"negative": -3,
+		"int8":     -100,
+		"int16":    -30000,
+		"int32":    -2000000000,
+		"int64":    int64(math.MinInt64),
+		"uint8":    200,
+		"uint16":   60000,
+		"uint32":   uint32(4000000000),
+		"uint64":   uint64(math.MaxUint64),
+		"float32":  float32(1.5),
+		"float64":  3.25,
+		"true":     true,
+		"false":    false,
+		"null":     nil,
+		"list":     []interface{}{"a", 1, []interface{}{"nested"}},
+		"kubernetes": map[string]interface{}{
+			"pod_name": "web-1",
+			"labels":   map[string]interface{}{"app": "web"},
+		},
+	}
+	chunk := encodeMsgpack(t, []interface{}{uint64(1705314600), record}, []interface{}{uint64(1705314601), map[string]interface{}{"n": 2}})
+
+	want := []map[interface{}]interface{}{}
+	dec := output.NewDecoder(unsafe.Pointer(&chunk[0]), len(chunk))
+	for {
+		ret, _, r := output.GetRecord(dec)
+		if ret != 0 {
+			break
+		}
+		want = append(want, r)
+	}
+
+	var got []map[interface{}]interface{}
+	next := newChunkIterator(chunk)
+	for {
+		ts, r, ok := next()
+		if !ok {
+			break
+		}
+		if ts.Unix() != int64(1705314600+len(got)) {
+			t.Errorf("unexpected timestamp %v for record %d", ts, len(got))
+		}
+		got = append(got, r)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded records differ from the fluent-bit-go decoder:\ngot  %v\nwant %v", got, want)
+	}
+}
+
+func TestChunkIterator(t *testing.T) {
+	record := encodeMsgpack(t, map[string]interface{}{"log": "hello"})
+	ts := time.Date(2024, 1, 15, 10, 30, 0, 123456789, time.UTC)
+
+	t.Run("event time", func(t *testing.T) {
+		chunk := append(append([]byte{0x92}, eventTime(ts)...), record...)
+
+		got, r, ok := newChunkIterator(chunk)()
+		if !ok {
+			t.Fatal("expected a record")
+		}
+		if !got.Equal(ts) {
+			t.Errorf("expected timestamp %v, got %v", ts, got)
+		}
+		if string(r["log"].([]byte)) != "hello" {
+			t.Errorf("unexpected record %v", r)
+		}
+	})
+
+	t.Run("fluent bit v2 format", func(t *testing.T) {
+		header := append(append([]byte{0x92}, eventTime(ts)...), 0x80)
+		chunk := append(append([]byte{0x92}, header...), record...)
+
+		got, r, ok := newChunkIterator(chunk)()
+		if !ok {
+			t.Fatal("expected a record")
+		}
+		if !got.Equal(ts) || string(r["log"].([]byte)) != "hello" {
+			t.Errorf("unexpected record %v at %v", r, got)
+		}
+	})
+
+	t.Run("byte fields point into the chunk", func(t *testing.T) {
+		chunk := encodeMsgpack(t, []interface{}{uint64(1705314600), map[string]interface{}{"log": "hello"}})
+
+		_, r, _ := newChunkIterator(chunk)()
+		value := r["log"].([]byte)
+		offset := bytes.Index(chunk, []byte("hello"))
+		if &value[0] != &chunk[offset] {
+			t.Error("expected the field to reference the chunk instead of a copy")
+		}
+	})
+
+	t.Run("stops at a truncated entry", func(t *testing.T) {
+		chunk := encodeMsgpack(t,
+			[]interface{}{uint64(1705314600), map[string]interface{}{"n": 1}},
+			[]interface{}{uint64(1705314601), map[string]interface{}{"log": "truncated"}},
+		)
+		next := newChunkIterator(chunk[:len(chunk)-3])
+
+		output := captureStdout(func() {
+			if _, _, ok := next(); !ok {
+				t.Fatal("expected the first record")
+			}
+			if _, _, ok := next(); ok {
+				t.Error("expected iteration to stop at the truncated record")
+			}
+		})
+		if !strings.Contains(output, "unable to decode record") {
+			t.Errorf("expected a decode error to be logged, got %q", output)
+		}
+	})
+
+	t.Run("rejects entries which are not records", func(t *testing.T) {
+		chunk := encodeMsgpack(t, []interface{}{uint64(1705314600), "not a map"})
+
+		captureStdout(func() {
+			if _, _, ok := newChunkIterator(chunk)(); ok {
+				t.Error("expected no record")
+			}
+		})
+	})
+}
@@ -0,0 +1,164 @@
+package sqsout
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// dedupWindowBatches is how many recently delivered batches are
+	// remembered
+	dedupWindowBatches = 10000
+	// dedupWindowFile is the file of the window in BufferPath
+	dedupWindowFile = "dedup-window"
+	// dedupSeqBytes is the size of a sequence number in the file
+	dedupSeqBytes = 8
+)
+
+// dedupWindow is a persisted LRU of the sequence numbers of the disk buffer
+// batches delivered recently. a batch is remembered once it is delivered,
+// ahead of its ack in the log, so the replay of a batch whose ack was lost in
+// a crash skips it instead of delivering it twice to a standard queue. the
+// sequence numbers are appended to the file of the window, which is
+// rewritten with the remembered ones once it holds twice as many
+type dedupWindow struct {
+	path     string
+	capacity int
+
+	mu       sync.Mutex
+	order    *list.List
+	entries  map[uint64]*list.Element
+	file     *os.File
+	appended int
+	// last is the highest sequence number remembered, the log numbers its
+	// next batches after it
+	last uint64
+}
+
+// openDedupWindow loads the window of dir
+func openDedupWindow(dir string, capacity int) (*dedupWindow, error) {
+	w := &dedupWindow{
+		path:     filepath.Join(dir, dedupWindowFile),
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[uint64]*list.Element),
+	}
+
+	data, err := os.ReadFile(w.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read the deduplication window: %v", err)
+	}
+	// a partial sequence number of a crash is left out
+	for ; len(data) >= dedupSeqBytes; data = data[dedupSeqBytes:] {
+		w.insertLocked(binary.LittleEndian.Uint64(data))
+	}
+
+	if err := w.rewriteLocked(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// seen returns true when the batch seq was delivered recently
+func (w *dedupWindow) seen(seq uint64) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	element, ok := w.entries[seq]
+	if ok {
+		w.order.MoveToFront(element)
+	}
+	return ok
+}
+
+// add remembers a delivered batch seq. it is synced, the ack of the batch
+// which follows may be lost in a crash
+func (w *dedupWindow) add(seq uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return
+	}
+	w.insertLocked(seq)
+
+	var data [dedupSeqBytes]byte
+	binary.LittleEndian.PutUint64(data[:], seq)
+	if _, err := w.file.Write(data[:]); err != nil {
+		writeErrorLog(fmt.Errorf("failed to persist the deduplication window: %v", err))
+	} else if err := w.file.Sync(); err != nil {
+		writeErrorLog(fmt.Errorf("failed to persist the deduplication window: %v", err))
+	}
+	w.appended++
+	if w.appended >= 2*w.capacity {
+		if err := w.rewriteLocked(); err != nil {
+			writeErrorLog(err)
+		}
+	}
+}
+
+func (w *dedupWindow) insertLocked(seq uint64) {
+	w.last = max(w.last, seq)
+	if element, ok := w.entries[seq]; ok {
+		w.order.MoveToFront(element)
+		return
+	}
+	w.entries[seq] = w.order.PushFront(seq)
+	if w.order.Len() > w.capacity {
+		oldest := w.order.Back()
+		w.order.Remove(oldest)
+		delete(w.entries, oldest.Value.(uint64))
+	}
+}
+
+// lastSeq returns the highest batch seq remembered
+func (w *dedupWindow) lastSeq() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.last
+}
+
+// rewriteLocked replaces the file with the remembered batches, oldest first,
+// and opens it for the next ones
+func (w *dedupWindow) rewriteLocked() error {
+	if w.file != nil {
+		_ = w.file.Close()
+		w.file = nil
+	}
+
+	data := make([]byte, 0, w.order.Len()*dedupSeqBytes)
+	for element := w.order.Back(); element != nil; element = element.Prev() {
+		data = binary.LittleEndian.AppendUint64(data, element.Value.(uint64))
+	}
+
+	tmp := w.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write the deduplication window: %v", err)
+	}
+	if err := os.Rename(tmp, w.path); err != nil {
+		return fmt.Errorf("failed to write the deduplication window: %v", err)
+	}
+
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open the deduplication window: %v", err)
+	}
+	w.file = file
+	w.appended = w.order.Len()
+	return nil
+}
+
+// stop closes the file of the window
+func (w *dedupWindow) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file != nil {
+		_ = w.file.Close()
+		w.file = nil
+	}
+}
@@ -0,0 +1,144 @@
+package sqsout
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDedupWindow(t *testing.T) {
+	dir := t.TempDir()
+	window, err := openDedupWindow(dir, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	window.add(1)
+	window.add(2)
+	if !window.seen(1) {
+		t.Error("expected the first batch to be remembered")
+	}
+	// the second batch is the least recently used
+	window.add(3)
+	if window.seen(2) || !window.seen(1) || !window.seen(3) {
+		t.Error("expected the least recently used batch to be forgotten")
+	}
+
+	// the file was rewritten with the remembered batches once it held 4
+	window.add(1)
+	window.stop()
+	info, err := os.Stat(filepath.Join(dir, dedupWindowFile))
+	if err != nil || info.Size() != 2*dedupSeqBytes {
+		t.Errorf("expected the window file to be compacted, got %v, %v", info, err)
+	}
+
+	// a partial sequence number of a crash
+	f, _ := os.OpenFile(filepath.Join(dir, dedupWindowFile), os.O_WRONLY|os.O_APPEND, 0o600)
+	_, _ = f.Write([]byte{1, 2, 3})
+	f.Close()
+
+	reopened, err := openDedupWindow(dir, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer reopened.stop()
+	if !reopened.seen(1) || !reopened.seen(3) || reopened.seen(2) {
+		t.Error("expected the window to be restored from its file")
+	}
+	if got := reopened.lastSeq(); got != 3 {
+		t.Errorf("expected the last batch to be 3, got %d", got)
+	}
+}
+
+func TestDiskBufferSkipsDeliveredReplay(t *testing.T) {
+	resetGlobals()
+	dir := t.TempDir()
+	window, err := openDedupWindow(dir, dedupWindowBatches)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer window.stop()
+
+	fake := &recordingSQS{}
+	sqsConf := &sqsConfig{
+		queueURL:    "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS:       fake,
+		dedupWindow: window,
+	}
+
+	var buffer *diskBuffer
+	captureStdout(func() { buffer, err = newDiskBuffer(sqsConf, dir, 0, 0, bufferSync{}) })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// a batch delivered whose ack was lost in a crash, and one which wasn't
+	// delivered
+	delivered, _ := buffer.wal.appendBatch(testBatch(2))
+	window.add(delivered)
+	if _, err := buffer.wal.appendBatch(testBatch(3)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	buffer.wal.close()
+
+	captureStdout(func() {
+		buffer, err = newDiskBuffer(sqsConf, dir, 0, 0, bufferSync{})
+		if err == nil {
+			buffer.replay()
+		}
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer buffer.wal.close()
+
+	if len(fake.batches) != 1 || len(fake.batches[0].Entries) != 3 {
+		t.Fatalf("expected only the undelivered batch to be replayed, got %d sends", len(fake.batches))
+	}
+	if got := sqsConf.stats.drops[dropDuplicate].Load(); got != 2 {
+		t.Errorf("expected the 2 messages already delivered to be counted as duplicates, got %d", got)
+	}
+	if len(buffer.wal.pending) != 0 {
+		t.Errorf("expected every batch to be acknowledged, got %d pending", len(buffer.wal.pending))
+	}
+	if !window.seen(delivered + 1) {
+		t.Error("expected the replayed batch to be remembered once delivered")
+	}
+
+	// the batches are remembered by their sequence number, a new batch of
+	// the same content is delivered
+	sqsConf.diskBuffer = buffer
+	captureStdout(func() {
+		for range 2 {
+			if err := sendBatchToSqs(sqsConf, testBatch(3)); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+	})
+	if len(fake.batches) != 3 {
+		t.Errorf("expected the batches of the same content to be delivered, got %d sends", len(fake.batches))
+	}
+}
+
+func TestDiskBufferNumbersAfterTheWindow(t *testing.T) {
+	resetGlobals()
+	dir := t.TempDir()
+	window, err := openDedupWindow(dir, dedupWindowBatches)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer window.stop()
+	// the segments of these batches were compacted
+	window.add(41)
+
+	sqsConf := &sqsConfig{queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue", mySQS: &recordingSQS{}, dedupWindow: window}
+	buffer, err := newDiskBuffer(sqsConf, dir, 0, 0, bufferSync{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer buffer.wal.close()
+
+	seq, err := buffer.wal.appendBatch(testBatch(1))
+	if err != nil || seq != 42 {
+		t.Errorf("expected the next batch to be numbered after the window, got %d, %v", seq, err)
+	}
+}
@@ -0,0 +1,362 @@
+package sqsout
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	// diskBufferRetryInterval is how often the batches kept in the disk
+	// buffer are sent again
+	diskBufferRetryInterval = 5 * time.Second
+	// defaultBufferMaxSize is the size cap of the disk buffer when
+	// BufferMaxSize is not set
+	defaultBufferMaxSize = 1 << 30
+)
+
+// parseBufferMaxSize parses BufferMaxSize, the size cap of the segments of
+// the disk buffer
+func parseBufferMaxSize(value string) (int64, error) {
+	if value == "" {
+		return defaultBufferMaxSize, nil
+	}
+	size, err := parseSize(value)
+	if err != nil || size <= 0 {
+		return 0, errors.New("BufferMaxSize should be a positive size, e.g. 512M or 4G")
+	}
+	return size, nil
+}
+
+// parseBufferMaxAge parses BufferMaxAge, how long a batch is kept in the disk
+// buffer. 0 keeps the batches until they are delivered or evicted by the size
+// cap
+func parseBufferMaxAge(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	maxAge, err := time.ParseDuration(value)
+	if err != nil || maxAge < 0 {
+		return 0, errors.New("BufferMaxAge should be a positive duration, e.g. 6h")
+	}
+	return maxAge, nil
+}
+
+// bufferSync is when the batches written to the disk buffer are synced to
+// the disk. unsynced batches survive a crash of the process, not a crash of
+// the host or a power loss
+type bufferSync struct {
+	// always syncs every batch before it is sent
+	always bool
+	// interval syncs the log in the background, 0 leaves it to the system
+	interval time.Duration
+}
+
+// parseBufferSync parses BufferSync: off, always or a sync interval
+func parseBufferSync(value string) (bufferSync, error) {
+	switch strings.ToLower(value) {
+	case "", "off":
+		return bufferSync{}, nil
+	case "always":
+		return bufferSync{always: true}, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		return bufferSync{}, fmt.Errorf("BufferSync should be off, always or a positive duration, e.g. 1s. got %q", value)
+	}
+	return bufferSync{interval: interval}, nil
+}
+
+// bufferReplayOrder is when the batches recovered from the disk buffer of a
+// previous run are sent
+type bufferReplayOrder string
+
+const (
+	// replayFirst sends them when the instance starts, ahead of the new
+	// records
+	replayFirst bufferReplayOrder = "first"
+	// replayBackground sends them with the failed batches, the new records
+	// don't wait for them
+	replayBackground bufferReplayOrder = "background"
+)
+
+func parseBufferReplayOrder(value string) (bufferReplayOrder, error) {
+	switch order := bufferReplayOrder(strings.ToLower(value)); order {
+	case "":
+		return replayFirst, nil
+	case replayFirst, replayBackground:
+		return order, nil
+	default:
+		return "", fmt.Errorf("BufferReplayOrder should be one of: first, background. got %q", value)
+	}
+}
+
+// diskBuffer writes every batch to the write ahead log of BufferPath ahead
+// of its send. a batch which fails is kept in the log and sent again in the
+// background instead of failing the chunk, the log is acknowledged once a
+// batch is delivered
+type diskBuffer struct {
+	sqsConf *sqsConfig
+	wal     *writeAheadLog
+
+	mu sync.Mutex
+	// failed are the buffered batches waiting for their next send
+	failed map[uint64]struct{}
+
+	// syncInterval is how often the log is synced, 0 when it isn't synced in
+	// the background
+	syncInterval time.Duration
+
+	stopCh   chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// newDiskBuffer opens the log of dir, the batches a previous run didn't
+// deliver are sent again like the failed ones. the oldest batches are evicted
+// to keep the log below maxBytes and the batches older than maxAge, the
+// batches are synced to the disk as syncPolicy says
+func newDiskBuffer(sqsConf *sqsConfig, dir string, maxBytes int64, maxAge time.Duration, syncPolicy bufferSync) (*diskBuffer, error) {
+	wal, err := openWriteAheadLog(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &diskBuffer{
+		sqsConf:      sqsConf,
+		wal:          wal,
+		failed:       make(map[uint64]struct{}),
+		syncInterval: syncPolicy.interval,
+		stopCh:       make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	wal.maxBytes, wal.maxAge, wal.onEvict = maxBytes, maxAge, b.evicted
+	wal.syncBatches = syncPolicy.always
+	wal.evict(time.Now())
+	// the segments of the batches remembered by the deduplication window may
+	// be compacted, their sequence numbers are not used again
+	if sqsConf.dedupWindow != nil {
+		wal.numberAfter(sqsConf.dedupWindow.lastSeq())
+	}
+
+	for _, seq := range wal.pendingBatches() {
+		b.failed[seq] = struct{}{}
+	}
+	if recovered := len(b.failed); recovered > 0 {
+		writeWarnLog(fmt.Sprintf("recovered %d undelivered batches from the disk buffer %s", recovered, dir))
+	}
+	return b, nil
+}
+
+// replay sends the recovered batches before the instance takes new records.
+// the ones left after a failure are sent again in the background
+func (b *diskBuffer) replay() {
+	recovered := b.failedBatches()
+	if recovered == 0 {
+		return
+	}
+	b.resend()
+	writeInfoLog(fmt.Sprintf("replayed %d of the %d recovered batches", recovered-b.failedBatches(), recovered))
+}
+
+// start sends the failed batches again every interval until stop is called,
+// and syncs the log every syncInterval
+func (b *diskBuffer) start(interval time.Duration) {
+	registerReporter(b.sqsConf, b)
+
+	go func() {
+		defer close(b.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var syncTick <-chan time.Time
+		if b.syncInterval > 0 {
+			syncTicker := time.NewTicker(b.syncInterval)
+			defer syncTicker.Stop()
+			syncTick = syncTicker.C
+		}
+
+		for {
+			select {
+			case <-ticker.C:
+				b.wal.evict(time.Now())
+				b.resend()
+			case <-syncTick:
+				b.wal.sync()
+			case <-b.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// stop stops the resends and closes the log, the failed batches stay in it
+func (b *diskBuffer) stop() {
+	b.stopOnce.Do(func() {
+		close(b.stopCh)
+	})
+	<-b.done
+	b.wal.close()
+}
+
+// send writes the batch to the log and sends it. a batch which can't be
+// written is sent unbuffered
+func (b *diskBuffer) send(records []*types.SendMessageBatchRequestEntry) error {
+	seq, err := b.wal.appendBatch(records)
+	if err != nil {
+		writeErrorLog(fmt.Errorf("failed to write the batch to the disk buffer, sending it unbuffered: %v", err))
+		return deliverBatch(b.sqsConf, records)
+	}
+
+	failed, err := deliverEntries(b.sqsConf, records)
+	if err != nil {
+		if !b.sqsConf.retry.classes.retryableError(err) {
+			b.wal.ack(seq)
+			b.sqsConf.stats.countDroppedRecords(dropRejected, len(records))
+			return err
+		}
+		writeWarnLog(fmt.Sprintf("%v. the batch is kept in the disk buffer and sent again", err))
+		b.mu.Lock()
+		b.failed[seq] = struct{}{}
+		b.mu.Unlock()
+		return nil
+	}
+	if len(failed) > 0 {
+		b.keepFailed(seq, failed)
+		return nil
+	}
+
+	b.delivered(seq)
+	b.wal.ack(seq)
+	return nil
+}
+
+// delivered remembers a batch delivered ahead of its ack, its replay after a
+// crash is skipped
+func (b *diskBuffer) delivered(seq uint64) {
+	if b.sqsConf.dedupWindow != nil {
+		b.sqsConf.dedupWindow.add(seq)
+	}
+}
+
+// replayed returns true when the batch was already delivered, it is
+// acknowledged and its records counted as duplicates
+func (b *diskBuffer) replayed(seq uint64) bool {
+	if b.sqsConf.dedupWindow == nil || !b.sqsConf.dedupWindow.seen(seq) {
+		return false
+	}
+	records := b.wal.batchRecords(seq)
+	writeDebugLog(fmt.Sprintf("skipping a batch of %d messages of the disk buffer already delivered", records))
+	b.sqsConf.stats.countDroppedRecords(dropDuplicate, records)
+	b.forget(seq)
+	return true
+}
+
+// keepFailed replaces a batch of the log with its entries which failed, the
+// delivered ones are not sent again. when they can't be written the whole
+// batch is sent again
+func (b *diskBuffer) keepFailed(seq uint64, failed []*types.SendMessageBatchRequestEntry) {
+	kept, err := b.wal.appendBatch(failed)
+	if err != nil {
+		writeErrorLog(fmt.Errorf("failed to write the %d failed messages of a batch to the disk buffer, the whole batch is sent again: %v", len(failed), err))
+		b.mu.Lock()
+		b.failed[seq] = struct{}{}
+		b.mu.Unlock()
+		return
+	}
+
+	b.delivered(seq)
+	b.wal.ack(seq)
+	b.mu.Lock()
+	delete(b.failed, seq)
+	b.failed[kept] = struct{}{}
+	b.mu.Unlock()
+	writeWarnLog(fmt.Sprintf("%d messages of a batch failed. they are kept in the disk buffer and sent again", len(failed)))
+}
+
+// resend sends the failed batches again, oldest first. it stops at the first
+// failure, the destination is still unavailable
+func (b *diskBuffer) resend() {
+	b.mu.Lock()
+	failed := make([]uint64, 0, len(b.failed))
+	for seq := range b.failed {
+		failed = append(failed, seq)
+	}
+	b.mu.Unlock()
+	sort.Slice(failed, func(i, j int) bool { return failed[i] < failed[j] })
+
+	for _, seq := range failed {
+		select {
+		case <-b.stopCh:
+			return
+		default:
+		}
+
+		if b.replayed(seq) {
+			continue
+		}
+
+		records, err := b.wal.readBatch(seq)
+		if err != nil {
+			writeErrorLog(fmt.Errorf("dropping a batch of the disk buffer which can't be read: %v", err))
+			// a batch whose records can't be counted is counted as one
+			b.sqsConf.stats.countDroppedRecords(dropUnreadable, max(b.wal.batchRecords(seq), 1))
+			b.forget(seq)
+			continue
+		}
+
+		failed, err := deliverEntries(b.sqsConf, records)
+		if err != nil {
+			if !b.sqsConf.retry.classes.retryableError(err) {
+				writeErrorLog(fmt.Errorf("dropping a batch of the disk buffer which can't be delivered: %v", err))
+				b.sqsConf.stats.countDroppedRecords(dropRejected, len(records))
+				b.forget(seq)
+				continue
+			}
+			writeWarnLog(fmt.Sprintf("%v. %d batches are kept in the disk buffer", err, b.failedBatches()))
+			return
+		}
+		if len(failed) > 0 {
+			// the destination rejects part of the batches, the next ones
+			// wait for the next resend
+			b.keepFailed(seq, failed)
+			return
+		}
+		b.delivered(seq)
+		b.forget(seq)
+	}
+}
+
+// evicted forgets the batches evicted from the log, they are lost
+func (b *diskBuffer) evicted(seqs []uint64, records int, bytes int64) {
+	b.mu.Lock()
+	for _, seq := range seqs {
+		delete(b.failed, seq)
+	}
+	b.mu.Unlock()
+
+	b.sqsConf.stats.bytesEvicted.Add(bytes)
+	b.sqsConf.stats.countDroppedRecords(dropEvicted, records)
+	writeWarnLog(fmt.Sprintf("evicted %d batches (%d records, %d bytes) from the disk buffer over BufferMaxSize or BufferMaxAge, they are lost", len(seqs), records, bytes))
+}
+
+// forget acknowledges a batch which is no longer sent again
+func (b *diskBuffer) forget(seq uint64) {
+	b.wal.ack(seq)
+	b.mu.Lock()
+	delete(b.failed, seq)
+	b.mu.Unlock()
+}
+
+// failedBatches returns how many batches wait to be sent again
+func (b *diskBuffer) failedBatches() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.failed)
+}
@@ -0,0 +1,318 @@
+package sqsout
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go"
+)
+
+func TestDiskBuffer(t *testing.T) {
+	resetGlobals()
+	fake := &recordingSQS{err: errors.New("service unavailable")}
+	sqsConf := &sqsConfig{
+		queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS:    fake,
+	}
+
+	dir := t.TempDir()
+	buffer, err := newDiskBuffer(sqsConf, dir, 0, 0, bufferSync{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer buffer.wal.close()
+	sqsConf.diskBuffer = buffer
+
+	captureStdout(func() {
+		if err := sendBatchToSqs(sqsConf, testBatch(2)); err != nil {
+			t.Errorf("expected a failed batch to be kept in the buffer, got %v", err)
+		}
+		buffer.resend()
+	})
+	if got := buffer.failedBatches(); got != 1 {
+		t.Fatalf("expected 1 failed batch, got %d", got)
+	}
+
+	fake.mu.Lock()
+	fake.err = nil
+	fake.mu.Unlock()
+
+	captureStdout(func() {
+		if err := sendBatchToSqs(sqsConf, testBatch(1)); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		buffer.resend()
+	})
+
+	if got := buffer.failedBatches(); got != 0 {
+		t.Errorf("expected the failed batch to be sent again, %d left", got)
+	}
+	if len(fake.batches) != 4 {
+		t.Fatalf("expected 2 failed and 2 delivered sends, got %d", len(fake.batches))
+	}
+	if got := len(fake.batches[3].Entries); got != 2 || aws.ToString(fake.batches[3].Entries[0].MessageBody) != `{"message":"test"}` {
+		t.Errorf("expected the buffered batch to be sent as written, got %d entries", got)
+	}
+	if len(buffer.wal.pending) != 0 {
+		t.Errorf("expected every batch to be acknowledged, got %d pending", len(buffer.wal.pending))
+	}
+}
+
+func TestParseBufferSync(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    bufferSync
+		wantErr bool
+	}{
+		{"", bufferSync{}, false},
+		{"off", bufferSync{}, false},
+		{"Always", bufferSync{always: true}, false},
+		{"1s", bufferSync{interval: time.Second}, false},
+		{"0s", bufferSync{}, true},
+		{"sometimes", bufferSync{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseBufferSync(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseBufferSync(%q) = %+v, %v, want %+v, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseBufferReplayOrder(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    bufferReplayOrder
+		wantErr bool
+	}{
+		{"", replayFirst, false},
+		{"first", replayFirst, false},
+		{"Background", replayBackground, false},
+		{"last", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseBufferReplayOrder(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseBufferReplayOrder(%q) = %q, %v, want %q, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDiskBufferReplay(t *testing.T) {
+	resetGlobals()
+	dir := t.TempDir()
+
+	w, err := openWriteAheadLog(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, _ = w.appendBatch(testBatch(1))
+	_, _ = w.appendBatch(testBatch(2))
+	w.close()
+
+	fake := &recordingSQS{}
+	sqsConf := &sqsConfig{
+		queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS:    fake,
+	}
+
+	var buffer *diskBuffer
+	captureStdout(func() {
+		buffer, err = newDiskBuffer(sqsConf, dir, 0, 0, bufferSync{})
+		if err != nil {
+			return
+		}
+		sqsConf.diskBuffer = buffer
+		buffer.replay()
+		err = sendBatchToSqs(sqsConf, testBatch(3))
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer buffer.wal.close()
+
+	var sizes []int
+	for _, batch := range fake.batches {
+		sizes = append(sizes, len(batch.Entries))
+	}
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("expected the recovered batches ahead of the new one, got batches of %v", sizes)
+	}
+	if got := buffer.wal.pendingBatches(); len(got) != 0 {
+		t.Errorf("expected every batch to be acknowledged, got %v pending", got)
+	}
+}
+
+func TestParseBufferLimits(t *testing.T) {
+	sizes := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{"", defaultBufferMaxSize, false},
+		{"512M", 512 << 20, false},
+		{"0", 0, true},
+		{"lots", 0, true},
+	}
+	for _, tt := range sizes {
+		got, err := parseBufferMaxSize(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseBufferMaxSize(%q) = %d, %v, want %d, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	ages := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"6h", 6 * time.Hour, false},
+		{"-1h", 0, true},
+		{"forever", 0, true},
+	}
+	for _, tt := range ages {
+		got, err := parseBufferMaxAge(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseBufferMaxAge(%q) = %v, %v, want %v, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDiskBufferEviction(t *testing.T) {
+	resetGlobals()
+	fake := &recordingSQS{err: errors.New("service unavailable")}
+	sqsConf := &sqsConfig{
+		queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS:    fake,
+	}
+
+	// room for two and a half batches
+	buffer, err := newDiskBuffer(sqsConf, t.TempDir(), 5*walBatchBytes(t, 2)/2, 0, bufferSync{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer buffer.wal.close()
+	sqsConf.diskBuffer = buffer
+
+	output := captureStdout(func() {
+		for i := 0; i < 3; i++ {
+			if err := sendBatchToSqs(sqsConf, testBatch(2)); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+	})
+
+	if got := buffer.failedBatches(); got != 1 {
+		t.Errorf("expected the oldest failed batches to be evicted, %d left", got)
+	}
+	if sqsConf.stats.bytesEvicted.Load() == 0 || !strings.Contains(output, "evicted 2 batches (4 records") {
+		t.Errorf("expected the evicted bytes to be counted and logged, got %d, %q", sqsConf.stats.bytesEvicted.Load(), output)
+	}
+	if got := sqsConf.stats.drops[dropEvicted].Load(); got != 4 {
+		t.Errorf("expected the 4 evicted records to be counted as dropped, got %d", got)
+	}
+}
+
+func TestDiskBufferDrops(t *testing.T) {
+	resetGlobals()
+	fake := &scriptedSQS{responses: []scriptedResponse{
+		{err: errors.New("service unavailable")},
+		{err: errors.New("service unavailable")},
+		{err: &smithy.GenericAPIError{Code: "AccessDenied"}},
+		{err: &smithy.GenericAPIError{Code: "AccessDenied"}},
+	}}
+	sqsConf := &sqsConfig{
+		queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS:    fake,
+	}
+
+	buffer, err := newDiskBuffer(sqsConf, t.TempDir(), 0, 0, bufferSync{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer buffer.wal.close()
+	sqsConf.diskBuffer = buffer
+
+	captureStdout(func() {
+		// kept, then rejected on its resend
+		if err := sendBatchToSqs(sqsConf, testBatch(2)); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		// kept, then unreadable
+		if err := sendBatchToSqs(sqsConf, testBatch(3)); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		buffer.wal.mu.Lock()
+		for seq, position := range buffer.wal.pending {
+			if position.records == 3 {
+				position.offset++
+				buffer.wal.pending[seq] = position
+			}
+		}
+		buffer.wal.mu.Unlock()
+		buffer.resend()
+
+		// rejected on its first send
+		if err := sendBatchToSqs(sqsConf, testBatch(1)); err == nil {
+			t.Error("expected the rejected batch to fail")
+		}
+	})
+
+	drops := sqsConf.stats.drops.snapshot()
+	if drops[dropRejected] != 3 || drops[dropUnreadable] != 3 {
+		t.Errorf("expected 3 rejected and 3 unreadable records, got %d and %d", drops[dropRejected], drops[dropUnreadable])
+	}
+	if got := sqsConf.stats.recordsDropped.Load(); got != 6 {
+		t.Errorf("expected the 6 lost records to be counted as dropped, got %d", got)
+	}
+	if got := buffer.failedBatches(); got != 0 {
+		t.Errorf("expected no batch left in the buffer, got %d", got)
+	}
+}
+
+func TestDiskBufferPartialFailure(t *testing.T) {
+	resetGlobals()
+	fake := &scriptedSQS{responses: []scriptedResponse{{fail: []string{"MessageNumber-2"}}}}
+	sqsConf := &sqsConfig{
+		queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS:    fake,
+	}
+
+	buffer, err := newDiskBuffer(sqsConf, t.TempDir(), 0, 0, bufferSync{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer buffer.wal.close()
+	sqsConf.diskBuffer = buffer
+
+	captureStdout(func() {
+		records := testBatch(3)
+		for i, entry := range records {
+			entry.Id = aws.String(fmt.Sprintf("MessageNumber-%d", i+1))
+		}
+		if err := sendBatchToSqs(sqsConf, records); err != nil {
+			t.Errorf("expected the failed messages to be kept in the buffer, got %v", err)
+		}
+	})
+	if got := buffer.failedBatches(); got != 1 || len(buffer.wal.pending) != 1 {
+		t.Fatalf("expected the failed messages to be kept as 1 batch, got %d failed and %d pending", got, len(buffer.wal.pending))
+	}
+
+	captureStdout(buffer.resend)
+
+	want := [][]string{{"MessageNumber-1", "MessageNumber-2", "MessageNumber-3"}, {"MessageNumber-2"}}
+	if !reflect.DeepEqual(fake.calls, want) {
+		t.Errorf("expected only the failed message to be sent again, got calls %v", fake.calls)
+	}
+	if got := buffer.failedBatches(); got != 0 || len(buffer.wal.pending) != 0 {
+		t.Errorf("expected every message to be delivered, got %d failed and %d pending", got, len(buffer.wal.pending))
+	}
+}
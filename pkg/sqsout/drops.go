@@ -0,0 +1,184 @@
+package sqsout
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultDropWarningInterval is the interval of the dropped record warnings
+// when DropWarningInterval is not set
+const defaultDropWarningInterval = time.Minute
+
+// dropReason is why the plugin discarded a record or a whole chunk
+type dropReason int
+
+const (
+	// records
+	dropEmpty dropReason = iota
+	dropSampled
+	dropFiltered
+	dropMissingKeys
+	dropUnserializable
+	dropOversize
+	dropVetoed
+	dropDuplicate
+	dropSideQueueFull
+	dropRejected
+	dropUnreadable
+	dropEvicted
+	// chunks
+	dropExcludedTag
+	dropOverflow
+)
+
+// dropReasonNames are the counter names of the reasons, in the order of the
+// constants
+var dropReasonNames = [...]string{
+	"empty",
+	"sampled",
+	"filtered",
+	"missing_keys",
+	"unserializable",
+	"oversize",
+	"vetoed",
+	"duplicate",
+	"side_queue_full",
+	"rejected",
+	"unreadable",
+	"evicted",
+	"excluded_tag_chunks",
+	"overflow_chunks",
+}
+
+// dropCounts counts the discarded records and chunks per reason
+type dropCounts [len(dropReasonNames)]atomic.Int64
+
+func (d *dropCounts) snapshot() [len(dropReasonNames)]int64 {
+	var s [len(dropReasonNames)]int64
+	for i := range d {
+		s[i] = d[i].Load()
+	}
+	return s
+}
+
+// countDroppedRecord accounts a record discarded for the given reason
+func (s *deliveryStats) countDroppedRecord(reason dropReason) {
+	s.recordsDropped.Add(1)
+	s.drops[reason].Add(1)
+}
+
+// countDroppedRecords accounts the records of a batch discarded whole for
+// the given reason
+func (s *deliveryStats) countDroppedRecords(reason dropReason, records int) {
+	s.recordsDropped.Add(int64(records))
+	s.drops[reason].Add(int64(records))
+}
+
+// countDroppedChunk accounts a chunk discarded whole for the given reason
+func (s *deliveryStats) countDroppedChunk(reason dropReason) {
+	s.chunksDropped.Add(1)
+	s.drops[reason].Add(1)
+}
+
+// parseDropWarningInterval parses DropWarningInterval, off disables the
+// warnings
+func parseDropWarningInterval(value string) (time.Duration, error) {
+	switch strings.ToLower(value) {
+	case "":
+		return defaultDropWarningInterval, nil
+	case "off":
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		return 0, errors.New("DropWarningInterval should be a positive duration, e.g. 60s, or off")
+	}
+	return interval, nil
+}
+
+// dropWarner logs a warning with the drop totals of every destination that
+// discarded records since the previous warning, so data loss is never silent
+type dropWarner struct {
+	sqsConf  *sqsConfig
+	interval time.Duration
+	// last holds the totals of the previous warning of each destination
+	last     map[string][len(dropReasonNames)]int64
+	stopCh   chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newDropWarner(sqsConf *sqsConfig, interval time.Duration) *dropWarner {
+	return &dropWarner{
+		sqsConf:  sqsConf,
+		interval: interval,
+		last:     map[string][len(dropReasonNames)]int64{},
+		stopCh:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// start checks the drop counters every interval until stop is called
+func (w *dropWarner) start() {
+	registerReporter(w.sqsConf, w)
+
+	go func() {
+		defer close(w.done)
+
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				w.warn()
+			case <-w.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// stop stops the warner, it is safe to call more than once
+func (w *dropWarner) stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopCh)
+	})
+	<-w.done
+}
+
+// warn logs the totals of the destinations with new drops
+func (w *dropWarner) warn() {
+	for _, d := range w.sqsConf.destinations() {
+		current := d.stats.drops.snapshot()
+		last := w.last[d.queueURL]
+		w.last[d.queueURL] = current
+
+		if current == last {
+			continue
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s dropped data in the last %s:", d.name(), w.interval)
+		for i, name := range dropReasonNames {
+			if delta := current[i] - last[i]; delta > 0 {
+				fmt.Fprintf(&b, " %s=%d", name, delta)
+			}
+		}
+		fmt.Fprintf(&b, ". totals: %s", formatDrops(current))
+		writeWarnLog(b.String())
+	}
+}
+
+// formatDrops formats the totals of every reason
+func formatDrops(counts [len(dropReasonNames)]int64) string {
+	parts := make([]string, len(dropReasonNames))
+	for i, name := range dropReasonNames {
+		parts[i] = fmt.Sprintf("%s=%d", name, counts[i])
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,78 @@
+package sqsout
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDropWarningInterval(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", defaultDropWarningInterval, false},
+		{"5m", 5 * time.Minute, false},
+		{"off", 0, false},
+		{"OFF", 0, false},
+		{"0s", 0, true},
+		{"often", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseDropWarningInterval(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseDropWarningInterval(%q) = %s, %v, want %s, wantErr %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestPrepareRecordCountsDropReasons(t *testing.T) {
+	resetGlobals()
+	sqsConf := &sqsConfig{requiredKeys: newRequiredKeys("level")}
+
+	prepareRecord(sqsConf, "app", time.Now(), map[interface{}]interface{}{})
+	prepareRecord(sqsConf, "app", time.Now(), map[interface{}]interface{}{"message": "no level"})
+	prepareRecord(sqsConf, "app", time.Now(), map[interface{}]interface{}{"level": strings.Repeat("x", maxMessageBytes)})
+
+	drops := sqsConf.stats.drops.snapshot()
+	if drops[dropEmpty] != 1 || drops[dropMissingKeys] != 1 || drops[dropOversize] != 1 {
+		t.Errorf("unexpected drops: %s", formatDrops(drops))
+	}
+	if got := sqsConf.stats.recordsDropped.Load(); got != 3 {
+		t.Errorf("expected 3 dropped records, got %d", got)
+	}
+}
+
+func TestDropWarnerWarn(t *testing.T) {
+	resetGlobals()
+	sqsConf := &sqsConfig{
+		queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		shadow:   newShadowRoute("https://sqs.us-east-1.amazonaws.com/123456789/shadow-queue", 100),
+	}
+	warner := newDropWarner(sqsConf, time.Minute)
+
+	sqsConf.stats.countDroppedRecord(dropOversize)
+	sqsConf.stats.countDroppedRecord(dropOversize)
+	sqsConf.stats.countDroppedChunk(dropOverflow)
+	first := captureStdout(warner.warn)
+
+	sqsConf.stats.countDroppedRecord(dropFiltered)
+	second := captureStdout(warner.warn)
+	third := captureStdout(warner.warn)
+
+	want := "test-queue dropped data in the last 1m0s: oversize=2 overflow_chunks=1. totals: empty=0 sampled=0 filtered=0 missing_keys=0 unserializable=0 oversize=2 vetoed=0 duplicate=0 side_queue_full=0 rejected=0 unreadable=0 evicted=0 excluded_tag_chunks=0 overflow_chunks=1"
+	if !strings.Contains(first, want) {
+		t.Errorf("expected %q in %q", want, first)
+	}
+	if strings.Contains(first, "shadow-queue") {
+		t.Errorf("expected no warning for the shadow queue, got %q", first)
+	}
+	if !strings.Contains(second, "in the last 1m0s: filtered=1. totals:") {
+		t.Errorf("expected the new drops only, got %q", second)
+	}
+	if third != "" {
+		t.Errorf("expected no warning without new drops, got %q", third)
+	}
+}
@@ -0,0 +1,241 @@
+package sqsout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	cwltypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+// defaultEmfNamespace is the CloudWatch namespace of the EMF metrics when
+// EmfNamespace is not set
+const defaultEmfNamespace = "FluentBit/SQS"
+
+// emfWriter writes an EMF document
+type emfWriter interface {
+	write(document []byte, timestamp time.Time) error
+}
+
+// cloudWatchLogsClient is the part of the CloudWatch Logs api used to write
+// EMF documents to a log group
+type cloudWatchLogsClient interface {
+	CreateLogStream(ctx context.Context, input *cloudwatchlogs.CreateLogStreamInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.CreateLogStreamOutput, error)
+	PutLogEvents(ctx context.Context, input *cloudwatchlogs.PutLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.PutLogEventsOutput, error)
+}
+
+// emfEmitter periodically writes the delivery counters as CloudWatch Embedded
+// Metric Format documents, to stdout (where the CloudWatch agent or fluent bit
+// itself picks them up) or straight to a CloudWatch Logs group
+type emfEmitter struct {
+	sqsConf   *sqsConfig
+	namespace string
+	interval  time.Duration
+	writer    emfWriter
+	// last holds the counters of the previous document of each destination
+	last     map[string]statsSnapshot
+	stopCh   chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func validateEmfConfig(intervalString, namespace, logGroup string) error {
+	if intervalString == "" && (namespace != "" || logGroup != "") {
+		return errors.New("EmfNamespace and EmfLogGroup require EmfInterval to be set")
+	}
+	return nil
+}
+
+func newEmfEmitter(sqsConf *sqsConfig, intervalString, namespace string, writer emfWriter) (*emfEmitter, error) {
+	interval, err := time.ParseDuration(intervalString)
+	if err != nil || interval <= 0 {
+		return nil, errors.New("EmfInterval should be a positive duration, e.g. 60s")
+	}
+
+	if namespace == "" {
+		namespace = defaultEmfNamespace
+	}
+
+	return &emfEmitter{
+		sqsConf:   sqsConf,
+		namespace: namespace,
+		interval:  interval,
+		writer:    writer,
+		last:      map[string]statsSnapshot{},
+		stopCh:    make(chan struct{}),
+		done:      make(chan struct{}),
+	}, nil
+}
+
+// start emits a document every interval until stop is called
+func (e *emfEmitter) start() {
+	registerReporter(e.sqsConf, e)
+
+	go func() {
+		defer close(e.done)
+
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				e.emit(time.Now())
+			case <-e.stopCh:
+				e.emit(time.Now())
+				return
+			}
+		}
+	}()
+}
+
+// stop emits a last document and stops the emitter. it is safe to call more
+// than once
+func (e *emfEmitter) stop() {
+	e.stopOnce.Do(func() {
+		close(e.stopCh)
+	})
+	<-e.done
+}
+
+// emit writes a document for every destination of the instance
+func (e *emfEmitter) emit(timestamp time.Time) {
+	for _, d := range e.sqsConf.destinations() {
+		current := d.stats.snapshot()
+		document, err := e.document(d, current, timestamp)
+		if err != nil {
+			writeErrorLog(fmt.Errorf("failed to create EMF document: %v", err))
+			continue
+		}
+
+		if err := e.writer.write(document, timestamp); err != nil {
+			writeErrorLog(fmt.Errorf("failed to write EMF document: %v", err))
+			continue
+		}
+
+		e.last[d.queueURL] = current
+	}
+}
+
+// document returns the EMF document of the counters of a destination since
+// the previous one
+func (e *emfEmitter) document(d destination, current statsSnapshot, timestamp time.Time) ([]byte, error) {
+	last := e.last[d.queueURL]
+	metrics := []struct {
+		name  string
+		unit  string
+		value int64
+	}{
+		{"MessagesSent", "Count", current.sent - last.sent},
+		{"MessagesFailed", "Count", current.failed - last.failed},
+		{"MessagesThrottled", "Count", current.throttled - last.throttled},
+		{"MessagesRetried", "Count", current.retried - last.retried},
+		{"RecordsDropped", "Count", current.dropped - last.dropped},
+		{"BytesSerialized", "Bytes", current.bytesSerialized - last.bytesSerialized},
+		{"BytesSent", "Bytes", current.bytesSent - last.bytesSent},
+		{"BytesFailed", "Bytes", current.bytesFailed - last.bytesFailed},
+		{"BytesRejected", "Bytes", current.bytesRejected - last.bytesRejected},
+		{"BytesEvicted", "Bytes", current.bytesEvicted - last.bytesEvicted},
+	}
+
+	latency := current.latency.sub(last.latency)
+	latencies := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"SendLatencyP50", latency.quantile(0.5)},
+		{"SendLatencyP90", latency.quantile(0.9)},
+		{"SendLatencyP99", latency.quantile(0.99)},
+	}
+
+	var definitions []map[string]string
+	document := map[string]interface{}{
+		"QueueUrl":  d.queueURL,
+		"QueueName": d.name(),
+	}
+	for _, metric := range metrics {
+		definitions = append(definitions, map[string]string{"Name": metric.name, "Unit": metric.unit})
+		document[metric.name] = metric.value
+	}
+	// the percentiles are bucket bounds of the latencies since the previous
+	// document, they are left out of intervals without sends
+	if latency.count() > 0 {
+		for _, metric := range latencies {
+			definitions = append(definitions, map[string]string{"Name": metric.name, "Unit": "Milliseconds"})
+			document[metric.name] = metric.value.Milliseconds()
+		}
+	}
+
+	document["_aws"] = map[string]interface{}{
+		"Timestamp": timestamp.UnixMilli(),
+		"CloudWatchMetrics": []interface{}{
+			map[string]interface{}{
+				"Namespace":  e.namespace,
+				"Dimensions": [][]string{{"QueueName"}},
+				"Metrics":    definitions,
+			},
+		},
+	}
+
+	return json.Marshal(document)
+}
+
+// stdoutEmfWriter writes every document as a line on stdout
+type stdoutEmfWriter struct{}
+
+func (stdoutEmfWriter) write(document []byte, _ time.Time) error {
+	_, err := fmt.Fprintf(os.Stdout, "%s\n", document)
+	return err
+}
+
+// logGroupEmfWriter writes the documents to a log stream of a CloudWatch Logs
+// group. the stream is created on the first write
+type logGroupEmfWriter struct {
+	client        cloudWatchLogsClient
+	logGroup      string
+	logStream     string
+	streamCreated bool
+}
+
+func newLogGroupEmfWriter(client cloudWatchLogsClient, logGroup string) *logGroupEmfWriter {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return &logGroupEmfWriter{
+		client:    client,
+		logGroup:  logGroup,
+		logStream: fmt.Sprintf("fluent-bit-sqs-%s-%d", hostname, os.Getpid()),
+	}
+}
+
+func (w *logGroupEmfWriter) write(document []byte, timestamp time.Time) error {
+	if !w.streamCreated {
+		_, err := w.client.CreateLogStream(context.Background(), &cloudwatchlogs.CreateLogStreamInput{
+			LogGroupName:  aws.String(w.logGroup),
+			LogStreamName: aws.String(w.logStream),
+		})
+		var exists *cwltypes.ResourceAlreadyExistsException
+		if err != nil && !errors.As(err, &exists) {
+			return err
+		}
+		w.streamCreated = true
+	}
+
+	_, err := w.client.PutLogEvents(context.Background(), &cloudwatchlogs.PutLogEventsInput{
+		LogGroupName:  aws.String(w.logGroup),
+		LogStreamName: aws.String(w.logStream),
+		LogEvents: []cwltypes.InputLogEvent{{
+			Message:   aws.String(string(document)),
+			Timestamp: aws.Int64(timestamp.UnixMilli()),
+		}},
+	})
+	return err
+}
@@ -0,0 +1,242 @@
+package sqsout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	cwltypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+// memoryEmfWriter implements emfWriter interface and keeps every document
+type memoryEmfWriter struct {
+	mu        sync.Mutex
+	documents []map[string]interface{}
+}
+
+func (w *memoryEmfWriter) write(document []byte, _ time.Time) error {
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(document, &decoded); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.documents = append(w.documents, decoded)
+	w.mu.Unlock()
+	return nil
+}
+
+func TestValidateEmfConfig(t *testing.T) {
+	tests := []struct {
+		interval  string
+		namespace string
+		logGroup  string
+		wantErr   bool
+	}{
+		{"", "", "", false},
+		{"60s", "", "", false},
+		{"60s", "MyApp", "/metrics/sqs", false},
+		{"", "MyApp", "", true},
+		{"", "", "/metrics/sqs", true},
+	}
+
+	for _, tt := range tests {
+		err := validateEmfConfig(tt.interval, tt.namespace, tt.logGroup)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateEmfConfig(%q, %q, %q) error = %v, wantErr %v", tt.interval, tt.namespace, tt.logGroup, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNewEmfEmitter(t *testing.T) {
+	tests := []struct {
+		interval      string
+		namespace     string
+		wantNamespace string
+		wantErr       bool
+	}{
+		{"60s", "", defaultEmfNamespace, false},
+		{"1m", "MyApp", "MyApp", false},
+		{"0s", "", "", true},
+		{"-5s", "", "", true},
+		{"soon", "", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := newEmfEmitter(&sqsConfig{}, tt.interval, tt.namespace, &memoryEmfWriter{})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newEmfEmitter(%q) error = %v, wantErr %v", tt.interval, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got.namespace != tt.wantNamespace {
+			t.Errorf("newEmfEmitter(%q, %q) namespace = %s, want %s", tt.interval, tt.namespace, got.namespace, tt.wantNamespace)
+		}
+	}
+}
+
+func TestEmfEmitterDocument(t *testing.T) {
+	sqsConf := &sqsConfig{queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue"}
+	writer := &memoryEmfWriter{}
+	emitter, err := newEmfEmitter(sqsConf, "60s", "", writer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sqsConf.stats.messagesSent.Add(10)
+	sqsConf.stats.messagesThrottled.Add(2)
+	emitter.emit(time.UnixMilli(1700000000000))
+
+	sqsConf.stats.messagesSent.Add(3)
+	emitter.emit(time.UnixMilli(1700000060000))
+
+	if len(writer.documents) != 2 {
+		t.Fatalf("expected 2 documents, got %d", len(writer.documents))
+	}
+
+	first, second := writer.documents[0], writer.documents[1]
+	if first["MessagesSent"] != float64(10) || first["MessagesThrottled"] != float64(2) {
+		t.Errorf("unexpected first document: %v", first)
+	}
+	if second["MessagesSent"] != float64(3) || second["MessagesThrottled"] != float64(0) {
+		t.Errorf("expected deltas in the second document, got %v", second)
+	}
+	if first["QueueName"] != "test-queue" || first["QueueUrl"] != sqsConf.queueURL {
+		t.Errorf("unexpected queue labels: %v %v", first["QueueName"], first["QueueUrl"])
+	}
+
+	metadata := first["_aws"].(map[string]interface{})
+	if metadata["Timestamp"] != float64(1700000000000) {
+		t.Errorf("unexpected timestamp: %v", metadata["Timestamp"])
+	}
+	directive := metadata["CloudWatchMetrics"].([]interface{})[0].(map[string]interface{})
+	if directive["Namespace"] != defaultEmfNamespace || len(directive["Metrics"].([]interface{})) != 10 {
+		t.Errorf("unexpected metric directive: %v", directive)
+	}
+}
+
+func TestEmfEmitterLatency(t *testing.T) {
+	sqsConf := &sqsConfig{queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue"}
+	writer := &memoryEmfWriter{}
+	emitter, err := newEmfEmitter(sqsConf, "60s", "", writer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 9; i++ {
+		sqsConf.stats.sendLatency.observe(20 * time.Millisecond)
+	}
+	sqsConf.stats.sendLatency.observe(3 * time.Second)
+	emitter.emit(time.Now())
+	emitter.emit(time.Now())
+
+	first, second := writer.documents[0], writer.documents[1]
+	if first["SendLatencyP50"] != float64(25) || first["SendLatencyP99"] != float64(5000) {
+		t.Errorf("unexpected latency percentiles: p50=%v p99=%v", first["SendLatencyP50"], first["SendLatencyP99"])
+	}
+	if _, ok := second["SendLatencyP50"]; ok {
+		t.Errorf("expected no latency without sends, got %v", second)
+	}
+}
+
+func TestEmfEmitterDestinations(t *testing.T) {
+	sqsConf := &sqsConfig{
+		queueURL:       "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		invalidRecords: newInvalidRecordRoute("https://sqs.us-east-1.amazonaws.com/123456789/invalid-queue", ""),
+	}
+	writer := &memoryEmfWriter{}
+	emitter, err := newEmfEmitter(sqsConf, "60s", "", writer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sqsConf.stats.messagesSent.Add(2)
+	sqsConf.invalidRecords.stats.messagesFailed.Add(1)
+	emitter.emit(time.Now())
+
+	if len(writer.documents) != 2 {
+		t.Fatalf("expected a document per queue, got %d", len(writer.documents))
+	}
+	main, invalid := writer.documents[0], writer.documents[1]
+	if main["QueueName"] != "test-queue" || main["MessagesSent"] != float64(2) || main["MessagesFailed"] != float64(0) {
+		t.Errorf("unexpected main queue document: %v", main)
+	}
+	if invalid["QueueName"] != "invalid-queue" || invalid["MessagesSent"] != float64(0) || invalid["MessagesFailed"] != float64(1) {
+		t.Errorf("unexpected invalid record queue document: %v", invalid)
+	}
+}
+
+func TestEmfEmitterStop(t *testing.T) {
+	sqsConf := &sqsConfig{queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue"}
+	writer := &memoryEmfWriter{}
+	emitter, err := newEmfEmitter(sqsConf, "1h", "", writer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	emitter.start()
+	sqsConf.stats.messagesSent.Add(4)
+	stopReporters()
+	emitter.stop()
+
+	if len(writer.documents) != 1 || writer.documents[0]["MessagesSent"] != float64(4) {
+		t.Errorf("expected a final document on stop, got %v", writer.documents)
+	}
+}
+
+// fakeCloudWatchLogs implements cloudWatchLogsClient interface
+type fakeCloudWatchLogs struct {
+	createErr error
+	streams   int
+	events    []cwltypes.InputLogEvent
+	logGroup  string
+}
+
+func (f *fakeCloudWatchLogs) CreateLogStream(ctx context.Context, input *cloudwatchlogs.CreateLogStreamInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.CreateLogStreamOutput, error) {
+	f.streams++
+	return &cloudwatchlogs.CreateLogStreamOutput{}, f.createErr
+}
+
+func (f *fakeCloudWatchLogs) PutLogEvents(ctx context.Context, input *cloudwatchlogs.PutLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	f.logGroup = *input.LogGroupName
+	f.events = append(f.events, input.LogEvents...)
+	return &cloudwatchlogs.PutLogEventsOutput{}, nil
+}
+
+func TestLogGroupEmfWriter(t *testing.T) {
+	tests := []struct {
+		name       string
+		createErr  error
+		wantErr    bool
+		wantEvents int
+	}{
+		{"creates the stream once", nil, false, 2},
+		{"reuses an existing stream", &cwltypes.ResourceAlreadyExistsException{Message: aws.String("exists")}, false, 2},
+		{"fails when the stream can't be created", errors.New("AccessDenied"), true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeCloudWatchLogs{createErr: tt.createErr}
+			writer := newLogGroupEmfWriter(fake, "/metrics/sqs")
+
+			for i := 0; i < 2; i++ {
+				err := writer.write([]byte(`{"MessagesSent":1}`), time.Now())
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("write() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			}
+
+			if len(fake.events) != tt.wantEvents {
+				t.Errorf("expected %d events, got %d", tt.wantEvents, len(fake.events))
+			}
+			if !tt.wantErr && (fake.streams != 1 || fake.logGroup != "/metrics/sqs") {
+				t.Errorf("expected one stream in /metrics/sqs, got %d streams in %q", fake.streams, fake.logGroup)
+			}
+		})
+	}
+}
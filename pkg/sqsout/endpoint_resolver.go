@@ -0,0 +1,141 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// endpointResolveTimeout bounds the DNS lookup or the script resolving the
+// endpoint
+const endpointResolveTimeout = 10 * time.Second
+
+// endpointResolver finds the endpoint of the aws clients when it depends on
+// where the plugin runs, e.g. sharded private SQS endpoints across VPCs. the
+// endpoint is resolved once when the instance is initialized
+type endpointResolver interface {
+	resolve(ctx context.Context, queueURL, region string) (string, error)
+}
+
+// parseEndpointResolver parses the EndpointResolver strategy:
+//
+//   - map:<region>=<url>,... picks the endpoint of QueueRegion, * matches
+//     the regions not listed
+//   - dns:<name> uses the target and port of the SRV record of name
+//   - exec:<path> runs the script and uses the url it prints, the script gets
+//     SQS_QUEUE_URL and SQS_QUEUE_REGION in its environment
+func parseEndpointResolver(value, endpoint string) (endpointResolver, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if endpoint != "" {
+		return nil, errors.New("Endpoint and EndpointResolver can't be set together")
+	}
+
+	strategy, arg, _ := strings.Cut(value, ":")
+	if arg == "" {
+		return nil, errors.New("EndpointResolver should be map:<region>=<url>,..., dns:<name> or exec:<path>")
+	}
+
+	switch strings.ToLower(strategy) {
+	case "map":
+		return parseRegionEndpoints(arg)
+	case "dns":
+		return &dnsEndpointResolver{name: arg, lookupSRV: net.DefaultResolver.LookupSRV}, nil
+	case "exec":
+		return execEndpointResolver{path: arg}, nil
+	default:
+		return nil, errors.New("EndpointResolver should be map:<region>=<url>,..., dns:<name> or exec:<path>")
+	}
+}
+
+// regionEndpoints maps the queue regions to their endpoint
+type regionEndpoints map[string]string
+
+func parseRegionEndpoints(value string) (regionEndpoints, error) {
+	endpoints := regionEndpoints{}
+	for _, item := range splitConfigList(value) {
+		region, endpoint, ok := strings.Cut(item, "=")
+		region = strings.TrimSpace(region)
+		if !ok || region == "" {
+			return nil, fmt.Errorf("invalid EndpointResolver entry %q, expected <region>=<url>", item)
+		}
+		if err := validateEndpointURL(strings.TrimSpace(endpoint)); err != nil {
+			return nil, fmt.Errorf("invalid EndpointResolver entry %q: %v", item, err)
+		}
+		endpoints[region] = strings.TrimSpace(endpoint)
+	}
+	return endpoints, nil
+}
+
+func (e regionEndpoints) resolve(_ context.Context, _, region string) (string, error) {
+	if endpoint, ok := e[region]; ok {
+		return endpoint, nil
+	}
+	if endpoint, ok := e["*"]; ok {
+		return endpoint, nil
+	}
+	return "", fmt.Errorf("EndpointResolver has no endpoint for region %s", region)
+}
+
+// dnsEndpointResolver discovers the endpoint from an SRV record, the records
+// are ordered by priority and weight and the first one is used
+type dnsEndpointResolver struct {
+	name      string
+	lookupSRV func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+func (d *dnsEndpointResolver) resolve(ctx context.Context, _, _ string) (string, error) {
+	_, records, err := d.lookupSRV(ctx, "", "", d.name)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up the SRV record of %s: %v", d.name, err)
+	}
+	if len(records) == 0 {
+		return "", fmt.Errorf("no SRV record for %s", d.name)
+	}
+
+	target := strings.TrimSuffix(records[0].Target, ".")
+	return "https://" + net.JoinHostPort(target, fmt.Sprint(records[0].Port)), nil
+}
+
+// execEndpointResolver runs a script printing the endpoint url
+type execEndpointResolver struct {
+	path string
+}
+
+func (e execEndpointResolver) resolve(ctx context.Context, queueURL, region string) (string, error) {
+	cmd := exec.CommandContext(ctx, e.path)
+	cmd.Env = append(os.Environ(), "SQS_QUEUE_URL="+queueURL, "SQS_QUEUE_REGION="+region)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("EndpointResolver script %s failed: %v", e.path, err)
+	}
+
+	endpoint, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	if err := validateEndpointURL(strings.TrimSpace(endpoint)); err != nil {
+		return "", fmt.Errorf("EndpointResolver script %s printed an invalid endpoint: %v", e.path, err)
+	}
+	return strings.TrimSpace(endpoint), nil
+}
+
+// resolveEndpoint resolves the endpoint of an instance
+func resolveEndpoint(resolver endpointResolver, queueURL, region string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), endpointResolveTimeout)
+	defer cancel()
+	return resolver.resolve(ctx, queueURL, region)
+}
+
+func validateEndpointURL(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http or https url", endpoint)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseEndpointResolver(t *testing.T) {
+	tests := []struct {
+		value    string
+		endpoint string
+		wantErr  string
+	}{
+		{"", "", ""},
+		{"", "http://localhost:4566", ""},
+		{"map:us-east-1=https://vpce-1.sqs.us-east-1.vpce.amazonaws.com", "", ""},
+		{"dns:_sqs._tcp.sqs.internal", "", ""},
+		{"exec:/usr/local/bin/sqs-endpoint", "", ""},
+		{"map:us-east-1=https://vpce-1.sqs.us-east-1.vpce.amazonaws.com", "http://localhost:4566", "can't be set together"},
+		{"map:", "", "EndpointResolver should be"},
+		{"consul:sqs", "", "EndpointResolver should be"},
+		{"map:us-east-1", "", "expected <region>=<url>"},
+		{"map:us-east-1=vpce-1.sqs.us-east-1.vpce.amazonaws.com", "", "not an http or https url"},
+	}
+
+	for _, tt := range tests {
+		_, err := parseEndpointResolver(tt.value, tt.endpoint)
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("parseEndpointResolver(%q, %q) unexpected error: %v", tt.value, tt.endpoint, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("parseEndpointResolver(%q, %q) expected an error with %q, got %v", tt.value, tt.endpoint, tt.wantErr, err)
+		}
+	}
+}
+
+func TestRegionEndpoints(t *testing.T) {
+	resolver, err := parseEndpointResolver("map:us-east-1=https://vpce-1.example.com, *=https://vpce-default.example.com", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		region string
+		want   string
+	}{
+		{"us-east-1", "https://vpce-1.example.com"},
+		{"eu-west-1", "https://vpce-default.example.com"},
+	}
+
+	for _, tt := range tests {
+		if got, err := resolveEndpoint(resolver, "", tt.region); err != nil || got != tt.want {
+			t.Errorf("resolve(%q) = %q, %v, want %q", tt.region, got, err, tt.want)
+		}
+	}
+
+	resolver, _ = parseEndpointResolver("map:us-east-1=https://vpce-1.example.com", "")
+	if _, err := resolveEndpoint(resolver, "", "eu-west-1"); err == nil {
+		t.Error("expected an error for a region without endpoint")
+	}
+}
+
+func TestDNSEndpointResolver(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []*net.SRV
+		err     error
+		want    string
+		wantErr bool
+	}{
+		{"first record", []*net.SRV{{Target: "sqs-a.internal.", Port: 8443}, {Target: "sqs-b.internal.", Port: 443}}, nil, "https://sqs-a.internal:8443", false},
+		{"no record", nil, nil, "", true},
+		{"lookup error", nil, errors.New("no such host"), "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := &dnsEndpointResolver{
+				name: "_sqs._tcp.sqs.internal",
+				lookupSRV: func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+					if name != "_sqs._tcp.sqs.internal" {
+						t.Errorf("unexpected lookup of %q", name)
+					}
+					return "", tt.records, tt.err
+				},
+			}
+
+			got, err := resolveEndpoint(resolver, "", "us-east-1")
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("resolve() = %q, %v, want %q, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExecEndpointResolver(t *testing.T) {
+	dir := t.TempDir()
+	script := func(name, body string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0700); err != nil {
+			t.Fatalf("failed to write script: %v", err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"region from env", script("region.sh", `echo "https://sqs-$SQS_QUEUE_REGION.internal"`), "https://sqs-us-east-1.internal", false},
+		{"first line", script("lines.sh", "echo https://sqs.internal; echo ignored"), "https://sqs.internal", false},
+		{"failing script", script("fail.sh", "exit 1"), "", true},
+		{"invalid output", script("invalid.sh", "echo sqs.internal"), "", true},
+		{"missing script", filepath.Join(dir, "missing.sh"), "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveEndpoint(execEndpointResolver{path: tt.path}, "https://sqs.us-east-1.amazonaws.com/123456789/test-queue", "us-east-1")
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("resolve() = %q, %v, want %q, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
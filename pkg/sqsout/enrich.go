@@ -0,0 +1,287 @@
+package sqsout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+)
+
+// defaultHostnameKey is the field AddHostname writes the hostname to when
+// HostnameKey is not set
+const defaultHostnameKey = "hostname"
+
+// newHostnameEnricher returns the processor adding the hostname of the node
+// to every record, nil when AddHostname is not enabled. the consumers of the
+// queue otherwise lose the node a record comes from
+func newHostnameEnricher(addHostnameString, key string) (Processor, error) {
+	addHostname, err := parseBool("AddHostname", addHostnameString)
+	if err != nil {
+		return nil, err
+	}
+	if !addHostname {
+		if key != "" {
+			return nil, errors.New("HostnameKey requires AddHostname to be enabled")
+		}
+		return nil, nil
+	}
+
+	if key == "" {
+		key = defaultHostnameKey
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the hostname for AddHostname: %v", err)
+	}
+
+	return fieldEnricher(key, hostname), nil
+}
+
+// fieldEnricher adds a field with a fixed value to every record. a field the
+// record already has is kept
+func fieldEnricher(key string, value interface{}) Processor {
+	return ProcessorFunc(func(r *Record) bool {
+		if _, ok := r.Fields[key]; !ok {
+			r.Fields[key] = value
+		}
+		return true
+	})
+}
+
+// kubernetesEnvVars are the downward API environment variables of the
+// kubernetes metadata, with the field of the kubernetes filter of fluent bit
+// they fill
+var kubernetesEnvVars = []struct {
+	env   string
+	field string
+}{
+	{"POD_NAME", "pod_name"},
+	{"POD_NAMESPACE", "namespace_name"},
+	{"NODE_NAME", "host"},
+}
+
+// newKubernetesEnricher returns the processor adding the pod name, namespace
+// and node name from the downward API environment variables, nil when
+// AddKubernetesMetadata is not enabled. the fields go in a kubernetes map,
+// as the kubernetes filter writes them, and records which already have one
+// are left as they are
+func newKubernetesEnricher(addKubernetesString string, getenv func(string) string) (Processor, error) {
+	addKubernetes, err := parseBool("AddKubernetesMetadata", addKubernetesString)
+	if err != nil || !addKubernetes {
+		return nil, err
+	}
+
+	metadata := map[string]string{}
+	for _, v := range kubernetesEnvVars {
+		if value := getenv(v.env); value != "" {
+			metadata[v.field] = value
+		}
+	}
+	if len(metadata) == 0 {
+		return nil, errors.New("AddKubernetesMetadata requires the POD_NAME, POD_NAMESPACE or NODE_NAME environment variables, set from the downward API")
+	}
+
+	return ProcessorFunc(func(r *Record) bool {
+		if _, ok := r.Fields["kubernetes"]; ok {
+			return true
+		}
+		// every record gets its own map, processors may change it
+		fields := make(map[interface{}]interface{}, len(metadata))
+		for field, value := range metadata {
+			fields[field] = value
+		}
+		r.Fields["kubernetes"] = fields
+		return true
+	}), nil
+}
+
+// ec2MetadataTimeout bounds the instance identity document request, IMDS
+// doesn't answer outside of EC2
+const ec2MetadataTimeout = 5 * time.Second
+
+// ec2MetadataClient is the part of the IMDS client used by AddEc2Metadata
+type ec2MetadataClient interface {
+	GetInstanceIdentityDocument(ctx context.Context, params *imds.GetInstanceIdentityDocumentInput, optFns ...func(*imds.Options)) (*imds.GetInstanceIdentityDocumentOutput, error)
+}
+
+// newEc2Enricher returns the processor adding the instance id, availability
+// zone and instance type to every record, with the field names of the aws
+// filter of fluent bit. IMDS is queried once, the imds client gets an IMDSv2
+// token before the request
+func newEc2Enricher(client ec2MetadataClient) (Processor, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ec2MetadataTimeout)
+	defer cancel()
+	document, err := client.GetInstanceIdentityDocument(ctx, &imds.GetInstanceIdentityDocumentInput{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the instance identity document for AddEc2Metadata: %v", err)
+	}
+
+	return fieldsEnricher(map[string]string{
+		"ec2_instance_id":   document.InstanceID,
+		"az":                document.AvailabilityZone,
+		"ec2_instance_type": document.InstanceType,
+	}), nil
+}
+
+// fieldsEnricher adds the fields with a non empty value to every record,
+// like fieldEnricher
+func fieldsEnricher(fields map[string]string) Processor {
+	return ProcessorFunc(func(r *Record) bool {
+		for key, value := range fields {
+			if _, ok := r.Fields[key]; !ok && value != "" {
+				r.Fields[key] = value
+			}
+		}
+		return true
+	})
+}
+
+// ecsMetadataTimeout bounds each request to the ECS task metadata endpoint
+const ecsMetadataTimeout = 5 * time.Second
+
+// ecsMetadataURI returns the task metadata endpoint ECS sets in the
+// environment of the containers, empty when not running on ECS
+func ecsMetadataURI(getenv func(string) string) string {
+	if uri := getenv("ECS_CONTAINER_METADATA_URI_V4"); uri != "" {
+		return uri
+	}
+	return getenv("ECS_CONTAINER_METADATA_URI")
+}
+
+// newEcsEnricher returns the processor adding the cluster, task arn and
+// container name to every record, with the field names of the aws output
+// plugins. the metadata endpoint is queried once
+func newEcsEnricher(metadataURI string, client *http.Client) (Processor, error) {
+	var container struct {
+		Name string
+	}
+	if err := getEcsMetadata(client, metadataURI, &container); err != nil {
+		return nil, err
+	}
+	var task struct {
+		Cluster string
+		TaskARN string
+	}
+	if err := getEcsMetadata(client, metadataURI+"/task", &task); err != nil {
+		return nil, err
+	}
+
+	return fieldsEnricher(map[string]string{
+		"ecs_cluster":        task.Cluster,
+		"ecs_task_arn":       task.TaskARN,
+		"ecs_container_name": container.Name,
+	}), nil
+}
+
+func getEcsMetadata(client *http.Client, url string, metadata interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ecsMetadataTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("invalid ECS task metadata endpoint %q: %v", url, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get the ECS task metadata for AddEcsMetadata: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get the ECS task metadata for AddEcsMetadata: %s returned %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(metadata); err != nil {
+		return fmt.Errorf("invalid ECS task metadata from %s: %v", url, err)
+	}
+	return nil
+}
+
+// newEnvFieldsEnricher returns the processor adding the comma separated
+// <field>:<env var> pairs of AddEnvFields to every record, nil when it is not
+// set. the environment variables are read once
+func newEnvFieldsEnricher(value string, lookupEnv func(string) (string, bool)) (Processor, error) {
+	items := splitConfigList(value)
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	fields := map[string]string{}
+	for _, item := range items {
+		field, env, ok := strings.Cut(item, ":")
+		field, env = strings.TrimSpace(field), strings.TrimSpace(env)
+		if !ok || field == "" || env == "" {
+			return nil, fmt.Errorf("invalid AddEnvFields entry %q, expected <field>:<env var>", item)
+		}
+		envValue, ok := lookupEnv(env)
+		if !ok || envValue == "" {
+			return nil, fmt.Errorf("environment variable %s of AddEnvFields is not set", env)
+		}
+		fields[field] = envValue
+	}
+	return fieldsEnricher(fields), nil
+}
+
+// defaultIngestLatencyKey is the field AddIngestLatency writes to when
+// IngestLatencyKey is not set
+const defaultIngestLatencyKey = "ingest_latency_ms"
+
+// newIngestLatencyEnricher returns the processor adding the milliseconds
+// between the timestamp of a record and its flush to every record, nil when
+// AddIngestLatency is not enabled. a record timestamp ahead of the clock of
+// the node counts as no latency
+func newIngestLatencyEnricher(addIngestLatencyString, key string, now func() time.Time) (Processor, error) {
+	addIngestLatency, err := parseBool("AddIngestLatency", addIngestLatencyString)
+	if err != nil {
+		return nil, err
+	}
+	if !addIngestLatency {
+		if key != "" {
+			return nil, errors.New("IngestLatencyKey requires AddIngestLatency to be enabled")
+		}
+		return nil, nil
+	}
+
+	if key == "" {
+		key = defaultIngestLatencyKey
+	}
+	return ProcessorFunc(func(r *Record) bool {
+		if r.Timestamp.IsZero() {
+			return true
+		}
+		r.Fields[key] = max(now().Sub(r.Timestamp).Milliseconds(), 0)
+		return true
+	}), nil
+}
+
+// newLocalityEnricher returns the processor adding the region and the
+// availability zone the plugin runs in to every record. the region comes
+// from AWS_REGION or AWS_DEFAULT_REGION, and from IMDS like the
+// availability zone. outside of EC2 only the region is added
+func newLocalityEnricher(client ec2MetadataClient, getenv func(string) string) (Processor, error) {
+	region := getenv("AWS_REGION")
+	if region == "" {
+		region = getenv("AWS_DEFAULT_REGION")
+	}
+
+	var zone string
+	ctx, cancel := context.WithTimeout(context.Background(), ec2MetadataTimeout)
+	defer cancel()
+	document, err := client.GetInstanceIdentityDocument(ctx, &imds.GetInstanceIdentityDocumentInput{})
+	switch {
+	case err == nil:
+		zone = document.AvailabilityZone
+		if region == "" {
+			region = document.Region
+		}
+	case region == "":
+		return nil, fmt.Errorf("failed to get the region for AddLocality, AWS_REGION is not set and IMDS failed: %v", err)
+	default:
+		writeWarnLog(fmt.Sprintf("failed to get the availability zone for AddLocality, only the region is added: %v", err))
+	}
+
+	return fieldsEnricher(map[string]string{"region": region, "az": zone}), nil
+}
@@ -0,0 +1,369 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+)
+
+func TestParseBool(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    bool
+		wantErr bool
+	}{
+		{"", false, false},
+		{"false", false, false},
+		{"Off", false, false},
+		{"true", true, false},
+		{"ON", true, false},
+		{"yes", false, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseBool("AddHostname", tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseBool(%q) = %v, %v, want %v, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNewHostnameEnricher(t *testing.T) {
+	hostname, _ := os.Hostname()
+
+	tests := []struct {
+		name        string
+		addHostname string
+		key         string
+		record      map[interface{}]interface{}
+		wantKey     string
+		wantValue   interface{}
+		wantNil     bool
+		wantErr     bool
+	}{
+		{"disabled", "", "", nil, "", nil, true, false},
+		{"key without AddHostname", "false", "host", nil, "", nil, true, true},
+		{"invalid value", "1", "", nil, "", nil, true, true},
+		{"default key", "true", "", map[interface{}]interface{}{"log": "hello"}, "hostname", hostname, false, false},
+		{"custom key", "true", "node", map[interface{}]interface{}{"log": "hello"}, "node", hostname, false, false},
+		{"existing field is kept", "true", "", map[interface{}]interface{}{"hostname": "origin"}, "hostname", "origin", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enricher, err := newHostnameEnricher(tt.addHostname, tt.key)
+			if (err != nil) != tt.wantErr || (enricher == nil) != tt.wantNil {
+				t.Fatalf("newHostnameEnricher() = %v, %v, wantNil %v, wantErr %v", enricher, err, tt.wantNil, tt.wantErr)
+			}
+			if enricher == nil {
+				return
+			}
+
+			if !enricher.Process(&Record{Fields: tt.record}) {
+				t.Error("expected the record to be kept")
+			}
+			if got := tt.record[tt.wantKey]; got != tt.wantValue {
+				t.Errorf("expected %s=%v, got %v", tt.wantKey, tt.wantValue, got)
+			}
+		})
+	}
+}
+
+func TestNewKubernetesEnricher(t *testing.T) {
+	downwardAPI := map[string]string{"POD_NAME": "api-7d9f", "POD_NAMESPACE": "payments", "NODE_NAME": "node-1"}
+
+	tests := []struct {
+		name    string
+		add     string
+		env     map[string]string
+		record  map[interface{}]interface{}
+		want    interface{}
+		wantNil bool
+		wantErr bool
+	}{
+		{"disabled", "", downwardAPI, nil, nil, true, false},
+		{"no env vars", "true", nil, nil, nil, true, true},
+		{"all fields", "true", downwardAPI, map[interface{}]interface{}{"log": "hello"},
+			map[interface{}]interface{}{"pod_name": "api-7d9f", "namespace_name": "payments", "host": "node-1"}, false, false},
+		{"only the set env vars", "true", map[string]string{"POD_NAME": "api-7d9f"}, map[interface{}]interface{}{"log": "hello"},
+			map[interface{}]interface{}{"pod_name": "api-7d9f"}, false, false},
+		{"kubernetes filter metadata is kept", "true", downwardAPI, map[interface{}]interface{}{"kubernetes": "from filter"}, "from filter", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enricher, err := newKubernetesEnricher(tt.add, func(key string) string { return tt.env[key] })
+			if (err != nil) != tt.wantErr || (enricher == nil) != tt.wantNil {
+				t.Fatalf("newKubernetesEnricher() = %v, %v, wantNil %v, wantErr %v", enricher, err, tt.wantNil, tt.wantErr)
+			}
+			if enricher == nil {
+				return
+			}
+
+			if !enricher.Process(&Record{Fields: tt.record}) {
+				t.Error("expected the record to be kept")
+			}
+			if got := tt.record["kubernetes"]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected kubernetes=%v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// fakeIMDS implements ec2MetadataClient interface for testing
+type fakeIMDS struct {
+	document imds.InstanceIdentityDocument
+	err      error
+}
+
+func (f *fakeIMDS) GetInstanceIdentityDocument(ctx context.Context, params *imds.GetInstanceIdentityDocumentInput, optFns ...func(*imds.Options)) (*imds.GetInstanceIdentityDocumentOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &imds.GetInstanceIdentityDocumentOutput{InstanceIdentityDocument: f.document}, nil
+}
+
+func TestNewEc2Enricher(t *testing.T) {
+	document := imds.InstanceIdentityDocument{InstanceID: "i-0abc123", AvailabilityZone: "us-east-1a", InstanceType: "m5.large"}
+
+	tests := []struct {
+		name    string
+		client  *fakeIMDS
+		record  map[interface{}]interface{}
+		want    map[interface{}]interface{}
+		wantErr bool
+	}{
+		{"IMDS unavailable", &fakeIMDS{err: errors.New("request canceled, context deadline exceeded")}, nil, nil, true},
+		{"all fields", &fakeIMDS{document: document}, map[interface{}]interface{}{"log": "hello"},
+			map[interface{}]interface{}{"log": "hello", "ec2_instance_id": "i-0abc123", "az": "us-east-1a", "ec2_instance_type": "m5.large"}, false},
+		{"existing field is kept", &fakeIMDS{document: document}, map[interface{}]interface{}{"az": "origin"},
+			map[interface{}]interface{}{"ec2_instance_id": "i-0abc123", "az": "origin", "ec2_instance_type": "m5.large"}, false},
+		{"empty values are skipped", &fakeIMDS{document: imds.InstanceIdentityDocument{InstanceID: "i-0abc123"}}, map[interface{}]interface{}{},
+			map[interface{}]interface{}{"ec2_instance_id": "i-0abc123"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enricher, err := newEc2Enricher(tt.client)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newEc2Enricher() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if enricher == nil {
+				return
+			}
+
+			if !enricher.Process(&Record{Fields: tt.record}) {
+				t.Error("expected the record to be kept")
+			}
+			if !reflect.DeepEqual(tt.record, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, tt.record)
+			}
+		})
+	}
+}
+
+func TestEcsMetadataURI(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"not on ECS", nil, ""},
+		{"v4", map[string]string{"ECS_CONTAINER_METADATA_URI_V4": "http://169.254.170.2/v4/abc", "ECS_CONTAINER_METADATA_URI": "http://169.254.170.2/v3/abc"}, "http://169.254.170.2/v4/abc"},
+		{"v3", map[string]string{"ECS_CONTAINER_METADATA_URI": "http://169.254.170.2/v3/abc"}, "http://169.254.170.2/v3/abc"},
+	}
+
+	for _, tt := range tests {
+		if got := ecsMetadataURI(func(key string) string { return tt.env[key] }); got != tt.want {
+			t.Errorf("%s: ecsMetadataURI() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewEcsEnricher(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v4/abc":
+			fmt.Fprint(w, `{"DockerId":"abc","Name":"app","Image":"app:latest"}`)
+		case "/v4/abc/task":
+			fmt.Fprint(w, `{"Cluster":"arn:aws:ecs:us-east-1:123456789:cluster/prod","TaskARN":"arn:aws:ecs:us-east-1:123456789:task/prod/0123"}`)
+		case "/v4/invalid":
+			fmt.Fprint(w, `not json`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		path    string
+		want    map[interface{}]interface{}
+		wantErr bool
+	}{
+		{"task and container", "/v4/abc", map[interface{}]interface{}{
+			"log":                "hello",
+			"ecs_cluster":        "arn:aws:ecs:us-east-1:123456789:cluster/prod",
+			"ecs_task_arn":       "arn:aws:ecs:us-east-1:123456789:task/prod/0123",
+			"ecs_container_name": "app",
+		}, false},
+		{"unknown container", "/v4/missing", nil, true},
+		{"invalid metadata", "/v4/invalid", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enricher, err := newEcsEnricher(server.URL+tt.path, server.Client())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newEcsEnricher() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if enricher == nil {
+				return
+			}
+
+			record := map[interface{}]interface{}{"log": "hello"}
+			if !enricher.Process(&Record{Fields: record}) {
+				t.Error("expected the record to be kept")
+			}
+			if !reflect.DeepEqual(record, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, record)
+			}
+		})
+	}
+}
+
+func TestNewEnvFieldsEnricher(t *testing.T) {
+	env := map[string]string{"AWS_REGION": "us-east-1", "STACK_NAME": "checkout", "EMPTY": ""}
+	lookupEnv := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		record  map[interface{}]interface{}
+		want    map[interface{}]interface{}
+		wantNil bool
+		wantErr string
+	}{
+		{"not set", "", nil, nil, true, ""},
+		{"fields", "region:AWS_REGION, stack:STACK_NAME", map[interface{}]interface{}{"log": "hello"},
+			map[interface{}]interface{}{"log": "hello", "region": "us-east-1", "stack": "checkout"}, false, ""},
+		{"existing field is kept", "region:AWS_REGION", map[interface{}]interface{}{"region": "eu-west-1"},
+			map[interface{}]interface{}{"region": "eu-west-1"}, false, ""},
+		{"missing env var", "stage:STAGE", nil, nil, true, "STAGE of AddEnvFields is not set"},
+		{"empty env var", "stage:EMPTY", nil, nil, true, "EMPTY of AddEnvFields is not set"},
+		{"invalid entry", "region", nil, nil, true, "expected <field>:<env var>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enricher, err := newEnvFieldsEnricher(tt.value, lookupEnv)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("newEnvFieldsEnricher() error = %v, want %q", err, tt.wantErr)
+			}
+			if (enricher == nil) != tt.wantNil {
+				t.Fatalf("newEnvFieldsEnricher() = %v, wantNil %v", enricher, tt.wantNil)
+			}
+			if enricher == nil {
+				return
+			}
+
+			if !enricher.Process(&Record{Fields: tt.record}) {
+				t.Error("expected the record to be kept")
+			}
+			if !reflect.DeepEqual(tt.record, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, tt.record)
+			}
+		})
+	}
+}
+
+func TestNewIngestLatencyEnricher(t *testing.T) {
+	now := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		add       string
+		key       string
+		timestamp time.Time
+		wantKey   string
+		want      interface{}
+		wantNil   bool
+		wantErr   bool
+	}{
+		{"disabled", "", "", now, "", nil, true, false},
+		{"key without AddIngestLatency", "false", "lag_ms", now, "", nil, true, true},
+		{"default key", "true", "", now.Add(-1500 * time.Millisecond), "ingest_latency_ms", int64(1500), false, false},
+		{"custom key", "true", "lag_ms", now.Add(-time.Minute), "lag_ms", int64(60000), false, false},
+		{"timestamp ahead of the clock", "true", "", now.Add(time.Second), "ingest_latency_ms", int64(0), false, false},
+		{"no timestamp", "true", "", time.Time{}, "ingest_latency_ms", nil, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enricher, err := newIngestLatencyEnricher(tt.add, tt.key, func() time.Time { return now })
+			if (err != nil) != tt.wantErr || (enricher == nil) != tt.wantNil {
+				t.Fatalf("newIngestLatencyEnricher() = %v, %v, wantNil %v, wantErr %v", enricher, err, tt.wantNil, tt.wantErr)
+			}
+			if enricher == nil {
+				return
+			}
+
+			record := map[interface{}]interface{}{"log": "hello"}
+			if !enricher.Process(&Record{Timestamp: tt.timestamp, Fields: record}) {
+				t.Error("expected the record to be kept")
+			}
+			if got := record[tt.wantKey]; got != tt.want {
+				t.Errorf("expected %s=%v, got %v", tt.wantKey, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNewLocalityEnricher(t *testing.T) {
+	document := imds.InstanceIdentityDocument{Region: "us-east-1", AvailabilityZone: "us-east-1a"}
+	unavailable := errors.New("request canceled, context deadline exceeded")
+
+	tests := []struct {
+		name    string
+		client  *fakeIMDS
+		env     map[string]string
+		want    map[interface{}]interface{}
+		wantErr bool
+	}{
+		{"from IMDS", &fakeIMDS{document: document}, nil, map[interface{}]interface{}{"region": "us-east-1", "az": "us-east-1a"}, false},
+		{"region from env", &fakeIMDS{document: document}, map[string]string{"AWS_REGION": "eu-west-1"}, map[interface{}]interface{}{"region": "eu-west-1", "az": "us-east-1a"}, false},
+		{"default region from env", &fakeIMDS{document: document}, map[string]string{"AWS_DEFAULT_REGION": "eu-west-1"}, map[interface{}]interface{}{"region": "eu-west-1", "az": "us-east-1a"}, false},
+		{"outside of EC2", &fakeIMDS{err: unavailable}, map[string]string{"AWS_REGION": "eu-west-1"}, map[interface{}]interface{}{"region": "eu-west-1"}, false},
+		{"no region", &fakeIMDS{err: unavailable}, nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enricher, err := newLocalityEnricher(tt.client, func(key string) string { return tt.env[key] })
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newLocalityEnricher() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if enricher == nil {
+				return
+			}
+
+			record := map[interface{}]interface{}{}
+			enricher.Process(&Record{Fields: record})
+			if !reflect.DeepEqual(record, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, record)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package sqsout
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// entryIDGenerator returns the id of a new batch entry. ids only have to be
+// unique within a batch
+type entryIDGenerator func(messageNumber int64, body string, batch []*types.SendMessageBatchRequestEntry) string
+
+// newEntryIDGenerator returns the generator selected with EntryIdMode:
+// "counter" (the default) numbers the entries of a batch, "uuid" gives every
+// entry a random UUID, unique across concurrent senders, and "hash" derives
+// the id from the message body so a retried message keeps its id
+func newEntryIDGenerator(mode string) (entryIDGenerator, error) {
+	switch mode {
+	case "", "counter":
+		return counterEntryID, nil
+	case "uuid":
+		return uuidEntryID, nil
+	case "hash":
+		return hashEntryID, nil
+	default:
+		return nil, fmt.Errorf("EntryIdMode should be one of: uuid, counter, hash. got %q", mode)
+	}
+}
+
+func counterEntryID(messageNumber int64, _ string, _ []*types.SendMessageBatchRequestEntry) string {
+	return fmt.Sprintf("MessageNumber-%d", messageNumber)
+}
+
+// uuidEntryID returns a random (version 4) UUID
+func uuidEntryID(messageNumber int64, body string, batch []*types.SendMessageBatchRequestEntry) string {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		// the entropy source of the system is not expected to fail, the
+		// counter is still unique within the batch
+		return counterEntryID(messageNumber, body, batch)
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return formatUUID(u)
+}
+
+// formatUUID returns the canonical text form of a UUID
+func formatUUID(u [16]byte) string {
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
+
+// hashEntryID returns the SHA-256 of the body. identical bodies within the
+// same batch get a numbered suffix since batch ids must be distinct
+func hashEntryID(_ int64, body string, batch []*types.SendMessageBatchRequestEntry) string {
+	sum := sha256.Sum256([]byte(body))
+	id := hex.EncodeToString(sum[:])
+
+	candidate := id
+	for n := 1; batchHasID(batch, candidate); n++ {
+		candidate = fmt.Sprintf("%s-%d", id, n)
+	}
+	return candidate
+}
+
+func batchHasID(batch []*types.SendMessageBatchRequestEntry, id string) bool {
+	for _, entry := range batch {
+		if entry.Id != nil && *entry.Id == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package sqsout
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// validEntryID matches the ids SQS accepts in a batch
+var validEntryID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,80}$`)
+
+func TestNewEntryIDGenerator(t *testing.T) {
+	for _, mode := range []string{"", "counter", "uuid", "hash"} {
+		if _, err := newEntryIDGenerator(mode); err != nil {
+			t.Errorf("newEntryIDGenerator(%q) unexpected error: %v", mode, err)
+		}
+	}
+
+	if _, err := newEntryIDGenerator("random"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
+
+func TestEntryIDGenerators(t *testing.T) {
+	t.Run("counter", func(t *testing.T) {
+		if got := counterEntryID(3, "body", nil); got != "MessageNumber-3" {
+			t.Errorf("expected MessageNumber-3, got %s", got)
+		}
+	})
+
+	t.Run("uuid", func(t *testing.T) {
+		uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+		first := uuidEntryID(1, "body", nil)
+		second := uuidEntryID(1, "body", nil)
+
+		if !uuid.MatchString(first) || !validEntryID.MatchString(first) {
+			t.Errorf("expected a version 4 UUID, got %s", first)
+		}
+		if first == second {
+			t.Error("expected distinct UUIDs")
+		}
+	})
+
+	t.Run("hash", func(t *testing.T) {
+		first := hashEntryID(1, "body", nil)
+		if first != hashEntryID(2, "body", nil) {
+			t.Error("expected the same id for the same body")
+		}
+		if first == hashEntryID(1, "other body", nil) {
+			t.Error("expected different ids for different bodies")
+		}
+		if !validEntryID.MatchString(first) {
+			t.Errorf("invalid batch entry id %s", first)
+		}
+
+		batch := []*types.SendMessageBatchRequestEntry{{Id: aws.String(first)}, {Id: aws.String(first + "-1")}}
+		if got := hashEntryID(3, "body", batch); got != first+"-2" {
+			t.Errorf("expected %s-2 for a duplicate body, got %s", first, got)
+		}
+	})
+}
+
+func TestEntryIDModeInBatches(t *testing.T) {
+	resetGlobals()
+	fake := &recordingSQS{}
+	sqsConf := &sqsConfig{
+		queueURL:  "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS:     fake,
+		batchSize: 3,
+		entryID:   hashEntryID,
+	}
+
+	record := map[interface{}]interface{}{"log": []byte("same")}
+	timestamp := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	if err := flushRecords(sqsConf, "app.log", sliceIterator(timestamp, record, record, record)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ids := map[string]bool{}
+	for _, entry := range fake.batches[0].Entries {
+		ids[*entry.Id] = true
+	}
+	if len(ids) != 3 {
+		t.Errorf("expected 3 distinct ids for identical records, got %v", ids)
+	}
+}
@@ -0,0 +1,104 @@
+package sqsout
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// terminalErrorCodes are the error codes sending again doesn't fix, on top of
+// the permission errors
+var terminalErrorCodes = map[string]bool{
+	"InvalidParameterValue":                       true,
+	"InvalidMessageContents":                      true,
+	"InvalidAttributeName":                        true,
+	"InvalidAttributeValue":                       true,
+	"InvalidBatchEntryId":                         true,
+	"BatchEntryIdsNotDistinct":                    true,
+	"BatchRequestTooLong":                         true,
+	"TooManyEntriesInBatchRequest":                true,
+	"EmptyBatchRequest":                           true,
+	"UnsupportedOperation":                        true,
+	"QueueDoesNotExist":                           true,
+	"AWS.SimpleQueueService.NonExistentQueue":     true,
+	"AWS.SimpleQueueService.UnsupportedOperation": true,
+	"InvalidSecurity":                             true,
+	"InvalidClientTokenId":                        true,
+	"UnrecognizedClientException":                 true,
+	"NotFound":                                    true,
+}
+
+// errorClassifier tells the failures worth sending again from the terminal
+// ones. the default table retries the throttling errors and the failures
+// which are not the fault of the sender, the configured patterns override it.
+// a pattern matches an error code, or is contained in the error message for
+// the nonstandard errors of proxies and emulators
+type errorClassifier struct {
+	retryablePatterns []string
+	terminalPatterns  []string
+}
+
+// parseErrorClassifier parses RetryableErrors and TerminalErrors
+func parseErrorClassifier(retryableValue, terminalValue string) (*errorClassifier, error) {
+	c := &errorClassifier{
+		retryablePatterns: splitConfigList(retryableValue),
+		terminalPatterns:  splitConfigList(terminalValue),
+	}
+	for _, pattern := range c.terminalPatterns {
+		if matchesAny(pattern, "", c.retryablePatterns) {
+			return nil, fmt.Errorf("%q is in both RetryableErrors and TerminalErrors", pattern)
+		}
+	}
+	return c, nil
+}
+
+// matchesAny returns true when a pattern is the code or part of the message
+func matchesAny(code, message string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if pattern == code || (message != "" && strings.Contains(message, pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryable classifies a failure, the configured terminal patterns first
+func (c *errorClassifier) retryable(code, message string, senderFault bool) bool {
+	if c != nil {
+		if matchesAny(code, message, c.terminalPatterns) {
+			return false
+		}
+		if matchesAny(code, message, c.retryablePatterns) {
+			return true
+		}
+	}
+
+	if isThrottlingCode(code) {
+		return true
+	}
+	if isPermissionCode(code) || terminalErrorCodes[code] {
+		return false
+	}
+	return !senderFault
+}
+
+// retryableError classifies the failure of a request, the ones without an
+// error code such as the network errors are retryable by default
+func (c *errorClassifier) retryableError(err error) bool {
+	return c.retryable(awsErrorCode(err), err.Error(), false)
+}
+
+// split separates the failed entries of a batch worth sending again from the
+// terminal ones
+func (c *errorClassifier) split(failed []types.BatchResultErrorEntry) (retry, terminal []types.BatchResultErrorEntry) {
+	for _, entry := range failed {
+		if c.retryable(aws.ToString(entry.Code), aws.ToString(entry.Message), entry.SenderFault) {
+			retry = append(retry, entry)
+		} else {
+			terminal = append(terminal, entry)
+		}
+	}
+	return retry, terminal
+}
@@ -0,0 +1,97 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestParseErrorClassifier(t *testing.T) {
+	if _, err := parseErrorClassifier("KmsThrottled, 502 Bad Gateway", "KmsAccessDenied"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := parseErrorClassifier("KmsAccessDenied", "KmsAccessDenied"); err == nil {
+		t.Error("expected a pattern in both lists to be refused")
+	}
+}
+
+func TestErrorClassifier(t *testing.T) {
+	configured, err := parseErrorClassifier("502 Bad Gateway,AccessDenied", "KmsAccessDenied")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		classifier  *errorClassifier
+		code        string
+		message     string
+		senderFault bool
+		want        bool
+	}{
+		{"throttling", nil, "ThrottlingException", "", true, true},
+		{"internal error", nil, "InternalError", "", false, true},
+		{"network error", nil, "", "connection reset by peer", false, true},
+		{"permission", nil, "AccessDenied", "", false, false},
+		{"invalid message", nil, "InvalidMessageContents", "", true, false},
+		{"unknown sender fault", nil, "SomethingWrong", "", true, false},
+		{"kms by default", nil, "KmsAccessDenied", "", false, true},
+		{"kms configured terminal", configured, "KmsAccessDenied", "", false, false},
+		{"proxy message configured retryable", configured, "", "proxy answered 502 Bad Gateway", true, true},
+		{"default overridden retryable", configured, "AccessDenied", "", false, true},
+		{"other codes keep the defaults", configured, "QueueDoesNotExist", "", true, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.classifier.retryable(tt.code, tt.message, tt.senderFault); got != tt.want {
+			t.Errorf("%s: retryable(%q, %q, %v) = %v, want %v", tt.name, tt.code, tt.message, tt.senderFault, got, tt.want)
+		}
+	}
+
+	apiErr := &smithy.GenericAPIError{Code: "KmsAccessDenied", Message: "the key is disabled"}
+	if configured.retryableError(apiErr) {
+		t.Error("expected the code of a request error to be classified")
+	}
+
+	retry, terminal := configured.split([]types.BatchResultErrorEntry{
+		{Id: aws.String("a"), Code: aws.String("InternalError")},
+		{Id: aws.String("b"), Code: aws.String("KmsAccessDenied")},
+	})
+	if len(retry) != 1 || len(terminal) != 1 || aws.ToString(terminal[0].Id) != "b" {
+		t.Errorf("expected the failed entries to be split by class, got %v and %v", retry, terminal)
+	}
+}
+
+func TestRetryPolicySkipsTerminalFailures(t *testing.T) {
+	classes, err := parseErrorClassifier("", "InternalError")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fake := &scriptedSQS{responses: []scriptedResponse{{fail: []string{"a"}}}}
+	policy := retryPolicy{retries: 3, backoff: time.Millisecond, classes: classes}
+	output, err := policy.sendBatch(context.Background(), sqsSink{client: fake, queueURL: "queue"}, "queue", []*types.SendMessageBatchRequestEntry{
+		{Id: aws.String("a"), MessageBody: aws.String("a")},
+		{Id: aws.String("b"), MessageBody: aws.String("b")},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(fake.calls) != 1 || len(output.Failed) != 1 || len(output.Successful) != 1 {
+		t.Errorf("expected the terminal failure not to be sent again, got %d calls and %d failed", len(fake.calls), len(output.Failed))
+	}
+
+	terminalErr := &smithy.GenericAPIError{Code: "AccessDenied"}
+	denied := &scriptedSQS{responses: []scriptedResponse{{err: terminalErr}, {}}}
+	if _, err := policy.sendBatch(context.Background(), sqsSink{client: denied, queueURL: "queue"}, "queue", testBatch(1)); !errors.Is(err, terminalErr) {
+		t.Errorf("expected the terminal request error, got %v", err)
+	}
+	if len(denied.calls) != 1 {
+		t.Errorf("expected a terminal request error not to be retried, got %d calls", len(denied.calls))
+	}
+}
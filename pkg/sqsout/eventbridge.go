@@ -0,0 +1,103 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	// defaultEventSource is the Source of the events when EventSource is not
+	// set
+	defaultEventSource = "fluent-bit"
+	// defaultEventDetailType is the DetailType of the events when
+	// EventDetailType is not set
+	defaultEventDetailType = "log"
+)
+
+// eventBridgeClient is an interface for EventBridge operations to enable
+// testing
+type eventBridgeClient interface {
+	PutEvents(ctx context.Context, input *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+}
+
+// eventBridgeSink puts the batches on an EventBridge bus, every message is
+// the Detail of an event. the AWSTraceHeader system attribute becomes the
+// trace header of the event
+type eventBridgeSink struct {
+	client     eventBridgeClient
+	busName    string
+	source     string
+	detailType string
+}
+
+func validateEventBridgeConfig(queueURL, snsTopicArn, format string) error {
+	if queueURL != "" || snsTopicArn != "" {
+		return errors.New("EventBusName can't be set together with QueueUrl or SnsTopicArn")
+	}
+
+	// the Detail of an event must be a JSON object
+	if strings.EqualFold(format, "logfmt") {
+		return errors.New("EventBusName requires a JSON Format, json or gelf")
+	}
+
+	return nil
+}
+
+func newEventBridgeSink(client eventBridgeClient, busName, source, detailType string) *eventBridgeSink {
+	if source == "" {
+		source = defaultEventSource
+	}
+	if detailType == "" {
+		detailType = defaultEventDetailType
+	}
+	return &eventBridgeSink{client: client, busName: busName, source: source, detailType: detailType}
+}
+
+func (s *eventBridgeSink) SendBatch(ctx context.Context, entries []*types.SendMessageBatchRequestEntry) ([]types.SendMessageBatchResultEntry, []types.BatchResultErrorEntry, error) {
+	events := make([]ebtypes.PutEventsRequestEntry, len(entries))
+	for i, entry := range entries {
+		events[i] = ebtypes.PutEventsRequestEntry{
+			EventBusName: aws.String(s.busName),
+			Source:       aws.String(s.source),
+			DetailType:   aws.String(s.detailType),
+			Detail:       entry.MessageBody,
+		}
+		if traceHeader, ok := entry.MessageSystemAttributes[string(types.MessageSystemAttributeNameForSendsAWSTraceHeader)]; ok {
+			events[i].TraceHeader = traceHeader.StringValue
+		}
+	}
+
+	output, err := s.client.PutEvents(ctx, &eventbridge.PutEventsInput{Entries: events})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the result entries are in the order of the request entries
+	var accepted []types.SendMessageBatchResultEntry
+	var failed []types.BatchResultErrorEntry
+	for i, result := range output.Entries {
+		if i >= len(entries) {
+			break
+		}
+		if result.ErrorCode != nil {
+			failed = append(failed, types.BatchResultErrorEntry{
+				Id:      entries[i].Id,
+				Code:    result.ErrorCode,
+				Message: result.ErrorMessage,
+			})
+			continue
+		}
+		accepted = append(accepted, types.SendMessageBatchResultEntry{
+			Id:        entries[i].Id,
+			MessageId: result.EventId,
+		})
+	}
+
+	return accepted, failed, nil
+}
@@ -0,0 +1,136 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// fakeEventBridge implements eventBridgeClient interface for testing
+type fakeEventBridge struct {
+	inputs []*eventbridge.PutEventsInput
+	output *eventbridge.PutEventsOutput
+	err    error
+}
+
+func (f *fakeEventBridge) PutEvents(ctx context.Context, input *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	f.inputs = append(f.inputs, input)
+	if f.output == nil && f.err == nil {
+		output := &eventbridge.PutEventsOutput{}
+		for i := range input.Entries {
+			output.Entries = append(output.Entries, ebtypes.PutEventsResultEntry{EventId: aws.String(fmt.Sprintf("event-%d", i+1))})
+		}
+		return output, nil
+	}
+	return f.output, f.err
+}
+
+func TestValidateEventBridgeConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		queueURL    string
+		snsTopicArn string
+		format      string
+		wantErr     bool
+	}{
+		{"json", "", "", "", false},
+		{"gelf", "", "", "gelf", false},
+		{"with a queue", "https://sqs.us-east-1.amazonaws.com/123456789/test-queue", "", "", true},
+		{"with a topic", "", "arn:aws:sns:us-east-1:123456789:logs", "", true},
+		{"logfmt", "", "", "logfmt", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateEventBridgeConfig(tt.queueURL, tt.snsTopicArn, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateEventBridgeConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEventBridgeSinkSendBatch(t *testing.T) {
+	entries := []*types.SendMessageBatchRequestEntry{
+		{
+			Id:          aws.String("MessageNumber-1"),
+			MessageBody: aws.String(`{"id":1}`),
+			MessageSystemAttributes: map[string]types.MessageSystemAttributeValue{
+				"AWSTraceHeader": {DataType: aws.String("String"), StringValue: aws.String("Root=1-5759e988-bd862e3fe1be46a994272793")},
+			},
+		},
+		{
+			Id:          aws.String("MessageNumber-2"),
+			MessageBody: aws.String(`{"id":2}`),
+		},
+	}
+
+	t.Run("translates the batch and the result", func(t *testing.T) {
+		fake := &fakeEventBridge{output: &eventbridge.PutEventsOutput{
+			FailedEntryCount: 1,
+			Entries: []ebtypes.PutEventsResultEntry{
+				{EventId: aws.String("event-1")},
+				{ErrorCode: aws.String("InternalFailure"), ErrorMessage: aws.String("try again")},
+			},
+		}}
+		sink := newEventBridgeSink(fake, "logs-bus", "", "")
+
+		accepted, failed, err := sink.SendBatch(context.Background(), entries)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		event := fake.inputs[0].Entries[0]
+		if aws.ToString(event.EventBusName) != "logs-bus" || aws.ToString(event.Source) != "fluent-bit" || aws.ToString(event.DetailType) != "log" || aws.ToString(event.Detail) != `{"id":1}` {
+			t.Errorf("unexpected event: %+v", event)
+		}
+		if aws.ToString(event.TraceHeader) != "Root=1-5759e988-bd862e3fe1be46a994272793" || fake.inputs[0].Entries[1].TraceHeader != nil {
+			t.Errorf("expected the trace header of the first event only, got %+v", fake.inputs[0].Entries)
+		}
+		if len(accepted) != 1 || aws.ToString(accepted[0].Id) != "MessageNumber-1" || aws.ToString(accepted[0].MessageId) != "event-1" {
+			t.Errorf("unexpected accepted entries: %+v", accepted)
+		}
+		if len(failed) != 1 || aws.ToString(failed[0].Id) != "MessageNumber-2" || aws.ToString(failed[0].Code) != "InternalFailure" {
+			t.Errorf("unexpected failed entries: %+v", failed)
+		}
+	})
+
+	t.Run("returns the request error", func(t *testing.T) {
+		sink := newEventBridgeSink(&fakeEventBridge{err: errors.New("EventBridge service error")}, "logs-bus", "app", "app log")
+		if _, _, err := sink.SendBatch(context.Background(), entries); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestFlushRecordsToEventBridge(t *testing.T) {
+	resetGlobals()
+	fake := &fakeEventBridge{}
+	sqsConf := &sqsConfig{
+		queueURL:  "logs-bus",
+		batchSize: 2,
+		sink:      newEventBridgeSink(fake, "logs-bus", "my-app", "app log"),
+	}
+
+	timestamp := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	if err := flushRecords(sqsConf, "app.log", sliceIterator(timestamp, messageRecords(2)...)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(fake.inputs) != 1 || len(fake.inputs[0].Entries) != 2 {
+		t.Fatalf("expected a single PutEvents call with 2 events, got %v", fake.inputs)
+	}
+	if event := fake.inputs[0].Entries[0]; aws.ToString(event.Source) != "my-app" || aws.ToString(event.DetailType) != "app log" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+	if got := sqsConf.stats.messagesSent.Load(); got != 2 {
+		t.Errorf("expected 2 sent messages, got %d", got)
+	}
+}
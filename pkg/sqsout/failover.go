@@ -0,0 +1,169 @@
+package sqsout
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultHealthProbeInterval is how often QueueUrl is probed when
+	// HealthProbeSeconds is not set
+	defaultHealthProbeInterval = 10 * time.Second
+	// defaultFailoverThreshold is how many probes in a row should fail
+	// before the traffic moves to the failover queue
+	defaultFailoverThreshold = 3
+	// defaultFailbackThreshold is how many probes in a row should succeed
+	// before the traffic returns to QueueUrl
+	defaultFailbackThreshold = 5
+)
+
+// validateFailoverQueue checks FailoverQueueUrl. the failover queue is a
+// standard queue, the order of a message group can't span queues. whether
+// QueueUrl is a FIFO queue is only known once its attributes are fetched
+func validateFailoverQueue(failoverQueueURL, queueURL, equivalentQueueURLs string) error {
+	if failoverQueueURL == "" {
+		return nil
+	}
+	if failoverQueueURL == queueURL {
+		return errors.New("FailoverQueueUrl should be another queue than QueueUrl")
+	}
+	if equivalentQueueURLs != "" {
+		return errors.New("FailoverQueueUrl and EquivalentQueueUrls can't be used together, the equivalent queues already take the traffic of an unhealthy queue")
+	}
+	if strings.HasSuffix(failoverQueueURL, ".fifo") {
+		return fmt.Errorf("FailoverQueueUrl %q is a FIFO queue, the order of a message group can't span queues", failoverQueueURL)
+	}
+	return nil
+}
+
+// parseHealthProbeInterval parses HealthProbeSeconds
+func parseHealthProbeInterval(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultHealthProbeInterval, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return 0, errors.New("HealthProbeSeconds should be a positive integer")
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// parseProbeThreshold parses FailoverThreshold and FailbackThreshold, a
+// number of probes in a row
+func parseProbeThreshold(key, value string, defaultValue int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	probes, err := strconv.Atoi(value)
+	if err != nil || probes <= 0 {
+		return 0, fmt.Errorf("%s should be a positive integer", key)
+	}
+	return probes, nil
+}
+
+// queueFailover moves the traffic of QueueUrl to the failover queue while
+// QueueUrl is unhealthy. QueueUrl is probed with a GetQueueAttributes call
+// every interval: after failoverAfter failed probes in a row the batches go
+// to the failover queue, after failbackAfter healthy probes in a row they
+// return to QueueUrl. a single probe never moves the traffic, a queue which
+// answers one probe out of two doesn't flap
+type queueFailover struct {
+	client        queueAttributesClient
+	primary       string
+	failover      string
+	interval      time.Duration
+	failoverAfter int
+	failbackAfter int
+
+	failedOver atomic.Bool
+	// streak is how many probes in a row disagree with the current
+	// destination, only the probes update it
+	streak int
+
+	stopCh   chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newQueueFailover(client queueAttributesClient, primary, failover string, interval time.Duration, failoverAfter, failbackAfter int) *queueFailover {
+	return &queueFailover{
+		client:        client,
+		primary:       primary,
+		failover:      failover,
+		interval:      interval,
+		failoverAfter: failoverAfter,
+		failbackAfter: failbackAfter,
+		stopCh:        make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+// queueURL returns the queue the batches are sent to
+func (f *queueFailover) queueURL() string {
+	if f.failedOver.Load() {
+		return f.failover
+	}
+	return f.primary
+}
+
+// probe checks the health of QueueUrl
+func (f *queueFailover) probe() {
+	f.observe(probeQueue(f.client, f.primary) != unhealthyLatency)
+}
+
+// observe counts the probes in a row which disagree with the destination and
+// moves the traffic once they reach the threshold
+func (f *queueFailover) observe(healthy bool) {
+	failedOver := f.failedOver.Load()
+	if healthy != failedOver {
+		// the probe agrees with the current destination
+		f.streak = 0
+		return
+	}
+
+	f.streak++
+	switch {
+	case !failedOver && f.streak >= f.failoverAfter:
+		f.failedOver.Store(true)
+		f.streak = 0
+		writeWarnLog(fmt.Sprintf("queue %s failed %d health probes in a row, sending to the failover queue %s", queueName(f.primary), f.failoverAfter, queueName(f.failover)))
+	case failedOver && f.streak >= f.failbackAfter:
+		f.failedOver.Store(false)
+		f.streak = 0
+		writeInfoLog(fmt.Sprintf("queue %s passed %d health probes in a row, sending to it again instead of %s", queueName(f.primary), f.failbackAfter, queueName(f.failover)))
+	}
+}
+
+// start probes QueueUrl every interval until stop is called
+func (f *queueFailover) start(owner *sqsConfig) {
+	registerReporter(owner, f)
+
+	go func() {
+		defer close(f.done)
+
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				f.probe()
+			case <-f.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// stop stops the probes, it is safe to call more than once
+func (f *queueFailover) stop() {
+	f.stopOnce.Do(func() {
+		close(f.stopCh)
+	})
+	<-f.done
+}
@@ -0,0 +1,125 @@
+package sqsout
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+func TestValidateFailoverQueue(t *testing.T) {
+	const queueURL = "https://sqs.us-east-1.amazonaws.com/123456789/logs"
+	tests := []struct {
+		name       string
+		failover   string
+		queueURL   string
+		equivalent string
+		wantErr    bool
+	}{
+		{"not set", "", queueURL, "", false},
+		{"standard queues", "https://sqs.us-east-1.amazonaws.com/123456789/logs-failover", queueURL, "", false},
+		{"fifo queues", "https://sqs.us-east-1.amazonaws.com/123456789/logs-failover.fifo", queueURL + ".fifo", "", true},
+		{"QueueUrl itself", queueURL, queueURL, "", true},
+		{"with equivalent queues", "https://sqs.us-east-1.amazonaws.com/123456789/logs-failover", queueURL, "https://sqs.us-east-1.amazonaws.com/123456789/logs-b", true},
+		{"fifo failover of a standard queue", "https://sqs.us-east-1.amazonaws.com/123456789/logs-failover.fifo", queueURL, "", true},
+	}
+
+	for _, tt := range tests {
+		if err := validateFailoverQueue(tt.failover, tt.queueURL, tt.equivalent); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateFailoverQueue() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseProbeThreshold(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"", defaultFailoverThreshold, false},
+		{"1", 1, false},
+		{"0", 0, true},
+		{"three", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseProbeThreshold("FailoverThreshold", tt.value, defaultFailoverThreshold)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseProbeThreshold(%q) = %v, %v, want %v, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	if got, err := parseHealthProbeInterval(""); err != nil || got != defaultHealthProbeInterval {
+		t.Errorf("parseHealthProbeInterval(\"\") = %v, %v, want %v", got, err, defaultHealthProbeInterval)
+	}
+}
+
+func TestQueueFailoverHysteresis(t *testing.T) {
+	resetGlobals()
+	const primary = "https://sqs.us-east-1.amazonaws.com/123456789/logs"
+	const failover = "https://sqs.us-east-1.amazonaws.com/123456789/logs-failover"
+
+	tests := []struct {
+		name   string
+		probes []bool
+		want   string
+	}{
+		{"healthy", []bool{true, true, true}, primary},
+		{"below the failover threshold", []bool{false, false}, primary},
+		{"failed probes are not in a row", []bool{false, false, true, false, false}, primary},
+		{"failover", []bool{false, false, false}, failover},
+		{"below the failback threshold", []bool{false, false, false, true, true}, failover},
+		{"flapping queue stays failed over", []bool{false, false, false, true, false, true, false, true}, failover},
+		{"failback", []bool{false, false, false, true, true, true}, primary},
+	}
+
+	for _, tt := range tests {
+		f := newQueueFailover(&probedQueues{}, primary, failover, time.Minute, 3, 3)
+		captureStdout(func() {
+			for _, healthy := range tt.probes {
+				f.observe(healthy)
+			}
+		})
+		if got := f.queueURL(); got != tt.want {
+			t.Errorf("%s: sending to %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestQueueFailoverProbe(t *testing.T) {
+	resetGlobals()
+	const primary = "https://sqs.us-east-1.amazonaws.com/123456789/logs"
+	const failover = "https://sqs.us-east-1.amazonaws.com/123456789/logs-failover"
+	client := &probedQueues{failing: map[string]bool{primary: true}}
+	f := newQueueFailover(client, primary, failover, time.Minute, 2, 1)
+	sqsConf := &sqsConfig{queueURL: primary, mySQS: &recordingSQS{}, failover: f}
+
+	captureStdout(func() {
+		f.probe()
+		f.probe()
+	})
+	if got := sqsConf.activeQueueURL(); got != failover {
+		t.Fatalf("expected the traffic to move to the failover queue, got %s", got)
+	}
+	for _, probed := range client.probed {
+		if probed != primary {
+			t.Errorf("expected only QueueUrl to be probed, got %s", probed)
+		}
+	}
+
+	sqsConf.mySQS = &recordingSQS{err: &smithy.GenericAPIError{Code: "AccessDenied"}}
+	var batchErr *batchError
+	captureStdout(func() {
+		if err := deliverBatch(sqsConf, testBatch(1)); !errors.As(err, &batchErr) || batchErr.queueURL != failover {
+			t.Errorf("expected a batchError of the failover queue, got %#v", err)
+		}
+	})
+
+	client.failing[primary] = false
+	captureStdout(f.probe)
+	if got := sqsConf.activeQueueURL(); got != primary {
+		t.Errorf("expected the traffic to return to QueueUrl once it recovered, got %s", got)
+	}
+}
@@ -0,0 +1,91 @@
+package sqsout
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// failurePreviewBytes is how much of the body of a failed message is logged
+const failurePreviewBytes = 256
+
+// failureCounts counts the failed batch entries by error code. it is safe for
+// concurrent use
+type failureCounts struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func (f *failureCounts) add(code string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.counts == nil {
+		f.counts = map[string]int64{}
+	}
+	f.counts[code]++
+}
+
+func (f *failureCounts) snapshot() map[string]int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	counts := make(map[string]int64, len(f.counts))
+	for code, count := range f.counts {
+		counts[code] = count
+	}
+	return counts
+}
+
+// String formats the counts sorted by code, e.g. "InternalError=2 KMS.DisabledException=1"
+func (f *failureCounts) String() string {
+	counts := f.snapshot()
+	codes := make([]string, 0, len(counts))
+	for code := range counts {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	parts := make([]string, len(codes))
+	for i, code := range codes {
+		parts[i] = fmt.Sprintf("%s=%d", code, counts[code])
+	}
+	return strings.Join(parts, " ")
+}
+
+// logBatchFailures logs an error for every entry the queue rejected, with
+// the reason SQS gave and the start of the message body
+func logBatchFailures(queueURL, batchID string, records []*types.SendMessageBatchRequestEntry, output *sqs.SendMessageBatchOutput) {
+	bodies := make(map[string]string, len(records))
+	for _, entry := range records {
+		bodies[aws.ToString(entry.Id)] = aws.ToString(entry.MessageBody)
+	}
+
+	for _, failed := range output.Failed {
+		id := aws.ToString(failed.Id)
+		writeErrorLog(&batchError{
+			queueURL: queueURL,
+			batchID:  batchID,
+			code:     aws.ToString(failed.Code),
+			err: fmt.Errorf("message %s of batch %s failed on %s: code=%s sender_fault=%t message=%q body=%q",
+				id, batchID, queueName(queueURL), aws.ToString(failed.Code), failed.SenderFault, aws.ToString(failed.Message), bodyPreview(bodies[id])),
+		})
+	}
+}
+
+// bodyPreview truncates a message body to failurePreviewBytes, without
+// splitting a multi-byte character
+func bodyPreview(body string) string {
+	if len(body) <= failurePreviewBytes {
+		return body
+	}
+	cut := failurePreviewBytes
+	for cut > 0 && !utf8.RuneStart(body[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s... (%d bytes)", body[:cut], len(body))
+}
@@ -0,0 +1,85 @@
+package sqsout
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestBodyPreview(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"short body", "abc", "abc"},
+		{"body at the limit", strings.Repeat("a", failurePreviewBytes), strings.Repeat("a", failurePreviewBytes)},
+		{"long body", strings.Repeat("a", 300), strings.Repeat("a", failurePreviewBytes) + "... (300 bytes)"},
+		{"multi-byte character at the cut", strings.Repeat("a", failurePreviewBytes-1) + "é", strings.Repeat("a", failurePreviewBytes-1) + "... (257 bytes)"},
+	}
+
+	for _, tt := range tests {
+		if got := bodyPreview(tt.body); got != tt.want {
+			t.Errorf("%s: bodyPreview() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFailureCounts(t *testing.T) {
+	var counts failureCounts
+	if got := counts.String(); got != "" {
+		t.Errorf("expected no failures, got %q", got)
+	}
+
+	counts.add("InternalError")
+	counts.add("AWS.SimpleQueueService.BatchEntryIdsNotDistinct")
+	counts.add("InternalError")
+
+	if got, want := counts.String(), "AWS.SimpleQueueService.BatchEntryIdsNotDistinct=1 InternalError=2"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestSendBatchToSqsLogsFailedEntries(t *testing.T) {
+	resetGlobals()
+	sqsConf := &sqsConfig{
+		queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS: &fakeSQS{output: &sqs.SendMessageBatchOutput{
+			Successful: []types.SendMessageBatchResultEntry{{Id: aws.String("msg-1")}},
+			Failed: []types.BatchResultErrorEntry{
+				{Id: aws.String("msg-2"), Code: aws.String("InvalidMessageContents"), Message: aws.String("invalid characters"), SenderFault: true},
+				{Id: aws.String("msg-3"), Code: aws.String("InternalError")},
+			},
+		}},
+	}
+	records := []*types.SendMessageBatchRequestEntry{
+		{Id: aws.String("msg-1"), MessageBody: aws.String("ok")},
+		{Id: aws.String("msg-2"), MessageBody: aws.String(`{"log":"bad"}`)},
+		{Id: aws.String("msg-3"), MessageBody: aws.String(strings.Repeat("x", 1000))},
+	}
+
+	output := captureStdout(func() {
+		if err := sendBatchToSqs(sqsConf, records); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	for _, want := range []string{
+		`message msg-2 of batch 1 failed on test-queue: code=InvalidMessageContents sender_fault=true message="invalid characters" body="{\"log\":\"bad\"}"`,
+		`message msg-3 of batch 1 failed on test-queue: code=InternalError sender_fault=false message="" body="` + strings.Repeat("x", failurePreviewBytes) + `... (1000 bytes)"`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in the logs, got %q", want, output)
+		}
+	}
+	if strings.Contains(output, "msg-1") {
+		t.Errorf("expected no log of the successful entry, got %q", output)
+	}
+
+	if got, want := sqsConf.stats.failureCodes.String(), "InternalError=1 InvalidMessageContents=1"; got != want {
+		t.Errorf("failure codes = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,308 @@
+package sqsout
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// recordEncoder serializes a decoded record and its formatted timestamp into
+// the message body
+type recordEncoder func(timestamp string, record map[interface{}]interface{}) (string, error)
+
+// newRecordEncoder returns the encoder selected with JsonEncoder. "fast" (the
+// default) streams the fields of the decoded record straight into a pooled
+// buffer, "standard" converts the record to a map and uses encoding/json.
+// both produce the same output, except that "fast" also supports nested
+// msgpack maps and writes nested byte slices as strings. without escapeHTML
+// <, > and & are written as is instead of \u003c, \u003e and \u0026
+func newRecordEncoder(name string, escapeHTML bool) (recordEncoder, error) {
+	switch name {
+	case "standard":
+		if !escapeHTML {
+			return encodeStandardNoHTMLEscape, nil
+		}
+		return encodeStandard, nil
+	case "", "fast":
+		if !escapeHTML {
+			return fastJSON{}.encode, nil
+		}
+		return encodeFast, nil
+	default:
+		return nil, fmt.Errorf("JsonEncoder should be one of: standard, fast. got %q", name)
+	}
+}
+
+func encodeStandard(timestamp string, record map[interface{}]interface{}) (string, error) {
+	m := make(map[string]interface{}, len(record)+1)
+	m["@timestamp"] = timestamp
+	copyRecordFields(m, record)
+	return marshalJSON(m)
+}
+
+func encodeStandardNoHTMLEscape(timestamp string, record map[interface{}]interface{}) (string, error) {
+	m := make(map[string]interface{}, len(record)+1)
+	m["@timestamp"] = timestamp
+	copyRecordFields(m, record)
+	return marshalJSONNoHTMLEscape(m)
+}
+
+// jsonField is a record field with its key converted to a string
+type jsonField struct {
+	key   string
+	value interface{}
+}
+
+var fastBufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 1024)
+		return &b
+	},
+}
+
+var fastFieldsPool = sync.Pool{
+	New: func() interface{} {
+		fields := make([]jsonField, 0, 32)
+		return &fields
+	},
+}
+
+// fastJSON writes JSON the way encoding/json does, with its HTML escaping
+// when escapeHTML is set
+type fastJSON struct {
+	escapeHTML bool
+}
+
+// htmlEscapingJSON is the default fastJSON
+var htmlEscapingJSON = fastJSON{escapeHTML: true}
+
+// encodeFast is the encode of htmlEscapingJSON
+func encodeFast(timestamp string, record map[interface{}]interface{}) (string, error) {
+	return htmlEscapingJSON.encode(timestamp, record)
+}
+
+// encode writes the record object directly into a pooled buffer, without an
+// intermediate map. the timestamp is added unless the record has its own
+// "@timestamp" field and keys are sorted like encoding/json does
+func (j fastJSON) encode(timestamp string, record map[interface{}]interface{}) (string, error) {
+	bufPtr := fastBufferPool.Get().(*[]byte)
+	buf := (*bufPtr)[:0]
+
+	defer func() {
+		*bufPtr = buf[:0]
+		fastBufferPool.Put(bufPtr)
+	}()
+
+	var err error
+	if _, ok := record["@timestamp"]; ok {
+		buf, err = j.appendObject(buf, record)
+	} else {
+		buf, err = j.appendObjectWith(buf, record, jsonField{key: "@timestamp", value: timestamp})
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return string(buf), nil
+}
+
+func (j fastJSON) appendObject(buf []byte, object map[interface{}]interface{}) ([]byte, error) {
+	return j.appendObjectWith(buf, object)
+}
+
+// appendObjectWith writes a msgpack map, plus the extra fields, as a JSON
+// object with sorted keys
+func (j fastJSON) appendObjectWith(buf []byte, object map[interface{}]interface{}, extra ...jsonField) ([]byte, error) {
+	fieldsPtr := fastFieldsPool.Get().(*[]jsonField)
+	fields := append((*fieldsPtr)[:0], extra...)
+	for k, v := range object {
+		fields = append(fields, jsonField{key: fieldString(k), value: v})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].key < fields[j].key })
+
+	defer func() {
+		for i := range fields {
+			fields[i] = jsonField{}
+		}
+		*fieldsPtr = fields[:0]
+		fastFieldsPool.Put(fieldsPtr)
+	}()
+
+	buf = append(buf, '{')
+	for i, field := range fields {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = j.appendString(buf, field.key)
+		buf = append(buf, ':')
+
+		var err error
+		if buf, err = j.appendValue(buf, field.value); err != nil {
+			return buf, err
+		}
+	}
+	return append(buf, '}'), nil
+}
+
+// appendJSONValue writes a record value as JSON, with the HTML escaping
+func appendJSONValue(buf []byte, value interface{}) ([]byte, error) {
+	return htmlEscapingJSON.appendValue(buf, value)
+}
+
+func (j fastJSON) appendValue(buf []byte, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(buf, "null"...), nil
+	case string:
+		return j.appendString(buf, v), nil
+	case []byte:
+		// prevent encoding to base64
+		return j.appendBytes(buf, v), nil
+	case map[interface{}]interface{}:
+		return j.appendObject(buf, v)
+	case []interface{}:
+		buf = append(buf, '[')
+		for i, item := range v {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			var err error
+			if buf, err = j.appendValue(buf, item); err != nil {
+				return buf, err
+			}
+		}
+		return append(buf, ']'), nil
+	case bool:
+		return strconv.AppendBool(buf, v), nil
+	case int:
+		return strconv.AppendInt(buf, int64(v), 10), nil
+	case int8:
+		return strconv.AppendInt(buf, int64(v), 10), nil
+	case int16:
+		return strconv.AppendInt(buf, int64(v), 10), nil
+	case int32:
+		return strconv.AppendInt(buf, int64(v), 10), nil
+	case int64:
+		return strconv.AppendInt(buf, v, 10), nil
+	case uint:
+		return strconv.AppendUint(buf, uint64(v), 10), nil
+	case uint8:
+		return strconv.AppendUint(buf, uint64(v), 10), nil
+	case uint16:
+		return strconv.AppendUint(buf, uint64(v), 10), nil
+	case uint32:
+		return strconv.AppendUint(buf, uint64(v), 10), nil
+	case uint64:
+		return strconv.AppendUint(buf, v, 10), nil
+	case float32:
+		return appendJSONFloat(buf, float64(v), 32)
+	case float64:
+		return appendJSONFloat(buf, v, 64)
+	default:
+		marshal := marshalJSON
+		if !j.escapeHTML {
+			marshal = marshalJSONNoHTMLEscape
+		}
+		nested, err := marshal(v)
+		if err != nil {
+			return buf, err
+		}
+		return append(buf, nested...), nil
+	}
+}
+
+// appendJSONFloat formats floats the way encoding/json does
+func appendJSONFloat(buf []byte, f float64, bits int) ([]byte, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return buf, errors.New("json: unsupported value: " + strconv.FormatFloat(f, 'g', -1, bits))
+	}
+
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+
+	buf = strconv.AppendFloat(buf, f, format, -1, bits)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(buf)
+		if n >= 4 && buf[n-4] == 'e' && buf[n-3] == '-' && buf[n-2] == '0' {
+			buf[n-2] = buf[n-1]
+			buf = buf[:n-1]
+		}
+	}
+
+	return buf, nil
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendString quotes s the way encoding/json does, including the
+// replacement of invalid UTF-8
+func (j fastJSON) appendString(buf []byte, s string) []byte {
+	return appendJSONText(buf, s, utf8.DecodeRuneInString, j.escapeHTML)
+}
+
+// appendBytes quotes a byte slice like appendString, without converting it
+// to a string first
+func (j fastJSON) appendBytes(buf []byte, s []byte) []byte {
+	return appendJSONText(buf, s, utf8.DecodeRune, j.escapeHTML)
+}
+
+func appendJSONText[T string | []byte](buf []byte, s T, decodeRune func(T) (rune, int), escapeHTML bool) []byte {
+	buf = append(buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && (!escapeHTML || b != '<' && b != '>' && b != '&') {
+				i++
+				continue
+			}
+			buf = append(buf, s[start:i]...)
+			switch b {
+			case '\\', '"':
+				buf = append(buf, '\\', b)
+			case '\b':
+				buf = append(buf, '\\', 'b')
+			case '\f':
+				buf = append(buf, '\\', 'f')
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			default:
+				buf = append(buf, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		c, size := decodeRune(s[i:])
+		if c == utf8.RuneError && size == 1 {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		if c == '\u2028' || c == '\u2029' {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, '\\', 'u', '2', '0', '2', hexDigits[c&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	buf = append(buf, s[start:]...)
+	return append(buf, '"')
+}
@@ -0,0 +1,132 @@
+package sqsout
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewRecordEncoder(t *testing.T) {
+	for _, name := range []string{"", "standard", "fast"} {
+		if encoder, err := newRecordEncoder(name, true); err != nil || encoder == nil {
+			t.Errorf("newRecordEncoder(%q) = %v, %v", name, encoder, err)
+		}
+	}
+
+	if _, err := newRecordEncoder("jsoniter", true); err == nil {
+		t.Error("expected error for unknown encoder")
+	}
+}
+
+func TestEncodeFastMatchesStandard(t *testing.T) {
+	records := []map[interface{}]interface{}{
+		{},
+		{"message": "hello world", "level": "info"},
+		{"escapes": "quote \" backslash \\ newline \n tab \t control \x01 html <a>&</a>"},
+		{"unicode": "héllo 世界 \u2028 \u2029 🎉", "invalid": string([]byte{0xff, 'a', 0xc3})},
+		{"bytes": []byte("binary data"), "nil": nil, "bool": true, "false": false},
+		{"int": 42, "int8": int8(-8), "int16": int16(16), "int32": int32(-32), "int64": int64(1) << 62},
+		{"uint": uint(1), "uint8": uint8(8), "uint16": uint16(16), "uint32": uint32(32), "uint64": uint64(math.MaxUint64)},
+		{"float": 3.14, "zero": 0.0, "small": 1e-7, "big": 1e21, "negative": -2.5e-10, "whole": 100.0},
+		{"float32": float32(3.14), "small32": float32(1e-7), "big32": float32(1e22)},
+		{"nested": map[string]interface{}{"a": []interface{}{1, "two", nil}}, "list": []interface{}{"x", 2.5}},
+		{"@timestamp": "overridden", "a": "before", "z": "after"},
+		{"Upper": 1, "lower": 2, "_under": 3, "123": 4},
+	}
+
+	for _, record := range records {
+		want, err := encodeStandard("2024-01-15T10:30:00Z", record)
+		if err != nil {
+			t.Fatalf("encodeStandard failed: %v", err)
+		}
+
+		got, err := encodeFast("2024-01-15T10:30:00Z", record)
+		if err != nil {
+			t.Fatalf("encodeFast(%v) failed: %v", record, err)
+		}
+		if got != want {
+			t.Errorf("encodeFast mismatch\n got: %s\nwant: %s", got, want)
+		}
+	}
+}
+
+func TestEncodeFastNestedMsgpackValues(t *testing.T) {
+	record := map[interface{}]interface{}{
+		"log": []byte("hello"),
+		"kubernetes": map[interface{}]interface{}{
+			"pod_name": []byte("web-1"),
+			"labels":   map[interface{}]interface{}{"app": []byte("web")},
+		},
+		"lines": []interface{}{[]byte("first"), []byte("second")},
+	}
+
+	got, err := encodeFast("2024-01-15T10:30:00Z", record)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := `{"@timestamp":"2024-01-15T10:30:00Z","kubernetes":{"labels":{"app":"web"},"pod_name":"web-1"},"lines":["first","second"],"log":"hello"}`
+	if got != want {
+		t.Errorf("encodeFast() = %s, want %s", got, want)
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(got), &m); err != nil {
+		t.Errorf("output is not valid JSON: %v", err)
+	}
+}
+
+func TestEncodeFastUnsupportedValues(t *testing.T) {
+	for _, value := range []interface{}{math.NaN(), math.Inf(-1), float32(math.Inf(1)), map[interface{}]interface{}{"x": math.NaN()}} {
+		if _, err := encodeFast("2024-01-15T10:30:00Z", map[interface{}]interface{}{"value": value}); err == nil {
+			t.Errorf("expected error for %v", value)
+		}
+	}
+}
+
+func TestEncodeRecordDefaultsToFastEncoder(t *testing.T) {
+	timestamp := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	record := map[interface{}]interface{}{
+		"message": []byte("hello <world>"),
+		"count":   42,
+	}
+
+	want, err := createRecordString(timestamp, "test.tag", record)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := encodeRecord(nil, timestamp, "test.tag", record)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != want {
+		t.Errorf("encodeRecord() = %s, want %s", got, want)
+	}
+}
+
+func TestEncodeWithoutHTMLEscape(t *testing.T) {
+	record := map[interface{}]interface{}{
+		"log":    []byte(`<a href="/x?a=1&b=2">link</a>`),
+		"nested": map[interface{}]interface{}{"query": "a < b && c > d"},
+		"quote":  "\" \\ \n",
+	}
+	want := `{"@timestamp":"2024-01-15T10:30:00Z","log":"<a href=\"/x?a=1&b=2\">link</a>","nested":{"query":"a < b && c > d"},"quote":"\" \\ \n"}`
+
+	for _, name := range []string{"fast", "standard"} {
+		encoder, err := newRecordEncoder(name, false)
+		if err != nil {
+			t.Fatalf("newRecordEncoder(%q) failed: %v", name, err)
+		}
+		if got, err := encoder("2024-01-15T10:30:00Z", record); err != nil || got != want {
+			t.Errorf("%s encoder = %s, %v, want %s", name, got, err, want)
+		}
+	}
+
+	escaped, _ := encodeFast("2024-01-15T10:30:00Z", record)
+	if strings.Contains(escaped, `<a href`) {
+		t.Errorf("expected the default encoder to escape HTML, got %s", escaped)
+	}
+}
@@ -0,0 +1,162 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+)
+
+// injectedFaultMessage is the message of the injected errors, so they can be
+// told from real ones in the logs
+const injectedFaultMessage = "injected fault"
+
+// fault is what happens to a SendMessageBatch call
+type fault struct {
+	// latency is added before the call
+	latency time.Duration
+	// err fails the whole request without calling the queue
+	err error
+	// failIDs are the entries reported as failed with failCode, the others
+	// are sent
+	failIDs  []string
+	failCode string
+}
+
+// faultInjector decides the fault of every SendMessageBatch call. the chaos
+// mode injects random faults, tests can script their own
+type faultInjector interface {
+	inject(input *sqs.SendMessageBatchInput) fault
+}
+
+// faultySQS implements sqsClient interface and applies the faults of the
+// injector to the calls of the client it wraps, so the retries and the
+// failure accounting can be exercised against a real queue
+type faultySQS struct {
+	client   sqsClient
+	injector faultInjector
+	sleep    func(time.Duration)
+}
+
+func newFaultySQS(client sqsClient, injector faultInjector) *faultySQS {
+	return &faultySQS{client: client, injector: injector, sleep: time.Sleep}
+}
+
+func (c *faultySQS) SendMessageBatch(ctx context.Context, input *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	f := c.injector.inject(input)
+	if f.latency > 0 {
+		c.sleep(f.latency)
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	if len(f.failIDs) == 0 {
+		return c.client.SendMessageBatch(ctx, input, optFns...)
+	}
+
+	failing := make(map[string]bool, len(f.failIDs))
+	for _, id := range f.failIDs {
+		failing[id] = true
+	}
+
+	var remaining []types.SendMessageBatchRequestEntry
+	for _, entry := range input.Entries {
+		if !failing[aws.ToString(entry.Id)] {
+			remaining = append(remaining, entry)
+		}
+	}
+
+	output := &sqs.SendMessageBatchOutput{}
+	if len(remaining) > 0 {
+		sent := *input
+		sent.Entries = remaining
+		result, err := c.client.SendMessageBatch(ctx, &sent, optFns...)
+		if err != nil {
+			return nil, err
+		}
+		output.Successful = result.Successful
+		output.Failed = result.Failed
+	}
+
+	for _, id := range f.failIDs {
+		output.Failed = append(output.Failed, types.BatchResultErrorEntry{
+			Id:      aws.String(id),
+			Code:    aws.String(f.failCode),
+			Message: aws.String(injectedFaultMessage),
+		})
+	}
+	return output, nil
+}
+
+// chaosFaults are the random faults of the chaos mode: a fixed latency, a
+// share of the requests throttled and a share of the entries failed
+type chaosFaults struct {
+	latency         time.Duration
+	throttlePercent float64
+	failPercent     float64
+}
+
+// newChaosFaults returns nil when the chaos mode is not configured
+func newChaosFaults(latencyString, throttlePercentString, failPercentString string) (*chaosFaults, error) {
+	if latencyString == "" && throttlePercentString == "" && failPercentString == "" {
+		return nil, nil
+	}
+
+	chaos := &chaosFaults{}
+	if latencyString != "" {
+		latency, err := time.ParseDuration(latencyString)
+		if err != nil || latency < 0 {
+			return nil, errors.New("ChaosLatency should be a duration, e.g. 200ms")
+		}
+		chaos.latency = latency
+	}
+
+	var err error
+	if chaos.throttlePercent, err = parseChaosPercent("ChaosThrottlePercent", throttlePercentString); err != nil {
+		return nil, err
+	}
+	if chaos.failPercent, err = parseChaosPercent("ChaosFailurePercent", failPercentString); err != nil {
+		return nil, err
+	}
+	return chaos, nil
+}
+
+func parseChaosPercent(key, value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	percent, err := strconv.ParseFloat(value, 64)
+	if err != nil || percent < 0 || percent > 100 {
+		return 0, fmt.Errorf("%s should be a number between 0 and 100", key)
+	}
+	return percent, nil
+}
+
+func (c *chaosFaults) inject(input *sqs.SendMessageBatchInput) fault {
+	f := fault{latency: c.latency}
+
+	if c.throttlePercent > 0 && randFloat64()*100 < c.throttlePercent {
+		f.err = &smithy.GenericAPIError{Code: "ThrottlingException", Message: injectedFaultMessage}
+		return f
+	}
+
+	if c.failPercent > 0 {
+		for _, entry := range input.Entries {
+			if randFloat64()*100 < c.failPercent {
+				f.failIDs = append(f.failIDs, aws.ToString(entry.Id))
+			}
+		}
+		f.failCode = "InternalError"
+	}
+	return f
+}
+
+func (c *chaosFaults) String() string {
+	return fmt.Sprintf("latency=%s throttled=%g%% failed=%g%%", c.latency, c.throttlePercent, c.failPercent)
+}
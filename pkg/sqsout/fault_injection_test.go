@@ -0,0 +1,160 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// scriptedFaults implements faultInjector interface and returns the next
+// scripted fault for every call, no fault once the script is over
+type scriptedFaults struct {
+	faults []fault
+}
+
+func (s *scriptedFaults) inject(input *sqs.SendMessageBatchInput) fault {
+	if len(s.faults) == 0 {
+		return fault{}
+	}
+	f := s.faults[0]
+	s.faults = s.faults[1:]
+	return f
+}
+
+func TestNewChaosFaults(t *testing.T) {
+	tests := []struct {
+		latency  string
+		throttle string
+		failure  string
+		want     *chaosFaults
+		wantErr  bool
+	}{
+		{"", "", "", nil, false},
+		{"200ms", "", "", &chaosFaults{latency: 200 * time.Millisecond}, false},
+		{"", "10", "5.5", &chaosFaults{throttlePercent: 10, failPercent: 5.5}, false},
+		{"slow", "", "", nil, true},
+		{"-1s", "", "", nil, true},
+		{"", "101", "", nil, true},
+		{"", "", "-1", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := newChaosFaults(tt.latency, tt.throttle, tt.failure)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newChaosFaults(%q, %q, %q) error = %v, wantErr %v", tt.latency, tt.throttle, tt.failure, err, tt.wantErr)
+			continue
+		}
+		if tt.want == nil && got != nil || tt.want != nil && (got == nil || *got != *tt.want) {
+			t.Errorf("newChaosFaults(%q, %q, %q) = %v, want %v", tt.latency, tt.throttle, tt.failure, got, tt.want)
+		}
+	}
+}
+
+func TestFaultySQS(t *testing.T) {
+	entries := func() []types.SendMessageBatchRequestEntry {
+		return []types.SendMessageBatchRequestEntry{
+			{Id: aws.String("msg-1"), MessageBody: aws.String("a")},
+			{Id: aws.String("msg-2"), MessageBody: aws.String("b")},
+		}
+	}
+
+	t.Run("latency is added before the call", func(t *testing.T) {
+		var slept time.Duration
+		fake := &recordingSQS{}
+		client := newFaultySQS(fake, &scriptedFaults{faults: []fault{{latency: time.Second}}})
+		client.sleep = func(d time.Duration) { slept += d }
+
+		if _, err := client.SendMessageBatch(context.Background(), &sqs.SendMessageBatchInput{Entries: entries()}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if slept != time.Second || len(fake.batches) != 1 {
+			t.Errorf("expected a 1s delay before the call, slept %s with %d calls", slept, len(fake.batches))
+		}
+	})
+
+	t.Run("request errors don't reach the queue", func(t *testing.T) {
+		fake := &recordingSQS{}
+		injected := errors.New("injected")
+		client := newFaultySQS(fake, &scriptedFaults{faults: []fault{{err: injected}}})
+
+		if _, err := client.SendMessageBatch(context.Background(), &sqs.SendMessageBatchInput{Entries: entries()}); err != injected {
+			t.Errorf("expected the injected error, got %v", err)
+		}
+		if len(fake.batches) != 0 {
+			t.Errorf("expected no call to the queue, got %d", len(fake.batches))
+		}
+	})
+
+	t.Run("failed entries are not sent", func(t *testing.T) {
+		fake := &scriptedSQS{}
+		client := newFaultySQS(fake, &scriptedFaults{faults: []fault{{failIDs: []string{"msg-2"}, failCode: "InternalError"}}})
+
+		output, err := client.SendMessageBatch(context.Background(), &sqs.SendMessageBatchInput{Entries: entries()})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(fake.calls) != 1 || len(fake.calls[0]) != 1 || fake.calls[0][0] != "msg-1" {
+			t.Errorf("expected only msg-1 to be sent, got %v", fake.calls)
+		}
+		if len(output.Successful) != 1 || len(output.Failed) != 1 || *output.Failed[0].Id != "msg-2" || *output.Failed[0].Code != "InternalError" {
+			t.Errorf("unexpected output: %v", output)
+		}
+	})
+}
+
+func TestChaosFaultsWithRetries(t *testing.T) {
+	resetGlobals()
+	defer func(f func() float64) { randFloat64 = f }(randFloat64)
+
+	// the first request is throttled, then msg-1 fails once
+	draws := []float64{0.05, 0.5, 0.01, 0.5, 0.5, 0.5}
+	randFloat64 = func() float64 {
+		if len(draws) == 0 {
+			return 0.99
+		}
+		d := draws[0]
+		draws = draws[1:]
+		return d
+	}
+
+	fake := &scriptedSQS{}
+	sqsConf := &sqsConfig{
+		queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS:    newFaultySQS(fake, &chaosFaults{throttlePercent: 10, failPercent: 10}),
+		retry:    retryPolicy{retries: 3},
+	}
+	sqsConf.retry.retried = &sqsConf.stats.messagesRetried
+
+	records := []*types.SendMessageBatchRequestEntry{
+		{Id: aws.String("msg-1"), MessageBody: aws.String("a")},
+		{Id: aws.String("msg-2"), MessageBody: aws.String("b")},
+	}
+	if err := sendBatchToSqs(sqsConf, records); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if sent := sqsConf.stats.messagesSent.Load(); sent != 2 {
+		t.Errorf("expected both messages to be sent in the end, got %d", sent)
+	}
+	if retried := sqsConf.stats.messagesRetried.Load(); retried != 3 {
+		t.Errorf("expected 2 messages retried after the throttling and 1 after the failure, got %d", retried)
+	}
+	if len(fake.calls) != 2 || len(fake.calls[0]) != 1 || fake.calls[0][0] != "msg-2" || fake.calls[1][0] != "msg-1" {
+		t.Errorf("unexpected calls reaching the queue: %v", fake.calls)
+	}
+}
+
+func TestChaosThrottlingError(t *testing.T) {
+	defer func(f func() float64) { randFloat64 = f }(randFloat64)
+	randFloat64 = func() float64 { return 0 }
+
+	f := (&chaosFaults{throttlePercent: 50}).inject(&sqs.SendMessageBatchInput{})
+	if !isThrottlingCode(awsErrorCode(f.err)) {
+		t.Errorf("expected a throttling error, got %v", f.err)
+	}
+}
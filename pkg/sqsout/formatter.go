@@ -0,0 +1,257 @@
+package sqsout
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// Formatter turns a record into the body of its message. the formats
+// selected with Format implement it, a new format only has to implement
+// Format and be added to newFormatter and the golden file tests
+type Formatter interface {
+	Format(tag string, ts time.Time, record map[interface{}]interface{}) ([]byte, error)
+}
+
+// newFormatter returns the formatter selected with Format, the json format
+// uses the JsonEncoder encoder
+func newFormatter(name string, encoder recordEncoder) (Formatter, error) {
+	switch strings.ToLower(name) {
+	case "", "json":
+		return jsonFormatter{encoder: encoder}, nil
+	case "logfmt":
+		return logfmtFormatter{}, nil
+	case "gelf":
+		host, err := os.Hostname()
+		if err != nil {
+			host = "unknown"
+		}
+		return gelfFormatter{host: host}, nil
+	default:
+		return nil, fmt.Errorf("Format should be one of: json, logfmt, gelf. got %q", name)
+	}
+}
+
+// formatRecord formats a record as the body of its message, with the json
+// format when no formatter is configured
+func formatRecord(sqsConf *sqsConfig, tag string, ts time.Time, record map[interface{}]interface{}) (string, error) {
+	formatter := sqsConf.formatter
+	if formatter == nil {
+		formatter = jsonFormatter{encoder: sqsConf.encoder}
+	}
+
+	body, err := formatter.Format(tag, ts, record)
+	if err != nil {
+		return "", err
+	}
+	// the formatters return a new slice for every record
+	return bytesToString(body), nil
+}
+
+// jsonFormatter writes the record as a JSON object with an @timestamp field,
+// the default format
+type jsonFormatter struct {
+	encoder recordEncoder
+}
+
+func (f jsonFormatter) Format(tag string, ts time.Time, record map[interface{}]interface{}) ([]byte, error) {
+	encoder := f.encoder
+	if encoder == nil {
+		encoder = encodeFast
+	}
+
+	body, err := encoder(ts.UTC().Format(time.RFC3339Nano), record)
+	if err != nil {
+		return nil, err
+	}
+	// the encoders return a fresh string, which is only read from here on
+	return unsafe.Slice(unsafe.StringData(body), len(body)), nil
+}
+
+// logfmtFormatter writes the record as logfmt key=value pairs, after the
+// time and tag of the record. nested maps are flattened to dotted keys and
+// the keys are sorted
+type logfmtFormatter struct{}
+
+func (logfmtFormatter) Format(tag string, ts time.Time, record map[interface{}]interface{}) ([]byte, error) {
+	fields, err := flattenRecord(record, ".", false)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, 256)
+	buf = append(buf, "time="...)
+	buf = append(buf, ts.UTC().Format(time.RFC3339Nano)...)
+	buf = append(buf, " tag="...)
+	buf = appendLogfmtValue(buf, tag)
+	for _, field := range fields {
+		buf = append(buf, ' ')
+		buf = append(buf, logfmtKey(field.key)...)
+		buf = append(buf, '=')
+		buf = appendLogfmtValue(buf, fieldString(field.value))
+	}
+	return buf, nil
+}
+
+// logfmtKey replaces the characters a logfmt key can't hold
+func logfmtKey(key string) string {
+	if key == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+// appendLogfmtValue quotes the values with spaces, quotes, equal signs or
+// characters which are not printable
+func appendLogfmtValue(buf []byte, value string) []byte {
+	for _, r := range value {
+		if r <= ' ' || r == '=' || r == '"' || r == '\\' || r == utf8.RuneError || !strconv.IsPrint(r) {
+			return strconv.AppendQuote(buf, value)
+		}
+	}
+	return append(buf, value...)
+}
+
+// gelfFormatter writes the record as a GELF 1.1 message for Graylog. the
+// message or log field is the short_message and the other fields are
+// additional fields, flattened with underscores
+type gelfFormatter struct {
+	host string
+}
+
+// gelfMessageKeys are the record fields used as the short_message, in order
+var gelfMessageKeys = []string{"message", "log", "msg"}
+
+func (f gelfFormatter) Format(tag string, ts time.Time, record map[interface{}]interface{}) ([]byte, error) {
+	message := map[string]interface{}{
+		"version":   "1.1",
+		"host":      f.host,
+		"timestamp": gelfTimestamp(ts),
+		"_tag":      tag,
+	}
+
+	messageKey := ""
+	for _, key := range gelfMessageKeys {
+		if value, ok := record[key]; ok {
+			if _, nested := value.(map[interface{}]interface{}); !nested {
+				messageKey = key
+				message["short_message"] = fieldString(value)
+				break
+			}
+		}
+	}
+	// short_message is mandatory
+	if s, _ := message["short_message"].(string); s == "" {
+		message["short_message"] = tag
+	}
+
+	fields, err := flattenRecord(record, "_", true)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		if field.key == messageKey {
+			continue
+		}
+		name := "_" + gelfFieldName(field.key)
+		if name == "_id" {
+			// reserved by GELF
+			name = "_id_"
+		}
+		message[name] = field.value
+	}
+
+	body, err := marshalJSON(message)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(body), nil
+}
+
+// gelfTimestamp is the time in seconds with millisecond decimals
+func gelfTimestamp(ts time.Time) gelfNumber {
+	ms := ts.UnixMilli()
+	return gelfNumber(fmt.Sprintf("%d.%03d", ms/1000, ms%1000))
+}
+
+// gelfNumber is a number written as is to the JSON message
+type gelfNumber string
+
+func (n gelfNumber) MarshalJSON() ([]byte, error) {
+	return []byte(n), nil
+}
+
+// gelfFieldName replaces the characters GELF doesn't allow in field names
+func gelfFieldName(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r < utf8.RuneSelf && (r == '.' || r == '-' || r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// flattenRecord returns the fields of the record sorted by key, nested maps
+// flattened to their keys joined with separator and lists encoded as JSON.
+// the values are strings, numbers are kept when numbers is set
+func flattenRecord(record map[interface{}]interface{}, separator string, numbers bool) ([]jsonField, error) {
+	var fields []jsonField
+	var flatten func(prefix string, m map[interface{}]interface{}) error
+	flatten = func(prefix string, m map[interface{}]interface{}) error {
+		for k, v := range m {
+			key := prefix + fieldString(k)
+			switch value := v.(type) {
+			case map[interface{}]interface{}:
+				if err := flatten(key+separator, value); err != nil {
+					return err
+				}
+			case []interface{}:
+				encoded, err := appendJSONValue(nil, value)
+				if err != nil {
+					return err
+				}
+				fields = append(fields, jsonField{key: key, value: string(encoded)})
+			default:
+				fields = append(fields, jsonField{key: key, value: flatValue(value, numbers)})
+			}
+		}
+		return nil
+	}
+
+	if err := flatten("", record); err != nil {
+		return nil, err
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].key < fields[j].key })
+	return fields, nil
+}
+
+// flatValue converts a scalar value to a string. with numbers set, the
+// finite numbers are kept as they are
+func flatValue(value interface{}, numbers bool) interface{} {
+	if numbers {
+		switch v := value.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+			return v
+		case float32:
+			if !math.IsNaN(float64(v)) && !math.IsInf(float64(v), 0) {
+				return v
+			}
+		case float64:
+			if !math.IsNaN(v) && !math.IsInf(v, 0) {
+				return v
+			}
+		}
+	}
+	return fieldString(value)
+}
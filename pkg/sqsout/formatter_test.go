@@ -0,0 +1,130 @@
+package sqsout
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// updateGolden rewrites the golden files with the current output:
+//
+//	go test -run TestFormatterGolden -update .
+var updateGolden = flag.Bool("update", false, "update the formatter golden files")
+
+// goldenFormatters are the formatters checked against testdata/formatter/<name>.
+// a new format is added here, its golden files are created with -update
+var goldenFormatters = map[string]Formatter{
+	"json":          jsonFormatter{encoder: encodeFast},
+	"json-standard": jsonFormatter{encoder: encodeStandard},
+	"logfmt":        logfmtFormatter{},
+	"gelf":          gelfFormatter{host: "test-host"},
+}
+
+// goldenRecords are the records every formatter is checked with
+var goldenRecords = map[string]map[interface{}]interface{}{
+	"simple": {
+		"log":    []byte("hello world"),
+		"stream": "stdout",
+	},
+	"types": {
+		"bool":   true,
+		"float":  1.5,
+		"int":    int64(-42),
+		"nil":    nil,
+		"uint":   uint64(42),
+		"bytes":  []byte("raw"),
+		"string": "text",
+	},
+	"nested": {
+		"message": "request served",
+		"kubernetes": map[interface{}]interface{}{
+			"namespace_name": "default",
+			"labels":         map[interface{}]interface{}{"app": "web"},
+		},
+		"tags": []interface{}{"a", int64(1), nil},
+	},
+	"escaping": {
+		"quote":          `say "hi"`,
+		"spaces":         "a b",
+		"equals":         "a=b",
+		"html":           "<a href=\"x\">&</a>",
+		"newline":        "line\nbreak",
+		"unicode":        "héllo ✓",
+		"invalid":        []byte{'a', 0xff, 'b'},
+		"key with space": "value",
+		"id":             "reserved in gelf",
+	},
+}
+
+func TestFormatterGolden(t *testing.T) {
+	ts := time.Date(2024, 1, 15, 10, 30, 0, 123000000, time.UTC)
+
+	for name, formatter := range goldenFormatters {
+		for recordName, record := range goldenRecords {
+			t.Run(name+"/"+recordName, func(t *testing.T) {
+				got, err := formatter.Format("app.log", ts, record)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				path := filepath.Join("testdata", "formatter", name, recordName+".golden")
+				if *updateGolden {
+					if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(path, append(got, '\n'), 0644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+
+				want, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatalf("missing golden file, create it with -update: %v", err)
+				}
+				if string(got)+"\n" != string(want) {
+					t.Errorf("%s output differs from %s:\ngot:  %s\nwant: %s", name, path, got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestNewFormatter(t *testing.T) {
+	tests := []struct {
+		name     string
+		wantType string
+		wantErr  bool
+	}{
+		{"", "sqsout.jsonFormatter", false},
+		{"json", "sqsout.jsonFormatter", false},
+		{"LOGFMT", "sqsout.logfmtFormatter", false},
+		{"gelf", "sqsout.gelfFormatter", false},
+		{"xml", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := newFormatter(tt.name, nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newFormatter(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && fmt.Sprintf("%T", got) != tt.wantType {
+			t.Errorf("newFormatter(%q) = %T, want %s", tt.name, got, tt.wantType)
+		}
+	}
+}
+
+func TestPrepareRecordUsesFormatter(t *testing.T) {
+	resetGlobals()
+	sqsConf := &sqsConfig{formatter: logfmtFormatter{}}
+	ts := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+
+	prepared := prepareRecord(sqsConf, "app.log", ts, map[interface{}]interface{}{"log": "hello"})
+	if prepared == nil || prepared.body != "time=2024-01-15T10:30:00Z tag=app.log log=hello" {
+		t.Errorf("unexpected prepared record: %+v", prepared)
+	}
+}
@@ -0,0 +1,89 @@
+package sqsout
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the fuzz targets harden the conversion of chunks to message bodies, run
+// them with e.g.
+//
+//	go test -run '^$' -fuzz FuzzChunkToJSON -fuzztime 1m .
+
+var fuzzEncoders = map[string]recordEncoder{
+	"standard": encodeStandard,
+	"fast":     encodeFast,
+}
+
+// checkEncodedRecord encodes the record with every encoder, a body must
+// always be valid JSON
+func checkEncodedRecord(t *testing.T, record map[interface{}]interface{}) {
+	for name, encoder := range fuzzEncoders {
+		body, err := encoder("2024-01-15T10:30:00Z", record)
+		if err != nil {
+			continue
+		}
+		if !json.Valid([]byte(body)) {
+			t.Fatalf("%s encoder produced invalid JSON for %#v: %q", name, record, body)
+		}
+	}
+}
+
+func FuzzChunkToJSON(f *testing.F) {
+	ts := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	f.Add(encodeMsgpack(f, []interface{}{uint64(ts.Unix()), map[string]interface{}{"log": "hello"}}))
+	f.Add(encodeMsgpack(f, []interface{}{uint64(ts.Unix()), map[string]interface{}{
+		"nested": map[string]interface{}{"a": []interface{}{1, "two", 3.5, nil, true}},
+		"bin":    []byte{0xff, 0xfe, 0x00},
+	}}))
+	// a record with integer and float keys
+	f.Add([]byte{0x92, 0xce, 0x65, 0xa5, 0x08, 0x28, 0x82, 0x01, 0xa1, 'a', 0xcb, 0x40, 0x04, 0, 0, 0, 0, 0, 0, 0xa1, 'b'})
+	f.Add(append(append([]byte{0x92}, eventTime(ts)...), 0x81, 0xa1, 'k', 0xcb, 0x7f, 0xf0, 0, 0, 0, 0, 0, 0))
+
+	f.Fuzz(func(t *testing.T, chunk []byte) {
+		resetGlobals()
+		sqsOutLogLevel = 3 // the decoding errors are expected
+
+		next := newChunkIterator(chunk)
+		for i := 0; i < 64; i++ {
+			_, record, ok := next()
+			if !ok {
+				return
+			}
+			checkEncodedRecord(t, record)
+		}
+	})
+}
+
+func FuzzEncodeRecord(f *testing.F) {
+	f.Add("log", []byte("hello"), uint8(0), int64(1), 1.5)
+	f.Add("", []byte{0xff, 0xc0, 0x80}, uint8(3), int64(-1), math.Inf(1))
+	f.Add(" <&>", []byte("\x00\x1f\"\\"), uint8(200), int64(math.MinInt64), math.NaN())
+	f.Add("@timestamp", []byte(strings.Repeat("x", 5000)), uint8(1), int64(0), 1e21)
+
+	f.Fuzz(func(t *testing.T, key string, value []byte, depth uint8, number int64, float float64) {
+		var nested interface{} = map[interface{}]interface{}{
+			key:           value,
+			"string":      string(value),
+			"number":      number,
+			"unsigned":    uint64(number),
+			"float":       float,
+			"float32":     float32(float),
+			"list":        []interface{}{value, number, nil, false},
+			"empty_map":   map[interface{}]interface{}{},
+			"empty_slice": []interface{}{},
+		}
+		for i := 0; i < int(depth)%64; i++ {
+			nested = map[interface{}]interface{}{key: nested, "level": int64(i)}
+		}
+
+		checkEncodedRecord(t, map[interface{}]interface{}{
+			key:        nested,
+			"value":    value,
+			"@metrics": []interface{}{nested, float},
+		})
+	})
+}
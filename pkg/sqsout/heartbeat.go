@@ -0,0 +1,139 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// heartbeatAttribute is the message attribute marking the heartbeat
+// messages, so consumers can tell them from the records and skip them
+const heartbeatAttribute = "fluentbit_sqs_heartbeat"
+
+// heartbeatBody is the body of a heartbeat message
+type heartbeatBody struct {
+	Heartbeat bool   `json:"heartbeat"`
+	Time      string `json:"time"`
+	Host      string `json:"host"`
+	Version   string `json:"version"`
+}
+
+// parseHeartbeatInterval parses HeartbeatIntervalSeconds, zero disables the
+// heartbeat
+func parseHeartbeatInterval(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, errors.New("HeartbeatIntervalSeconds should be a positive integer")
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// heartbeat sends a small synthetic message to the queue at every interval,
+// so the path to the consumers can be monitored when no logs flow. the
+// heartbeats are not counted in the delivery stats
+type heartbeat struct {
+	sqsConf  *sqsConfig
+	interval time.Duration
+	host     string
+	stopCh   chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newHeartbeat(sqsConf *sqsConfig, interval time.Duration) *heartbeat {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	return &heartbeat{
+		sqsConf:  sqsConf,
+		interval: interval,
+		host:     host,
+		stopCh:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// start sends a heartbeat every interval until stop is called
+func (h *heartbeat) start() {
+	registerReporter(h.sqsConf, h)
+
+	go func() {
+		defer close(h.done)
+
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				h.send(time.Now())
+			case <-h.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// stop stops the heartbeat, it is safe to call more than once
+func (h *heartbeat) stop() {
+	h.stopOnce.Do(func() {
+		close(h.stopCh)
+	})
+	<-h.done
+}
+
+// send sends a single heartbeat message. failures are logged only, the next
+// heartbeat is the retry
+func (h *heartbeat) send(now time.Time) {
+	body, err := marshalJSON(heartbeatBody{
+		Heartbeat: true,
+		Time:      now.UTC().Format(time.RFC3339Nano),
+		Host:      h.host,
+		Version:   version,
+	})
+	if err != nil {
+		writeErrorLog(fmt.Errorf("failed to create the heartbeat message: %v", err))
+		return
+	}
+
+	entry := &types.SendMessageBatchRequestEntry{
+		Id:          aws.String("Heartbeat"),
+		MessageBody: aws.String(body),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			heartbeatAttribute: {
+				DataType:    aws.String("String"),
+				StringValue: aws.String("true"),
+			},
+		},
+	}
+	if h.sqsConf.queueMessageGroupID != "" {
+		entry.MessageGroupId = aws.String(h.sqsConf.queueMessageGroupID)
+		entry.MessageDeduplicationId = aws.String(fmt.Sprintf("Heartbeat-%d", now.UnixNano()))
+	}
+
+	queueURL := h.sqsConf.activeQueueURL()
+	retry := h.sqsConf.retry
+	retry.retried = nil
+	output, err := retry.sendBatch(context.Background(), h.sqsConf.mainSink(queueURL), queueURL, []*types.SendMessageBatchRequestEntry{entry})
+	if err == nil && len(output.Failed) > 0 {
+		err = fmt.Errorf("%s: %s", aws.ToString(output.Failed[0].Code), aws.ToString(output.Failed[0].Message))
+	}
+	if err != nil {
+		writeErrorLog(fmt.Errorf("failed to send the heartbeat to %s: %v", queueURL, err))
+		return
+	}
+
+	writeDebugLog(fmt.Sprintf("heartbeat sent to %s", queueName(queueURL)))
+}
@@ -0,0 +1,93 @@
+package sqsout
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestParseHeartbeatInterval(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"30", 30 * time.Second, false},
+		{"-1", 0, true},
+		{"30s", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseHeartbeatInterval(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseHeartbeatInterval(%q) = %s, %v, want %s, wantErr %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestHeartbeatSend(t *testing.T) {
+	now := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		queueURL     string
+		messageGroup string
+	}{
+		{"standard queue", "https://sqs.us-east-1.amazonaws.com/123456789/test-queue", ""},
+		{"fifo queue", "https://sqs.us-east-1.amazonaws.com/123456789/test-queue.fifo", "logs"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetGlobals()
+			fake := &recordingSQS{}
+			sqsConf := &sqsConfig{queueURL: tt.queueURL, queueMessageGroupID: tt.messageGroup, mySQS: fake}
+
+			newHeartbeat(sqsConf, time.Minute).send(now)
+
+			if len(fake.batches) != 1 || len(fake.batches[0].Entries) != 1 {
+				t.Fatalf("expected a single heartbeat message, got %v", fake.batches)
+			}
+			entry := fake.batches[0].Entries[0]
+
+			var body heartbeatBody
+			if err := json.Unmarshal([]byte(*entry.MessageBody), &body); err != nil {
+				t.Fatalf("heartbeat body is not JSON: %v", err)
+			}
+			if !body.Heartbeat || body.Time != "2024-01-15T10:30:00Z" || body.Version != version || body.Host == "" {
+				t.Errorf("unexpected heartbeat body: %+v", body)
+			}
+			if attribute, ok := entry.MessageAttributes[heartbeatAttribute]; !ok || aws.ToString(attribute.StringValue) != "true" {
+				t.Errorf("expected the %s attribute, got %v", heartbeatAttribute, entry.MessageAttributes)
+			}
+			if tt.messageGroup != "" && (entry.MessageGroupId == nil || *entry.MessageGroupId != tt.messageGroup || entry.MessageDeduplicationId == nil) {
+				t.Errorf("expected the FIFO fields, got %v", entry)
+			}
+			if sqsConf.stats.messagesSent.Load() != 0 {
+				t.Error("expected the heartbeat not to be counted as a sent message")
+			}
+		})
+	}
+}
+
+func TestHeartbeatSendFailure(t *testing.T) {
+	resetGlobals()
+	sqsConf := &sqsConfig{
+		queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS:    &recordingSQS{err: errors.New("access denied")},
+	}
+
+	output := captureStdout(func() {
+		newHeartbeat(sqsConf, time.Minute).send(time.Now())
+	})
+
+	if !strings.Contains(output, "failed to send the heartbeat to https://sqs.us-east-1.amazonaws.com/123456789/test-queue: access denied") {
+		t.Errorf("expected the failure to be logged, got %q", output)
+	}
+}
@@ -0,0 +1,120 @@
+package sqsout
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the send latency histogram buckets,
+// a last bucket counts the latencies above the highest bound
+var latencyBuckets = [...]time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// latencyHistogram counts the SendMessageBatch round trips per latency
+// bucket. it is safe for concurrent use
+type latencyHistogram struct {
+	counts [len(latencyBuckets) + 1]atomic.Int64
+	sum    atomic.Int64
+}
+
+func (h *latencyHistogram) observe(latency time.Duration) {
+	i := 0
+	for i < len(latencyBuckets) && latency > latencyBuckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(latency))
+}
+
+func (h *latencyHistogram) snapshot() histogramSnapshot {
+	var s histogramSnapshot
+	for i := range h.counts {
+		s.counts[i] = h.counts[i].Load()
+	}
+	s.sum = time.Duration(h.sum.Load())
+	return s
+}
+
+// histogramSnapshot is a copy of the histogram counts at some point
+type histogramSnapshot struct {
+	counts [len(latencyBuckets) + 1]int64
+	sum    time.Duration
+}
+
+// sub returns the observations made since prev
+func (s histogramSnapshot) sub(prev histogramSnapshot) histogramSnapshot {
+	for i := range s.counts {
+		s.counts[i] -= prev.counts[i]
+	}
+	s.sum -= prev.sum
+	return s
+}
+
+func (s histogramSnapshot) count() int64 {
+	var total int64
+	for _, count := range s.counts {
+		total += count
+	}
+	return total
+}
+
+// mean is the average latency, zero without observations
+func (s histogramSnapshot) mean() time.Duration {
+	count := s.count()
+	if count == 0 {
+		return 0
+	}
+	return s.sum / time.Duration(count)
+}
+
+// quantile returns the upper bound of the bucket holding the q quantile. the
+// latencies above the highest bound report that bound
+func (s histogramSnapshot) quantile(q float64) time.Duration {
+	count := s.count()
+	if count == 0 {
+		return 0
+	}
+
+	rank := int64(q*float64(count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen int64
+	for i, bucketCount := range s.counts {
+		seen += bucketCount
+		if seen >= rank && i < len(latencyBuckets) {
+			return latencyBuckets[i]
+		}
+	}
+	return latencyBuckets[len(latencyBuckets)-1]
+}
+
+// String formats the cumulative bucket counts, prometheus style
+func (s histogramSnapshot) String() string {
+	var b strings.Builder
+	var cumulative int64
+	for i, count := range s.counts {
+		cumulative += count
+		bound := "+Inf"
+		if i < len(latencyBuckets) {
+			bound = latencyBuckets[i].String()
+		}
+		fmt.Fprintf(&b, "le_%s=%d ", bound, cumulative)
+	}
+	fmt.Fprintf(&b, "count=%d mean=%s", s.count(), s.mean())
+	return b.String()
+}
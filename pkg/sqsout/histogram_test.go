@@ -0,0 +1,83 @@
+package sqsout
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	var h latencyHistogram
+	for _, latency := range []time.Duration{
+		3 * time.Millisecond,
+		5 * time.Millisecond,
+		20 * time.Millisecond,
+		20 * time.Millisecond,
+		300 * time.Millisecond,
+		time.Minute,
+	} {
+		h.observe(latency)
+	}
+
+	s := h.snapshot()
+	if s.count() != 6 {
+		t.Fatalf("expected 6 observations, got %d", s.count())
+	}
+	if s.counts[0] != 2 || s.counts[2] != 2 || s.counts[6] != 1 || s.counts[len(latencyBuckets)] != 1 {
+		t.Errorf("unexpected bucket counts: %v", s.counts)
+	}
+
+	tests := []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0, 5 * time.Millisecond},
+		{0.5, 25 * time.Millisecond},
+		{0.8, 500 * time.Millisecond},
+		{0.99, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := s.quantile(tt.q); got != tt.want {
+			t.Errorf("quantile(%v) = %s, want %s", tt.q, got, tt.want)
+		}
+	}
+
+	if !strings.Contains(s.String(), "le_5ms=2 ") || !strings.Contains(s.String(), "le_+Inf=6 count=6") {
+		t.Errorf("unexpected string: %s", s)
+	}
+}
+
+func TestHistogramSnapshotSub(t *testing.T) {
+	var h latencyHistogram
+	h.observe(time.Millisecond)
+	prev := h.snapshot()
+
+	h.observe(2 * time.Second)
+	h.observe(2 * time.Second)
+	delta := h.snapshot().sub(prev)
+
+	if delta.count() != 2 || delta.mean() != 2*time.Second || delta.quantile(0.5) != 2500*time.Millisecond {
+		t.Errorf("unexpected delta: count=%d mean=%s p50=%s", delta.count(), delta.mean(), delta.quantile(0.5))
+	}
+	if empty := prev.sub(prev); empty.count() != 0 || empty.quantile(0.99) != 0 || empty.mean() != 0 {
+		t.Errorf("expected an empty delta, got %s", empty)
+	}
+}
+
+func TestLatencyHistogramConcurrent(t *testing.T) {
+	var h latencyHistogram
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.observe(40 * time.Millisecond)
+		}()
+	}
+	wg.Wait()
+
+	if s := h.snapshot(); s.counts[3] != 50 || s.sum != 50*40*time.Millisecond {
+		t.Errorf("unexpected histogram: %s", s)
+	}
+}
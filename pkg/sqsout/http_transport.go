@@ -0,0 +1,67 @@
+package sqsout
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+// defaultMaxIdleConnsPerHost keeps enough idle connections to the SQS
+// endpoint for concurrent senders. the net/http default of 2 makes sustained
+// high throughput sends churn TCP and TLS handshakes
+const defaultMaxIdleConnsPerHost = 64
+
+func parseMaxIdleConnsPerHost(value string) (int, error) {
+	if value == "" {
+		return defaultMaxIdleConnsPerHost, nil
+	}
+
+	maxIdleConnsPerHost, err := strconv.Atoi(value)
+	if err != nil || maxIdleConnsPerHost < 1 {
+		return 0, errors.New("MaxIdleConnsPerHost should be a positive integer")
+	}
+
+	return maxIdleConnsPerHost, nil
+}
+
+// newHTTPClient builds the http client used by the aws clients. the
+// transport reuses connections, caches TLS sessions for resumption and
+// negotiates HTTP/2 when the endpoint supports it. requests go through the
+// proxy when one is configured, otherwise through the environment proxy. the
+// sdk can still add the AWS_CA_BUNDLE certificates to the buildable client
+func newHTTPClient(proxyURL string, maxIdleConnsPerHost int) (*awshttp.BuildableClient, error) {
+	proxy := http.ProxyFromEnvironment
+	if proxyURL != "" {
+		parsedProxyURL, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("ProxyUrl is not a valid url: %v", err)
+		}
+		proxy = http.ProxyURL(parsedProxyURL)
+	}
+
+	client := awshttp.NewBuildableClient().WithDialerOptions(func(dialer *net.Dialer) {
+		dialer.Timeout = 30 * time.Second
+		dialer.KeepAlive = 30 * time.Second
+	}).WithTransportOptions(func(transport *http.Transport) {
+		transport.Proxy = proxy
+		transport.ForceAttemptHTTP2 = true
+		transport.MaxIdleConns = maxIdleConnsPerHost * 2
+		transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+		transport.IdleConnTimeout = 90 * time.Second
+		transport.TLSHandshakeTimeout = 10 * time.Second
+		transport.ExpectContinueTimeout = 1 * time.Second
+		transport.TLSClientConfig = &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			ClientSessionCache: tls.NewLRUClientSessionCache(0),
+		}
+	})
+
+	return client, nil
+}
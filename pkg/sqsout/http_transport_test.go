@@ -0,0 +1,67 @@
+package sqsout
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseMaxIdleConnsPerHost(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int
+		wantErr bool
+	}{
+		{"", defaultMaxIdleConnsPerHost, false},
+		{"1", 1, false},
+		{"256", 256, false},
+		{"0", 0, true},
+		{"-3", 0, true},
+		{"lots", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseMaxIdleConnsPerHost(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseMaxIdleConnsPerHost(%q) = %d, %v, want %d, wantErr %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	t.Run("tuned transport", func(t *testing.T) {
+		client, err := newHTTPClient("", 32)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		transport := client.GetTransport()
+		if transport.MaxIdleConnsPerHost != 32 || transport.MaxIdleConns != 64 {
+			t.Errorf("unexpected idle connection limits: %d per host, %d total", transport.MaxIdleConnsPerHost, transport.MaxIdleConns)
+		}
+		if !transport.ForceAttemptHTTP2 {
+			t.Error("expected HTTP/2 to be attempted")
+		}
+		if transport.TLSClientConfig == nil || transport.TLSClientConfig.ClientSessionCache == nil {
+			t.Error("expected a TLS session cache")
+		}
+	})
+
+	t.Run("proxy url", func(t *testing.T) {
+		client, err := newHTTPClient("http://proxy:8080", 8)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		request, _ := http.NewRequest("POST", "https://sqs.us-east-1.amazonaws.com/", nil)
+		proxy, err := client.GetTransport().Proxy(request)
+		if err != nil || proxy == nil || proxy.Host != "proxy:8080" {
+			t.Errorf("unexpected proxy: %v, %v", proxy, err)
+		}
+	})
+
+	t.Run("invalid proxy url", func(t *testing.T) {
+		if _, err := newHTTPClient("http://[::1", 8); err == nil {
+			t.Error("expected error for invalid proxy url")
+		}
+	})
+}
@@ -0,0 +1,225 @@
+//go:build integration
+
+package sqsout
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/localstack"
+)
+
+// the integration tests run the plugin against SQS in LocalStack:
+//
+//	go test -tags=integration -run Integration ./...
+//
+// they need a docker daemon testcontainers can reach and are skipped
+// without one
+
+const integrationRegion = "us-east-1"
+
+// startLocalStack starts a LocalStack container and returns its endpoint
+func startLocalStack(t *testing.T) string {
+	t.Helper()
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+	ctx := context.Background()
+
+	container, err := localstack.RunContainer(ctx, testcontainers.WithImage("localstack/localstack:3.4"))
+	if err != nil {
+		t.Fatalf("failed to start LocalStack: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Terminate(ctx); err != nil {
+			t.Errorf("failed to stop LocalStack: %v", err)
+		}
+	})
+
+	host, err := container.Host(ctx)
+	if err != nil {
+		t.Fatalf("failed to get the LocalStack host: %v", err)
+	}
+	port, err := container.MappedPort(ctx, "4566/tcp")
+	if err != nil {
+		t.Fatalf("failed to get the LocalStack port: %v", err)
+	}
+	return fmt.Sprintf("http://%s:%s", host, port.Port())
+}
+
+// localStackSQS returns an SQS client of the test itself, to create the
+// queues and read the messages the plugin sent
+func localStackSQS(t *testing.T, endpoint string) *sqs.Client {
+	t.Helper()
+	return sqs.New(sqs.Options{
+		Region:       integrationRegion,
+		BaseEndpoint: aws.String(endpoint),
+		Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+	})
+}
+
+func createQueue(t *testing.T, client *sqs.Client, name string, attributes map[string]string) string {
+	t.Helper()
+	output, err := client.CreateQueue(context.Background(), &sqs.CreateQueueInput{
+		QueueName:  aws.String(name),
+		Attributes: attributes,
+	})
+	if err != nil {
+		t.Fatalf("failed to create queue %s: %v", name, err)
+	}
+	return aws.ToString(output.QueueUrl)
+}
+
+// receiveBodies reads the messages of a queue until want messages arrived or
+// the queue stays empty
+func receiveBodies(t *testing.T, client *sqs.Client, queueURL string, want int) []string {
+	t.Helper()
+	var bodies []string
+	for len(bodies) < want {
+		output, err := client.ReceiveMessage(context.Background(), &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(queueURL),
+			MaxNumberOfMessages: 10,
+			WaitTimeSeconds:     2,
+		})
+		if err != nil {
+			t.Fatalf("failed to receive messages: %v", err)
+		}
+		if len(output.Messages) == 0 {
+			break
+		}
+		for _, message := range output.Messages {
+			bodies = append(bodies, aws.ToString(message.Body))
+		}
+	}
+	return bodies
+}
+
+// initIntegrationPlugin creates a plugin instance the way fluent bit does,
+// from its configuration keys
+func initIntegrationPlugin(t *testing.T, config map[string]string) *sqsConfig {
+	t.Helper()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+
+	sqsConf, err := initPlugin(func(key string) string {
+		return config[key]
+	})
+	if err != nil {
+		t.Fatalf("failed to init the plugin with %v: %v", config, err)
+	}
+	return sqsConf
+}
+
+// integrationChunk encodes records as a fluent bit chunk
+func integrationChunk(t *testing.T, records ...map[string]interface{}) []byte {
+	t.Helper()
+	entries := make([]interface{}, len(records))
+	for i, record := range records {
+		entries[i] = []interface{}{uint64(time.Now().Unix()), record}
+	}
+	return encodeMsgpack(t, entries...)
+}
+
+func TestIntegrationDelivery(t *testing.T) {
+	resetGlobals()
+	endpoint := startLocalStack(t)
+	client := localStackSQS(t, endpoint)
+
+	tests := []struct {
+		name       string
+		queue      string
+		attributes map[string]string
+		config     map[string]string
+	}{
+		{
+			name:  "standard queue",
+			queue: "integration-standard",
+		},
+		{
+			name:       "fifo queue",
+			queue:      "integration.fifo",
+			attributes: map[string]string{"FifoQueue": "true"},
+			config:     map[string]string{"QueueMessageGroupId": "logs"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queueURL := createQueue(t, client, tt.queue, tt.attributes)
+			config := map[string]string{
+				"QueueUrl":    queueURL,
+				"QueueRegion": integrationRegion,
+				"Endpoint":    endpoint,
+				"BatchSize":   "2",
+			}
+			for key, value := range tt.config {
+				config[key] = value
+			}
+			sqsConf := initIntegrationPlugin(t, config)
+
+			chunk := integrationChunk(t,
+				map[string]interface{}{"log": "first"},
+				map[string]interface{}{"log": "second"},
+				map[string]interface{}{"log": "third"},
+				map[string]interface{}{"log": "fourth"},
+			)
+			if status := flushChunk(sqsConf, "app.log", chunk); status != FlushOK {
+				t.Fatalf("flush returned %d", status)
+			}
+
+			bodies := receiveBodies(t, client, queueURL, 4)
+			if len(bodies) != 4 {
+				t.Fatalf("expected 4 messages, got %d: %v", len(bodies), bodies)
+			}
+			joined := strings.Join(bodies, "\n")
+			for _, log := range []string{"first", "second", "third", "fourth"} {
+				if !strings.Contains(joined, `"log":"`+log+`"`) {
+					t.Errorf("expected the %s record in the messages, got %v", log, bodies)
+				}
+			}
+			if sent := sqsConf.stats.messagesSent.Load(); sent != 4 {
+				t.Errorf("expected 4 sent messages, got %d", sent)
+			}
+		})
+	}
+}
+
+func TestIntegrationPartialFailure(t *testing.T) {
+	resetGlobals()
+	endpoint := startLocalStack(t)
+	client := localStackSQS(t, endpoint)
+
+	// the queue only takes messages up to 1 KiB, so SQS rejects the large
+	// entry of the batch while accepting the other one
+	queueURL := createQueue(t, client, "integration-small", map[string]string{"MaximumMessageSize": "1024"})
+	sqsConf := initIntegrationPlugin(t, map[string]string{
+		"QueueUrl":    queueURL,
+		"QueueRegion": integrationRegion,
+		"Endpoint":    endpoint,
+		"BatchSize":   "2",
+	})
+
+	chunk := integrationChunk(t,
+		map[string]interface{}{"log": "small"},
+		map[string]interface{}{"log": strings.Repeat("x", 2048)},
+	)
+	if status := flushChunk(sqsConf, "app.log", chunk); status != FlushOK {
+		t.Fatalf("flush returned %d", status)
+	}
+
+	bodies := receiveBodies(t, client, queueURL, 1)
+	if len(bodies) != 1 || !strings.Contains(bodies[0], `"log":"small"`) {
+		t.Errorf("expected only the small record to be delivered, got %v", bodies)
+	}
+	if sent, failed := sqsConf.stats.messagesSent.Load(), sqsConf.stats.messagesFailed.Load(); sent != 1 || failed != 1 {
+		t.Errorf("expected 1 sent and 1 failed message, got sent=%d failed=%d", sent, failed)
+	}
+	if failures := sqsConf.stats.failureCodes.String(); failures == "" {
+		t.Error("expected the failure to be counted by code")
+	}
+}
@@ -0,0 +1,79 @@
+package sqsout
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// invalidRecordRoute sends records failing validation to a dedicated queue
+// for later inspection, wrapped with diagnostic information about the failure
+type invalidRecordRoute struct {
+	sideQueue
+	queueMessageGroupID string
+}
+
+func newInvalidRecordRoute(queueURL, queueMessageGroupID string) *invalidRecordRoute {
+	route := &invalidRecordRoute{
+		sideQueue: sideQueue{name: "invalid record", queueURL: queueURL, idPrefix: "Invalid"},
+	}
+
+	if strings.HasSuffix(queueURL, ".fifo") {
+		route.queueMessageGroupID = queueMessageGroupID
+	}
+
+	return route
+}
+
+func validateInvalidRecordConfig(queueURL, queueMessageGroupID string) error {
+	if strings.HasSuffix(queueURL, ".fifo") && queueMessageGroupID == "" {
+		return errors.New("QueueMessageGroupId configuration key is mandatory for a FIFO InvalidRecordQueueUrl")
+	}
+
+	return nil
+}
+
+// createInvalidRecordString wraps the original record with the reason it was
+// rejected, its tag and its timestamp
+func createInvalidRecordString(timestamp time.Time, tag, reason string, record map[interface{}]interface{}) (string, error) {
+	original := make(map[string]interface{}, len(record))
+	copyRecordFields(original, record)
+
+	js, err := json.Marshal(map[string]interface{}{
+		"@timestamp": timestamp.UTC().Format(time.RFC3339Nano),
+		"tag":        tag,
+		"error":      reason,
+		"record":     original,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(js), nil
+}
+
+// addRecord queues an invalid record for the invalid record queue and returns
+// the number of pending invalid records. a record which can't even be wrapped
+// is only logged
+func (r *invalidRecordRoute) addRecord(timestamp time.Time, tag, reason string, record map[interface{}]interface{}) int {
+	body, err := createInvalidRecordString(timestamp, tag, reason, record)
+	if err != nil {
+		writeErrorLog(fmt.Errorf("error creating invalid record message. tag: %s. error: %v", tag, err))
+		return 0
+	}
+
+	var configure func(entry *types.SendMessageBatchRequestEntry)
+	if r.queueMessageGroupID != "" {
+		configure = func(entry *types.SendMessageBatchRequestEntry) {
+			entry.MessageGroupId = aws.String(r.queueMessageGroupID)
+			entry.MessageDeduplicationId = aws.String(fmt.Sprintf("%s-%d", *entry.Id, timestamp.UnixNano()))
+		}
+	}
+
+	return r.add(&types.SendMessageBatchRequestEntry{MessageBody: aws.String(body)}, configure)
+}
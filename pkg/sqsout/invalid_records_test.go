@@ -0,0 +1,129 @@
+package sqsout
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestValidateInvalidRecordConfig(t *testing.T) {
+	if err := validateInvalidRecordConfig("https://sqs.us-east-1.amazonaws.com/123456789/invalid", ""); err != nil {
+		t.Errorf("unexpected error for standard queue: %v", err)
+	}
+	if err := validateInvalidRecordConfig("https://sqs.us-east-1.amazonaws.com/123456789/invalid.fifo", ""); err == nil {
+		t.Error("expected error for FIFO queue without group id")
+	}
+	if err := validateInvalidRecordConfig("https://sqs.us-east-1.amazonaws.com/123456789/invalid.fifo", "group-1"); err != nil {
+		t.Errorf("unexpected error for FIFO queue with group id: %v", err)
+	}
+}
+
+func TestCreateInvalidRecordString(t *testing.T) {
+	timestamp := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	record := map[interface{}]interface{}{
+		"message": []byte("hello"),
+		"count":   3,
+	}
+
+	result, err := createInvalidRecordString(timestamp, "app.log", "missing required keys: service", record)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(result), &m); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+	if m["@timestamp"] != "2024-01-15T10:30:00Z" {
+		t.Errorf("unexpected timestamp: %v", m["@timestamp"])
+	}
+	if m["tag"] != "app.log" {
+		t.Errorf("unexpected tag: %v", m["tag"])
+	}
+	if m["error"] != "missing required keys: service" {
+		t.Errorf("unexpected error: %v", m["error"])
+	}
+
+	original, ok := m["record"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("record is not an object: %v", m["record"])
+	}
+	if original["message"] != "hello" || original["count"] != float64(3) {
+		t.Errorf("unexpected original record: %v", original)
+	}
+}
+
+func TestInvalidRecordRouteAddRecord(t *testing.T) {
+	timestamp := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	record := map[interface{}]interface{}{"message": "hello"}
+
+	t.Run("standard queue", func(t *testing.T) {
+		route := newInvalidRecordRoute("https://sqs.us-east-1.amazonaws.com/123456789/invalid", "group-1")
+		route.addRecord(timestamp, "app.log", "missing required keys: service", record)
+
+		if len(route.records) != 1 {
+			t.Fatalf("expected 1 queued record, got %d", len(route.records))
+		}
+		entry := route.records[0]
+		if *entry.Id != "InvalidMessageNumber-1" {
+			t.Errorf("unexpected id: %s", *entry.Id)
+		}
+		if entry.MessageGroupId != nil || entry.MessageDeduplicationId != nil {
+			t.Error("standard queue entries should not have FIFO fields")
+		}
+	})
+
+	t.Run("FIFO queue", func(t *testing.T) {
+		route := newInvalidRecordRoute("https://sqs.us-east-1.amazonaws.com/123456789/invalid.fifo", "group-1")
+		route.addRecord(timestamp, "app.log", "missing required keys: service", record)
+		route.addRecord(timestamp, "app.log", "missing required keys: service", record)
+
+		if len(route.records) != 2 {
+			t.Fatalf("expected 2 queued records, got %d", len(route.records))
+		}
+		if *route.records[0].MessageGroupId != "group-1" {
+			t.Errorf("unexpected group id: %s", *route.records[0].MessageGroupId)
+		}
+		if *route.records[0].MessageDeduplicationId == *route.records[1].MessageDeduplicationId {
+			t.Error("deduplication ids should be unique")
+		}
+	})
+
+	t.Run("records over the SQS size limit are rejected", func(t *testing.T) {
+		resetGlobals()
+		route := newInvalidRecordRoute("https://sqs.us-east-1.amazonaws.com/123456789/invalid", "")
+		large := map[interface{}]interface{}{"message": strings.Repeat("x", maxMessageBytes)}
+
+		var pending int
+		captureStdout(func() { pending = route.addRecord(timestamp, "app.log", "missing required keys: service", large) })
+
+		if pending != 0 || route.stats.recordsDropped.Load() != 1 || route.stats.bytesRejected.Load() <= maxMessageBytes {
+			t.Errorf("unexpected state: pending=%d dropped=%d bytesRejected=%d", pending, route.stats.recordsDropped.Load(), route.stats.bytesRejected.Load())
+		}
+	})
+
+	t.Run("flush sends to the invalid record queue", func(t *testing.T) {
+		resetGlobals()
+		route := newInvalidRecordRoute("https://sqs.us-east-1.amazonaws.com/123456789/invalid", "")
+		route.addRecord(timestamp, "app.log", "missing required keys: service", record)
+
+		fake := &fakeSQS{
+			output: &sqs.SendMessageBatchOutput{
+				Successful: []types.SendMessageBatchResultEntry{{Id: aws.String("InvalidMessageNumber-1")}},
+			},
+		}
+		captureStdout(func() { route.flush(fake, retryPolicy{}) })
+
+		if *fake.input.QueueUrl != "https://sqs.us-east-1.amazonaws.com/123456789/invalid" {
+			t.Errorf("unexpected queue URL: %s", *fake.input.QueueUrl)
+		}
+		if route.stats.messagesSent.Load() != 1 || len(route.records) != 0 {
+			t.Errorf("unexpected state after flush: success=%d queued=%d", route.stats.messagesSent.Load(), len(route.records))
+		}
+	})
+}
@@ -0,0 +1,155 @@
+package sqsout
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sqsOutLogJSON is set by SQS_OUT_LOG_FORMAT=json, the plugin logs are then
+// written as JSON lines instead of the fluent bit text format
+var sqsOutLogJSON bool
+
+// logOutput is where the plugin logs go, stdout when nil. logs on stdout
+// can be picked up by fluent bit again, LogOutput moves them out of the way
+var logOutput io.Writer
+
+// logFile is the open LogOutput file, reused by the instances logging to the
+// same path so a reload doesn't open it again
+var logFile *os.File
+
+// logMu guards logOutput and logFile. the lines are written with it held, the
+// file replaced by the init of another instance is never written once closed
+var logMu sync.Mutex
+
+// logComponent is the component name in every plugin log line
+const logComponent = "sqs-out"
+
+func setLogFormat() {
+	sqsOutLogJSON = strings.EqualFold(os.Getenv("SQS_OUT_LOG_FORMAT"), "json")
+}
+
+// setLogOutput sets the log output from the LogOutput value: stdout, stderr
+// or file:<path>. the logs are shared by every instance of the plugin
+func setLogOutput(value string) error {
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	switch {
+	case value == "" || strings.EqualFold(value, "stdout"):
+		closeLogFileLocked()
+		logOutput = nil
+	case strings.EqualFold(value, "stderr"):
+		closeLogFileLocked()
+		logOutput = os.Stderr
+	case strings.HasPrefix(value, "file:") && len(value) > len("file:"):
+		path := strings.TrimPrefix(value, "file:")
+		if logFile != nil && logFile.Name() == path {
+			// another instance, or the same one after a reload
+			logOutput = logFile
+			return nil
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open LogOutput file: %v", err)
+		}
+		closeLogFileLocked()
+		logFile = file
+		logOutput = file
+	default:
+		return errors.New("LogOutput should be stdout, stderr or file:<path>")
+	}
+	return nil
+}
+
+// closeLogFile closes the LogOutput file
+func closeLogFile() {
+	logMu.Lock()
+	defer logMu.Unlock()
+	closeLogFileLocked()
+}
+
+// closeLogFileLocked closes the LogOutput file replaced by another output
+func closeLogFileLocked() {
+	if logFile == nil {
+		return
+	}
+	logFile.Close()
+	logFile = nil
+}
+
+// writeLogLine writes a formatted line to the log output
+func writeLogLine(line string) {
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	var w io.Writer = os.Stdout
+	if logOutput != nil {
+		w = logOutput
+	}
+	_, _ = io.WriteString(w, line)
+}
+
+// jsonLogLine is a plugin log line in the JSON format
+type jsonLogLine struct {
+	Time      string `json:"time"`
+	Level     string `json:"level"`
+	Component string `json:"component"`
+	Message   string `json:"message"`
+	QueueURL  string `json:"queue_url,omitempty"`
+	BatchID   string `json:"batch_id,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+// batchError is an error of a batch send, it carries the destination and
+// the batch so the logs can tell them apart
+type batchError struct {
+	queueURL string
+	batchID  string
+	code     string
+	err      error
+}
+
+func (e *batchError) Error() string {
+	return e.err.Error()
+}
+
+func (e *batchError) Unwrap() error {
+	return e.err
+}
+
+// writeLog writes a log line in the configured format. err is nil for
+// anything but error logs
+func writeLog(level, message string, err error) {
+	currentTime := time.Now()
+
+	if !sqsOutLogJSON {
+		writeLogLine(fmt.Sprintf("[%s] [ %s] [%s] %s\n", currentTime.Format("2006.01.02 15:04:05"), level, logComponent, message))
+		return
+	}
+
+	line := jsonLogLine{
+		Time:      currentTime.UTC().Format(time.RFC3339Nano),
+		Level:     level,
+		Component: logComponent,
+		Message:   message,
+	}
+
+	var batchErr *batchError
+	if errors.As(err, &batchErr) {
+		line.QueueURL = batchErr.queueURL
+		line.BatchID = batchErr.batchID
+		line.ErrorCode = batchErr.code
+	}
+	if line.ErrorCode == "" {
+		line.ErrorCode = awsErrorCode(err)
+	}
+
+	encoded, _ := json.Marshal(line)
+	writeLogLine(string(encoded) + "\n")
+}
@@ -0,0 +1,220 @@
+package sqsout
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestSetLogFormat(t *testing.T) {
+	tests := []struct {
+		envValue string
+		want     bool
+	}{
+		{"json", true},
+		{"JSON", true},
+		{"text", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		resetGlobals()
+		_ = os.Setenv("SQS_OUT_LOG_FORMAT", tt.envValue)
+		setLogFormat()
+		_ = os.Unsetenv("SQS_OUT_LOG_FORMAT")
+
+		if sqsOutLogJSON != tt.want {
+			t.Errorf("setLogFormat() with %q = %v, want %v", tt.envValue, sqsOutLogJSON, tt.want)
+		}
+	}
+}
+
+func TestWriteLogJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func()
+		want  jsonLogLine
+	}{
+		{
+			name:  "info log",
+			write: func() { writeInfoLog("QueueUrl is: q") },
+			want:  jsonLogLine{Level: "info", Message: "QueueUrl is: q"},
+		},
+		{
+			name:  "aws error code",
+			write: func() { writeErrorLog(&smithy.GenericAPIError{Code: "AccessDenied", Message: "not allowed"}) },
+			want:  jsonLogLine{Level: "error", Message: "api error AccessDenied: not allowed", ErrorCode: "AccessDenied"},
+		},
+		{
+			name: "batch error",
+			write: func() {
+				writeErrorLog(&batchError{
+					queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+					batchID:  "7",
+					err:      &smithy.GenericAPIError{Code: "RequestThrottled", Message: "slow down"},
+				})
+			},
+			want: jsonLogLine{
+				Level:     "error",
+				Message:   "api error RequestThrottled: slow down",
+				QueueURL:  "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+				BatchID:   "7",
+				ErrorCode: "RequestThrottled",
+			},
+		},
+		{
+			name:  "plain error",
+			write: func() { writeErrorLog(errors.New("boom")) },
+			want:  jsonLogLine{Level: "error", Message: "boom"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetGlobals()
+			sqsOutLogJSON = true
+
+			output := captureStdout(tt.write)
+
+			var got jsonLogLine
+			if err := json.Unmarshal([]byte(output), &got); err != nil {
+				t.Fatalf("log line is not JSON: %q", output)
+			}
+			if got.Time == "" || got.Component != "sqs-out" {
+				t.Errorf("missing time or component: %q", output)
+			}
+			got.Time, got.Component = "", ""
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendBatchToSqsLogsBatchFields(t *testing.T) {
+	resetGlobals()
+	sqsOutLogJSON = true
+	sqsConf := &sqsConfig{
+		queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS: &fakeSQS{output: &sqs.SendMessageBatchOutput{
+			Failed: []types.BatchResultErrorEntry{{Id: aws.String("msg-1"), Code: aws.String("InvalidMessageContents")}},
+		}},
+	}
+	records := []*types.SendMessageBatchRequestEntry{{Id: aws.String("msg-1"), MessageBody: aws.String("x")}}
+
+	output := captureStdout(func() {
+		if err := sendBatchToSqs(sqsConf, records); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	var got jsonLogLine
+	if err := json.Unmarshal([]byte(strings.TrimSpace(output)), &got); err != nil {
+		t.Fatalf("log line is not JSON: %q", output)
+	}
+	if got.QueueURL != sqsConf.queueURL || got.BatchID != "1" || got.ErrorCode != "InvalidMessageContents" {
+		t.Errorf("unexpected log fields: %+v", got)
+	}
+
+	sqsConf.mySQS = &fakeSQS{err: &smithy.GenericAPIError{Code: "AccessDenied", Message: "not allowed"}}
+	err := sendBatchToSqs(sqsConf, records)
+	var batchErr *batchError
+	if !errors.As(err, &batchErr) || batchErr.batchID != "2" || batchErr.queueURL != sqsConf.queueURL {
+		t.Errorf("expected a batchError for batch 2, got %#v", err)
+	}
+}
+
+func TestSetLogOutput(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "plugin.log")
+
+	tests := []struct {
+		value   string
+		want    io.Writer
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"stdout", nil, false},
+		{"STDERR", os.Stderr, false},
+		{"file:", nil, true},
+		{"file:" + filepath.Join(t.TempDir(), "missing", "plugin.log"), nil, true},
+		{"syslog", nil, true},
+	}
+
+	for _, tt := range tests {
+		resetGlobals()
+		err := setLogOutput(tt.value)
+		if (err != nil) != tt.wantErr || logOutput != tt.want {
+			t.Errorf("setLogOutput(%q) = %v, %v, want %v, wantErr %v", tt.value, logOutput, err, tt.want, tt.wantErr)
+		}
+	}
+
+	t.Run("file output", func(t *testing.T) {
+		resetGlobals()
+		defer resetGlobals()
+		if err := setLogOutput("file:" + logFile); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		file := logOutput
+		// a reloaded instance logging to the same file doesn't open it again
+		if err := setLogOutput("file:" + logFile); err != nil || logOutput != file {
+			t.Fatalf("expected the open file to be reused, got %v, %v", logOutput, err)
+		}
+
+		stdout := captureStdout(func() { writeInfoLog("to the file") })
+		if err := setLogOutput("stdout"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := file.(*os.File).Close(); err == nil {
+			t.Error("expected the replaced file to be closed")
+		}
+
+		content, err := os.ReadFile(logFile)
+		if err != nil {
+			t.Fatalf("failed to read log file: %v", err)
+		}
+		if stdout != "" || !strings.Contains(string(content), "to the file") {
+			t.Errorf("expected the log in the file only, got stdout %q and file %q", stdout, content)
+		}
+	})
+}
+
+func TestSetLogOutputWhileLogging(t *testing.T) {
+	resetGlobals()
+	defer resetGlobals()
+	dir := t.TempDir()
+
+	// the init of other instances replaces the file the senders log to
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				writeInfoLog("from a sender")
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if err := setLogOutput("file:" + filepath.Join(dir, fmt.Sprintf("plugin-%d.log", i%2))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,93 @@
+package sqsout
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// memBufLimit tracks the serialized bytes the plugin holds in memory, in the
+// pending batch and in the batches handed over to the workers, against
+// MemBufLimit. like the Mem_Buf_Limit of fluent bit inputs, once the limit is
+// reached new chunks are not accepted until sends free memory. with the
+// "retry" overflow policy the chunks are handed back to fluent bit, which
+// keeps them in its own storage (on disk with storage.type filesystem)
+type memBufLimit struct {
+	limit         int64
+	dropOverflow  bool
+	pending       atomic.Int64
+	overflowCount atomic.Int64
+}
+
+func newMemBufLimit(limitString, overflowString string) (*memBufLimit, error) {
+	if limitString == "" {
+		if overflowString != "" {
+			return nil, errors.New("MemBufOverflow requires MemBufLimit to be set")
+		}
+		return nil, nil
+	}
+
+	limit, err := parseSize(limitString)
+	if err != nil || limit <= 0 {
+		return nil, errors.New("MemBufLimit should be a positive size, e.g. 512k, 64M or 1G")
+	}
+
+	memBuf := &memBufLimit{limit: limit}
+	switch overflowString {
+	case "", "retry":
+	case "drop":
+		memBuf.dropOverflow = true
+	default:
+		return nil, fmt.Errorf("MemBufOverflow should be one of: retry, drop. got %q", overflowString)
+	}
+
+	return memBuf, nil
+}
+
+// parseSize parses a size with the units fluent bit uses in its own
+// configuration: a plain number of bytes or a number followed by k, m or g
+// (optionally with a trailing b), case insensitive
+func parseSize(value string) (int64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	value = strings.TrimSuffix(value, "b")
+
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "k"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(value, "m"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(value, "g"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return size * multiplier, nil
+}
+
+func (m *memBufLimit) add(bytes int64) {
+	m.pending.Add(bytes)
+}
+
+func (m *memBufLimit) release(bytes int64) {
+	m.pending.Add(-bytes)
+}
+
+// admit returns true when a new chunk can be buffered
+func (m *memBufLimit) admit() bool {
+	if m.pending.Load() < m.limit {
+		return true
+	}
+
+	m.overflowCount.Add(1)
+	return false
+}
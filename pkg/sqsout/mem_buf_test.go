@@ -0,0 +1,135 @@
+package sqsout
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{"1024", 1024, false},
+		{"512k", 512 << 10, false},
+		{"512KB", 512 << 10, false},
+		{"64M", 64 << 20, false},
+		{"64mb", 64 << 20, false},
+		{"1G", 1 << 30, false},
+		{" 8m ", 8 << 20, false},
+		{"", 0, true},
+		{"M", 0, true},
+		{"12T", 0, true},
+		{"lots", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSize(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d, wantErr %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNewMemBufLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    string
+		overflow string
+		wantNil  bool
+		wantDrop bool
+		wantErr  bool
+	}{
+		{name: "not configured", wantNil: true},
+		{name: "default overflow", limit: "64M"},
+		{name: "retry overflow", limit: "64M", overflow: "retry"},
+		{name: "drop overflow", limit: "64M", overflow: "drop", wantDrop: true},
+		{name: "overflow without limit", overflow: "drop", wantErr: true},
+		{name: "unknown overflow", limit: "64M", overflow: "spill", wantErr: true},
+		{name: "invalid limit", limit: "a lot", wantErr: true},
+		{name: "zero limit", limit: "0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memBuf, err := newMemBufLimit(tt.limit, tt.overflow)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newMemBufLimit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (memBuf == nil) != tt.wantNil {
+				t.Fatalf("newMemBufLimit() = %v, wantNil %v", memBuf, tt.wantNil)
+			}
+			if memBuf != nil && memBuf.dropOverflow != tt.wantDrop {
+				t.Errorf("dropOverflow = %v, want %v", memBuf.dropOverflow, tt.wantDrop)
+			}
+		})
+	}
+}
+
+func TestMemBufLimitAdmit(t *testing.T) {
+	memBuf := &memBufLimit{limit: 100}
+
+	memBuf.add(99)
+	if !memBuf.admit() {
+		t.Error("expected chunk to be admitted below the limit")
+	}
+
+	memBuf.add(1)
+	if memBuf.admit() {
+		t.Error("expected chunk to be refused at the limit")
+	}
+	if memBuf.overflowCount.Load() != 1 {
+		t.Errorf("expected 1 overflow, got %d", memBuf.overflowCount.Load())
+	}
+
+	memBuf.release(50)
+	if !memBuf.admit() {
+		t.Error("expected chunk to be admitted once memory was released")
+	}
+}
+
+func TestMemBufLimitTracking(t *testing.T) {
+	timestamp := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+
+	t.Run("synchronous sends release the sent batches", func(t *testing.T) {
+		resetGlobals()
+		sqsConf := &sqsConfig{
+			queueURL:  "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+			mySQS:     &recordingSQS{},
+			batchSize: 2,
+			memBuf:    &memBufLimit{limit: 1 << 20},
+		}
+
+		if err := flushRecords(sqsConf, "app.log", sliceIterator(timestamp, messageRecords(3)...)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got, want := sqsConf.memBuf.pending.Load(), batchBytes(sqsConf.batches.get("app.log").records); got != want || want == 0 {
+			t.Errorf("expected the %d bytes of the pending batch, got %d", want, got)
+		}
+	})
+
+	t.Run("workers release the batches they sent", func(t *testing.T) {
+		resetGlobals()
+		sqsConf := &sqsConfig{
+			queueURL:  "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+			mySQS:     &concurrentFakeSQS{},
+			batchSize: 2,
+			memBuf:    &memBufLimit{limit: 1 << 20},
+		}
+		sqsConf.senders = newSenderPool(sqsConf, 2, 6)
+
+		if err := flushRecords(sqsConf, "app.log", sliceIterator(timestamp, messageRecords(4)...)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		stopSenderPools()
+
+		if got := sqsConf.memBuf.pending.Load(); got != 0 {
+			t.Errorf("expected no pending bytes, got %d", got)
+		}
+	})
+}
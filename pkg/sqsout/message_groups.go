@@ -0,0 +1,169 @@
+package sqsout
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// groupTicket is the position of a batch in the send order of each message
+// group it holds
+type groupTicket map[string]int64
+
+// groupOrder keeps the batches of a FIFO message group in the order they
+// were handed over to the workers, while the batches of distinct groups are
+// sent concurrently. a batch waits until the batches handed over before it
+// with a common group are sent. the tickets of a batch are issued together,
+// two batches are in the same order for all their groups and can't wait on
+// each other
+type groupOrder struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	issued map[string]int64
+	sent   map[string]int64
+}
+
+func newGroupOrder() *groupOrder {
+	o := &groupOrder{
+		issued: make(map[string]int64),
+		sent:   make(map[string]int64),
+	}
+	o.cond = sync.NewCond(&o.mu)
+	return o
+}
+
+// issue returns the tickets of a batch, nil when it holds no FIFO entry
+func (o *groupOrder) issue(records []*types.SendMessageBatchRequestEntry) groupTicket {
+	var ticket groupTicket
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, record := range records {
+		if record.MessageGroupId == nil {
+			continue
+		}
+		group := aws.ToString(record.MessageGroupId)
+		if _, ok := ticket[group]; ok {
+			continue
+		}
+		if ticket == nil {
+			ticket = make(groupTicket)
+		}
+		ticket[group] = o.issued[group]
+		o.issued[group]++
+	}
+	return ticket
+}
+
+// wait blocks until the batch is the next one to send in all its groups
+func (o *groupOrder) wait(ticket groupTicket) {
+	if ticket == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for !o.turn(ticket) {
+		o.cond.Wait()
+	}
+}
+
+func (o *groupOrder) turn(ticket groupTicket) bool {
+	for group, position := range ticket {
+		if o.sent[group] != position {
+			return false
+		}
+	}
+	return true
+}
+
+// done lets the next batches of the groups be sent. the groups without a
+// waiting batch are forgotten, so the maps don't grow with the groups used
+// over time
+func (o *groupOrder) done(ticket groupTicket) {
+	if ticket == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for group := range ticket {
+		o.sent[group]++
+		if o.sent[group] == o.issued[group] {
+			delete(o.sent, group)
+			delete(o.issued, group)
+		}
+	}
+	o.cond.Broadcast()
+}
+
+// messageGroupID is the message group of a record: the value of
+// MessageGroupIdKey in the record, QueueMessageGroupId when the record has
+// none
+func (sqsConf *sqsConfig) messageGroupID(prepared *preparedRecord) string {
+	if prepared.messageGroupID != "" {
+		return prepared.messageGroupID
+	}
+	return sqsConf.queueMessageGroupID
+}
+
+// balanceWindowBatches is the number of batches of records staged per tag
+// with BalanceMessageGroups, the batches are composed from them
+const balanceWindowBatches = 4
+
+// balanceGroups composes a batch of at most size records and maxBytes of
+// message bodies taking them from the message groups in turn, so a chatty
+// group doesn't delay the records of the others behind its own. the records
+// of a group keep their order and the records left out are returned in their
+// staging order. next is the group the batch starts with, it moves on at
+// every batch so all the groups get a turn when they are more than the batch
+// size
+func balanceGroups(records []*types.SendMessageBatchRequestEntry, size int, maxBytes int64, next *int) (batch, staged []*types.SendMessageBatchRequestEntry) {
+	var groups []string
+	queues := make(map[string][]int)
+	for i, record := range records {
+		group := aws.ToString(record.MessageGroupId)
+		if _, ok := queues[group]; !ok {
+			groups = append(groups, group)
+		}
+		queues[group] = append(queues[group], i)
+	}
+
+	picked := make([]bool, len(records))
+	batch = make([]*types.SendMessageBatchRequestEntry, 0, size)
+	start := 0
+	if len(groups) > 0 {
+		start = *next % len(groups)
+	}
+	var payload int64
+	for added := true; added && len(batch) < size; {
+		added = false
+		for i := range groups {
+			group := groups[(start+i)%len(groups)]
+			if len(queues[group]) == 0 || len(batch) == size {
+				continue
+			}
+			index := queues[group][0]
+			// the first record always goes, a record is never over the limit
+			bytes := entryBytes(records[index])
+			if len(batch) > 0 && payload+bytes > maxBytes {
+				continue
+			}
+			queues[group] = queues[group][1:]
+			picked[index] = true
+			batch = append(batch, records[index])
+			payload += bytes
+			added = true
+		}
+	}
+	*next++
+
+	staged = make([]*types.SendMessageBatchRequestEntry, 0, len(records)-len(batch))
+	for i, record := range records {
+		if !picked[i] {
+			staged = append(staged, record)
+		}
+	}
+	return batch, staged
+}
@@ -0,0 +1,252 @@
+package sqsout
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// groupRecordingSQS implements sqsClient interface recording the bodies
+// sent for every message group
+type groupRecordingSQS struct {
+	mu          sync.Mutex
+	bodies      map[string][]string
+	inFlight    int64
+	maxInFlight int64
+}
+
+func (f *groupRecordingSQS) SendMessageBatch(ctx context.Context, input *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	current := atomic.AddInt64(&f.inFlight, 1)
+	defer atomic.AddInt64(&f.inFlight, -1)
+
+	f.mu.Lock()
+	if current > f.maxInFlight {
+		f.maxInFlight = current
+	}
+	f.mu.Unlock()
+
+	// the later batches are faster, they overtake the earlier ones unless
+	// they wait for them
+	time.Sleep(time.Duration(20-len(f.bodiesOf(input))) * time.Millisecond)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, entry := range input.Entries {
+		group := aws.ToString(entry.MessageGroupId)
+		f.bodies[group] = append(f.bodies[group], aws.ToString(entry.MessageBody))
+	}
+	return &sqs.SendMessageBatchOutput{}, nil
+}
+
+func (f *groupRecordingSQS) bodiesOf(input *sqs.SendMessageBatchInput) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.bodies[aws.ToString(input.Entries[0].MessageGroupId)]
+}
+
+func groupBatch(group string, bodies ...string) []*types.SendMessageBatchRequestEntry {
+	records := make([]*types.SendMessageBatchRequestEntry, len(bodies))
+	for i, body := range bodies {
+		records[i] = &types.SendMessageBatchRequestEntry{
+			Id:             aws.String(fmt.Sprintf("msg-%d", i)),
+			MessageBody:    aws.String(body),
+			MessageGroupId: aws.String(group),
+		}
+	}
+	return records
+}
+
+func TestSenderPoolMessageGroups(t *testing.T) {
+	resetGlobals()
+	fake := &groupRecordingSQS{bodies: map[string][]string{}}
+	pool := newSenderPool(&sqsConfig{queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue.fifo", mySQS: fake}, 4, 12)
+
+	for i := 0; i < 4; i++ {
+		pool.submit(groupBatch("a", fmt.Sprintf("a-%d", i)))
+		pool.submit(groupBatch("b", fmt.Sprintf("b-%d", i)))
+	}
+	stopSenderPools()
+
+	want := map[string][]string{
+		"a": {"a-0", "a-1", "a-2", "a-3"},
+		"b": {"b-0", "b-1", "b-2", "b-3"},
+	}
+	if !reflect.DeepEqual(fake.bodies, want) {
+		t.Errorf("expected the batches of each group in order, got %v", fake.bodies)
+	}
+	if fake.maxInFlight < 2 {
+		t.Errorf("expected the groups to be sent concurrently, max in flight was %d", fake.maxInFlight)
+	}
+	if len(pool.groups.issued) != 0 || len(pool.groups.sent) != 0 {
+		t.Errorf("expected the sent groups to be forgotten, got %v and %v", pool.groups.issued, pool.groups.sent)
+	}
+}
+
+func TestGroupOrderIssue(t *testing.T) {
+	order := newGroupOrder()
+
+	tests := []struct {
+		name    string
+		records []*types.SendMessageBatchRequestEntry
+		want    groupTicket
+	}{
+		{"standard queue", testBatch(2), nil},
+		{"first batch of a group", groupBatch("a", "1", "2"), groupTicket{"a": 0}},
+		{"mixed groups", append(groupBatch("a", "3"), groupBatch("b", "4")...), groupTicket{"a": 1, "b": 0}},
+	}
+
+	for _, tt := range tests {
+		if got := order.issue(tt.records); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: issue() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMessageGroupIDKey(t *testing.T) {
+	resetGlobals()
+	fake := &recordingSQS{}
+	sqsConf := &sqsConfig{
+		queueURL:            "https://sqs.us-east-1.amazonaws.com/123456789/test-queue.fifo",
+		queueMessageGroupID: "default",
+		messageGroupIDKey:   "tenant",
+		mySQS:               fake,
+		batchSize:           10,
+	}
+
+	err := flushRecords(sqsConf, "app", sliceIterator(time.Now(),
+		map[interface{}]interface{}{"log": "first", "tenant": []byte("acme")},
+		map[interface{}]interface{}{"log": "second"},
+	))
+	if err == nil {
+		err = flushPendingBatches(sqsConf)
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entries := fake.batches[0].Entries
+	if got := []string{aws.ToString(entries[0].MessageGroupId), aws.ToString(entries[1].MessageGroupId)}; !reflect.DeepEqual(got, []string{"acme", "default"}) {
+		t.Errorf("expected the groups of the key then QueueMessageGroupId, got %v", got)
+	}
+}
+
+func TestBalanceGroups(t *testing.T) {
+	bodies := func(records []*types.SendMessageBatchRequestEntry) []string {
+		var got []string
+		for _, record := range records {
+			got = append(got, aws.ToString(record.MessageBody))
+		}
+		return got
+	}
+
+	tests := []struct {
+		name       string
+		records    []*types.SendMessageBatchRequestEntry
+		size       int
+		maxBytes   int64
+		next       int
+		wantBatch  []string
+		wantStaged []string
+	}{
+		{
+			name:       "chatty group",
+			records:    append(groupBatch("a", "a1", "a2", "a3", "a4"), groupBatch("b", "b1")...),
+			size:       3,
+			wantBatch:  []string{"a1", "b1", "a2"},
+			wantStaged: []string{"a3", "a4"},
+		},
+		{
+			name:       "more groups than the batch size",
+			records:    append(append(groupBatch("a", "a1"), groupBatch("b", "b1")...), groupBatch("c", "c1")...),
+			size:       2,
+			next:       1,
+			wantBatch:  []string{"b1", "c1"},
+			wantStaged: []string{"a1"},
+		},
+		{
+			name:       "payload limit",
+			records:    append(groupBatch("a", "a1", "a2"), groupBatch("b", "b1")...),
+			size:       10,
+			maxBytes:   4,
+			wantBatch:  []string{"a1", "b1"},
+			wantStaged: []string{"a2"},
+		},
+		{
+			name:      "fewer records than the batch size",
+			records:   append(groupBatch("a", "a1", "a2"), groupBatch("b", "b1")...),
+			size:      10,
+			wantBatch: []string{"a1", "b1", "a2"},
+		},
+	}
+
+	for _, tt := range tests {
+		next := tt.next
+		maxBytes := tt.maxBytes
+		if maxBytes == 0 {
+			maxBytes = maxMessageBytes
+		}
+		batch, staged := balanceGroups(tt.records, tt.size, maxBytes, &next)
+		if !reflect.DeepEqual(bodies(batch), tt.wantBatch) || !reflect.DeepEqual(bodies(staged), tt.wantStaged) {
+			t.Errorf("%s: balanceGroups() = %v, %v, want %v, %v", tt.name, bodies(batch), bodies(staged), tt.wantBatch, tt.wantStaged)
+		}
+		if next != tt.next+1 {
+			t.Errorf("%s: expected the next batch to start with the next group, got %d", tt.name, next)
+		}
+	}
+}
+
+func TestBalanceMessageGroups(t *testing.T) {
+	resetGlobals()
+	fake := &recordingSQS{}
+	sqsConf := &sqsConfig{
+		queueURL:            "https://sqs.us-east-1.amazonaws.com/123456789/test-queue.fifo",
+		queueMessageGroupID: "default",
+		messageGroupIDKey:   "tenant",
+		balanceGroups:       true,
+		mySQS:               fake,
+		batchSize:           2,
+	}
+
+	var records []map[interface{}]interface{}
+	for i := 0; i < 7; i++ {
+		records = append(records, map[interface{}]interface{}{"log": fmt.Sprintf("chatty-%d", i), "tenant": "chatty"})
+	}
+	records = append(records, map[interface{}]interface{}{"log": "quiet-0", "tenant": "quiet"})
+
+	err := flushRecords(sqsConf, "app", sliceIterator(time.Now(), records...))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the window of 4 batches is full with the last record, the first batch
+	// already has the quiet group
+	if len(fake.batches) != 1 || aws.ToString(fake.batches[0].Entries[1].MessageGroupId) != "quiet" {
+		t.Fatalf("expected a balanced batch, got %v", fake.batches)
+	}
+
+	if err := flushPendingBatches(sqsConf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var sent int
+	for _, batch := range fake.batches {
+		sent += len(batch.Entries)
+		ids := map[string]bool{}
+		for _, entry := range batch.Entries {
+			if ids[aws.ToString(entry.Id)] {
+				t.Errorf("duplicate entry id %s in a batch", aws.ToString(entry.Id))
+			}
+			ids[aws.ToString(entry.Id)] = true
+		}
+	}
+	if sent != 8 || sqsConf.batches.pending() != 0 {
+		t.Errorf("expected the staged records to be sent, got %d sent and %d pending", sent, sqsConf.batches.pending())
+	}
+}
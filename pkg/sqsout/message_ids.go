@@ -0,0 +1,64 @@
+package sqsout
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// messageIDLog correlates the batch entries with a record field, so the debug
+// logs of the accepted MessageIds tell which record became which message
+type messageIDLog struct {
+	key string
+	// fields holds the field value of the entries waiting to be sent
+	fields sync.Map
+}
+
+func newMessageIDLog(key string) *messageIDLog {
+	if key == "" {
+		return nil
+	}
+	return &messageIDLog{key: key}
+}
+
+// field returns a copyFieldString of the correlation field of a record
+func (l *messageIDLog) field(record map[interface{}]interface{}) string {
+	value, ok := recordField(record, l.key)
+	if !ok {
+		return ""
+	}
+	return copyFieldString(value)
+}
+
+func (l *messageIDLog) remember(entry *types.SendMessageBatchRequestEntry, value string) {
+	l.fields.Store(entry, value)
+}
+
+// forget drops the field values of a sent batch, before its entries go back
+// to the pool
+func (l *messageIDLog) forget(records []*types.SendMessageBatchRequestEntry) {
+	for _, entry := range records {
+		l.fields.Delete(entry)
+	}
+}
+
+// logAcceptedMessages logs the MessageId of every message the queue accepted
+func logAcceptedMessages(sqsConf *sqsConfig, queueURL, batchID string, records []*types.SendMessageBatchRequestEntry, output *sqs.SendMessageBatchOutput) {
+	entries := make(map[string]*types.SendMessageBatchRequestEntry, len(records))
+	for _, entry := range records {
+		entries[aws.ToString(entry.Id)] = entry
+	}
+
+	for _, result := range output.Successful {
+		message := fmt.Sprintf("message %s of batch %s accepted by %s with MessageId %s", aws.ToString(result.Id), batchID, queueName(queueURL), aws.ToString(result.MessageId))
+		if sqsConf.messageIDLog != nil {
+			if value, ok := sqsConf.messageIDLog.fields.Load(entries[aws.ToString(result.Id)]); ok {
+				message += fmt.Sprintf(" (%s=%s)", sqsConf.messageIDLog.key, value)
+			}
+		}
+		writeDebugLog(message)
+	}
+}
@@ -0,0 +1,111 @@
+package sqsout
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestMessageIDLogField(t *testing.T) {
+	record := map[interface{}]interface{}{
+		"request_id": []byte("req-1"),
+		"http":       map[interface{}]interface{}{"trace": "abc"},
+	}
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"request_id", "req-1"},
+		{"http.trace", "abc"},
+		{"missing", ""},
+	}
+
+	for _, tt := range tests {
+		if got := newMessageIDLog(tt.key).field(record); got != tt.want {
+			t.Errorf("field(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+
+	if newMessageIDLog("") != nil {
+		t.Error("expected no message id log without a key")
+	}
+}
+
+func TestSendBatchToSqsLogsMessageIDs(t *testing.T) {
+	tests := []struct {
+		name     string
+		logLevel int
+		key      string
+		want     []string
+		notWant  []string
+	}{
+		{
+			name:     "debug level logs the message ids",
+			logLevel: 0,
+			want:     []string{"message msg-1 of batch 1 accepted by test-queue with MessageId sqs-id-1"},
+			notWant:  []string{"msg-2"},
+		},
+		{
+			name:     "debug level logs the correlated field",
+			logLevel: 0,
+			key:      "request_id",
+			want:     []string{"with MessageId sqs-id-1 (request_id=req-1)"},
+		},
+		{
+			name:     "info level logs nothing",
+			logLevel: 1,
+			key:      "request_id",
+			notWant:  []string{"msg-1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetGlobals()
+			sqsOutLogLevel = tt.logLevel
+			sqsConf := &sqsConfig{
+				queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+				mySQS: &fakeSQS{output: &sqs.SendMessageBatchOutput{
+					Successful: []types.SendMessageBatchResultEntry{{Id: aws.String("msg-1"), MessageId: aws.String("sqs-id-1")}},
+					Failed:     []types.BatchResultErrorEntry{{Id: aws.String("msg-2"), Code: aws.String("InternalError")}},
+				}},
+				messageIDLog: newMessageIDLog(tt.key),
+			}
+			records := []*types.SendMessageBatchRequestEntry{
+				{Id: aws.String("msg-1"), MessageBody: aws.String("a")},
+				{Id: aws.String("msg-2"), MessageBody: aws.String("b")},
+			}
+			if sqsConf.messageIDLog != nil {
+				sqsConf.messageIDLog.remember(records[0], "req-1")
+			}
+
+			output := captureStdout(func() {
+				if err := sendBatchToSqs(sqsConf, records); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			})
+
+			for _, want := range tt.want {
+				if !strings.Contains(output, want) {
+					t.Errorf("expected %q in the logs, got %q", want, output)
+				}
+			}
+			for _, line := range strings.Split(output, "\n") {
+				for _, notWant := range tt.notWant {
+					if strings.Contains(line, "accepted") && strings.Contains(line, notWant) {
+						t.Errorf("unexpected %q in the logs: %q", notWant, line)
+					}
+				}
+			}
+			if sqsConf.messageIDLog != nil {
+				if _, ok := sqsConf.messageIDLog.fields.Load(records[0]); ok {
+					t.Error("expected the field values to be forgotten after the send")
+				}
+			}
+		})
+	}
+}
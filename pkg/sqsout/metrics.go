@@ -0,0 +1,220 @@
+package sqsout
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// pluginMetrics are the counters fluent bit reports for every output in
+// /api/v1/metrics, as seen from inside the plugin:
+//
+//   - procRecords: messages accepted by the destination
+//   - errors: messages the destination rejected plus chunks failed with
+//     FLB_ERROR
+//   - retries: messages sent again by SendRetries plus chunks handed back
+//     with FLB_RETRY
+//   - dropped: records dropped by the plugin (filters, sampling, invalid
+//     records, serialization errors) plus chunks dropped whole
+//
+// the version of fluent-bit-go the plugin is built with has no hooks to
+// register metrics with the engine, which counts the chunks of Go outputs from
+// the return codes of the flush callback only. the plugin metrics are served
+// by MetricsListen on the paths of fluent bit, logged when fluent bit stops
+// and are the source of the plugin's own reporting
+type pluginMetrics struct {
+	procRecords int64
+	errors      int64
+	retries     int64
+	dropped     int64
+}
+
+// pluginMetrics returns the current plugin metrics
+func (s *deliveryStats) pluginMetrics() pluginMetrics {
+	return pluginMetrics{
+		procRecords: s.messagesSent.Load(),
+		errors:      s.messagesFailed.Load() + s.chunksFailed.Load(),
+		retries:     s.messagesRetried.Load() + s.chunksRetried.Load(),
+		dropped:     s.recordsDropped.Load() + s.chunksDropped.Load(),
+	}
+}
+
+func (m pluginMetrics) String() string {
+	return fmt.Sprintf("proc_records=%d errors=%d retries=%d dropped=%d", m.procRecords, m.errors, m.retries, m.dropped)
+}
+
+// destination is a queue an instance sends to, with its own counters. the
+// metrics are reported per destination so a failing shadow or invalid record
+// queue can be told from the main queue
+type destination struct {
+	queueURL string
+	stats    *deliveryStats
+}
+
+// name is the queue name, the label of the destination metrics
+func (d destination) name() string {
+	return queueName(d.queueURL)
+}
+
+// destinations returns the main queue followed by the side queues in use
+func (sqsConf *sqsConfig) destinations() []destination {
+	destinations := []destination{{queueURL: sqsConf.queueURL, stats: &sqsConf.stats}}
+	if sqsConf.shadow != nil {
+		destinations = append(destinations, destination{queueURL: sqsConf.shadow.queueURL, stats: &sqsConf.shadow.stats})
+	}
+	if sqsConf.invalidRecords != nil {
+		destinations = append(destinations, destination{queueURL: sqsConf.invalidRecords.queueURL, stats: &sqsConf.invalidRecords.stats})
+	}
+	if sqsConf.mirror != nil {
+		destinations = append(destinations, destination{queueURL: sqsConf.mirror.queueURL, stats: &sqsConf.mirror.stats})
+	}
+	return destinations
+}
+
+// queueName returns the name of a queue from its url, or of a topic from its
+// ARN
+func queueName(queueURL string) string {
+	queueURL = strings.TrimRight(queueURL, "/")
+	if i := strings.LastIndexAny(queueURL, "/:"); i >= 0 {
+		return queueURL[i+1:]
+	}
+	return queueURL
+}
+
+// instances holds the initialized plugin instances so Shutdown, which
+// gets no context, can close them
+var (
+	instances   []*sqsConfig
+	instancesMu sync.Mutex
+)
+
+func registerInstance(sqsConf *sqsConfig) {
+	instancesMu.Lock()
+	instances = append(instances, sqsConf)
+	instancesMu.Unlock()
+}
+
+// unregisterInstance removes a closed instance and returns how many are left.
+// ok is false when the instance was already closed
+func unregisterInstance(sqsConf *sqsConfig) (remaining int, ok bool) {
+	instancesMu.Lock()
+	defer instancesMu.Unlock()
+
+	for i, instance := range instances {
+		if instance == sqsConf {
+			instances = append(instances[:i], instances[i+1:]...)
+			return len(instances), true
+		}
+	}
+	return len(instances), false
+}
+
+// closeInstance sends the partial batches of an instance, drains its senders,
+// stops its reporters and logs its metrics. the process wide reporters are
+// stopped with the last instance. closing an instance twice does nothing
+func closeInstance(sqsConf *sqsConfig) {
+	remaining, ok := unregisterInstance(sqsConf)
+	if !ok {
+		return
+	}
+
+	// fluent bit already got FLB_OK for the records of the partial batches,
+	// they would be lost on a reload
+	if err := flushPendingBatches(sqsConf); err != nil {
+		writeErrorLog(fmt.Errorf("failed to send the pending batches of %s: %v", sqsConf.queueURL, err))
+	}
+	if sqsConf.senders != nil {
+		unregisterSenderPool(sqsConf.senders)
+		sqsConf.senders.stop()
+	}
+	stopInstanceReporters(sqsConf)
+	unpaceQueues(sqsConf)
+	logMetrics(sqsConf)
+
+	if remaining == 0 {
+		stopInstanceReporters(nil)
+	}
+}
+
+// closeInstances closes every instance, so the plugin initialized again by a
+// fluent bit reload starts without the senders and reporters of the previous
+// configuration
+func closeInstances() {
+	instancesMu.Lock()
+	closing := append([]*sqsConfig(nil), instances...)
+	instancesMu.Unlock()
+
+	for _, sqsConf := range closing {
+		closeInstance(sqsConf)
+	}
+}
+
+// logMetrics logs the plugin metrics of the destinations of an instance
+func logMetrics(sqsConf *sqsConfig) {
+	for _, d := range sqsConf.destinations() {
+		writeInfoLog(fmt.Sprintf("metrics of %s (%s): %s", d.name(), d.queueURL, d.stats.pluginMetrics()))
+		writeInfoLog(fmt.Sprintf("bytes of %s: serialized=%d sent=%d failed=%d rejected=%d evicted=%d", d.name(), d.stats.bytesSerialized.Load(), d.stats.bytesSent.Load(), d.stats.bytesFailed.Load(), d.stats.bytesRejected.Load(), d.stats.bytesEvicted.Load()))
+		writeInfoLog(fmt.Sprintf("retries of %s: messages sent again by the plugin=%d chunks handed back to fluent bit=%d", d.name(), d.stats.messagesRetried.Load(), d.stats.chunksRetried.Load()))
+		writeInfoLog(fmt.Sprintf("send latency of %s: %s", d.name(), d.stats.sendLatency.snapshot()))
+		writeInfoLog(fmt.Sprintf("drops of %s: %s", d.name(), formatDrops(d.stats.drops.snapshot())))
+		if failures := d.stats.failureCodes.String(); failures != "" {
+			writeInfoLog(fmt.Sprintf("failures of %s by code: %s", d.name(), failures))
+		}
+	}
+}
+
+// reporter is a background metrics reporter, stopped by Shutdown or when
+// the instance it reports on is closed
+type reporter interface {
+	stop()
+}
+
+// instanceReporter is a running reporter and its instance, nil for the
+// process wide reporters
+type instanceReporter struct {
+	owner    *sqsConfig
+	reporter reporter
+}
+
+var (
+	reporters   []instanceReporter
+	reportersMu sync.Mutex
+)
+
+func registerReporter(owner *sqsConfig, r reporter) {
+	reportersMu.Lock()
+	reporters = append(reporters, instanceReporter{owner: owner, reporter: r})
+	reportersMu.Unlock()
+}
+
+// stopReporters stops every running reporter
+func stopReporters() {
+	reportersMu.Lock()
+	stopping := reporters
+	reporters = nil
+	reportersMu.Unlock()
+
+	for _, r := range stopping {
+		r.reporter.stop()
+	}
+}
+
+// stopInstanceReporters stops the running reporters of an instance
+func stopInstanceReporters(owner *sqsConfig) {
+	reportersMu.Lock()
+	var stopping []reporter
+	running := reporters[:0]
+	for _, r := range reporters {
+		if r.owner == owner {
+			stopping = append(stopping, r.reporter)
+		} else {
+			running = append(running, r)
+		}
+	}
+	reporters = running
+	reportersMu.Unlock()
+
+	for _, r := range stopping {
+		r.stop()
+	}
+}
@@ -0,0 +1,132 @@
+package sqsout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsServerShutdownTimeout is how long stop waits for the requests in
+// flight
+const metricsServerShutdownTimeout = 5 * time.Second
+
+// metricsServer serves the plugin metrics of every destination of an
+// instance over HTTP, fluent bit has no hook to report them in its own
+// /api/v1/metrics. the paths are the ones of fluent bit:
+//
+//   - /api/v1/metrics: JSON in the shape of fluent bit, the outputs keyed
+//     by queue name
+//   - /api/v1/metrics/prometheus: the Prometheus text format, labeled with
+//     the destination queue
+type metricsServer struct {
+	sqsConf  *sqsConfig
+	listener net.Listener
+	server   *http.Server
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// newMetricsServer listens on the MetricsListen address
+func newMetricsServer(sqsConf *sqsConfig, address string) (*metricsServer, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, errors.New("MetricsListen should be a host:port address, e.g. 127.0.0.1:2021")
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on MetricsListen %s: %v", address, err)
+	}
+
+	m := &metricsServer{
+		sqsConf:  sqsConf,
+		listener: listener,
+		done:     make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/metrics", m.serveJSON)
+	mux.HandleFunc("/api/v1/metrics/prometheus", m.servePrometheus)
+	m.server = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	return m, nil
+}
+
+// start serves the metrics until stop is called
+func (m *metricsServer) start() {
+	registerReporter(m.sqsConf, m)
+
+	go func() {
+		defer close(m.done)
+		if err := m.server.Serve(m.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			writeErrorLog(fmt.Errorf("the metrics server stopped: %v", err))
+		}
+	}()
+}
+
+// stop closes the listener, it is safe to call more than once
+func (m *metricsServer) stop() {
+	m.stopOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), metricsServerShutdownTimeout)
+		defer cancel()
+		_ = m.server.Shutdown(ctx)
+		<-m.done
+	})
+}
+
+// jsonPluginMetrics are the counters of a destination in the JSON format of
+// fluent bit
+type jsonPluginMetrics struct {
+	ProcRecords    int64 `json:"proc_records"`
+	Errors         int64 `json:"errors"`
+	Retries        int64 `json:"retries"`
+	DroppedRecords int64 `json:"dropped_records"`
+}
+
+func (m *metricsServer) serveJSON(w http.ResponseWriter, r *http.Request) {
+	outputs := map[string]jsonPluginMetrics{}
+	for _, d := range m.sqsConf.destinations() {
+		metrics := d.stats.pluginMetrics()
+		outputs[d.name()] = jsonPluginMetrics{
+			ProcRecords:    metrics.procRecords,
+			Errors:         metrics.errors,
+			Retries:        metrics.retries,
+			DroppedRecords: metrics.dropped,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"output": outputs})
+}
+
+func (m *metricsServer) servePrometheus(w http.ResponseWriter, r *http.Request) {
+	destinations := m.sqsConf.destinations()
+	metrics := make([]pluginMetrics, len(destinations))
+	for i, d := range destinations {
+		metrics[i] = d.stats.pluginMetrics()
+	}
+
+	var b strings.Builder
+	for _, counter := range []struct {
+		name  string
+		help  string
+		value func(pluginMetrics) int64
+	}{
+		{"proc_records", "messages accepted by the destination", func(m pluginMetrics) int64 { return m.procRecords }},
+		{"errors", "messages rejected by the destination and chunks failed", func(m pluginMetrics) int64 { return m.errors }},
+		{"retries", "messages sent again and chunks handed back for a retry", func(m pluginMetrics) int64 { return m.retries }},
+		{"dropped_records", "records and chunks dropped by the plugin", func(m pluginMetrics) int64 { return m.dropped }},
+	} {
+		name := "fluentbit_sqs_output_" + counter.name + "_total"
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", name, counter.help, name)
+		for i, d := range destinations {
+			fmt.Fprintf(&b, "%s{queue=%q} %d\n", name, d.name(), counter.value(metrics[i]))
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write([]byte(b.String()))
+}
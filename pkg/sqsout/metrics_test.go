@@ -0,0 +1,230 @@
+package sqsout
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPluginMetrics(t *testing.T) {
+	var stats deliveryStats
+	stats.messagesSent.Add(10)
+	stats.messagesFailed.Add(2)
+	stats.chunksFailed.Add(1)
+	stats.messagesRetried.Add(3)
+	stats.chunksRetried.Add(4)
+	stats.recordsDropped.Add(5)
+	stats.chunksDropped.Add(6)
+
+	got := stats.pluginMetrics()
+	want := pluginMetrics{procRecords: 10, errors: 3, retries: 7, dropped: 11}
+	if got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+	if got.String() != "proc_records=10 errors=3 retries=7 dropped=11" {
+		t.Errorf("unexpected string %q", got.String())
+	}
+}
+
+func TestPluginMetricsFromFlush(t *testing.T) {
+	resetGlobals()
+	fake := &scriptedSQS{responses: []scriptedResponse{{err: errors.New("timeout")}}}
+	filter, _ := newRecordFilter("message", "keep", "")
+	sqsConf := &sqsConfig{
+		queueURL:   "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS:      fake,
+		batchSize:  2,
+		processors: newProcessorChain(nil, filter),
+		retry:      retryPolicy{retries: 1, backoff: time.Millisecond},
+	}
+	sqsConf.retry.retried = &sqsConf.stats.messagesRetried
+
+	timestamp := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	captureStdout(func() {
+		err := flushRecords(sqsConf, "app.log", sliceIterator(timestamp,
+			map[interface{}]interface{}{},
+			map[interface{}]interface{}{"message": []byte("keep 1")},
+			map[interface{}]interface{}{"message": []byte("drop")},
+			map[interface{}]interface{}{"message": []byte("keep 2")},
+		))
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	want := pluginMetrics{procRecords: 2, retries: 2, dropped: 2}
+	if got := sqsConf.stats.pluginMetrics(); got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestCloseInstances(t *testing.T) {
+	resetGlobals()
+	instancesMu.Lock()
+	saved := instances
+	instances = nil
+	instancesMu.Unlock()
+	defer func() {
+		instancesMu.Lock()
+		instances = saved
+		instancesMu.Unlock()
+	}()
+
+	sqsConf := &sqsConfig{queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue"}
+	sqsConf.stats.messagesSent.Add(4)
+	registerInstance(sqsConf)
+
+	output := captureStdout(closeInstances)
+	if !strings.Contains(output, "metrics of test-queue (https://sqs.us-east-1.amazonaws.com/123456789/test-queue): proc_records=4 errors=0 retries=0 dropped=0") {
+		t.Errorf("unexpected output %q", output)
+	}
+	if len(instances) != 0 {
+		t.Errorf("expected the closed instances to be unregistered, got %d", len(instances))
+	}
+
+	// the legacy exit callback may follow the per instance ones
+	if output := captureStdout(func() { closeInstance(sqsConf) }); strings.Contains(output, "metrics of") {
+		t.Errorf("expected closing a closed instance to do nothing, got %q", output)
+	}
+}
+
+// countingReporter implements reporter interface and counts its stops
+type countingReporter struct {
+	stops int
+}
+
+func (r *countingReporter) stop() {
+	r.stops++
+}
+
+func TestCloseInstance(t *testing.T) {
+	resetGlobals()
+	instancesMu.Lock()
+	savedInstances := instances
+	instances = nil
+	instancesMu.Unlock()
+	reportersMu.Lock()
+	savedReporters := reporters
+	reporters = nil
+	reportersMu.Unlock()
+	defer func() {
+		instancesMu.Lock()
+		instances = savedInstances
+		instancesMu.Unlock()
+		reportersMu.Lock()
+		reporters = savedReporters
+		reportersMu.Unlock()
+	}()
+
+	first := &sqsConfig{queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/first-queue"}
+	second := &sqsConfig{queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/second-queue"}
+	second.senders = newSenderPool(second, 1, 1)
+	firstReporter, secondReporter, processReporter := &countingReporter{}, &countingReporter{}, &countingReporter{}
+	registerInstance(first)
+	registerInstance(second)
+	registerReporter(first, firstReporter)
+	registerReporter(second, secondReporter)
+	registerReporter(nil, processReporter)
+
+	output := captureStdout(func() { closeInstance(first) })
+	if firstReporter.stops != 1 || secondReporter.stops != 0 || processReporter.stops != 0 {
+		t.Errorf("expected only the reporter of the first instance to stop, got stops %d %d %d", firstReporter.stops, secondReporter.stops, processReporter.stops)
+	}
+	if !strings.Contains(output, "metrics of first-queue") || strings.Contains(output, "second-queue") {
+		t.Errorf("expected the metrics of the first instance only, got %q", output)
+	}
+
+	captureStdout(func() { closeInstance(second) })
+	if secondReporter.stops != 1 || processReporter.stops != 1 {
+		t.Errorf("expected the last close to stop the process wide reporters, got stops %d %d", secondReporter.stops, processReporter.stops)
+	}
+	senderPoolsMu.Lock()
+	pools := len(senderPools)
+	senderPoolsMu.Unlock()
+	if len(instances) != 0 || len(reporters) != 0 || pools != 0 {
+		t.Errorf("expected nothing left running, got %d instances, %d reporters and %d sender pools", len(instances), len(reporters), pools)
+	}
+}
+
+func TestQueueName(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"https://sqs.us-east-1.amazonaws.com/123456789/test-queue", "test-queue"},
+		{"https://sqs.us-east-1.amazonaws.com/123456789/test-queue.fifo/", "test-queue.fifo"},
+		{"arn:aws:sns:us-east-1:123456789:alerts", "alerts"},
+		{"test-queue", "test-queue"},
+	}
+
+	for _, tt := range tests {
+		if got := queueName(tt.input); got != tt.want {
+			t.Errorf("queueName(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestDestinations(t *testing.T) {
+	sqsConf := &sqsConfig{queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue"}
+	if got := sqsConf.destinations(); len(got) != 1 || got[0].stats != &sqsConf.stats {
+		t.Errorf("expected the main queue only, got %v", got)
+	}
+
+	sqsConf.shadow = newShadowRoute("https://sqs.us-east-1.amazonaws.com/123456789/shadow-queue", 100)
+	sqsConf.invalidRecords = newInvalidRecordRoute("https://sqs.us-east-1.amazonaws.com/123456789/invalid-queue", "")
+
+	var names []string
+	for _, d := range sqsConf.destinations() {
+		names = append(names, d.name())
+	}
+	if strings.Join(names, ",") != "test-queue,shadow-queue,invalid-queue" {
+		t.Errorf("unexpected destinations: %v", names)
+	}
+}
+
+func TestMetricsServer(t *testing.T) {
+	resetGlobals()
+	sqsConf := &sqsConfig{queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue"}
+	sqsConf.stats.messagesSent.Add(10)
+	sqsConf.stats.recordsDropped.Add(2)
+
+	if _, err := newMetricsServer(sqsConf, "2021"); err == nil {
+		t.Error("expected an address without a port to be rejected")
+	}
+
+	server, err := newMetricsServer(sqsConf, "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	server.start()
+	defer stopInstanceReporters(sqsConf)
+
+	get := func(path string) string {
+		resp, err := http.Get("http://" + server.listener.Addr().String() + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	var got struct {
+		Output map[string]jsonPluginMetrics `json:"output"`
+	}
+	if err := json.Unmarshal([]byte(get("/api/v1/metrics")), &got); err != nil {
+		t.Fatalf("expected JSON metrics: %v", err)
+	}
+	if want := (jsonPluginMetrics{ProcRecords: 10, DroppedRecords: 2}); got.Output["test-queue"] != want {
+		t.Errorf("got %+v, want %+v", got.Output["test-queue"], want)
+	}
+
+	prometheus := get("/api/v1/metrics/prometheus")
+	if !strings.Contains(prometheus, `fluentbit_sqs_output_proc_records_total{queue="test-queue"} 10`) {
+		t.Errorf("expected the Prometheus counters, got %q", prometheus)
+	}
+}
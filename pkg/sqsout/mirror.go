@@ -0,0 +1,129 @@
+package sqsout
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	// mirrorBacklogBatches is how many batches the mirror keeps while its
+	// queue is unavailable, the oldest are dropped first
+	mirrorBacklogBatches = 1000
+	// mirrorBacklogBytes is how many serialized bytes the backlog of the
+	// mirror holds, the oldest batches are dropped first
+	mirrorBacklogBytes = 64 << 20
+)
+
+// mirrorRoute duplicates every record to a queue of another region, for the
+// trails which must survive a regional outage of SQS. the mirror has its own
+// client, batches, sender and backlog: the main queue never waits for the
+// mirror, and the batches the mirror couldn't send are sent again with its
+// next batch. the backlog is kept in memory within its own budget, it is not
+// counted against MemBufLimit so an outage of the mirror never holds back the
+// chunks of the main queue
+type mirrorRoute struct {
+	sideQueue
+	client sqsClient
+
+	// sendMu keeps the backlog in order across concurrent flushes
+	sendMu       sync.Mutex
+	backlog      [][]*types.SendMessageBatchRequestEntry
+	backlogBytes int64
+	// maxBacklogBytes is the budget of the backlog, mirrorBacklogBytes
+	// unless a test sets it
+	maxBacklogBytes int64
+}
+
+func newMirrorRoute(mirrorQueueURL string, client sqsClient) *mirrorRoute {
+	return &mirrorRoute{
+		sideQueue:       sideQueue{name: "mirror", queueURL: mirrorQueueURL, idPrefix: "Mirror"},
+		client:          client,
+		maxBacklogBytes: mirrorBacklogBytes,
+	}
+}
+
+// validateMirrorConfig checks MirrorQueueUrl and MirrorRegion. the emulators
+// serve every region, the mirror defaults to the region of the main queue
+func validateMirrorConfig(mirrorQueueURL, mirrorRegion, queueURL, queueMessageGroupID string, emulator bool) error {
+	if mirrorQueueURL == "" {
+		if mirrorRegion != "" {
+			return errors.New("MirrorRegion requires MirrorQueueUrl")
+		}
+		return nil
+	}
+	if mirrorQueueURL == queueURL {
+		return errors.New("MirrorQueueUrl should be another queue than QueueUrl")
+	}
+	if mirrorRegion == "" && !emulator {
+		return errors.New("MirrorRegion is mandatory with MirrorQueueUrl")
+	}
+	if strings.HasSuffix(mirrorQueueURL, ".fifo") && queueMessageGroupID == "" {
+		return errors.New("QueueMessageGroupId configuration key is mandatory for FIFO mirror queues")
+	}
+	return nil
+}
+
+// addCopy queues a copy of the given primary entry for the mirror queue. the
+// deduplication id is kept, the mirror is a queue of its own
+func (m *mirrorRoute) addCopy(entry *types.SendMessageBatchRequestEntry) {
+	m.add(&types.SendMessageBatchRequestEntry{
+		MessageBody:             entry.MessageBody,
+		MessageAttributes:       entry.MessageAttributes,
+		MessageSystemAttributes: entry.MessageSystemAttributes,
+		MessageGroupId:          entry.MessageGroupId,
+		MessageDeduplicationId:  entry.MessageDeduplicationId,
+	}, nil)
+}
+
+// flush sends the backlog and the queued entries, oldest first. it stops at
+// the first request failing with a retryable error, the batches left wait for
+// the next flush. the batches evicted from a full backlog and the ones the
+// mirror rejects are counted as dropped
+func (m *mirrorRoute) flush(retry retryPolicy) {
+	m.sendMu.Lock()
+	defer m.sendMu.Unlock()
+
+	for _, records := range m.batches(m.take()) {
+		m.backlog = append(m.backlog, records)
+		m.backlogBytes += batchBytes(records)
+	}
+	over := len(m.backlog) - mirrorBacklogBatches
+	if over < 0 {
+		over = 0
+	}
+	var records int
+	var dropped int64
+	for _, batch := range m.backlog[:over] {
+		records += len(batch)
+		dropped += batchBytes(batch)
+	}
+	for over < len(m.backlog)-1 && m.backlogBytes-dropped > m.maxBacklogBytes {
+		records += len(m.backlog[over])
+		dropped += batchBytes(m.backlog[over])
+		over++
+	}
+	if over > 0 {
+		m.stats.bytesEvicted.Add(dropped)
+		m.stats.countDroppedRecords(dropEvicted, records)
+		m.backlogBytes -= dropped
+		writeWarnLog(fmt.Sprintf("the mirror queue %s is unavailable for %d batches, dropping the %d oldest (%d records, %d bytes)", queueName(m.queueURL), len(m.backlog), over, records, dropped))
+		m.backlog = m.backlog[over:]
+	}
+
+	for len(m.backlog) > 0 {
+		records := m.backlog[0]
+		if err := m.send(m.client, retry, records); err != nil {
+			if retry.classes.retryableError(err) {
+				return
+			}
+			m.stats.countDroppedRecords(dropRejected, len(records))
+		}
+		m.backlogBytes -= batchBytes(records)
+		m.backlog[0] = nil
+		m.backlog = m.backlog[1:]
+	}
+}
@@ -0,0 +1,174 @@
+package sqsout
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestValidateMirrorConfig(t *testing.T) {
+	queueURL := "https://sqs.us-east-1.amazonaws.com/123456789/logs"
+	tests := []struct {
+		name      string
+		mirrorURL string
+		region    string
+		groupID   string
+		emulator  bool
+		wantErr   bool
+	}{
+		{"no mirror", "", "", "", false, false},
+		{"mirror", "https://sqs.us-west-2.amazonaws.com/123456789/logs", "us-west-2", "", false, false},
+		{"region without mirror", "", "us-west-2", "", false, true},
+		{"same queue", queueURL, "us-east-1", "", false, true},
+		{"missing region", "https://sqs.us-west-2.amazonaws.com/123456789/logs", "", "", false, true},
+		{"missing region on an emulator", "http://localhost:4566/000000000000/mirror", "", "", true, false},
+		{"fifo without group", "https://sqs.us-west-2.amazonaws.com/123456789/logs.fifo", "us-west-2", "", false, true},
+		{"fifo", "https://sqs.us-west-2.amazonaws.com/123456789/logs.fifo", "us-west-2", "group-1", false, false},
+	}
+
+	for _, tt := range tests {
+		err := validateMirrorConfig(tt.mirrorURL, tt.region, queueURL, tt.groupID, tt.emulator)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateMirrorConfig() = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMirrorRouteBacklog(t *testing.T) {
+	resetGlobals()
+	fake := &scriptedSQS{responses: []scriptedResponse{{err: errors.New("service unavailable")}}}
+	mirror := newMirrorRoute("https://sqs.us-west-2.amazonaws.com/123456789/mirror", fake)
+	retry := retryPolicy{backoff: time.Millisecond}
+
+	entry := func(body string) *types.SendMessageBatchRequestEntry {
+		return &types.SendMessageBatchRequestEntry{MessageBody: aws.String(body), MessageDeduplicationId: aws.String("dedup-" + body)}
+	}
+
+	captureStdout(func() {
+		mirror.addCopy(entry("first"))
+		mirror.flush(retry)
+		mirror.addCopy(entry("second"))
+		mirror.flush(retry)
+	})
+
+	if len(mirror.backlog) != 0 {
+		t.Errorf("expected the backlog to be sent once the mirror is back, %d batches left", len(mirror.backlog))
+	}
+	want := [][]string{{"MirrorMessageNumber-1"}, {"MirrorMessageNumber-1"}, {"MirrorMessageNumber-1"}}
+	if !reflect.DeepEqual(fake.calls, want) {
+		t.Errorf("expected the failed batch to be sent again before the next one, got calls %v", fake.calls)
+	}
+	if got := mirror.stats.messagesSent.Load(); got != 2 {
+		t.Errorf("expected 2 mirrored messages, got %d", got)
+	}
+}
+
+func TestMirrorRouteBacklogCap(t *testing.T) {
+	resetGlobals()
+	fake := &scriptedSQS{}
+	for i := 0; i <= mirrorBacklogBatches; i++ {
+		fake.responses = append(fake.responses, scriptedResponse{err: errors.New("service unavailable")})
+	}
+	mirror := newMirrorRoute("https://sqs.us-west-2.amazonaws.com/123456789/mirror", fake)
+
+	sqsOutLogLevel = 3 // the failures of the 1001 flushes are expected
+	for i := 0; i <= mirrorBacklogBatches; i++ {
+		mirror.addCopy(&types.SendMessageBatchRequestEntry{MessageBody: aws.String("body")})
+		mirror.flush(retryPolicy{})
+	}
+
+	if len(mirror.backlog) != mirrorBacklogBatches {
+		t.Errorf("expected the backlog to be capped at %d batches, got %d", mirrorBacklogBatches, len(mirror.backlog))
+	}
+	if got := mirror.stats.bytesEvicted.Load(); got != int64(len("body")) {
+		t.Errorf("expected the dropped batch to be counted as evicted, got %d bytes", got)
+	}
+	if got := mirror.stats.drops[dropEvicted].Load(); got != 1 {
+		t.Errorf("expected the evicted record to be counted as dropped, got %d", got)
+	}
+	if got := mirror.backlogBytes; got != int64(mirrorBacklogBatches*len("body")) {
+		t.Errorf("expected the backlog to hold %d bytes, got %d", mirrorBacklogBatches*len("body"), got)
+	}
+}
+
+func TestMirrorRouteBacklogBytes(t *testing.T) {
+	resetGlobals()
+	fake := &scriptedSQS{}
+	for i := 0; i < 5; i++ {
+		fake.responses = append(fake.responses, scriptedResponse{err: errors.New("service unavailable")})
+	}
+	mirror := newMirrorRoute("https://sqs.us-west-2.amazonaws.com/123456789/mirror", fake)
+	mirror.maxBacklogBytes = 10
+
+	sqsOutLogLevel = 3
+	for i := 0; i < 5; i++ {
+		mirror.addCopy(&types.SendMessageBatchRequestEntry{MessageBody: aws.String("body")})
+		mirror.flush(retryPolicy{})
+	}
+
+	if len(mirror.backlog) != 2 || mirror.backlogBytes != 8 {
+		t.Errorf("expected the backlog to keep the 2 newest batches within 10 bytes, got %d batches of %d bytes", len(mirror.backlog), mirror.backlogBytes)
+	}
+	if got := mirror.stats.drops[dropEvicted].Load(); got != 3 {
+		t.Errorf("expected the 3 evicted records to be counted as dropped, got %d", got)
+	}
+}
+
+func TestMirrorRouteRejected(t *testing.T) {
+	resetGlobals()
+	fake := &scriptedSQS{responses: []scriptedResponse{{err: &smithy.GenericAPIError{Code: "AccessDenied"}}}}
+	mirror := newMirrorRoute("https://sqs.us-west-2.amazonaws.com/123456789/mirror", fake)
+
+	captureStdout(func() {
+		mirror.addCopy(&types.SendMessageBatchRequestEntry{MessageBody: aws.String("first")})
+		mirror.addCopy(&types.SendMessageBatchRequestEntry{MessageBody: aws.String("second")})
+		mirror.flush(retryPolicy{})
+	})
+
+	if len(mirror.backlog) != 0 {
+		t.Errorf("expected the rejected batch to leave the backlog, %d batches left", len(mirror.backlog))
+	}
+	if got := mirror.stats.drops[dropRejected].Load(); got != 2 {
+		t.Errorf("expected the 2 rejected records to be counted as dropped, got %d", got)
+	}
+	if mirror.backlogBytes != 0 {
+		t.Errorf("expected the rejected batch to leave the backlog, %d bytes left", mirror.backlogBytes)
+	}
+}
+
+func TestFlushRecordsMirror(t *testing.T) {
+	resetGlobals()
+	main := &recordingSQS{err: errors.New("service unavailable")}
+	mirrorSQS := &recordingSQS{}
+	sqsConf := &sqsConfig{
+		queueURL:  "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS:     main,
+		batchSize: 3,
+		mirror:    newMirrorRoute("https://sqs.us-west-2.amazonaws.com/123456789/test-queue", mirrorSQS),
+	}
+
+	captureStdout(func() {
+		if err := flushRecords(sqsConf, "app.log", sliceIterator(time.Now(), messageRecords(2)...)); err != nil {
+			t.Errorf("expected the records to wait for a full batch, got %v", err)
+		}
+		if err := flushPendingBatches(sqsConf); err == nil {
+			t.Error("expected the failure of the main queue to fail the flush")
+		}
+	})
+
+	var mirrored int
+	for _, batch := range mirrorSQS.batches {
+		if aws.ToString(batch.QueueUrl) != sqsConf.mirror.queueURL {
+			t.Errorf("expected the mirror batches to go to the mirror queue, got %s", aws.ToString(batch.QueueUrl))
+		}
+		mirrored += len(batch.Entries)
+	}
+	if mirrored != 2 {
+		t.Errorf("expected every record to be mirrored despite the main queue failing, got %d", mirrored)
+	}
+}
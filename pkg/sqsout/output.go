@@ -0,0 +1,128 @@
+// Package sqsout sends fluent bit records to AWS SQS. it holds the
+// formatting, batching and sending of the fluent bit output plugin without
+// depending on cgo or fluent bit, so other Go shippers can use it too. the
+// plugin itself is a thin wrapper around Output
+package sqsout
+
+import "errors"
+
+// FlushStatus is the result of a flush. the values are the ones of the
+// return codes of fluent bit output plugins, FLB_ERROR, FLB_OK and FLB_RETRY
+type FlushStatus int
+
+const (
+	FlushError FlushStatus = iota
+	FlushOK
+	FlushRetry
+)
+
+// Output is a configured output, the equivalent of a plugin instance
+type Output struct {
+	conf *sqsConfig
+}
+
+// errClosed is returned by the sends of a closed output
+var errClosed = errors.New("the output is closed")
+
+// New creates an output from its configuration, configKey returns the value
+// of a configuration key, as in the fluent bit configuration. the background
+// reporters and senders of the output run until Shutdown is called
+func New(configKey func(key string) string) (*Output, error) {
+	sqsConf, err := initPlugin(configKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Output{conf: sqsConf}, nil
+}
+
+// NewWithSink creates an output sending its batches to sink instead of the
+// queue of QueueUrl, which still names the destination in the logs and
+// metrics. the side queues, such as ShadowQueueUrl, are still SQS queues
+func NewWithSink(configKey func(key string) string, sink Sink) (*Output, error) {
+	out, err := New(configKey)
+	if err != nil {
+		return nil, err
+	}
+	out.conf.sink = sink
+	return out, nil
+}
+
+// Use appends processors to the output, they run in order after the
+// built-in SamplePercent and FilterRegex processors. Use is called before the
+// first send
+func (o *Output) Use(processors ...Processor) {
+	o.conf.processors = o.conf.processors.add(processors...)
+}
+
+// WrapSink wraps the sink of the output with middlewares, the first one runs
+// first. WrapSink is called before the first send
+func (o *Output) WrapSink(middlewares ...SinkMiddleware) {
+	sink := o.conf.mainSink(o.conf.queueURL)
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		sink = middlewares[i](sink)
+	}
+	o.conf.sink = sink
+}
+
+// Flush sends the records of a msgpack chunk of fluent bit. the records
+// reference the chunk, which must not be modified until Flush returns
+func (o *Output) Flush(tag string, chunk []byte) FlushStatus {
+	if o.conf == nil {
+		writeErrorLog(errClosed)
+		return FlushError
+	}
+	return flushChunk(o.conf, tag, chunk)
+}
+
+// Send sends the records returned by next. the records are batched per tag
+// and the last partial batch of a tag is kept until the next records of the
+// tag fill it, see SendPending
+func (o *Output) Send(tag string, next RecordIterator) error {
+	if o.conf == nil {
+		return errClosed
+	}
+	return flushRecords(o.conf, tag, next)
+}
+
+// SendPending sends the partial batches kept by the previous sends
+func (o *Output) SendPending() error {
+	if o.conf == nil {
+		return errClosed
+	}
+	return flushPendingBatches(o.conf)
+}
+
+// Close sends the partial batches of the output, drains its senders, stops
+// its reporters and logs its delivery metrics. the process wide reporters,
+// such as the tracer provider, are stopped with the last output. Close can be
+// called more than once, the output can't send anything afterwards
+func (o *Output) Close() {
+	if o.conf == nil {
+		return
+	}
+	closeInstance(o.conf)
+	// the fluent bit context of the instance outlives it, the configuration
+	// and clients are released for the garbage collector
+	o.conf = nil
+}
+
+// SetupLogging reads the log level and format from the SQS_OUT_LOG_LEVEL and
+// SQS_OUT_LOG_FORMAT environment variables
+func SetupLogging() {
+	setLogLevel()
+	setLogFormat()
+}
+
+// LogError writes an error to the plugin log
+func LogError(err error) {
+	writeErrorLog(err)
+}
+
+// Shutdown closes every output. the outputs created afterwards, e.g. when
+// fluent bit reloads its configuration, start from a clean state
+func Shutdown() {
+	closeInstances()
+	// the senders and reporters of outputs which failed to initialize
+	stopSenderPools()
+	stopReporters()
+}
@@ -0,0 +1,202 @@
+package sqsout
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestOutput(t *testing.T) {
+	resetGlobals()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	fake := &fakeSQSEndpoint{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	config := map[string]string{
+		"QueueUrl":    server.URL + "/123456789/test-queue",
+		"QueueRegion": "us-east-1",
+		"Endpoint":    server.URL,
+		"BatchSize":   "2",
+	}
+
+	var out *Output
+	var err error
+	captureStdout(func() {
+		out, err = New(func(key string) string { return config[key] })
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer captureStdout(Shutdown)
+
+	captureStdout(func() {
+		if status := out.Flush("app", encodeMsgpack(t, []interface{}{uint64(1705314600), map[string]interface{}{"log": "from a chunk"}})); status != FlushOK {
+			t.Errorf("expected FlushOK, got %d", status)
+		}
+		if err := out.Send("app", sliceIterator(time.Now(), map[interface{}]interface{}{"log": "from an iterator"})); err != nil {
+			t.Errorf("unexpected Send error: %v", err)
+		}
+		if err := out.Send("other", sliceIterator(time.Now(), map[interface{}]interface{}{"log": "pending"})); err != nil {
+			t.Errorf("unexpected Send error: %v", err)
+		}
+	})
+
+	if len(fake.bodies) != 2 {
+		t.Fatalf("expected the full batch of app to be sent, got %v", fake.bodies)
+	}
+
+	captureStdout(func() {
+		if err := out.SendPending(); err != nil {
+			t.Errorf("unexpected SendPending error: %v", err)
+		}
+	})
+	if len(fake.bodies) != 3 || !strings.Contains(fake.bodies[2], "pending") {
+		t.Errorf("expected the partial batch of other to be sent, got %v", fake.bodies)
+	}
+
+	if _, err := New(func(key string) string { return "" }); err == nil || !strings.Contains(err.Error(), "QueueUrl") {
+		t.Errorf("expected a missing QueueUrl error, got %v", err)
+	}
+}
+
+func TestOutputClose(t *testing.T) {
+	resetGlobals()
+	fake := &recordingSQS{}
+	sqsConf := &sqsConfig{
+		queueURL:  "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS:     fake,
+		batchSize: 10,
+	}
+	sqsConf.senders = newSenderPool(sqsConf, 1, 1)
+	registerInstance(sqsConf)
+	out := &Output{conf: sqsConf}
+
+	captureStdout(func() {
+		if err := out.Send("app", sliceIterator(time.Now(), map[interface{}]interface{}{"log": "pending"})); err != nil {
+			t.Errorf("unexpected Send error: %v", err)
+		}
+		out.Close()
+		out.Close()
+	})
+
+	if len(fake.batches) != 1 || len(fake.batches[0].Entries) != 1 {
+		t.Errorf("expected the partial batch to be sent on close, got %v", fake.batches)
+	}
+	if err := out.Send("app", sliceIterator(time.Now(), map[interface{}]interface{}{"log": "late"})); err != errClosed {
+		t.Errorf("expected errClosed after Close, got %v", err)
+	}
+	var status FlushStatus
+	captureStdout(func() { status = out.Flush("app", nil) })
+	if status != FlushError {
+		t.Errorf("expected FlushError after Close, got %d", status)
+	}
+}
+
+func TestOutputReload(t *testing.T) {
+	resetGlobals()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	server := httptest.NewServer(&fakeSQSEndpoint{})
+	defer server.Close()
+
+	config := map[string]string{
+		"QueueUrl":        server.URL + "/123456789/test-queue",
+		"QueueRegion":     "us-east-1",
+		"Endpoint":        server.URL,
+		"BatchSize":       "10",
+		"SummaryInterval": "1h",
+		"Workers":         "2",
+	}
+	running := func() (int, int, int) {
+		instancesMu.Lock()
+		defer instancesMu.Unlock()
+		reportersMu.Lock()
+		defer reportersMu.Unlock()
+		senderPoolsMu.Lock()
+		defer senderPoolsMu.Unlock()
+		return len(instances), len(reporters), len(senderPools)
+	}
+
+	// fluent bit initializes the outputs again after a reload, which must not
+	// start more than the first initialization
+	firstReporters := 0
+	for i := 0; i < 2; i++ {
+		var err error
+		captureStdout(func() { _, err = New(func(key string) string { return config[key] }) })
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		instances, reporters, pools := running()
+		if i == 0 {
+			firstReporters = reporters
+		}
+		if instances != 1 || reporters == 0 || reporters != firstReporters || pools != 1 {
+			t.Errorf("expected a single instance running, got %d instances, %d reporters and %d sender pools", instances, reporters, pools)
+		}
+		captureStdout(Shutdown)
+		if instances, reporters, pools := running(); instances != 0 || reporters != 0 || pools != 0 {
+			t.Errorf("expected nothing running after Shutdown, got %d instances, %d reporters and %d sender pools", instances, reporters, pools)
+		}
+	}
+
+	config["AuditLogFile"] = filepath.Join(t.TempDir(), "missing", "audit.log")
+	var err error
+	captureStdout(func() { _, err = New(func(key string) string { return config[key] }) })
+	if err == nil || !strings.Contains(err.Error(), "AuditLogFile") {
+		t.Errorf("expected an AuditLogFile error, got %v", err)
+	}
+	if _, reporters, _ := running(); reporters != 0 {
+		t.Errorf("expected the reporters of the failed output to be stopped, got %d", reporters)
+	}
+}
+
+// recordingSink implements Sink interface and accepts every entry
+type recordingSink struct {
+	bodies []string
+}
+
+func (s *recordingSink) SendBatch(ctx context.Context, entries []*types.SendMessageBatchRequestEntry) ([]types.SendMessageBatchResultEntry, []types.BatchResultErrorEntry, error) {
+	var accepted []types.SendMessageBatchResultEntry
+	for _, entry := range entries {
+		s.bodies = append(s.bodies, aws.ToString(entry.MessageBody))
+		accepted = append(accepted, types.SendMessageBatchResultEntry{Id: entry.Id, MessageId: entry.Id})
+	}
+	return accepted, nil, nil
+}
+
+func TestNewWithSink(t *testing.T) {
+	resetGlobals()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	config := map[string]string{
+		"QueueUrl":    "https://sqs.us-east-1.amazonaws.com/123456789/file-sink",
+		"QueueRegion": "us-east-1",
+		"BatchSize":   "2",
+	}
+
+	sink := &recordingSink{}
+	var out *Output
+	var err error
+	captureStdout(func() {
+		out, err = NewWithSink(func(key string) string { return config[key] }, sink)
+		if err == nil {
+			err = out.Send("app", sliceIterator(time.Now(), map[interface{}]interface{}{"log": "first"}, map[interface{}]interface{}{"log": "second"}))
+			out.Close()
+		}
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(sink.bodies) != 2 || !strings.Contains(sink.bodies[1], "second") {
+		t.Errorf("expected the batch to go to the sink, got %v", sink.bodies)
+	}
+}
@@ -0,0 +1,148 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// fifoRequestsPerSecond is the published quota of SendMessageBatch calls per
+// second of a FIFO queue without high throughput mode. the standard queues
+// have no practical quota and are not paced by default
+const fifoRequestsPerSecond = 300
+
+// parseMaxRequestsPerSecond parses MaxRequestsPerSecond, the ceiling of the
+// send calls per second to every destination queue. set is false when the
+// SQS quotas should be used
+func parseMaxRequestsPerSecond(value string) (perSecond float64, set bool, err error) {
+	if value == "" {
+		return 0, false, nil
+	}
+	perSecond, err = strconv.ParseFloat(value, 64)
+	if err != nil || perSecond < 0 {
+		return 0, false, errors.New("MaxRequestsPerSecond should be a positive number, 0 disables the pacing")
+	}
+	return perSecond, true, nil
+}
+
+// queueRequestsPerSecond is the pacing of a destination queue: the
+// configured ceiling, else the quota of the FIFO queues
+func queueRequestsPerSecond(perSecond float64, set, fifo bool) float64 {
+	if set {
+		return perSecond
+	}
+	if fifo {
+		return fifoRequestsPerSecond
+	}
+	return 0
+}
+
+// queuePacer spaces the send calls to a queue so no more than the given rate
+// is sent per second, across the workers and the instances sending to it.
+// many agents paced below the quota of a queue don't get into throttling
+// storms, they would otherwise all retry together. every instance sending
+// to the queue has its own interval, the pacer spaces the calls by the
+// longest one
+type queuePacer struct {
+	mu        sync.Mutex
+	interval  time.Duration
+	intervals map[*sqsConfig]time.Duration
+	next      time.Time
+	now       func() time.Time
+}
+
+// mergeLocked sets the interval to the longest of the instances
+func (p *queuePacer) mergeLocked() {
+	p.interval = 0
+	for _, interval := range p.intervals {
+		p.interval = max(p.interval, interval)
+	}
+}
+
+// queuePacers holds the pacer of every paced destination queue
+var (
+	queuePacers   = make(map[string]*queuePacer)
+	queuePacersMu sync.Mutex
+)
+
+// paceQueue paces the send calls of the owner to a queue at the given rate, 0
+// doesn't pace it. the queue is paced at the lowest rate of the instances
+// sending to it, until they are closed
+func paceQueue(owner *sqsConfig, queueURL string, perSecond float64) {
+	if perSecond <= 0 {
+		return
+	}
+	interval := time.Duration(float64(time.Second) / perSecond)
+
+	queuePacersMu.Lock()
+	defer queuePacersMu.Unlock()
+
+	pacer, ok := queuePacers[queueURL]
+	if !ok {
+		writeInfoLog(fmt.Sprintf("pacing the sends to %s at %g requests per second", queueName(queueURL), perSecond))
+		pacer = &queuePacer{intervals: make(map[*sqsConfig]time.Duration), now: time.Now}
+		queuePacers[queueURL] = pacer
+	}
+	pacer.mu.Lock()
+	pacer.intervals[owner] = interval
+	pacer.mergeLocked()
+	pacer.mu.Unlock()
+}
+
+// unpaceQueues removes the rates of a closed instance, a queue no instance
+// paces any longer has no pacer. a reload can raise MaxRequestsPerSecond
+func unpaceQueues(owner *sqsConfig) {
+	queuePacersMu.Lock()
+	defer queuePacersMu.Unlock()
+
+	for queueURL, pacer := range queuePacers {
+		pacer.mu.Lock()
+		delete(pacer.intervals, owner)
+		pacer.mergeLocked()
+		unpaced := len(pacer.intervals) == 0
+		pacer.mu.Unlock()
+		if unpaced {
+			delete(queuePacers, queueURL)
+		}
+	}
+}
+
+// pacerOf returns the pacer of a queue, nil when it isn't paced
+func pacerOf(queueURL string) *queuePacer {
+	queuePacersMu.Lock()
+	defer queuePacersMu.Unlock()
+	return queuePacers[queueURL]
+}
+
+// wait reserves the next call slot of the queue and waits for it, or until
+// the context is done. a nil pacer doesn't wait
+func (p *queuePacer) wait(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	now := p.now()
+	slot := now
+	if p.next.After(now) {
+		slot = p.next
+	}
+	p.next = slot.Add(p.interval)
+	p.mu.Unlock()
+
+	delay := slot.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,119 @@
+package sqsout
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseMaxRequestsPerSecond(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    float64
+		wantSet bool
+		wantErr bool
+	}{
+		{"", 0, false, false},
+		{"0", 0, true, false},
+		{"50", 50, true, false},
+		{"2.5", 2.5, true, false},
+		{"-1", 0, false, true},
+		{"fast", 0, false, true},
+	}
+
+	for _, tt := range tests {
+		got, set, err := parseMaxRequestsPerSecond(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want || set != tt.wantSet {
+			t.Errorf("parseMaxRequestsPerSecond(%q) = %v, %v, %v, want %v, %v, wantErr %v", tt.value, got, set, err, tt.want, tt.wantSet, tt.wantErr)
+		}
+	}
+}
+
+func TestQueueRequestsPerSecond(t *testing.T) {
+	tests := []struct {
+		name      string
+		perSecond float64
+		set       bool
+		fifo      bool
+		want      float64
+	}{
+		{"standard queue", 0, false, false, 0},
+		{"fifo queue quota", 0, false, true, fifoRequestsPerSecond},
+		{"configured", 50, true, false, 50},
+		{"disabled on a fifo queue", 0, true, true, 0},
+	}
+
+	for _, tt := range tests {
+		if got := queueRequestsPerSecond(tt.perSecond, tt.set, tt.fifo); got != tt.want {
+			t.Errorf("%s: queueRequestsPerSecond() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestQueuePacer(t *testing.T) {
+	resetGlobals()
+	queueURL := "https://sqs.us-east-1.amazonaws.com/123456789/paced-queue.fifo"
+	slow, fast := &sqsConfig{}, &sqsConfig{}
+	paceQueue(slow, queueURL, 10)
+	paceQueue(fast, queueURL, 100)
+	t.Cleanup(func() { delete(queuePacers, queueURL) })
+
+	pacer := pacerOf(queueURL)
+	if pacer == nil || pacer.interval != 100*time.Millisecond {
+		t.Fatalf("expected the lower rate to be kept, got %+v", pacer)
+	}
+	if pacerOf("https://sqs.us-east-1.amazonaws.com/123456789/other-queue") != nil {
+		t.Error("expected the queues without pacing to have no pacer")
+	}
+
+	now := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	pacer.now = func() time.Time { return now }
+
+	if err := pacer.wait(context.Background()); err != nil {
+		t.Fatalf("expected the first call to go through, got %v", err)
+	}
+
+	// the next slot is 100ms away, the caller gives up before
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := pacer.wait(ctx); err == nil {
+		t.Error("expected the second call to wait for its slot")
+	}
+	if want := now.Add(200 * time.Millisecond); !pacer.next.Equal(want) {
+		t.Errorf("expected the slots to be reserved up to %v, got %v", want, pacer.next)
+	}
+
+	var nilPacer *queuePacer
+	if err := nilPacer.wait(ctx); err != nil {
+		t.Errorf("expected a nil pacer not to wait, got %v", err)
+	}
+}
+
+func TestUnpaceQueues(t *testing.T) {
+	resetGlobals()
+	queueURL := "https://sqs.us-east-1.amazonaws.com/123456789/reloaded-queue.fifo"
+	t.Cleanup(func() { delete(queuePacers, queueURL) })
+
+	slow, fast := &sqsConfig{}, &sqsConfig{}
+	captureStdout(func() {
+		paceQueue(slow, queueURL, 10)
+		paceQueue(fast, queueURL, 100)
+	})
+
+	unpaceQueues(slow)
+	if pacer := pacerOf(queueURL); pacer == nil || pacer.interval != 10*time.Millisecond {
+		t.Fatalf("expected the rate of the instance left to be used, got %+v", pacer)
+	}
+
+	unpaceQueues(fast)
+	if pacerOf(queueURL) != nil {
+		t.Fatal("expected the queue no instance paces to have no pacer")
+	}
+
+	// a reload raising the rate
+	reloaded := &sqsConfig{}
+	captureStdout(func() { paceQueue(reloaded, queueURL, 1000) })
+	if pacer := pacerOf(queueURL); pacer == nil || pacer.interval != time.Millisecond {
+		t.Errorf("expected the raised rate to be used after a reload, got %+v", pacer)
+	}
+}
@@ -0,0 +1,418 @@
+package sqsout
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// maxMessageBytes is the default SQS message size limit, used until the
+// attributes of the queue are known. larger messages fail their whole batch,
+// so they are dropped before being batched
+const maxMessageBytes = 256 * 1024
+
+// pipelineBufferSize bounds the number of serialized records waiting between
+// the decode and the send stages of a flush
+const pipelineBufferSize = 100
+
+// RecordIterator returns the next decoded record of a chunk, ok is false once
+// the chunk is exhausted
+type RecordIterator func() (timestamp time.Time, record map[interface{}]interface{}, ok bool)
+
+// preparedRecord is a record which went through filtering and serialization
+// and is ready to be batched. records failing validation carry the reason and
+// the original record so they can be sent to the invalid record queue
+type preparedRecord struct {
+	timestamp     time.Time
+	body          string
+	invalidReason string
+	record        map[interface{}]interface{}
+	// messageIDField is the MessageIdLogKey value logged with the MessageId
+	messageIDField string
+	// systemAttributes are the MessageSystemAttributes values of the record
+	systemAttributes map[string]string
+	// correlationID is the AddCorrelationId value sent as message attribute
+	correlationID string
+	// messageGroupID is the MessageGroupIdKey value of the record
+	messageGroupID string
+}
+
+// flushRecords runs a flush as two stages connected by a bounded channel: a
+// producer decoding, filtering and serializing records and a consumer
+// batching and sending them, so slow SQS responses don't block decoding and
+// vice versa. the first send error stops the flush and is returned
+func flushRecords(sqsConf *sqsConfig, tag string, next RecordIterator) error {
+	span := startFlushSpan(tag)
+	records := make(chan *preparedRecord, pipelineBufferSize)
+	done := make(chan struct{})
+	// decoded is written by the producer and read once records is closed
+	decoded := 0
+
+	go func() {
+		defer close(records)
+		for {
+			timestamp, record, ok := next()
+			if !ok {
+				return
+			}
+			decoded++
+
+			prepared := prepareRecord(sqsConf, tag, timestamp, record)
+			if prepared == nil {
+				continue
+			}
+
+			select {
+			case records <- prepared:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var sendErr error
+	for prepared := range records {
+		if sendErr != nil {
+			// drain what the producer already queued until it sees done
+			continue
+		}
+
+		if err := addPreparedRecord(sqsConf, tag, prepared); err != nil {
+			sendErr = err
+			close(done)
+		}
+	}
+
+	endFlushSpan(span, decoded, sendErr)
+	return sendErr
+}
+
+// prepareRecord filters and serializes a single record. it returns nil for
+// records which should not be sent anywhere
+func prepareRecord(sqsConf *sqsConfig, tag string, timestamp time.Time, record map[interface{}]interface{}) *preparedRecord {
+	sqsConf.stats.recordsIn.Add(1)
+
+	writeDebugLog(fmt.Sprintf("got new record from input. record length is: %d", len(record)))
+
+	if len(record) == 0 {
+		writeInfoLog("got empty record from input. skipping it")
+		sqsConf.stats.countDroppedRecord(dropEmpty)
+		return nil
+	}
+
+	// the limits are checked first, they protect the processors as well
+	if sqsConf.recordLimits != nil {
+		if reason := sqsConf.recordLimits.exceeded(record); reason != "" {
+			if sqsConf.recordLimits.invalid {
+				return &preparedRecord{
+					timestamp:     timestamp,
+					invalidReason: "record has " + reason,
+					record:        record,
+				}
+			}
+			sqsConf.recordLimits.truncate(tag, record)
+		}
+	}
+
+	if len(sqsConf.processors) > 0 {
+		processed := Record{Tag: tag, Timestamp: timestamp, Fields: record}
+		if vetoed := sqsConf.processors.run(&processed); vetoed != nil {
+			writeDebugLog(vetoed.vetoed())
+			sqsConf.stats.countDroppedRecord(vetoed.reason)
+			return nil
+		}
+		timestamp, record = processed.Timestamp, processed.Fields
+	}
+
+	if sqsConf.requiredKeys != nil {
+		if missing := sqsConf.requiredKeys.missing(record); len(missing) > 0 {
+			if sqsConf.invalidRecords == nil {
+				sqsConf.requiredKeys.reject(tag, missing, "")
+				sqsConf.stats.countDroppedRecord(dropMissingKeys)
+				return nil
+			}
+			sqsConf.requiredKeys.reject(tag, missing, sqsConf.invalidRecords.queueURL)
+			return &preparedRecord{
+				timestamp:     timestamp,
+				invalidReason: fmt.Sprintf("missing required keys: %s", strings.Join(missing, ",")),
+				record:        record,
+			}
+		}
+	}
+
+	if sqsConf.sequence != nil {
+		sqsConf.sequence.stamp(tag, record)
+	}
+
+	if len(sqsConf.transforms) > 0 {
+		record = sqsConf.transforms.apply(record)
+	}
+
+	recordString, err := formatRecord(sqsConf, tag, timestamp, record)
+	if err != nil {
+		writeErrorLog(fmt.Errorf("error creating message for sqs. tag: %s. error: %v", tag, err))
+		// DO NOT RETURN AN ERROR HERE becase one message has an error when json
+		// is generated, but a retry would fetch ALL messages again. instead an
+		// error should be printed to console
+		sqsConf.stats.countDroppedRecord(dropUnserializable)
+		return nil
+	}
+
+	sqsConf.stats.bytesSerialized.Add(int64(len(recordString)))
+
+	if limit := sqsConf.maxMessageBytes(); len(recordString) > limit {
+		writeWarnLog(fmt.Sprintf("message of %d bytes from tag %s is over the queue limit of %d bytes. dropping it", len(recordString), tag, limit))
+		sqsConf.stats.bytesRejected.Add(int64(len(recordString)))
+		sqsConf.stats.countDroppedRecord(dropOversize)
+		return nil
+	}
+
+	// only format the message body when it is logged, it may be large
+	if sqsOutLogLevel == 0 {
+		writeDebugLog(fmt.Sprintf("record string: %s", recordString))
+	}
+
+	prepared := &preparedRecord{timestamp: timestamp, body: recordString}
+	if sqsConf.messageIDLog != nil && sqsOutLogLevel == 0 {
+		prepared.messageIDField = sqsConf.messageIDLog.field(record)
+	}
+	if sqsConf.systemAttributes != nil {
+		prepared.systemAttributes = sqsConf.systemAttributes.values(record)
+	}
+	if sqsConf.correlationIDKey != "" {
+		prepared.correlationID = copyFieldString(record[sqsConf.correlationIDKey])
+	}
+	if sqsConf.messageGroupIDKey != "" {
+		prepared.messageGroupID = copyFieldString(record[sqsConf.messageGroupIDKey])
+	}
+	return prepared
+}
+
+// addPreparedRecord adds a prepared record to the batch of its tag and sends
+// the batch once it is full
+func addPreparedRecord(sqsConf *sqsConfig, tag string, prepared *preparedRecord) error {
+	if prepared.invalidReason != "" {
+		pending := sqsConf.invalidRecords.addRecord(prepared.timestamp, tag, prepared.invalidReason, prepared.record)
+		if pending >= sqsConf.batchSize && !sqsConf.invalidRecords.handOver() {
+			sqsConf.invalidRecords.flush(sqsConf.sideSQS, sqsConf.retry)
+		}
+		return nil
+	}
+
+	batch := sqsConf.batches.get(tag)
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	// a batch going over the payload limit would be rejected whole, it is
+	// sent before the record is added. the balanced batches are composed
+	// within the limit
+	var sendErr error
+	if !sqsConf.balanceGroups && len(batch.records) > 0 && batch.payload+int64(len(prepared.body)) > sqsConf.batchPayloadLimit() {
+		sendErr = sendTagBatch(sqsConf, batch)
+	}
+
+	batch.messageNumber++
+	messageNumber := batch.messageNumber
+
+	writeDebugLog(fmt.Sprintf("message counter of tag %s: %d", tag, messageNumber))
+
+	entryID := sqsConf.entryID
+	if entryID == nil {
+		entryID = counterEntryID
+	}
+
+	sqsRecord := getEntry()
+	sqsRecord.Id = aws.String(entryID(messageNumber, prepared.body, batch.records))
+	sqsRecord.MessageBody = aws.String(prepared.body)
+	if prepared.messageIDField != "" {
+		sqsConf.messageIDLog.remember(sqsRecord, prepared.messageIDField)
+	}
+
+	if sqsConf.pluginTagAttribute != "" {
+		sqsRecord.MessageAttributes = map[string]types.MessageAttributeValue{
+			sqsConf.pluginTagAttribute: {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(tag),
+			},
+		}
+	}
+
+	if sqsConf.versionAttribute != "" {
+		if sqsRecord.MessageAttributes == nil {
+			sqsRecord.MessageAttributes = make(map[string]types.MessageAttributeValue, 1)
+		}
+		sqsRecord.MessageAttributes[sqsConf.versionAttribute] = types.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(version),
+		}
+	}
+
+	if prepared.correlationID != "" {
+		if sqsRecord.MessageAttributes == nil {
+			sqsRecord.MessageAttributes = make(map[string]types.MessageAttributeValue, 1)
+		}
+		sqsRecord.MessageAttributes[sqsConf.correlationIDKey] = types.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(prepared.correlationID),
+		}
+	}
+
+	if len(prepared.systemAttributes) > 0 {
+		sqsRecord.MessageSystemAttributes = messageSystemAttributes(prepared.systemAttributes)
+	}
+
+	if groupID := sqsConf.messageGroupID(prepared); groupID != "" {
+		sqsRecord.MessageGroupId = aws.String(groupID)
+		// Add MessageDeduplicationId for FIFO queues to prevent deduplication
+		sqsRecord.MessageDeduplicationId = aws.String(fmt.Sprintf("MessageNumber-%d-%d", messageNumber, prepared.timestamp.UnixNano()))
+	}
+
+	if batch.records == nil {
+		batch.records = make([]*types.SendMessageBatchRequestEntry, 0, sqsConf.batchSize)
+	}
+	batch.records = append(batch.records, sqsRecord)
+	batch.payload += int64(len(prepared.body))
+
+	if sqsConf.memBuf != nil {
+		sqsConf.memBuf.add(int64(len(prepared.body)))
+	}
+
+	if sqsConf.shadow != nil && sqsConf.shadow.sampled() {
+		sqsConf.shadow.addCopy(sqsRecord)
+	}
+
+	if sqsConf.mirror != nil {
+		sqsConf.mirror.addCopy(sqsRecord)
+	}
+
+	if sqsConf.balanceGroups {
+		if int64(len(batch.records)) < sqsConf.currentBatchSize()*balanceWindowBatches {
+			return nil
+		}
+	} else if messageNumber < sqsConf.currentBatchSize() {
+		return sendErr
+	}
+
+	if err := sendTagBatch(sqsConf, batch); err != nil {
+		return err
+	}
+	return sendErr
+}
+
+// sendTagBatch sends the pending records of a tag batch, together with the
+// side queue batches. the caller holds the batch lock
+func sendTagBatch(sqsConf *sqsConfig, batch *tagBatch) error {
+	// a balanced batch takes its records from the staged ones, the others stay
+	// for the next batches
+	var staged []*types.SendMessageBatchRequestEntry
+	if sqsConf.balanceGroups {
+		batch.records, staged = balanceGroups(batch.records, int(sqsConf.currentBatchSize()), sqsConf.batchPayloadLimit(), &batch.nextGroup)
+	}
+
+	batch.sentBatches.Add(1)
+	batch.sentMessages.Add(int64(len(batch.records)))
+
+	// the batch and the side queue batches go to different queues, they are
+	// sent concurrently so a slow destination doesn't delay the others. the
+	// side queues with a sender send in the background, the flush doesn't
+	// wait for them
+	var err error
+	var sends []func()
+	if sqsConf.senders != nil {
+		// the workers own the handed over batch, the next one gets a new array
+		sqsConf.senders.submit(batch.records)
+		batch.records = nil
+	} else {
+		records := batch.records
+		sends = append(sends, func() { err = sendBatchToSqs(sqsConf, records) })
+	}
+
+	if sqsConf.shadow != nil && !sqsConf.shadow.handOver() {
+		sends = append(sends, func() { sqsConf.shadow.flush(sqsConf.sideSQS, sqsConf.retry) })
+	}
+
+	if sqsConf.invalidRecords != nil && !sqsConf.invalidRecords.handOver() {
+		sends = append(sends, func() { sqsConf.invalidRecords.flush(sqsConf.sideSQS, sqsConf.retry) })
+	}
+
+	if sqsConf.mirror != nil && !sqsConf.mirror.handOver() {
+		sends = append(sends, func() { sqsConf.mirror.flush(sqsConf.retry) })
+	}
+
+	dispatch(sends...)
+
+	if sqsConf.senders == nil {
+		if sqsConf.memBuf != nil {
+			sqsConf.memBuf.release(batchBytes(batch.records))
+		}
+		releaseEntries(batch.records)
+		batch.records = resetBatch(batch.records)
+	}
+
+	batch.payload = 0
+	if sqsConf.balanceGroups {
+		batch.records = staged
+		batch.payload = batchBytes(staged)
+		if len(staged) > 0 {
+			// the staged records keep their numbers, the entry ids of a
+			// batch stay distinct
+			return err
+		}
+	}
+
+	batch.messageNumber = 0
+
+	return err
+}
+
+// flushPendingBatches sends the batches which are not full yet. fluent bit
+// keeps them for the next flush, the standalone mode sends them at the end of
+// its input
+func flushPendingBatches(sqsConf *sqsConfig) error {
+	var firstErr error
+	sqsConf.batches.each(func(tag string, batch *tagBatch) {
+		batch.mu.Lock()
+		defer batch.mu.Unlock()
+
+		for len(batch.records) > 0 {
+			if err := sendTagBatch(sqsConf, batch); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	})
+
+	// the side queues may hold records of tags without a pending batch. their
+	// senders send them in the background, and once more when they stop with
+	// the instance
+	if sqsConf.shadow != nil && !sqsConf.shadow.handOver() {
+		sqsConf.shadow.flush(sqsConf.sideSQS, sqsConf.retry)
+	}
+	if sqsConf.invalidRecords != nil && !sqsConf.invalidRecords.handOver() {
+		sqsConf.invalidRecords.flush(sqsConf.sideSQS, sqsConf.retry)
+	}
+	if sqsConf.mirror != nil && !sqsConf.mirror.handOver() {
+		sqsConf.mirror.flush(sqsConf.retry)
+	}
+
+	return firstErr
+}
+
+// currentBatchSize is the number of messages after which the batch is sent
+func (sqsConf *sqsConfig) currentBatchSize() int64 {
+	if sqsConf.adaptiveBatch != nil {
+		return sqsConf.adaptiveBatch.size()
+	}
+	return int64(sqsConf.batchSize)
+}
+
+// resetBatch empties a sent batch while keeping its backing array for the
+// next batch. the entries are cleared so they can be garbage collected
+func resetBatch(records []*types.SendMessageBatchRequestEntry) []*types.SendMessageBatchRequestEntry {
+	for i := range records {
+		records[i] = nil
+	}
+	return records[:0]
+}
@@ -0,0 +1,287 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// recordingSQS implements sqsClient interface and keeps a copy of every batch
+// it got, since the plugin reuses the batch array and entries once a send
+// returns
+type recordingSQS struct {
+	mu      sync.Mutex
+	batches []*sqs.SendMessageBatchInput
+	err     error
+}
+
+func (f *recordingSQS) SendMessageBatch(ctx context.Context, input *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	batch := *input
+	batch.Entries = append([]types.SendMessageBatchRequestEntry(nil), input.Entries...)
+	f.batches = append(f.batches, &batch)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &sqs.SendMessageBatchOutput{}, nil
+}
+
+// sliceIterator returns a RecordIterator over the given records
+func sliceIterator(timestamp time.Time, records ...map[interface{}]interface{}) RecordIterator {
+	i := 0
+	return func() (time.Time, map[interface{}]interface{}, bool) {
+		if i == len(records) {
+			return time.Time{}, nil, false
+		}
+		i++
+		return timestamp, records[i-1], true
+	}
+}
+
+func messageRecords(count int) []map[interface{}]interface{} {
+	records := make([]map[interface{}]interface{}, count)
+	for i := range records {
+		records[i] = map[interface{}]interface{}{"message": []byte("hello"), "n": i}
+	}
+	return records
+}
+
+func TestFlushRecords(t *testing.T) {
+	timestamp := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+
+	t.Run("sends full batches and keeps the remainder", func(t *testing.T) {
+		resetGlobals()
+		fake := &recordingSQS{}
+		sqsConf := &sqsConfig{
+			queueURL:           "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+			mySQS:              fake,
+			batchSize:          2,
+			pluginTagAttribute: "tag",
+		}
+
+		err := flushRecords(sqsConf, "app.log", sliceIterator(timestamp, messageRecords(5)...))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(fake.batches) != 2 {
+			t.Fatalf("expected 2 batches, got %d", len(fake.batches))
+		}
+		first := fake.batches[0].Entries[0]
+		if *first.Id != "MessageNumber-1" || *first.MessageAttributes["tag"].StringValue != "app.log" {
+			t.Errorf("unexpected entry: %v", first)
+		}
+		if batch := sqsConf.batches.get("app.log"); batch.messageNumber != 1 || len(batch.records) != 1 {
+			t.Errorf("expected 1 pending record, got counter=%d records=%d", batch.messageNumber, len(batch.records))
+		}
+		if sqsConf.stats.recordsIn.Load() != 5 || sqsConf.stats.bytesSerialized.Load() == 0 {
+			t.Errorf("unexpected stats: recordsIn=%d bytesSerialized=%d", sqsConf.stats.recordsIn.Load(), sqsConf.stats.bytesSerialized.Load())
+		}
+	})
+
+	t.Run("FIFO entries get group and deduplication ids", func(t *testing.T) {
+		resetGlobals()
+		fake := &recordingSQS{}
+		sqsConf := &sqsConfig{
+			queueURL:            "https://sqs.us-east-1.amazonaws.com/123456789/test-queue.fifo",
+			queueMessageGroupID: "group-1",
+			mySQS:               fake,
+			batchSize:           1,
+		}
+
+		if err := flushRecords(sqsConf, "app.log", sliceIterator(timestamp, messageRecords(1)...)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		entry := fake.batches[0].Entries[0]
+		if *entry.MessageGroupId != "group-1" || entry.MessageDeduplicationId == nil {
+			t.Errorf("unexpected FIFO fields: %v", entry)
+		}
+	})
+
+	t.Run("skips empty and filtered records", func(t *testing.T) {
+		resetGlobals()
+		fake := &recordingSQS{}
+		filter, _ := newRecordFilter("message", "keep", "")
+		sqsConf := &sqsConfig{
+			queueURL:   "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+			mySQS:      fake,
+			batchSize:  10,
+			processors: newProcessorChain(nil, filter),
+		}
+
+		captureStdout(func() {
+			err := flushRecords(sqsConf, "app.log", sliceIterator(timestamp,
+				map[interface{}]interface{}{},
+				map[interface{}]interface{}{"message": []byte("keep me")},
+				map[interface{}]interface{}{"message": []byte("drop me")},
+			))
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+
+		if batch := sqsConf.batches.get("app.log"); batch.messageNumber != 1 {
+			t.Errorf("expected 1 pending record, got %d", batch.messageNumber)
+		}
+	})
+
+	t.Run("invalid records are routed to the invalid record queue", func(t *testing.T) {
+		resetGlobals()
+		fake := &recordingSQS{}
+		side := &recordingSQS{}
+		sqsConf := &sqsConfig{
+			queueURL:       "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+			mySQS:          fake,
+			sideSQS:        side,
+			batchSize:      1,
+			requiredKeys:   newRequiredKeys("service"),
+			invalidRecords: newInvalidRecordRoute("https://sqs.us-east-1.amazonaws.com/123456789/invalid", ""),
+		}
+
+		captureStdout(func() {
+			err := flushRecords(sqsConf, "app.log", sliceIterator(timestamp,
+				map[interface{}]interface{}{"message": []byte("no service")},
+				map[interface{}]interface{}{"message": []byte("ok"), "service": "web"},
+			))
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+
+		if len(fake.batches) != 1 || len(side.batches) != 1 {
+			t.Fatalf("expected 1 batch per queue, got %d main and %d invalid", len(fake.batches), len(side.batches))
+		}
+		if *side.batches[0].QueueUrl != "https://sqs.us-east-1.amazonaws.com/123456789/invalid" {
+			t.Errorf("unexpected invalid record queue: %s", *side.batches[0].QueueUrl)
+		}
+	})
+
+	t.Run("send error stops the flush", func(t *testing.T) {
+		resetGlobals()
+		fake := &recordingSQS{err: errors.New("SQS service error")}
+		sqsConf := &sqsConfig{
+			queueURL:  "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+			mySQS:     fake,
+			batchSize: 1,
+		}
+
+		err := flushRecords(sqsConf, "app.log", sliceIterator(timestamp, messageRecords(500)...))
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if len(fake.batches) != 1 {
+			t.Errorf("expected the flush to stop after the first failure, got %d batches", len(fake.batches))
+		}
+	})
+
+	t.Run("messages over the SQS size limit are rejected", func(t *testing.T) {
+		resetGlobals()
+		fake := &recordingSQS{}
+		sqsConf := &sqsConfig{
+			queueURL:  "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+			mySQS:     fake,
+			batchSize: 1,
+		}
+
+		captureStdout(func() {
+			err := flushRecords(sqsConf, "app.log", sliceIterator(timestamp,
+				map[interface{}]interface{}{"message": []byte(strings.Repeat("x", maxMessageBytes))},
+				map[interface{}]interface{}{"message": []byte("small")},
+			))
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+
+		if len(fake.batches) != 1 || len(fake.batches[0].Entries) != 1 {
+			t.Fatalf("expected only the small message to be sent, got %d batches", len(fake.batches))
+		}
+		sent := int64(len(*fake.batches[0].Entries[0].MessageBody))
+		if sqsConf.stats.bytesRejected.Load() <= maxMessageBytes || sqsConf.stats.recordsDropped.Load() != 1 {
+			t.Errorf("unexpected rejection stats: bytesRejected=%d recordsDropped=%d", sqsConf.stats.bytesRejected.Load(), sqsConf.stats.recordsDropped.Load())
+		}
+		if sqsConf.stats.bytesSerialized.Load() != sqsConf.stats.bytesRejected.Load()+sent {
+			t.Errorf("expected the rejected bytes to be serialized bytes too, got serialized=%d rejected=%d", sqsConf.stats.bytesSerialized.Load(), sqsConf.stats.bytesRejected.Load())
+		}
+	})
+
+	t.Run("shadow copies are flushed with the batch", func(t *testing.T) {
+		resetGlobals()
+		fake := &recordingSQS{}
+		side := &recordingSQS{}
+		sqsConf := &sqsConfig{
+			queueURL:  "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+			mySQS:     fake,
+			sideSQS:   side,
+			batchSize: 3,
+			shadow:    newShadowRoute("https://sqs.us-east-1.amazonaws.com/123456789/shadow", 100),
+		}
+
+		captureStdout(func() {
+			if err := flushRecords(sqsConf, "app.log", sliceIterator(timestamp, messageRecords(3)...)); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+
+		if len(side.batches) != 1 || len(side.batches[0].Entries) != 3 {
+			t.Fatalf("expected one shadow batch of 3 entries, got %v", side.batches)
+		}
+		if *side.batches[0].Entries[0].MessageBody != *fake.batches[0].Entries[0].MessageBody {
+			t.Error("shadow copy body differs from the original")
+		}
+		if aws.ToString(side.batches[0].QueueUrl) != "https://sqs.us-east-1.amazonaws.com/123456789/shadow" {
+			t.Errorf("unexpected shadow queue: %s", aws.ToString(side.batches[0].QueueUrl))
+		}
+	})
+}
+
+func TestBatchBackingArrayReuse(t *testing.T) {
+	timestamp := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+
+	t.Run("synchronous sends reuse the batch array", func(t *testing.T) {
+		resetGlobals()
+		sqsConf := &sqsConfig{
+			queueURL:  "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+			mySQS:     &recordingSQS{},
+			batchSize: 4,
+		}
+
+		if err := flushRecords(sqsConf, "app.log", sliceIterator(timestamp, messageRecords(1)...)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		batch := sqsConf.batches.get("app.log")
+		if cap(batch.records) != 4 {
+			t.Fatalf("expected the batch to be preallocated with capacity 4, got %d", cap(batch.records))
+		}
+		backingArray := &batch.records[:1][0]
+
+		if err := flushRecords(sqsConf, "app.log", sliceIterator(timestamp, messageRecords(4)...)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(batch.records) != 1 || &batch.records[0] != backingArray {
+			t.Error("expected the backing array to be reused after a send")
+		}
+	})
+
+	t.Run("reset clears the sent entries", func(t *testing.T) {
+		records := testBatch(3)
+		reset := resetBatch(records)
+		if len(reset) != 0 || cap(reset) != 3 {
+			t.Errorf("unexpected reset batch: len=%d cap=%d", len(reset), cap(reset))
+		}
+		for i, entry := range records {
+			if entry != nil {
+				t.Errorf("entry %d was not cleared", i)
+			}
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package sqsout
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// entryPool recycles batch entries once their batch has been sent, reducing
+// allocation pressure in high throughput deployments
+var entryPool = sync.Pool{
+	New: func() interface{} {
+		return new(types.SendMessageBatchRequestEntry)
+	},
+}
+
+// getEntry returns an empty batch entry from the pool
+func getEntry() *types.SendMessageBatchRequestEntry {
+	return entryPool.Get().(*types.SendMessageBatchRequestEntry)
+}
+
+// releaseEntries returns the entries of a sent batch to the pool. the entries
+// must not be used by the caller afterwards
+func releaseEntries(records []*types.SendMessageBatchRequestEntry) {
+	for _, entry := range records {
+		if entry == nil {
+			continue
+		}
+		*entry = types.SendMessageBatchRequestEntry{}
+		entryPool.Put(entry)
+	}
+}
+
+// jsonBuffer is a reusable serialization buffer with its encoder
+type jsonBuffer struct {
+	buf bytes.Buffer
+	enc *json.Encoder
+}
+
+var jsonBufferPool = sync.Pool{
+	New: func() interface{} {
+		b := &jsonBuffer{}
+		b.enc = json.NewEncoder(&b.buf)
+		return b
+	},
+}
+
+// noHTMLEscapeJSONBufferPool holds the buffers of marshalJSONNoHTMLEscape
+var noHTMLEscapeJSONBufferPool = sync.Pool{
+	New: func() interface{} {
+		b := &jsonBuffer{}
+		b.enc = json.NewEncoder(&b.buf)
+		b.enc.SetEscapeHTML(false)
+		return b
+	},
+}
+
+// marshalJSON encodes v like json.Marshal does, using a pooled buffer and
+// encoder. the returned string is a copy so the buffer can be reused
+func marshalJSON(v interface{}) (string, error) {
+	return marshalPooledJSON(&jsonBufferPool, v)
+}
+
+// marshalJSONNoHTMLEscape is marshalJSON writing <, > and & as is
+func marshalJSONNoHTMLEscape(v interface{}) (string, error) {
+	return marshalPooledJSON(&noHTMLEscapeJSONBufferPool, v)
+}
+
+func marshalPooledJSON(pool *sync.Pool, v interface{}) (string, error) {
+	b := pool.Get().(*jsonBuffer)
+	defer pool.Put(b)
+
+	b.buf.Reset()
+	if err := b.enc.Encode(v); err != nil {
+		return "", err
+	}
+
+	// the encoder terminates every value with a newline
+	return string(bytes.TrimSuffix(b.buf.Bytes(), []byte("\n"))), nil
+}
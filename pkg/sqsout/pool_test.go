@@ -0,0 +1,55 @@
+package sqsout
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestReleaseEntries(t *testing.T) {
+	records := testBatch(3)
+	records[1].MessageGroupId = aws.String("group-1")
+	entries := append(records[:0:0], records...)
+	records = append(records, nil)
+
+	releaseEntries(records)
+
+	for i, entry := range entries {
+		if entry.Id != nil || entry.MessageBody != nil || entry.MessageGroupId != nil {
+			t.Errorf("entry %d was not reset: %v", i, entry)
+		}
+	}
+
+	entry := getEntry()
+	if entry.Id != nil || entry.MessageBody != nil {
+		t.Errorf("pooled entry is not empty: %v", entry)
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	values := []interface{}{
+		map[string]interface{}{"message": "hello", "count": 3},
+		map[string]interface{}{"html": "<a href=\"x\">&</a>"},
+		map[string]interface{}{},
+		"text",
+	}
+
+	for _, value := range values {
+		want, _ := json.Marshal(value)
+		for i := 0; i < 2; i++ {
+			got, err := marshalJSON(value)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != string(want) {
+				t.Errorf("marshalJSON(%v) = %s, want %s", value, got, want)
+			}
+		}
+	}
+
+	if _, err := marshalJSON(math.Inf(1)); err == nil {
+		t.Error("expected error for unsupported value")
+	}
+}
@@ -0,0 +1,93 @@
+package sqsout
+
+import (
+	"fmt"
+	"time"
+)
+
+// Record is a record going through the processors of an output, before it
+// is formatted. the byte slice values of Fields point into the chunk, they
+// are replaced rather than modified in place
+type Record struct {
+	// Tag is the tag the record was flushed with, it can't be changed
+	Tag       string
+	Timestamp time.Time
+	Fields    map[interface{}]interface{}
+}
+
+// Processor mutates a record before it is sent, or vetoes it by returning
+// false. the processors of an output run in order and a vetoed record is
+// dropped without running the next ones
+type Processor interface {
+	Process(record *Record) bool
+}
+
+// ProcessorFunc is a function used as a Processor
+type ProcessorFunc func(record *Record) bool
+
+func (f ProcessorFunc) Process(record *Record) bool {
+	return f(record)
+}
+
+// SinkMiddleware wraps the sink of an output to act before and after every
+// batch is sent, e.g. to change or veto entries or to observe the results
+type SinkMiddleware func(next Sink) Sink
+
+// processorStep is a processor of the chain, with the drop reason counted
+// and the debug log written when it vetoes a record
+type processorStep struct {
+	processor Processor
+	reason    dropReason
+	vetoed    func() string
+}
+
+// processorChain runs the processors of an output in order
+type processorChain []processorStep
+
+// newProcessorChain starts the chain with the built-in SamplePercent and
+// FilterRegex/ExcludeRegex processors, the ones not configured are skipped
+func newProcessorChain(sampler *recordSampler, filter *recordFilter) processorChain {
+	var chain processorChain
+	if sampler != nil {
+		chain = append(chain, processorStep{
+			processor: ProcessorFunc(func(r *Record) bool { return sampler.keep(r.Fields) }),
+			reason:    dropSampled,
+			vetoed:    func() string { return "record was not selected by SamplePercent. skipping it" },
+		})
+	}
+	if filter != nil {
+		chain = append(chain, processorStep{
+			processor: ProcessorFunc(func(r *Record) bool { return filter.keep(r.Fields) }),
+			reason:    dropFiltered,
+			vetoed: func() string {
+				return fmt.Sprintf("record was dropped by FilterRegex/ExcludeRegex. total dropped by filter: %d", filter.droppedCount.Load())
+			},
+		})
+	}
+	return chain
+}
+
+// add appends the processors of a library user, their vetoes are counted as
+// vetoed drops
+func (c processorChain) add(processors ...Processor) processorChain {
+	for _, processor := range processors {
+		index := len(c)
+		c = append(c, processorStep{
+			processor: processor,
+			reason:    dropVetoed,
+			vetoed:    func() string { return fmt.Sprintf("record was vetoed by processor %d. skipping it", index) },
+		})
+	}
+	return c
+}
+
+// run passes the record through the processors and returns the step which
+// vetoed it, nil when the record is kept
+func (c processorChain) run(record *Record) *processorStep {
+	for i := range c {
+		if !c[i].processor.Process(record) {
+			return &c[i]
+		}
+	}
+	return nil
+}
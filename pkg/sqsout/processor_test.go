@@ -0,0 +1,164 @@
+package sqsout
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestProcessorChain(t *testing.T) {
+	var calls []string
+	step := func(name string, keep bool) Processor {
+		return ProcessorFunc(func(r *Record) bool {
+			calls = append(calls, name)
+			return keep
+		})
+	}
+
+	tests := []struct {
+		name       string
+		processors []Processor
+		wantCalls  []string
+		wantVetoed bool
+	}{
+		{"no processor", nil, nil, false},
+		{"all keep", []Processor{step("first", true), step("second", true)}, []string{"first", "second"}, false},
+		{"veto stops the chain", []Processor{step("first", false), step("second", true)}, []string{"first"}, true},
+	}
+
+	for _, tt := range tests {
+		calls = nil
+		vetoed := processorChain(nil).add(tt.processors...).run(&Record{Fields: map[interface{}]interface{}{"log": "hello"}})
+		if (vetoed != nil) != tt.wantVetoed || !reflect.DeepEqual(calls, tt.wantCalls) {
+			t.Errorf("%s: vetoed %v after %v, want vetoed %v after %v", tt.name, vetoed != nil, calls, tt.wantVetoed, tt.wantCalls)
+		}
+	}
+}
+
+func TestNewProcessorChain(t *testing.T) {
+	filter, _ := newRecordFilter("log", "keep", "")
+	chain := newProcessorChain(&recordSampler{percent: 0}, filter)
+	if len(chain) != 2 || chain[0].reason != dropSampled || chain[1].reason != dropFiltered {
+		t.Fatalf("expected the sampler then the filter, got %+v", chain)
+	}
+
+	if chain := newProcessorChain(nil, nil); len(chain) != 0 {
+		t.Errorf("expected an empty chain without sampling or filtering, got %d steps", len(chain))
+	}
+}
+
+func TestOutputProcessors(t *testing.T) {
+	resetGlobals()
+	fake := &recordingSQS{}
+	out := &Output{conf: &sqsConfig{
+		queueURL:  "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS:     fake,
+		batchSize: 10,
+	}}
+
+	redact := ProcessorFunc(func(r *Record) bool {
+		if _, ok := r.Fields["password"]; ok {
+			r.Fields["password"] = "[REDACTED]"
+		}
+		return true
+	})
+	enrich := ProcessorFunc(func(r *Record) bool {
+		r.Fields["tag"] = r.Tag
+		return true
+	})
+	dropDebug := ProcessorFunc(func(r *Record) bool {
+		return r.Fields["level"] != "debug"
+	})
+	out.Use(redact, enrich, dropDebug)
+
+	err := out.Send("app", sliceIterator(time.Now(),
+		map[interface{}]interface{}{"log": "login", "password": []byte("hunter2")},
+		map[interface{}]interface{}{"log": "noise", "level": "debug"},
+	))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := out.SendPending(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(fake.batches) != 1 || len(fake.batches[0].Entries) != 1 {
+		t.Fatalf("expected a single message, got %v", fake.batches)
+	}
+	body := aws.ToString(fake.batches[0].Entries[0].MessageBody)
+	if !strings.Contains(body, `"password":"[REDACTED]"`) || !strings.Contains(body, `"tag":"app"`) {
+		t.Errorf("expected the record to be redacted and enriched, got %s", body)
+	}
+	if got := out.conf.stats.drops[dropVetoed].Load(); got != 1 {
+		t.Errorf("expected 1 vetoed record, got %d", got)
+	}
+}
+
+// vetoSink is a middleware dropping the entries of a body before sending
+// the others
+func vetoSink(body string) SinkMiddleware {
+	return func(next Sink) Sink {
+		return sinkFunc(func(ctx context.Context, entries []*types.SendMessageBatchRequestEntry) ([]types.SendMessageBatchResultEntry, []types.BatchResultErrorEntry, error) {
+			var kept []*types.SendMessageBatchRequestEntry
+			for _, entry := range entries {
+				if !strings.Contains(aws.ToString(entry.MessageBody), body) {
+					kept = append(kept, entry)
+				}
+			}
+			return next.SendBatch(ctx, kept)
+		})
+	}
+}
+
+// sinkFunc is a function used as a Sink
+type sinkFunc func(ctx context.Context, entries []*types.SendMessageBatchRequestEntry) ([]types.SendMessageBatchResultEntry, []types.BatchResultErrorEntry, error)
+
+func (f sinkFunc) SendBatch(ctx context.Context, entries []*types.SendMessageBatchRequestEntry) ([]types.SendMessageBatchResultEntry, []types.BatchResultErrorEntry, error) {
+	return f(ctx, entries)
+}
+
+func TestOutputWrapSink(t *testing.T) {
+	resetGlobals()
+	fake := &recordingSQS{}
+	out := &Output{conf: &sqsConfig{
+		queueURL:  "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS:     fake,
+		batchSize: 10,
+	}}
+
+	var order []string
+	seen := map[string]int{}
+	observe := func(name string) SinkMiddleware {
+		return func(next Sink) Sink {
+			return sinkFunc(func(ctx context.Context, entries []*types.SendMessageBatchRequestEntry) ([]types.SendMessageBatchResultEntry, []types.BatchResultErrorEntry, error) {
+				order = append(order, name)
+				seen[name] += len(entries)
+				return next.SendBatch(ctx, entries)
+			})
+		}
+	}
+	out.WrapSink(observe("outer"), vetoSink("secret"), observe("inner"))
+
+	err := out.Send("app", sliceIterator(time.Now(),
+		map[interface{}]interface{}{"log": "public"},
+		map[interface{}]interface{}{"log": "secret"},
+	))
+	if err == nil {
+		err = out.SendPending()
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(order, []string{"outer", "inner"}) {
+		t.Errorf("expected the middlewares to run in order, got %v", order)
+	}
+	if len(fake.batches) != 1 || len(fake.batches[0].Entries) != 1 || seen["outer"] != 2 || seen["inner"] != 1 {
+		t.Errorf("expected the vetoed entry to be dropped before the queue, got %d batches and %v entries seen", len(fake.batches), seen)
+	}
+}
@@ -0,0 +1,188 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// queueAttributesTimeout bounds a GetQueueAttributes call
+const queueAttributesTimeout = 10 * time.Second
+
+// defaultQueueAttributesRefresh is how often the queue attributes are
+// fetched again when QueueAttributesRefreshMinutes is not set
+const defaultQueueAttributesRefresh = 5 * time.Minute
+
+// queueAttributesClient is the SQS operation reading the queue attributes
+type queueAttributesClient interface {
+	GetQueueAttributes(ctx context.Context, input *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error)
+}
+
+// parseQueueAttributesRefresh parses QueueAttributesRefreshMinutes, zero
+// fetches the attributes at init only
+func parseQueueAttributesRefresh(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultQueueAttributesRefresh, nil
+	}
+	minutes, err := strconv.Atoi(value)
+	if err != nil || minutes < 0 {
+		return 0, errors.New("QueueAttributesRefreshMinutes should be a positive integer")
+	}
+	return time.Duration(minutes) * time.Minute, nil
+}
+
+// parseFifoQueue parses FifoQueue, set is false when the queue type should
+// be detected from the queue attributes
+func parseFifoQueue(value string) (fifo, set bool, err error) {
+	if value == "" {
+		return false, false, nil
+	}
+	fifo, err = parseBool("FifoQueue", value)
+	return fifo, err == nil, err
+}
+
+// queueAttributes caches the attributes of the queue changing how the
+// records are sent, so a queue reconfigured while fluent bit runs is picked
+// up at the next refresh. the SQS defaults are used until the first fetch
+// succeeds
+type queueAttributes struct {
+	client   queueAttributesClient
+	queueURL string
+	interval time.Duration
+
+	maxMessageSize atomic.Int64
+	fifo           atomic.Bool
+	sse            atomic.Bool
+	redrive        atomic.Bool
+
+	stopCh   chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newQueueAttributes(client queueAttributesClient, queueURL string, interval time.Duration) *queueAttributes {
+	q := &queueAttributes{
+		client:   client,
+		queueURL: queueURL,
+		interval: interval,
+		stopCh:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	q.maxMessageSize.Store(maxMessageBytes)
+	return q
+}
+
+// fetch reads the attributes of the queue and logs the ones which changed
+// since the previous fetch
+func (q *queueAttributes) fetch() error {
+	ctx, cancel := context.WithTimeout(context.Background(), queueAttributesTimeout)
+	defer cancel()
+
+	output, err := q.client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(q.queueURL),
+		AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameAll},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get the attributes of %s: %v", q.queueURL, err)
+	}
+
+	attributes := output.Attributes
+	if value, ok := attributes[string(types.QueueAttributeNameMaximumMessageSize)]; ok {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || size <= 0 {
+			return fmt.Errorf("queue %s has an invalid MaximumMessageSize %q", q.queueURL, value)
+		}
+		if previous := q.maxMessageSize.Swap(size); previous != size {
+			writeInfoLog(fmt.Sprintf("maximum message size of %s is %d bytes", queueName(q.queueURL), size))
+		}
+	}
+
+	fifo := attributes[string(types.QueueAttributeNameFifoQueue)] == "true"
+	if previous := q.fifo.Swap(fifo); previous != fifo {
+		writeInfoLog(fmt.Sprintf("queue %s is a FIFO queue: %t", queueName(q.queueURL), fifo))
+	}
+
+	sse := attributes[string(types.QueueAttributeNameKmsMasterKeyId)] != "" ||
+		attributes[string(types.QueueAttributeNameSqsManagedSseEnabled)] == "true"
+	if previous := q.sse.Swap(sse); previous != sse {
+		writeInfoLog(fmt.Sprintf("server side encryption of %s is enabled: %t", queueName(q.queueURL), sse))
+	}
+
+	redrive := attributes[string(types.QueueAttributeNameRedrivePolicy)] != ""
+	q.redrive.Store(redrive)
+
+	return nil
+}
+
+// checkDeadLetterQueue warns when the queue has no redrive policy, the
+// messages its consumers fail to process are then received again forever.
+// with RequireDlq it fails instead, also when the attributes couldn't be
+// fetched. fetchErr is the error of the first fetch
+func (q *queueAttributes) checkDeadLetterQueue(require bool, fetchErr error) error {
+	if fetchErr != nil {
+		if require {
+			return fmt.Errorf("RequireDlq is set but the redrive policy of %s can't be checked: %v", q.queueURL, fetchErr)
+		}
+		return nil
+	}
+	if q.redrive.Load() {
+		return nil
+	}
+	if require {
+		return fmt.Errorf("queue %s has no redrive policy and RequireDlq is set", q.queueURL)
+	}
+	writeWarnLog(fmt.Sprintf("queue %s has no redrive policy, the messages its consumers fail to process will be received again forever. set a dead letter queue on it", queueName(q.queueURL)))
+	return nil
+}
+
+// start fetches the attributes again every interval until stop is called,
+// the refresh stops with the instance owning it. failures are logged only,
+// the cached attributes are kept until the next fetch
+func (q *queueAttributes) start(owner *sqsConfig) {
+	registerReporter(owner, q)
+
+	go func() {
+		defer close(q.done)
+
+		ticker := time.NewTicker(q.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := q.fetch(); err != nil {
+					writeWarnLog(err.Error())
+				}
+			case <-q.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// stop stops the refresh, it is safe to call more than once
+func (q *queueAttributes) stop() {
+	q.stopOnce.Do(func() {
+		close(q.stopCh)
+	})
+	<-q.done
+}
+
+// maxMessageBytes is the size limit of the messages, the one of the queue
+// when its attributes are known. a message is never over the batch payload
+// limit
+func (c *sqsConfig) maxMessageBytes() int {
+	limit := int64(maxMessageBytes)
+	if c.queueAttributes != nil {
+		limit = c.queueAttributes.maxMessageSize.Load()
+	}
+	return int(min(limit, c.batchPayloadLimit()))
+}
@@ -0,0 +1,206 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// fakeQueueAttributes implements queueAttributesClient interface for testing
+type fakeQueueAttributes struct {
+	attributes map[string]string
+	err        error
+}
+
+func (f *fakeQueueAttributes) GetQueueAttributes(ctx context.Context, input *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &sqs.GetQueueAttributesOutput{Attributes: f.attributes}, nil
+}
+
+func TestParseQueueAttributesRefresh(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 5 * time.Minute, false},
+		{"0", 0, false},
+		{"15", 15 * time.Minute, false},
+		{"-1", 0, true},
+		{"5m", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseQueueAttributesRefresh(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseQueueAttributesRefresh(%q) = %v, %v, want %v, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestQueueAttributesFetch(t *testing.T) {
+	tests := []struct {
+		name       string
+		attributes map[string]string
+		err        error
+		wantSize   int64
+		wantFifo   bool
+		wantSSE    bool
+		wantErr    bool
+	}{
+		{"defaults", map[string]string{}, nil, maxMessageBytes, false, false, false},
+		{"fifo with kms", map[string]string{"MaximumMessageSize": "1024", "FifoQueue": "true", "KmsMasterKeyId": "alias/aws/sqs"}, nil, 1024, true, true, false},
+		{"sqs managed sse", map[string]string{"SqsManagedSseEnabled": "true"}, nil, maxMessageBytes, false, true, false},
+		{"invalid size", map[string]string{"MaximumMessageSize": "big"}, nil, maxMessageBytes, false, false, true},
+		{"request error", nil, errors.New("AccessDenied"), maxMessageBytes, false, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attributes := newQueueAttributes(&fakeQueueAttributes{attributes: tt.attributes, err: tt.err}, "https://sqs.us-east-1.amazonaws.com/123456789/test-queue", 0)
+			err := attributes.fetch()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fetch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if attributes.maxMessageSize.Load() != tt.wantSize || attributes.fifo.Load() != tt.wantFifo || attributes.sse.Load() != tt.wantSSE {
+				t.Errorf("got size %d, fifo %v, sse %v, want %d, %v, %v", attributes.maxMessageSize.Load(), attributes.fifo.Load(), attributes.sse.Load(), tt.wantSize, tt.wantFifo, tt.wantSSE)
+			}
+		})
+	}
+}
+
+func TestQueueAttributesRefresh(t *testing.T) {
+	resetGlobals()
+	client := &fakeQueueAttributes{attributes: map[string]string{"MaximumMessageSize": "262144"}}
+	sqsConf := &sqsConfig{
+		queueURL:        "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		queueAttributes: newQueueAttributes(client, "https://sqs.us-east-1.amazonaws.com/123456789/test-queue", 0),
+	}
+	if err := sqsConf.queueAttributes.fetch(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	record := map[interface{}]interface{}{"message": strings.Repeat("x", 2000)}
+	if prepared := prepareRecord(sqsConf, "app", time.Now(), record); prepared == nil {
+		t.Fatal("expected the record to fit the queue")
+	}
+
+	// the queue is reconfigured while the plugin runs
+	client.attributes = map[string]string{"MaximumMessageSize": "1024"}
+	if err := sqsConf.queueAttributes.fetch(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if prepared := prepareRecord(sqsConf, "app", time.Now(), record); prepared != nil {
+		t.Errorf("expected the record to be dropped with the new limit, got %d bytes", len(prepared.body))
+	}
+}
+
+func TestQueueAttributesStartStop(t *testing.T) {
+	resetGlobals()
+	client := &fakeQueueAttributes{attributes: map[string]string{"FifoQueue": "true"}}
+	sqsConf := &sqsConfig{queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue.fifo"}
+	attributes := newQueueAttributes(client, sqsConf.queueURL, 10*time.Millisecond)
+	attributes.start(sqsConf)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !attributes.fifo.Load() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	stopInstanceReporters(sqsConf)
+
+	if !attributes.fifo.Load() {
+		t.Error("expected the refresh to fetch the attributes")
+	}
+}
+
+func TestCheckDeadLetterQueue(t *testing.T) {
+	redrivePolicy := `{"deadLetterTargetArn":"arn:aws:sqs:us-east-1:123456789:test-queue-dlq","maxReceiveCount":"5"}`
+
+	tests := []struct {
+		name       string
+		attributes map[string]string
+		err        error
+		require    bool
+		wantErr    bool
+	}{
+		{"redrive policy", map[string]string{"RedrivePolicy": redrivePolicy}, nil, true, false},
+		{"no redrive policy warns", map[string]string{}, nil, false, false},
+		{"no redrive policy with RequireDlq", map[string]string{}, nil, true, true},
+		{"fetch failure", nil, errors.New("AccessDenied"), false, false},
+		{"fetch failure with RequireDlq", nil, errors.New("AccessDenied"), true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attributes := newQueueAttributes(&fakeQueueAttributes{attributes: tt.attributes, err: tt.err}, "https://sqs.us-east-1.amazonaws.com/123456789/test-queue", 0)
+			fetchErr := attributes.fetch()
+			if err := attributes.checkDeadLetterQueue(tt.require, fetchErr); (err != nil) != tt.wantErr {
+				t.Errorf("checkDeadLetterQueue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFifoQueueDetection(t *testing.T) {
+	resetGlobals()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+
+	tests := []struct {
+		name       string
+		queue      string
+		fifoQueue  string
+		attributes map[string]string
+		failover   string
+		wantErr    string
+	}{
+		{"fifo from the attributes", "logs", "", map[string]string{"FifoQueue": "true"}, "", "QueueMessageGroupId"},
+		{"standard queue with a .fifo url", "logs.fifo", "", map[string]string{}, "", ""},
+		{"override", "logs", "false", map[string]string{"FifoQueue": "true"}, "", ""},
+		{"fifo override", "logs", "true", map[string]string{}, "", "QueueMessageGroupId"},
+		{"invalid override", "logs", "maybe", map[string]string{}, "", "FifoQueue"},
+		{"failover of a fifo queue", "logs", "", map[string]string{"FifoQueue": "true"}, "logs-failover", "FailoverQueueUrl"},
+		{"failover of a standard queue with a .fifo url", "logs.fifo", "", map[string]string{}, "logs-failover", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(&fakeSQSEndpoint{attributes: tt.attributes})
+			defer server.Close()
+
+			config := map[string]string{
+				"QueueUrl":    server.URL + "/123456789/" + tt.queue,
+				"QueueRegion": "us-east-1",
+				"Endpoint":    server.URL,
+				"BatchSize":   "1",
+				"FifoQueue":   tt.fifoQueue,
+			}
+			if tt.failover != "" {
+				config["FailoverQueueUrl"] = server.URL + "/123456789/" + tt.failover
+				config["QueueMessageGroupId"] = "logs"
+			}
+
+			var err error
+			captureStdout(func() {
+				var out *Output
+				out, err = New(func(key string) string { return config[key] })
+				if err == nil {
+					out.Close()
+				}
+			})
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("New() unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("New() expected an error with %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	// defaultLatencyProbeInterval is how often the equivalent queues are
+	// probed when LatencyProbeSeconds is not set
+	defaultLatencyProbeInterval = 30 * time.Second
+	// queueSwitchMargin is how much faster another queue should answer than
+	// the selected one to take its traffic, the queues of close latencies
+	// don't take turns at every probe
+	queueSwitchMargin = 0.8
+	// unhealthyLatency is the latency of a queue whose probe failed
+	unhealthyLatency time.Duration = -1
+)
+
+// parseEquivalentQueueURLs parses EquivalentQueueUrls, the queues consumed
+// like QueueUrl the records may be sent to instead
+func parseEquivalentQueueURLs(value, queueURL string, compatibility compatibilityMode) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	seen := map[string]bool{queueURL: true}
+	var queues []string
+	for _, equivalent := range splitConfigList(value) {
+		if err := compatibility.validateQueueURL("EquivalentQueueUrls", equivalent); err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(equivalent, ".fifo") {
+			return nil, fmt.Errorf("EquivalentQueueUrls %q is a FIFO queue, the order of a message group can't span queues", equivalent)
+		}
+		if seen[equivalent] {
+			return nil, fmt.Errorf("EquivalentQueueUrls has %s more than once or with QueueUrl", equivalent)
+		}
+		seen[equivalent] = true
+		queues = append(queues, equivalent)
+	}
+	return queues, nil
+}
+
+// parseLatencyProbeInterval parses LatencyProbeSeconds
+func parseLatencyProbeInterval(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultLatencyProbeInterval, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return 0, errors.New("LatencyProbeSeconds should be a positive integer")
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// queueSelector sends the batches to the fastest of equivalent queues. every
+// interval each queue is probed with a GetQueueAttributes call, the queues
+// whose probe fails are skipped until they answer again. QueueUrl is selected
+// until the first probe
+type queueSelector struct {
+	client   queueAttributesClient
+	queues   []string
+	interval time.Duration
+
+	selected atomic.Int64
+
+	stopCh   chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// newQueueSelector selects among queueURL and its equivalent queues
+func newQueueSelector(client queueAttributesClient, queueURL string, equivalent []string, interval time.Duration) *queueSelector {
+	return &queueSelector{
+		client:   client,
+		queues:   append([]string{queueURL}, equivalent...),
+		interval: interval,
+		stopCh:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// queueURL returns the selected queue
+func (s *queueSelector) queueURL() string {
+	return s.queues[s.selected.Load()]
+}
+
+// probe measures the latency of every queue and selects the fastest
+func (s *queueSelector) probe() {
+	latencies := make([]time.Duration, len(s.queues))
+	for i, queueURL := range s.queues {
+		latencies[i] = probeQueue(s.client, queueURL)
+	}
+	s.choose(latencies)
+}
+
+// probeQueue returns the latency of a GetQueueAttributes call to the queue,
+// unhealthyLatency when it fails
+func probeQueue(client queueAttributesClient, queueURL string) time.Duration {
+	ctx, cancel := context.WithTimeout(context.Background(), queueAttributesTimeout)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(queueURL),
+		AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameQueueArn},
+	})
+	if err != nil {
+		writeDebugLog(fmt.Sprintf("probe of %s failed: %v", queueName(queueURL), err))
+		return unhealthyLatency
+	}
+	return time.Since(start)
+}
+
+// choose selects the queue of the lowest latency. the selected queue keeps
+// the traffic unless its probe failed or another one is faster by the switch
+// margin, and when no queue answered
+func (s *queueSelector) choose(latencies []time.Duration) {
+	best := -1
+	for i, latency := range latencies {
+		if latency != unhealthyLatency && (best < 0 || latency < latencies[best]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		writeWarnLog(fmt.Sprintf("no queue answered the latency probe, keeping %s", queueName(s.queueURL())))
+		return
+	}
+
+	selected := int(s.selected.Load())
+	current := latencies[selected]
+	if best == selected || (current != unhealthyLatency && float64(latencies[best]) >= float64(current)*queueSwitchMargin) {
+		return
+	}
+
+	s.selected.Store(int64(best))
+	if current == unhealthyLatency {
+		writeWarnLog(fmt.Sprintf("queue %s failed its latency probe, sending to %s (%s)", queueName(s.queues[selected]), queueName(s.queues[best]), latencies[best]))
+		return
+	}
+	writeInfoLog(fmt.Sprintf("sending to %s (%s) instead of %s (%s)", queueName(s.queues[best]), latencies[best], queueName(s.queues[selected]), current))
+}
+
+// start probes the queues every interval until stop is called
+func (s *queueSelector) start(owner *sqsConfig) {
+	registerReporter(owner, s)
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.probe()
+			case <-s.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// stop stops the probes, it is safe to call more than once
+func (s *queueSelector) stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+	<-s.done
+}
+
+// activeQueueURL is the queue the batches are sent to, the selected one when
+// the queue has equivalent queues and the failover queue while QueueUrl is
+// unhealthy
+func (sqsConf *sqsConfig) activeQueueURL() string {
+	if sqsConf.queueSelector != nil {
+		return sqsConf.queueSelector.queueURL()
+	}
+	if sqsConf.failover != nil {
+		return sqsConf.failover.queueURL()
+	}
+	return sqsConf.queueURL
+}
@@ -0,0 +1,160 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+)
+
+// probedQueues answers the probes of the queues, the failing ones return an
+// error
+type probedQueues struct {
+	mu      sync.Mutex
+	failing map[string]bool
+	probed  []string
+}
+
+func (p *probedQueues) GetQueueAttributes(ctx context.Context, input *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	queueURL := aws.ToString(input.QueueUrl)
+	p.probed = append(p.probed, queueURL)
+	if p.failing[queueURL] {
+		return nil, errors.New("connection timed out")
+	}
+	return &sqs.GetQueueAttributesOutput{}, nil
+}
+
+func TestParseEquivalentQueueURLs(t *testing.T) {
+	const queueURL = "https://sqs.us-east-1.amazonaws.com/123456789/logs"
+	tests := []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{"not set", "", nil, false},
+		{"two queues", "https://sqs.us-east-1.amazonaws.com/123456789/logs-b, https://sqs.us-east-1.amazonaws.com/123456789/logs-c",
+			[]string{"https://sqs.us-east-1.amazonaws.com/123456789/logs-b", "https://sqs.us-east-1.amazonaws.com/123456789/logs-c"}, false},
+		{"not a queue url", "logs-b", nil, true},
+		{"fifo queue", "https://sqs.us-east-1.amazonaws.com/123456789/logs-b.fifo", nil, true},
+		{"QueueUrl itself", queueURL, nil, true},
+		{"duplicate", "https://sqs.us-east-1.amazonaws.com/123456789/logs-b,https://sqs.us-east-1.amazonaws.com/123456789/logs-b", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseEquivalentQueueURLs(tt.value, queueURL, compatibilityAWS)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseEquivalentQueueURLs() = %v, %v, want %v, wantErr %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseLatencyProbeInterval(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 30 * time.Second, false},
+		{"5", 5 * time.Second, false},
+		{"0", 0, true},
+		{"often", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseLatencyProbeInterval(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseLatencyProbeInterval(%q) = %v, %v, want %v, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestQueueSelectorChoose(t *testing.T) {
+	const ms = time.Millisecond
+	tests := []struct {
+		name      string
+		selected  int64
+		latencies []time.Duration
+		want      int64
+	}{
+		{"fastest queue", 0, []time.Duration{40 * ms, 10 * ms, 20 * ms}, 1},
+		{"within the switch margin", 0, []time.Duration{10 * ms, 9 * ms}, 0},
+		{"selected queue is the fastest", 1, []time.Duration{40 * ms, 10 * ms}, 1},
+		{"selected queue failed", 0, []time.Duration{unhealthyLatency, 90 * ms, 80 * ms}, 2},
+		{"failed queue is skipped", 0, []time.Duration{30 * ms, unhealthyLatency}, 0},
+		{"no queue answered", 1, []time.Duration{unhealthyLatency, unhealthyLatency}, 1},
+	}
+
+	for _, tt := range tests {
+		selector := newQueueSelector(&probedQueues{}, "q0", []string{"q1", "q2"}[:len(tt.latencies)-1], time.Minute)
+		selector.selected.Store(tt.selected)
+		captureStdout(func() { selector.choose(tt.latencies) })
+		if got := selector.selected.Load(); got != tt.want {
+			t.Errorf("%s: selected queue %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestQueueSelectorProbe(t *testing.T) {
+	resetGlobals()
+	const queueURL = "https://sqs.us-east-1.amazonaws.com/123456789/logs"
+	const equivalentURL = "https://sqs.us-east-1.amazonaws.com/123456789/logs-b"
+	client := &probedQueues{failing: map[string]bool{queueURL: true}}
+	selector := newQueueSelector(client, queueURL, []string{equivalentURL}, time.Minute)
+
+	output := captureStdout(selector.probe)
+
+	if got := selector.queueURL(); got != equivalentURL {
+		t.Errorf("expected the queue which answered to be selected, got %s", got)
+	}
+	if !reflect.DeepEqual(client.probed, []string{queueURL, equivalentURL}) {
+		t.Errorf("expected every queue to be probed, got %v", client.probed)
+	}
+	if !strings.Contains(output, "failed its latency probe") {
+		t.Errorf("expected the switch to be logged, got %q", output)
+	}
+
+	sqsConf := &sqsConfig{queueURL: queueURL, mySQS: &recordingSQS{}, queueSelector: selector}
+	if got := sqsConf.activeQueueURL(); got != equivalentURL {
+		t.Errorf("expected the batches to be sent to the selected queue, got %s", got)
+	}
+}
+
+func TestDeliverBatchNamesSelectedQueue(t *testing.T) {
+	resetGlobals()
+	const queueURL = "https://sqs.us-east-1.amazonaws.com/123456789/logs"
+	const equivalentURL = "https://sqs.us-east-1.amazonaws.com/123456789/logs-b"
+	selector := newQueueSelector(&probedQueues{}, queueURL, []string{equivalentURL}, time.Minute)
+	selector.selected.Store(1)
+	fake := &fakeSQS{output: &sqs.SendMessageBatchOutput{
+		Failed: []types.BatchResultErrorEntry{{Id: aws.String("msg-1"), Code: aws.String("InvalidMessageContents")}},
+	}}
+	sqsConf := &sqsConfig{queueURL: queueURL, mySQS: fake, queueSelector: selector}
+	records := []*types.SendMessageBatchRequestEntry{{Id: aws.String("msg-1"), MessageBody: aws.String("x")}}
+
+	output := captureStdout(func() { deliverBatch(sqsConf, records) })
+	if got := aws.ToString(fake.input.QueueUrl); got != equivalentURL {
+		t.Errorf("expected the batch to be sent to the selected queue, got %s", got)
+	}
+	if !strings.Contains(output, "logs-b") {
+		t.Errorf("expected the failures to name the selected queue, got %q", output)
+	}
+
+	fake.err = &smithy.GenericAPIError{Code: "AccessDenied"}
+	var batchErr *batchError
+	captureStdout(func() {
+		if err := deliverBatch(sqsConf, records); !errors.As(err, &batchErr) || batchErr.queueURL != equivalentURL {
+			t.Errorf("expected a batchError of the selected queue, got %#v", err)
+		}
+	})
+}
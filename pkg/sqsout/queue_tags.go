@@ -0,0 +1,71 @@
+package sqsout
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// queueTagsTimeout bounds the TagQueue call
+const queueTagsTimeout = 10 * time.Second
+
+// queueTaggingClient is the SQS operation tagging a queue
+type queueTaggingClient interface {
+	TagQueue(ctx context.Context, input *sqs.TagQueueInput, optFns ...func(*sqs.Options)) (*sqs.TagQueueOutput, error)
+}
+
+// parseQueueTags parses TagQueueOnInit, a list of key:value tags
+func parseQueueTags(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	tags := make(map[string]string)
+	for _, item := range splitConfigList(value) {
+		key, tagValue, ok := strings.Cut(item, ":")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid TagQueueOnInit entry %q, expected <key>:<value>", item)
+		}
+		if _, duplicate := tags[key]; duplicate {
+			return nil, fmt.Errorf("TagQueueOnInit has tag %s more than once", key)
+		}
+		tags[key] = strings.TrimSpace(tagValue)
+	}
+	return tags, nil
+}
+
+// isPermissionCode returns true for the error codes of a request the
+// credentials are not allowed to make
+func isPermissionCode(code string) bool {
+	switch code {
+	case "AccessDenied", "AccessDeniedException", "UnauthorizedOperation", "AuthorizationError":
+		return true
+	}
+	return false
+}
+
+// tagQueue tags the queue once when the instance starts, for the cost
+// allocation and the ownership of the logging queues. the credentials
+// allowed to send are not always allowed to tag, a permission error is
+// logged only
+func tagQueue(client queueTaggingClient, queueURL string, tags map[string]string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queueTagsTimeout)
+	defer cancel()
+
+	_, err := client.TagQueue(ctx, &sqs.TagQueueInput{QueueUrl: aws.String(queueURL), Tags: tags})
+	if err != nil {
+		if isPermissionCode(awsErrorCode(err)) {
+			writeWarnLog(fmt.Sprintf("not allowed to tag %s, skipping TagQueueOnInit: %v", queueName(queueURL), err))
+			return nil
+		}
+		return fmt.Errorf("failed to tag %s: %v", queueURL, err)
+	}
+
+	writeInfoLog(fmt.Sprintf("tagged %s with %d tags", queueName(queueURL), len(tags)))
+	return nil
+}
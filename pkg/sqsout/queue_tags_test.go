@@ -0,0 +1,75 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/smithy-go"
+)
+
+// fakeQueueTagging implements queueTaggingClient interface for testing
+type fakeQueueTagging struct {
+	tags map[string]string
+	err  error
+}
+
+func (f *fakeQueueTagging) TagQueue(ctx context.Context, input *sqs.TagQueueInput, optFns ...func(*sqs.Options)) (*sqs.TagQueueOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.tags = input.Tags
+	return &sqs.TagQueueOutput{}, nil
+}
+
+func TestParseQueueTags(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"owner:platform, source:fluentbit", map[string]string{"owner": "platform", "source": "fluentbit"}, false},
+		{"cost-center:", map[string]string{"cost-center": ""}, false},
+		{"owner", nil, true},
+		{":platform", nil, true},
+		{"owner:platform,owner:logs", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseQueueTags(tt.value)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseQueueTags(%q) = %v, %v, want %v, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestTagQueue(t *testing.T) {
+	tags := map[string]string{"owner": "platform"}
+
+	tests := []struct {
+		name    string
+		err     error
+		wantErr bool
+	}{
+		{"tagged", nil, false},
+		{"permission error is ignored", &smithy.GenericAPIError{Code: "AccessDenied", Message: "not authorized to perform sqs:TagQueue"}, false},
+		{"other errors", &smithy.GenericAPIError{Code: "InvalidParameterValue", Message: "invalid tag"}, true},
+		{"network error", errors.New("connection refused"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeQueueTagging{err: tt.err}
+			err := tagQueue(fake, "https://sqs.us-east-1.amazonaws.com/123456789/test-queue", tags)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("tagQueue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.err == nil && !reflect.DeepEqual(fake.tags, tags) {
+				t.Errorf("expected the queue to be tagged with %v, got %v", tags, fake.tags)
+			}
+		})
+	}
+}
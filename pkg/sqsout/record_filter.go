@@ -0,0 +1,79 @@
+package sqsout
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync/atomic"
+)
+
+// defaultFilterKey is the field FilterRegex/ExcludeRegex are applied to when
+// FilterKey is not set. it is the key Fluent Bit inputs store raw lines under
+const defaultFilterKey = "log"
+
+// recordFilter is a grep-like filter applied to one record field before
+// serialization, so simple filtering doesn't need an extra Fluent Bit filter
+type recordFilter struct {
+	key          string
+	regex        *regexp.Regexp
+	excludeRegex *regexp.Regexp
+	droppedCount atomic.Int64
+}
+
+// newRecordFilter returns nil when no regex is configured
+func newRecordFilter(key, filterRegex, excludeRegex string) (*recordFilter, error) {
+	if filterRegex == "" && excludeRegex == "" {
+		if key != "" {
+			return nil, errors.New("FilterKey requires FilterRegex or ExcludeRegex to be set")
+		}
+		return nil, nil
+	}
+
+	if key == "" {
+		key = defaultFilterKey
+	}
+
+	filter := &recordFilter{key: key}
+
+	var err error
+	if filterRegex != "" {
+		if filter.regex, err = regexp.Compile(filterRegex); err != nil {
+			return nil, fmt.Errorf("FilterRegex is not a valid regular expression: %v", err)
+		}
+	}
+
+	if excludeRegex != "" {
+		if filter.excludeRegex, err = regexp.Compile(excludeRegex); err != nil {
+			return nil, fmt.Errorf("ExcludeRegex is not a valid regular expression: %v", err)
+		}
+	}
+
+	return filter, nil
+}
+
+// keep reports whether the record passes the filter. records missing the
+// field never match FilterRegex and are never excluded by ExcludeRegex.
+// dropped records are counted
+func (f *recordFilter) keep(record map[interface{}]interface{}) bool {
+	value, found := recordField(record, f.key)
+
+	if f.regex != nil && (!found || !matchField(f.regex, value)) {
+		f.droppedCount.Add(1)
+		return false
+	}
+
+	if f.excludeRegex != nil && found && matchField(f.excludeRegex, value) {
+		f.droppedCount.Add(1)
+		return false
+	}
+
+	return true
+}
+
+// matchField matches byte slices in place, without converting them to strings
+func matchField(regex *regexp.Regexp, value interface{}) bool {
+	if b, ok := value.([]byte); ok {
+		return regex.Match(b)
+	}
+	return regex.MatchString(fieldString(value))
+}
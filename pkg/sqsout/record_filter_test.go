@@ -0,0 +1,126 @@
+package sqsout
+
+import (
+	"testing"
+)
+
+func TestNewRecordFilter(t *testing.T) {
+	tests := []struct {
+		name         string
+		key          string
+		filterRegex  string
+		excludeRegex string
+		wantFilter   bool
+		wantKey      string
+		wantErr      bool
+	}{
+		{"not configured", "", "", "", false, "", false},
+		{"key without regex", "message", "", "", false, "", true},
+		{"filter regex with default key", "", "error", "", true, "log", false},
+		{"exclude regex with custom key", "message", "", "debug", true, "message", false},
+		{"both regexes", "message", "error", "healthcheck", true, "message", false},
+		{"invalid filter regex", "", "(", "", false, "", true},
+		{"invalid exclude regex", "", "", "[a-", false, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := newRecordFilter(tt.key, tt.filterRegex, tt.excludeRegex)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newRecordFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (filter != nil) != tt.wantFilter {
+				t.Fatalf("newRecordFilter() filter = %v, wantFilter %v", filter, tt.wantFilter)
+			}
+			if filter != nil && filter.key != tt.wantKey {
+				t.Errorf("unexpected key: %s, want %s", filter.key, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestRecordFilterKeep(t *testing.T) {
+	tests := []struct {
+		name         string
+		key          string
+		filterRegex  string
+		excludeRegex string
+		record       map[interface{}]interface{}
+		want         bool
+	}{
+		{
+			name:        "filter regex matches",
+			filterRegex: "(?i)error",
+			record:      map[interface{}]interface{}{"log": []byte("an ERROR happened")},
+			want:        true,
+		},
+		{
+			name:        "filter regex does not match",
+			filterRegex: "error",
+			record:      map[interface{}]interface{}{"log": []byte("all good")},
+			want:        false,
+		},
+		{
+			name:        "filter regex with missing field",
+			filterRegex: ".*",
+			record:      map[interface{}]interface{}{"message": []byte("all good")},
+			want:        false,
+		},
+		{
+			name:         "exclude regex matches",
+			excludeRegex: "^GET /health",
+			record:       map[interface{}]interface{}{"log": "GET /health 200"},
+			want:         false,
+		},
+		{
+			name:         "exclude regex with missing field",
+			excludeRegex: ".*",
+			record:       map[interface{}]interface{}{"message": "GET /health 200"},
+			want:         true,
+		},
+		{
+			name:         "filter matches but excluded",
+			filterRegex:  "GET",
+			excludeRegex: "/health",
+			record:       map[interface{}]interface{}{"log": "GET /health 200"},
+			want:         false,
+		},
+		{
+			name:        "nested key",
+			key:         "kubernetes.namespace_name",
+			filterRegex: "^prod-",
+			record: map[interface{}]interface{}{
+				"kubernetes": map[interface{}]interface{}{"namespace_name": []byte("prod-web")},
+			},
+			want: true,
+		},
+		{
+			name:        "numeric field",
+			key:         "status",
+			filterRegex: "^5",
+			record:      map[interface{}]interface{}{"status": 503},
+			want:        true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := newRecordFilter(tt.key, tt.filterRegex, tt.excludeRegex)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := filter.keep(tt.record); got != tt.want {
+				t.Errorf("keep() = %v, want %v", got, tt.want)
+			}
+
+			wantDropped := int64(0)
+			if !tt.want {
+				wantDropped = 1
+			}
+			if filter.droppedCount.Load() != wantDropped {
+				t.Errorf("droppedCount = %d, want %d", filter.droppedCount.Load(), wantDropped)
+			}
+		})
+	}
+}
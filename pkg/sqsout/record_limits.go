@@ -0,0 +1,155 @@
+package sqsout
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// truncatedMarkerKey is the field set on the records truncated by
+// MaxRecordDepth or MaxRecordKeys
+const truncatedMarkerKey = "_truncated"
+
+// truncatedValue replaces the maps and arrays nested deeper than
+// MaxRecordDepth
+const truncatedValue = "[truncated]"
+
+// recordLimits guards the plugin against pathological records, nested too
+// deep or with too many keys. such records are truncated, or sent to the
+// invalid record queue with RecordLimitAction invalid
+type recordLimits struct {
+	// maxDepth counts the top level map as 1, 0 is no limit
+	maxDepth int
+	// maxKeys counts the keys of the nested maps too, 0 is no limit
+	maxKeys        int
+	invalid        bool
+	truncatedCount atomic.Int64
+}
+
+// newRecordLimits returns nil when neither MaxRecordDepth nor MaxRecordKeys
+// is set
+func newRecordLimits(maxDepthString, maxKeysString, action string, hasInvalidRecordQueue bool) (*recordLimits, error) {
+	parse := func(name, value string) (int, error) {
+		if value == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("%s should be a positive integer", name)
+		}
+		return n, nil
+	}
+
+	maxDepth, err := parse("MaxRecordDepth", maxDepthString)
+	if err != nil {
+		return nil, err
+	}
+	maxKeys, err := parse("MaxRecordKeys", maxKeysString)
+	if err != nil {
+		return nil, err
+	}
+	if maxDepth == 0 && maxKeys == 0 {
+		if action != "" {
+			return nil, errors.New("RecordLimitAction requires MaxRecordDepth or MaxRecordKeys to be set")
+		}
+		return nil, nil
+	}
+
+	limits := &recordLimits{maxDepth: maxDepth, maxKeys: maxKeys}
+	switch strings.ToLower(action) {
+	case "", "truncate":
+	case "invalid":
+		if !hasInvalidRecordQueue {
+			return nil, errors.New("RecordLimitAction invalid requires InvalidRecordQueueUrl to be set")
+		}
+		limits.invalid = true
+	default:
+		return nil, fmt.Errorf("RecordLimitAction should be one of: truncate, invalid. got %q", action)
+	}
+	return limits, nil
+}
+
+// exceeded returns which limit the record is over, empty when it is within
+// them. the walk stops at the first limit reached
+func (l *recordLimits) exceeded(record map[interface{}]interface{}) string {
+	keys := 0
+	var walk func(value interface{}, depth int) string
+	walk = func(value interface{}, depth int) string {
+		switch v := value.(type) {
+		case map[interface{}]interface{}:
+			keys += len(v)
+			if l.maxKeys > 0 && keys > l.maxKeys {
+				return fmt.Sprintf("more than %d keys", l.maxKeys)
+			}
+			if l.maxDepth > 0 && depth > l.maxDepth {
+				return fmt.Sprintf("nested deeper than %d levels", l.maxDepth)
+			}
+			for _, item := range v {
+				if reason := walk(item, depth+1); reason != "" {
+					return reason
+				}
+			}
+		case []interface{}:
+			if l.maxDepth > 0 && depth > l.maxDepth {
+				return fmt.Sprintf("nested deeper than %d levels", l.maxDepth)
+			}
+			for _, item := range v {
+				if reason := walk(item, depth+1); reason != "" {
+					return reason
+				}
+			}
+		}
+		return ""
+	}
+	return walk(record, 1)
+}
+
+// truncate replaces the maps and arrays nested too deep and removes the keys
+// over the limit, in key order so the same record is always truncated the
+// same way, and marks the record as truncated
+func (l *recordLimits) truncate(tag string, record map[interface{}]interface{}) {
+	keys := 0
+	var truncate func(value interface{}, depth int) interface{}
+	truncate = func(value interface{}, depth int) interface{} {
+		switch v := value.(type) {
+		case map[interface{}]interface{}:
+			if l.maxDepth > 0 && depth > l.maxDepth {
+				return truncatedValue
+			}
+			for _, key := range sortedKeys(v) {
+				if l.maxKeys > 0 && keys >= l.maxKeys {
+					delete(v, key)
+					continue
+				}
+				keys++
+				v[key] = truncate(v[key], depth+1)
+			}
+		case []interface{}:
+			if l.maxDepth > 0 && depth > l.maxDepth {
+				return truncatedValue
+			}
+			for i := range v {
+				v[i] = truncate(v[i], depth+1)
+			}
+		}
+		return value
+	}
+	truncate(record, 1)
+	record[truncatedMarkerKey] = true
+
+	truncated := l.truncatedCount.Add(1)
+	writeWarnLog(fmt.Sprintf("truncated record with tag %s over MaxRecordDepth/MaxRecordKeys. total truncated: %d", tag, truncated))
+}
+
+// sortedKeys returns the keys of a map in their text order
+func sortedKeys(m map[interface{}]interface{}) []interface{} {
+	keys := make([]interface{}, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+	return keys
+}
@@ -0,0 +1,118 @@
+package sqsout
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewRecordLimits(t *testing.T) {
+	tests := []struct {
+		depth, keys, action string
+		invalidQueue        bool
+		wantNil             bool
+		wantErr             bool
+	}{
+		{"", "", "", false, true, false},
+		{"", "", "truncate", false, true, true},
+		{"5", "", "", false, false, false},
+		{"", "100", "Truncate", false, false, false},
+		{"5", "100", "invalid", true, false, false},
+		{"5", "", "invalid", false, true, true},
+		{"0", "", "", false, true, true},
+		{"", "many", "", false, true, true},
+		{"5", "", "drop", false, true, true},
+	}
+
+	for _, tt := range tests {
+		got, err := newRecordLimits(tt.depth, tt.keys, tt.action, tt.invalidQueue)
+		if (err != nil) != tt.wantErr || (got == nil) != tt.wantNil {
+			t.Errorf("newRecordLimits(%q, %q, %q, %v) = %v, %v, wantNil %v, wantErr %v", tt.depth, tt.keys, tt.action, tt.invalidQueue, got, err, tt.wantNil, tt.wantErr)
+		}
+	}
+}
+
+// nestedRecord returns a record with maps nested depth levels deep
+func nestedRecord(depth int) map[interface{}]interface{} {
+	record := map[interface{}]interface{}{"log": "leaf"}
+	for i := 1; i < depth; i++ {
+		record = map[interface{}]interface{}{"nested": record}
+	}
+	return record
+}
+
+func TestRecordLimitsExceeded(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxDepth int
+		maxKeys  int
+		record   map[interface{}]interface{}
+		want     string
+	}{
+		{"within the depth", 3, 0, nestedRecord(3), ""},
+		{"too deep", 3, 0, nestedRecord(4), "nested deeper than 3 levels"},
+		{"too deep in an array", 2, 0, map[interface{}]interface{}{"items": []interface{}{[]interface{}{1}}}, "nested deeper than 2 levels"},
+		{"within the keys", 0, 3, map[interface{}]interface{}{"a": 1, "b": map[interface{}]interface{}{"c": 2}}, ""},
+		{"too many nested keys", 0, 3, map[interface{}]interface{}{"a": 1, "b": map[interface{}]interface{}{"c": 2, "d": 3}}, "more than 3 keys"},
+	}
+
+	for _, tt := range tests {
+		limits := &recordLimits{maxDepth: tt.maxDepth, maxKeys: tt.maxKeys}
+		if got := limits.exceeded(tt.record); got != tt.want {
+			t.Errorf("%s: exceeded() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRecordLimitsTruncate(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxDepth int
+		maxKeys  int
+		record   map[interface{}]interface{}
+		want     map[interface{}]interface{}
+	}{
+		{
+			"too deep",
+			2, 0,
+			map[interface{}]interface{}{"log": "hello", "a": map[interface{}]interface{}{"b": map[interface{}]interface{}{"c": 1}, "list": []interface{}{1}}},
+			map[interface{}]interface{}{"log": "hello", "a": map[interface{}]interface{}{"b": "[truncated]", "list": "[truncated]"}, "_truncated": true},
+		},
+		{
+			"too many keys, in key order",
+			0, 3,
+			map[interface{}]interface{}{"d": 4, "a": 1, "c": map[interface{}]interface{}{"y": 2, "x": 1}, "b": 2},
+			map[interface{}]interface{}{"a": 1, "b": 2, "c": map[interface{}]interface{}{}, "_truncated": true},
+		},
+	}
+
+	for _, tt := range tests {
+		limits := &recordLimits{maxDepth: tt.maxDepth, maxKeys: tt.maxKeys}
+		limits.truncate("app.log", tt.record)
+		if !reflect.DeepEqual(tt.record, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.record, tt.want)
+		}
+		if limits.exceeded(tt.record) != "" && tt.maxKeys == 0 {
+			t.Errorf("%s: expected the truncated record to be within the limits", tt.name)
+		}
+	}
+}
+
+func TestPrepareRecordLimits(t *testing.T) {
+	resetGlobals()
+
+	truncate, _ := newRecordLimits("2", "", "", false)
+	sqsConf := &sqsConfig{recordLimits: truncate}
+	prepared := prepareRecord(sqsConf, "app.log", time.Now(), nestedRecord(3))
+	if prepared == nil || !strings.Contains(prepared.body, `"nested":{"nested":"[truncated]"}`) || !strings.Contains(prepared.body, `"_truncated":true`) {
+		t.Errorf("expected a truncated record, got %+v", prepared)
+	}
+
+	invalid, _ := newRecordLimits("2", "", "invalid", true)
+	sqsConf = &sqsConfig{recordLimits: invalid}
+	prepared = prepareRecord(sqsConf, "app.log", time.Now(), nestedRecord(3))
+	if prepared == nil || prepared.invalidReason != "record has nested deeper than 2 levels" {
+		t.Errorf("expected a record for the invalid record queue, got %+v", prepared)
+	}
+}
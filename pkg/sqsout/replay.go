@@ -0,0 +1,108 @@
+package sqsout
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// replayFiles lists the files a replay reads: the file itself, or the dump
+// files of a directory, oldest first
+func replayFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(path, debugDumpPrefix+"*"+debugDumpSuffix))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no %s*%s files in %s", debugDumpPrefix, debugDumpSuffix, path)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// rateLimiter spaces the replayed messages so no more than the given rate is
+// sent per second. a nil limiter doesn't wait
+type rateLimiter struct {
+	interval time.Duration
+	next     time.Time
+	now      func() time.Time
+	sleep    func(time.Duration)
+}
+
+// newRateLimiter returns nil for a rate of 0, which is unlimited
+func newRateLimiter(perSecond float64) (*rateLimiter, error) {
+	if perSecond < 0 {
+		return nil, errors.New("the rate should be a positive number of messages per second")
+	}
+	if perSecond == 0 {
+		return nil, nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond), now: time.Now, sleep: time.Sleep}, nil
+}
+
+func (l *rateLimiter) wait() {
+	if l == nil {
+		return
+	}
+	now := l.now()
+	if l.next.After(now) {
+		l.sleep(l.next.Sub(now))
+		now = l.next
+	}
+	l.next = now.Add(l.interval)
+}
+
+// replayMessages re-sends the message bodies of the files, one body per line
+// like DebugDumpDir writes them, through the batching of the tag. the bodies
+// are sent as they are, they were already formatted when they were captured.
+// it returns the number of lines which could not be replayed
+func replayMessages(sqsConf *sqsConfig, tag string, files []string, limiter *rateLimiter) (int, error) {
+	invalid := 0
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return invalid, fmt.Errorf("failed to open the replay file: %v", err)
+		}
+
+		scanner := bufio.NewScanner(f)
+		// a line is the body and its new line
+		scanner.Buffer(make([]byte, 0, 64*1024), maxMessageBytes+1)
+		line := 0
+		replayed := 0
+		for scanner.Scan() {
+			line++
+			body := scanner.Text()
+			if body == "" {
+				continue
+			}
+
+			limiter.wait()
+			sqsConf.stats.recordsIn.Add(1)
+			if err := addPreparedRecord(sqsConf, tag, &preparedRecord{timestamp: time.Now(), body: body}); err != nil {
+				f.Close()
+				return invalid, err
+			}
+			replayed++
+		}
+
+		if err := scanner.Err(); err != nil {
+			writeErrorLog(fmt.Errorf("failed to read %s after line %d: %v", file, line, err))
+			invalid++
+		}
+		f.Close()
+		writeInfoLog(fmt.Sprintf("replayed %d messages of %s", replayed, file))
+	}
+	return invalid, nil
+}
@@ -0,0 +1,119 @@
+package sqsout
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplayFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"batch-20240102T000000.000000000Z-q-2.ndjson", "batch-20240101T000000.000000000Z-q-1.ndjson", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("{}\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := replayFiles(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{
+		filepath.Join(dir, "batch-20240101T000000.000000000Z-q-1.ndjson"),
+		filepath.Join(dir, "batch-20240102T000000.000000000Z-q-2.ndjson"),
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("replayFiles(dir) = %v, want %v", files, want)
+	}
+
+	single := filepath.Join(dir, "notes.txt")
+	if files, err := replayFiles(single); err != nil || !reflect.DeepEqual(files, []string{single}) {
+		t.Errorf("replayFiles(file) = %v, %v", files, err)
+	}
+
+	for _, path := range []string{t.TempDir(), filepath.Join(dir, "missing")} {
+		if _, err := replayFiles(path); err == nil {
+			t.Errorf("replayFiles(%q) expected an error", path)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	if _, err := newRateLimiter(-1); err == nil {
+		t.Error("expected an error for a negative rate")
+	}
+	if limiter, _ := newRateLimiter(0); limiter != nil {
+		t.Error("expected no limiter for a rate of 0")
+	}
+
+	now := time.Unix(0, 0)
+	var slept []time.Duration
+	limiter, _ := newRateLimiter(4)
+	limiter.now = func() time.Time { return now }
+	limiter.sleep = func(d time.Duration) { slept = append(slept, d); now = now.Add(d) }
+
+	limiter.wait()
+	limiter.wait()
+	now = now.Add(100 * time.Millisecond)
+	limiter.wait()
+	// a pause longer than the interval doesn't allow a burst afterwards
+	now = now.Add(time.Second)
+	limiter.wait()
+	limiter.wait()
+
+	want := []time.Duration{250 * time.Millisecond, 150 * time.Millisecond, 250 * time.Millisecond}
+	if !reflect.DeepEqual(slept, want) {
+		t.Errorf("slept %v, want %v", slept, want)
+	}
+}
+
+func TestRunStandaloneReplay(t *testing.T) {
+	resetGlobals()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	fake := &fakeSQSEndpoint{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"batch-20240101T000000.000000000Z-q-1.ndjson": "{\"log\":\"first\"}\n{\"log\":\"second\"}\n",
+		"batch-20240101T000001.000000000Z-q-2.ndjson": "\nnot json, sent as it is\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	args := []string{
+		"-p", "QueueUrl=" + server.URL + "/123456789/test-queue",
+		"-p", "QueueRegion=us-east-1",
+		"-p", "Endpoint=" + server.URL,
+		"-p", "BatchSize=2",
+		"-replay", dir,
+		"-rate", "1000",
+	}
+
+	var code int
+	captureStdout(func() { code = RunStandalone(args, strings.NewReader("{\"log\":\"stdin is not read\"}\n")) })
+
+	if code != 0 {
+		t.Errorf("expected exit code 0, got %d", code)
+	}
+	want := []string{`{"log":"first"}`, `{"log":"second"}`, "not json, sent as it is"}
+	if !reflect.DeepEqual(fake.bodies, want) {
+		t.Errorf("replayed %q, want %q", fake.bodies, want)
+	}
+
+	for _, invalid := range [][]string{{"-replay", filepath.Join(dir, "missing")}, {"-rate", "-1"}} {
+		captureStdout(func() { code = RunStandalone(invalid, strings.NewReader("")) })
+		if code != 2 {
+			t.Errorf("RunStandalone(%v) expected exit code 2, got %d", invalid, code)
+		}
+	}
+}
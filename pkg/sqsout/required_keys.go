@@ -0,0 +1,47 @@
+package sqsout
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// requiredKeys drops records lacking any of the configured fields, or routes
+// them to InvalidRecordQueueUrl, preventing malformed events from reaching
+// strict downstream consumers
+type requiredKeys struct {
+	keys          []string
+	rejectedCount atomic.Int64
+}
+
+// newRequiredKeys returns nil when RequireKeys is not configured
+func newRequiredKeys(value string) *requiredKeys {
+	keys := splitConfigList(value)
+	if len(keys) == 0 {
+		return nil
+	}
+	return &requiredKeys{keys: keys}
+}
+
+// missing returns the required keys which are absent (or null) in the record
+func (r *requiredKeys) missing(record map[interface{}]interface{}) []string {
+	var missing []string
+	for _, key := range r.keys {
+		if value, ok := recordField(record, key); !ok || value == nil {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+// reject counts a record missing required keys and reports it with a
+// warning. invalidQueueURL is the queue the record is routed to, empty when
+// it is dropped
+func (r *requiredKeys) reject(tag string, missing []string, invalidQueueURL string) {
+	rejected := r.rejectedCount.Add(1)
+	if invalidQueueURL != "" {
+		writeWarnLog(fmt.Sprintf("routing record with tag %s missing required keys: %s to the invalid record queue %s. total records missing keys: %d", tag, strings.Join(missing, ","), queueName(invalidQueueURL), rejected))
+		return
+	}
+	writeWarnLog(fmt.Sprintf("dropping record with tag %s missing required keys: %s. total records missing keys: %d", tag, strings.Join(missing, ","), rejected))
+}
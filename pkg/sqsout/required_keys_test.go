@@ -0,0 +1,92 @@
+package sqsout
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewRequiredKeys(t *testing.T) {
+	if newRequiredKeys("") != nil {
+		t.Error("expected nil when RequireKeys is not configured")
+	}
+
+	required := newRequiredKeys("message, service")
+	if required == nil || len(required.keys) != 2 || required.keys[1] != "service" {
+		t.Errorf("unexpected required keys: %v", required)
+	}
+}
+
+func TestRequiredKeysMissing(t *testing.T) {
+	tests := []struct {
+		name        string
+		keys        string
+		record      map[interface{}]interface{}
+		wantMissing string
+	}{
+		{
+			name:   "all keys present",
+			keys:   "message,service",
+			record: map[interface{}]interface{}{"message": []byte("hello"), "service": "web"},
+		},
+		{
+			name:        "one key missing",
+			keys:        "message,service",
+			record:      map[interface{}]interface{}{"message": []byte("hello")},
+			wantMissing: "service",
+		},
+		{
+			name:        "null value counts as missing",
+			keys:        "message,service",
+			record:      map[interface{}]interface{}{"message": nil, "service": "web"},
+			wantMissing: "message",
+		},
+		{
+			name:        "all keys missing",
+			keys:        "message,service",
+			record:      map[interface{}]interface{}{"log": "hello"},
+			wantMissing: "message,service",
+		},
+		{
+			name: "nested key",
+			keys: "kubernetes.pod_name",
+			record: map[interface{}]interface{}{
+				"kubernetes": map[interface{}]interface{}{"pod_name": "web-1"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			required := newRequiredKeys(tt.keys)
+
+			missing := strings.Join(required.missing(tt.record), ",")
+			if missing != tt.wantMissing {
+				t.Errorf("missing() = %q, want %q", missing, tt.wantMissing)
+			}
+		})
+	}
+}
+
+func TestRequiredKeysReject(t *testing.T) {
+	resetGlobals()
+	required := newRequiredKeys("message,service")
+
+	output := captureStdout(func() {
+		required.reject("app.log", []string{"service"}, "")
+		required.reject("app.log", []string{"message", "service"}, "")
+	})
+
+	if required.rejectedCount.Load() != 2 {
+		t.Errorf("rejectedCount = %d, want 2", required.rejectedCount.Load())
+	}
+	if !strings.Contains(output, "warn") || !strings.Contains(output, "dropping record") || !strings.Contains(output, "message,service") {
+		t.Errorf("expected warning about missing keys, got: %s", output)
+	}
+
+	routed := captureStdout(func() {
+		required.reject("app.log", []string{"service"}, "https://sqs.us-east-1.amazonaws.com/123456789/invalid-records")
+	})
+	if strings.Contains(routed, "dropping") || !strings.Contains(routed, "to the invalid record queue invalid-records") {
+		t.Errorf("expected the routed record not to be reported as dropped, got: %s", routed)
+	}
+}
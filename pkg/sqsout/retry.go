@@ -0,0 +1,280 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// defaultSendTimeout bounds every SendMessageBatch call when SendTimeout is
+// not set, the retries of the sdk included
+const defaultSendTimeout = 30 * time.Second
+
+// defaultRetryBackoff is the wait before the first retry of a batch, it
+// doubles with every further attempt
+const defaultRetryBackoff = 100 * time.Millisecond
+
+// retryPolicy is the retry loop of a destination. every queue a batch goes to
+// runs its own loop, so the retries of one destination don't hold back the
+// others. the zero value sends once
+type retryPolicy struct {
+	retries int
+	backoff time.Duration
+	// multiplier grows the backoff after every attempt, 0 doubles it
+	multiplier float64
+	// maxBackoff caps the backoff, 0 for no cap
+	maxBackoff time.Duration
+	// classes tells the retryable failures from the terminal ones
+	classes *errorClassifier
+	// timeout is the deadline of every call, 0 for none
+	timeout time.Duration
+	// retried counts the messages sent again, when not nil
+	retried *atomic.Int64
+}
+
+func newRetryPolicy(sendRetriesString string) (retryPolicy, error) {
+	if sendRetriesString == "" {
+		return retryPolicy{}, nil
+	}
+
+	retries, err := strconv.Atoi(sendRetriesString)
+	if err != nil || retries < 0 || retries > 10 {
+		return retryPolicy{}, errors.New("SendRetries should be integer value between 0 and 10")
+	}
+
+	return retryPolicy{retries: retries, backoff: defaultRetryBackoff}, nil
+}
+
+// parseBackoffMultiplier parses BackoffMultiplier, the growth of the backoff
+// between the retries, 1 retries at a constant pace
+func parseBackoffMultiplier(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	multiplier, err := strconv.ParseFloat(value, 64)
+	if err != nil || multiplier < 1 || multiplier > 10 {
+		return 0, errors.New("BackoffMultiplier should be a number between 1 and 10")
+	}
+	return multiplier, nil
+}
+
+// parseBackoffMaxSeconds parses BackoffMaxSeconds, the cap of the backoff
+// between the retries
+func parseBackoffMaxSeconds(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds <= 0 {
+		return 0, errors.New("BackoffMaxSeconds should be a positive number of seconds")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// delay is the backoff after the attempt, counted from 0
+func (r retryPolicy) delay(attempt int) time.Duration {
+	multiplier := r.multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+	delay := float64(r.backoff) * math.Pow(multiplier, float64(attempt))
+	if r.maxBackoff > 0 && delay > float64(r.maxBackoff) {
+		return r.maxBackoff
+	}
+	return time.Duration(delay)
+}
+
+// validateDeferToEngine checks the keys DeferToEngineRetries can't be used
+// with, they retry the batches inside the plugin
+func validateDeferToEngine(deferToEngine bool, retry retryPolicy, bufferPath string) error {
+	if !deferToEngine {
+		return nil
+	}
+	if retry.retries > 0 {
+		return errors.New("SendRetries can't be used with DeferToEngineRetries, fluent bit retries the chunks")
+	}
+	if bufferPath != "" {
+		return errors.New("BufferPath can't be used with DeferToEngineRetries, the disk buffer retries the failed batches itself")
+	}
+	return nil
+}
+
+// describeRetries explains who retries a failed batch, for the operators
+// setting the Retry_Limit of the output
+func describeRetries(deferToEngine bool, retry retryPolicy, mode aws.RetryMode) string {
+	if deferToEngine {
+		return "every batch is sent once without retries, a failed chunk is handed back to fluent bit (FLB_RETRY) and Retry_Limit bounds the attempts"
+	}
+	return fmt.Sprintf("every batch is sent up to %d times, each request retried by the aws sdk in %s mode. a failed chunk is not retried by fluent bit (FLB_ERROR), Retry_Limit doesn't apply", retry.retries+1, mode)
+}
+
+// parseSendTimeout parses SendTimeout, the deadline of a SendMessageBatch
+// call. a hung connection then fails the call instead of the flush waiting
+// for it forever
+func parseSendTimeout(value string) (time.Duration, error) {
+	switch strings.ToLower(value) {
+	case "":
+		return defaultSendTimeout, nil
+	case "off":
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, errors.New("SendTimeout should be a positive duration, e.g. 30s, or off")
+	}
+	return timeout, nil
+}
+
+// parseRetryMode parses RetryMode, the retry mode of the requests of the aws
+// sdk. the sdk retries below SendRetries, which resends the failed entries
+func parseRetryMode(value string) (aws.RetryMode, error) {
+	if value == "" {
+		return aws.RetryModeStandard, nil
+	}
+
+	mode, err := aws.ParseRetryMode(strings.ToLower(value))
+	if err != nil {
+		return "", fmt.Errorf("RetryMode should be standard or adaptive. got %q", value)
+	}
+	return mode, nil
+}
+
+// sendBatch sends the entries to the sink. failed entries, or the whole
+// batch when the request fails, are sent again until they succeed or the
+// retries are exhausted or ctx is done. the terminal failures are not sent
+// again. the returned output covers every attempt
+func (r retryPolicy) sendBatch(ctx context.Context, sink Sink, queueURL string, entries []*types.SendMessageBatchRequestEntry) (*sqs.SendMessageBatchOutput, error) {
+	result := &sqs.SendMessageBatchOutput{}
+	pending := entries
+
+	for attempt := 0; ; attempt++ {
+		output, err := r.call(ctx, sink, queueURL, pending)
+		if err != nil && (ctx.Err() != nil || !r.classes.retryableError(err)) {
+			// the caller gave up or the failure is terminal, there is no
+			// point in retrying
+			attempt = r.retries
+		}
+
+		if err == nil {
+			result.Successful = append(result.Successful, output.Successful...)
+			retry, terminal := r.classes.split(output.Failed)
+			result.Failed = append(result.Failed, terminal...)
+			if len(retry) == 0 || attempt >= r.retries {
+				result.Failed = append(result.Failed, retry...)
+				return result, nil
+			}
+			pending = failedEntries(pending, retry)
+		} else if attempt >= r.retries {
+			if len(result.Successful) == 0 {
+				return nil, err
+			}
+			// part of the batch went through on an earlier attempt
+			result.Failed = append(result.Failed, requestFailures(pending, err)...)
+			return result, nil
+		}
+
+		if r.retried != nil {
+			r.retried.Add(int64(len(pending)))
+		}
+		writeDebugLog(fmt.Sprintf("retrying %d messages to %s (attempt %d of %d)", len(pending), queueURL, attempt+2, r.retries+1))
+		timer := time.NewTimer(r.delay(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+}
+
+// call sends the entries once, within the timeout of the policy
+func (r retryPolicy) call(ctx context.Context, sink Sink, queueURL string, entries []*types.SendMessageBatchRequestEntry) (*sqs.SendMessageBatchOutput, error) {
+	if err := pacerOf(queueURL).wait(ctx); err != nil {
+		return nil, err
+	}
+
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	accepted, failed, err := sink.SendBatch(ctx, entries)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && r.timeout > 0 {
+			return nil, fmt.Errorf("no response from %s within the SendTimeout of %s: %w", queueName(queueURL), r.timeout, err)
+		}
+		return nil, err
+	}
+	return &sqs.SendMessageBatchOutput{Successful: accepted, Failed: failed}, nil
+}
+
+// batchEntries copies the entries into the request, the sdk takes them by
+// value while the plugin batches pointers to pooled entries
+func batchEntries(entries []*types.SendMessageBatchRequestEntry) []types.SendMessageBatchRequestEntry {
+	batch := make([]types.SendMessageBatchRequestEntry, len(entries))
+	for i, entry := range entries {
+		batch[i] = *entry
+	}
+	return batch
+}
+
+// failedEntries returns the entries reported as failed
+func failedEntries(entries []*types.SendMessageBatchRequestEntry, failed []types.BatchResultErrorEntry) []*types.SendMessageBatchRequestEntry {
+	failedIDs := make(map[string]bool, len(failed))
+	for _, entry := range failed {
+		if entry.Id != nil {
+			failedIDs[*entry.Id] = true
+		}
+	}
+
+	retry := make([]*types.SendMessageBatchRequestEntry, 0, len(failed))
+	for _, entry := range entries {
+		if entry.Id != nil && failedIDs[*entry.Id] {
+			retry = append(retry, entry)
+		}
+	}
+	return retry
+}
+
+// requestFailures reports every entry of a failed request as a failed entry
+func requestFailures(entries []*types.SendMessageBatchRequestEntry, err error) []types.BatchResultErrorEntry {
+	failed := make([]types.BatchResultErrorEntry, len(entries))
+	for i, entry := range entries {
+		failed[i] = types.BatchResultErrorEntry{
+			Id:          entry.Id,
+			Code:        aws.String("RequestError"),
+			Message:     aws.String(err.Error()),
+			SenderFault: false,
+		}
+	}
+	return failed
+}
+
+// dispatch runs the sends to the different destinations concurrently and
+// waits for all of them
+func dispatch(sends ...func()) {
+	if len(sends) == 1 {
+		sends[0]()
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(sends))
+	for _, send := range sends {
+		go func(send func()) {
+			defer wg.Done()
+			send()
+		}(send)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,422 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/ugorji/go/codec"
+)
+
+// scriptedSQS implements sqsClient interface and answers every call with the
+// next scripted response. entries listed in fail are reported as failed
+type scriptedSQS struct {
+	mu        sync.Mutex
+	responses []scriptedResponse
+	calls     [][]string
+}
+
+type scriptedResponse struct {
+	fail []string
+	err  error
+}
+
+func (f *scriptedSQS) SendMessageBatch(ctx context.Context, input *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var ids []string
+	for _, entry := range input.Entries {
+		ids = append(ids, *entry.Id)
+	}
+	f.calls = append(f.calls, ids)
+
+	var response scriptedResponse
+	if len(f.responses) > 0 {
+		response = f.responses[0]
+		f.responses = f.responses[1:]
+	}
+	if response.err != nil {
+		return nil, response.err
+	}
+
+	failed := map[string]bool{}
+	for _, id := range response.fail {
+		failed[id] = true
+	}
+
+	output := &sqs.SendMessageBatchOutput{}
+	for _, id := range ids {
+		if failed[id] {
+			output.Failed = append(output.Failed, types.BatchResultErrorEntry{Id: aws.String(id), Code: aws.String("InternalError")})
+		} else {
+			output.Successful = append(output.Successful, types.SendMessageBatchResultEntry{Id: aws.String(id)})
+		}
+	}
+	return output, nil
+}
+
+func TestNewRetryPolicy(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"3", 3, false},
+		{"10", 10, false},
+		{"11", 0, true},
+		{"-1", 0, true},
+		{"forever", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := newRetryPolicy(tt.input)
+		if (err != nil) != tt.wantErr || got.retries != tt.want {
+			t.Errorf("newRetryPolicy(%q) = %d, %v, want %d, wantErr %v", tt.input, got.retries, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestRetryPolicySendBatch(t *testing.T) {
+	queueURL := "https://sqs.us-east-1.amazonaws.com/123456789/test-queue"
+	retry := retryPolicy{retries: 2, backoff: time.Millisecond}
+
+	tests := []struct {
+		name          string
+		retry         retryPolicy
+		responses     []scriptedResponse
+		wantCalls     [][]string
+		wantErr       bool
+		wantSucceeded int
+		wantFailed    int
+	}{
+		{
+			name:          "sends once without retries",
+			responses:     []scriptedResponse{{fail: []string{"a"}}},
+			wantCalls:     [][]string{{"a", "b", "c"}},
+			wantSucceeded: 2,
+			wantFailed:    1,
+		},
+		{
+			name:          "resends only the failed entries",
+			retry:         retry,
+			responses:     []scriptedResponse{{fail: []string{"a", "c"}}, {fail: []string{"c"}}},
+			wantCalls:     [][]string{{"a", "b", "c"}, {"a", "c"}, {"c"}},
+			wantSucceeded: 3,
+		},
+		{
+			name:          "resends the batch after a request error",
+			retry:         retry,
+			responses:     []scriptedResponse{{err: errors.New("timeout")}},
+			wantCalls:     [][]string{{"a", "b", "c"}, {"a", "b", "c"}},
+			wantSucceeded: 3,
+		},
+		{
+			name:      "returns the error once retries are exhausted",
+			retry:     retry,
+			responses: []scriptedResponse{{err: errors.New("timeout")}, {err: errors.New("timeout")}, {err: errors.New("timeout")}},
+			wantCalls: [][]string{{"a", "b", "c"}, {"a", "b", "c"}, {"a", "b", "c"}},
+			wantErr:   true,
+		},
+		{
+			name:          "keeps earlier successes when the last attempt fails",
+			retry:         retry,
+			responses:     []scriptedResponse{{fail: []string{"b"}}, {fail: []string{"b"}}, {err: errors.New("timeout")}},
+			wantCalls:     [][]string{{"a", "b", "c"}, {"b"}, {"b"}},
+			wantSucceeded: 2,
+			wantFailed:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &scriptedSQS{responses: tt.responses}
+			entries := []*types.SendMessageBatchRequestEntry{
+				{Id: aws.String("a"), MessageBody: aws.String("1")},
+				{Id: aws.String("b"), MessageBody: aws.String("2")},
+				{Id: aws.String("c"), MessageBody: aws.String("3")},
+			}
+
+			output, err := tt.retry.sendBatch(context.Background(), sqsSink{client: fake, queueURL: queueURL}, queueURL, entries)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sendBatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if len(fake.calls) != len(tt.wantCalls) {
+				t.Fatalf("expected calls %v, got %v", tt.wantCalls, fake.calls)
+			}
+			for i := range tt.wantCalls {
+				if len(fake.calls[i]) != len(tt.wantCalls[i]) {
+					t.Errorf("call %d: expected entries %v, got %v", i, tt.wantCalls[i], fake.calls[i])
+				}
+			}
+
+			if tt.wantErr {
+				return
+			}
+			if len(output.Successful) != tt.wantSucceeded || len(output.Failed) != tt.wantFailed {
+				t.Errorf("expected %d successful and %d failed, got %d and %d", tt.wantSucceeded, tt.wantFailed, len(output.Successful), len(output.Failed))
+			}
+		})
+	}
+}
+
+// blockingSQS implements sqsClient interface and blocks the primary queue
+// until the side queue got its batch
+type blockingSQS struct {
+	primaryURL string
+	sideSent   chan struct{}
+}
+
+func (f *blockingSQS) SendMessageBatch(ctx context.Context, input *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	if *input.QueueUrl == f.primaryURL {
+		select {
+		case <-f.sideSent:
+		case <-time.After(time.Second):
+			return nil, errors.New("side queue batch was not sent concurrently")
+		}
+	} else {
+		close(f.sideSent)
+	}
+	return &sqs.SendMessageBatchOutput{}, nil
+}
+
+func TestParallelDelivery(t *testing.T) {
+	resetGlobals()
+	queueURL := "https://sqs.us-east-1.amazonaws.com/123456789/test-queue"
+	fake := &blockingSQS{primaryURL: queueURL, sideSent: make(chan struct{})}
+	sqsConf := &sqsConfig{
+		queueURL:  queueURL,
+		mySQS:     fake,
+		sideSQS:   fake,
+		batchSize: 2,
+		shadow:    newShadowRoute("https://sqs.us-east-1.amazonaws.com/123456789/shadow-queue", 100),
+	}
+
+	timestamp := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	if err := flushRecords(sqsConf, "app.log", sliceIterator(timestamp, messageRecords(2)...)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestParseRetryMode(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    aws.RetryMode
+		wantErr bool
+	}{
+		{"", aws.RetryModeStandard, false},
+		{"standard", aws.RetryModeStandard, false},
+		{"Adaptive", aws.RetryModeAdaptive, false},
+		{"legacy", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseRetryMode(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseRetryMode(%q) = %q, %v, want %q, wantErr %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseSendTimeout(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", defaultSendTimeout, false},
+		{"off", 0, false},
+		{"5s", 5 * time.Second, false},
+		{"0s", 0, true},
+		{"soon", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSendTimeout(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseSendTimeout(%q) = %s, %v, want %s, wantErr %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// hungSQS implements sqsClient interface and never answers, like a network
+// path dropping the packets, until the context of the call is done
+type hungSQS struct {
+	calls atomic.Int64
+}
+
+func (f *hungSQS) SendMessageBatch(ctx context.Context, input *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	f.calls.Add(1)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestRetryPolicySendTimeout(t *testing.T) {
+	queueURL := "https://sqs.us-east-1.amazonaws.com/123456789/test-queue"
+	entries := []*types.SendMessageBatchRequestEntry{{Id: aws.String("msg-1"), MessageBody: aws.String("a")}}
+
+	t.Run("every call has a deadline", func(t *testing.T) {
+		fake := &hungSQS{}
+		retry := retryPolicy{retries: 1, backoff: time.Millisecond, timeout: 20 * time.Millisecond}
+
+		_, err := retry.sendBatch(context.Background(), sqsSink{client: fake, queueURL: queueURL}, queueURL, entries)
+		if err == nil || !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "SendTimeout of 20ms") {
+			t.Errorf("expected a SendTimeout error, got %v", err)
+		}
+		if calls := fake.calls.Load(); calls != 2 {
+			t.Errorf("expected the timed out call to be retried once, got %d calls", calls)
+		}
+	})
+
+	t.Run("a done context stops the retries", func(t *testing.T) {
+		fake := &hungSQS{}
+		retry := retryPolicy{retries: 5, backoff: time.Hour}
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		if _, err := retry.sendBatch(ctx, sqsSink{client: fake, queueURL: queueURL}, queueURL, entries); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected the context error, got %v", err)
+		}
+		if calls := fake.calls.Load(); calls != 1 || time.Since(start) > time.Second {
+			t.Errorf("expected a single call without backoff, got %d calls in %s", calls, time.Since(start))
+		}
+	})
+}
+
+func TestValidateDeferToEngine(t *testing.T) {
+	tests := []struct {
+		name       string
+		deferred   bool
+		retries    int
+		bufferPath string
+		wantErr    bool
+	}{
+		{"off", false, 3, "/var/lib/sqs", false},
+		{"on", true, 0, "", false},
+		{"with SendRetries", true, 2, "", true},
+		{"with BufferPath", true, 0, "/var/lib/sqs", true},
+	}
+
+	for _, tt := range tests {
+		err := validateDeferToEngine(tt.deferred, retryPolicy{retries: tt.retries}, tt.bufferPath)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateDeferToEngine() = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDeferToEngineRetries(t *testing.T) {
+	handle := new(codec.MsgpackHandle)
+	handle.WriteExt = true
+	var chunk []byte
+	enc := codec.NewEncoderBytes(&chunk, handle)
+	for _, log := range []string{"first", "second"} {
+		if err := enc.Encode([]interface{}{uint64(1705314600), map[string]interface{}{"log": log}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		deferred    bool
+		response    scriptedResponse
+		want        FlushStatus
+		wantRetried int64
+	}{
+		{"request error", false, scriptedResponse{err: errors.New("service unavailable")}, FlushError, 0},
+		{"request error deferred", true, scriptedResponse{err: errors.New("service unavailable")}, FlushRetry, 1},
+		{"failed message", false, scriptedResponse{fail: []string{"MessageNumber-1"}}, FlushOK, 0},
+		{"failed message deferred", true, scriptedResponse{fail: []string{"MessageNumber-1"}}, FlushRetry, 1},
+	}
+
+	for _, tt := range tests {
+		resetGlobals()
+		sqsConf := &sqsConfig{
+			queueURL:      "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+			mySQS:         &scriptedSQS{responses: []scriptedResponse{tt.response}},
+			batchSize:     2,
+			deferToEngine: tt.deferred,
+		}
+
+		var status FlushStatus
+		captureStdout(func() {
+			status = flushChunk(sqsConf, "app.log", chunk)
+		})
+		if status != tt.want || sqsConf.stats.chunksRetried.Load() != tt.wantRetried {
+			t.Errorf("%s: flushChunk() = %d with %d chunks retried, want %d and %d", tt.name, status, sqsConf.stats.chunksRetried.Load(), tt.want, tt.wantRetried)
+		}
+	}
+}
+
+func TestParseBackoff(t *testing.T) {
+	multipliers := []struct {
+		value   string
+		want    float64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"1", 1, false},
+		{"1.5", 1.5, false},
+		{"0.5", 0, true},
+		{"11", 0, true},
+		{"fast", 0, true},
+	}
+	for _, tt := range multipliers {
+		got, err := parseBackoffMultiplier(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseBackoffMultiplier(%q) = %v, %v, want %v, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	maxSeconds := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"30", 30 * time.Second, false},
+		{"0.5", 500 * time.Millisecond, false},
+		{"0", 0, true},
+		{"long", 0, true},
+	}
+	for _, tt := range maxSeconds {
+		got, err := parseBackoffMaxSeconds(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseBackoffMaxSeconds(%q) = %v, %v, want %v, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy retryPolicy
+		want   []time.Duration
+	}{
+		{"doubling by default", retryPolicy{backoff: 100 * time.Millisecond}, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond}},
+		{"constant", retryPolicy{backoff: 100 * time.Millisecond, multiplier: 1}, []time.Duration{100 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond}},
+		{"capped", retryPolicy{backoff: 100 * time.Millisecond, multiplier: 3, maxBackoff: time.Second}, []time.Duration{100 * time.Millisecond, 300 * time.Millisecond, 900 * time.Millisecond, time.Second}},
+	}
+
+	for _, tt := range tests {
+		var got []time.Duration
+		for attempt := range tt.want {
+			got = append(got, tt.policy.delay(attempt))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: delays %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package sqsout
+
+import (
+	"errors"
+	"hash/fnv"
+	"strconv"
+)
+
+// recordSampler keeps only a percentage of the records, which cuts SQS cost
+// for high volume debug streams. when a key is configured, all records sharing
+// the same key value are kept or dropped together
+type recordSampler struct {
+	percent float64
+	key     string
+}
+
+// newRecordSampler returns nil when sampling is not configured (or configured
+// to keep everything) so the flush path can skip it entirely
+func newRecordSampler(percentString, key string) (*recordSampler, error) {
+	if percentString == "" {
+		if key != "" {
+			return nil, errors.New("SampleKey requires SamplePercent to be set")
+		}
+		return nil, nil
+	}
+
+	percent, err := strconv.ParseFloat(percentString, 64)
+	if err != nil || percent < 0 || percent > 100 {
+		return nil, errors.New("SamplePercent should be a number between 0 and 100")
+	}
+
+	if percent == 100 {
+		return nil, nil
+	}
+
+	return &recordSampler{percent: percent, key: key}, nil
+}
+
+func (s *recordSampler) keep(record map[interface{}]interface{}) bool {
+	if s.percent <= 0 {
+		return false
+	}
+
+	if s.key != "" {
+		if value, ok := recordField(record, s.key); ok {
+			return keyedSample(fieldString(value), s.percent)
+		}
+	}
+
+	return randFloat64()*100 < s.percent
+}
+
+// keyedSample deterministically maps a key value into [0, 100) so the same
+// value always gets the same sampling decision, across flushes and agents
+func keyedSample(value string, percent float64) bool {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(value))
+	return float64(h.Sum64()%10000)/100 < percent
+}
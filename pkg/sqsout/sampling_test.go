@@ -0,0 +1,96 @@
+package sqsout
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNewRecordSampler(t *testing.T) {
+	tests := []struct {
+		name        string
+		percent     string
+		key         string
+		wantSampler bool
+		wantErr     bool
+	}{
+		{"not configured", "", "", false, false},
+		{"key without percent", "", "trace_id", false, true},
+		{"hundred percent keeps everything", "100", "", false, false},
+		{"valid percent", "10", "", true, false},
+		{"valid percent with key", "10", "trace_id", true, false},
+		{"zero percent", "0", "", true, false},
+		{"negative percent", "-5", "", false, true},
+		{"percent above 100", "150", "", false, true},
+		{"not a number", "ten", "", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sampler, err := newRecordSampler(tt.percent, tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newRecordSampler() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (sampler != nil) != tt.wantSampler {
+				t.Errorf("newRecordSampler() sampler = %v, wantSampler %v", sampler, tt.wantSampler)
+			}
+		})
+	}
+}
+
+func TestRecordSamplerKeep(t *testing.T) {
+	original := randFloat64
+	defer func() { randFloat64 = original }()
+
+	t.Run("random sampling", func(t *testing.T) {
+		sampler := &recordSampler{percent: 10}
+		record := map[interface{}]interface{}{"message": []byte("hello")}
+
+		randFloat64 = func() float64 { return 0.05 }
+		if !sampler.keep(record) {
+			t.Error("expected record to be kept")
+		}
+
+		randFloat64 = func() float64 { return 0.5 }
+		if sampler.keep(record) {
+			t.Error("expected record to be dropped")
+		}
+	})
+
+	t.Run("zero percent drops everything", func(t *testing.T) {
+		randFloat64 = func() float64 { return 0 }
+		sampler := &recordSampler{percent: 0}
+		if sampler.keep(map[interface{}]interface{}{"message": "hello"}) {
+			t.Error("expected record to be dropped")
+		}
+	})
+
+	t.Run("keyed sampling is consistent per key", func(t *testing.T) {
+		randFloat64 = func() float64 { panic("keyed sampling should not use random numbers") }
+		sampler := &recordSampler{percent: 50, key: "trace_id"}
+
+		kept := 0
+		for i := 0; i < 1000; i++ {
+			traceID := []byte(fmt.Sprintf("trace-%d", i))
+			first := sampler.keep(map[interface{}]interface{}{"trace_id": traceID, "n": 1})
+			second := sampler.keep(map[interface{}]interface{}{"trace_id": string(traceID), "n": 2})
+			if first != second {
+				t.Fatalf("records with trace_id %s were sampled differently", traceID)
+			}
+			if first {
+				kept++
+			}
+		}
+
+		if kept < 400 || kept > 600 {
+			t.Errorf("expected roughly half of the keys to be kept, got %d/1000", kept)
+		}
+	})
+
+	t.Run("records without the key fall back to random sampling", func(t *testing.T) {
+		randFloat64 = func() float64 { return 0.99 }
+		sampler := &recordSampler{percent: 50, key: "trace_id"}
+		if sampler.keep(map[interface{}]interface{}{"message": "hello"}) {
+			t.Error("expected record to be dropped")
+		}
+	})
+}
@@ -0,0 +1,38 @@
+package sqsout
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go/logging"
+)
+
+// sdkLogLevels maps the SdkLogLevel values to the aws sdk log modes. the
+// names are the log levels of the first version of the sdk
+var sdkLogLevels = map[string]aws.ClientLogMode{
+	"off":                        0,
+	"debug":                      aws.LogRequest | aws.LogResponse,
+	"debug_with_signing":         aws.LogSigning | aws.LogRequest | aws.LogResponse,
+	"debug_with_http_body":       aws.LogRequestWithBody | aws.LogResponseWithBody,
+	"debug_with_request_retries": aws.LogRetries | aws.LogRequest | aws.LogResponse,
+	"debug_with_request_errors":  aws.LogRetries | aws.LogRequest | aws.LogResponse,
+}
+
+func parseSdkLogLevel(value string) (aws.ClientLogMode, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	level, ok := sdkLogLevels[strings.ToLower(value)]
+	if !ok {
+		return 0, fmt.Errorf("SdkLogLevel should be one of off, debug, debug_with_signing, debug_with_http_body, debug_with_request_retries or debug_with_request_errors. got %q", value)
+	}
+	return level, nil
+}
+
+// sdkLogger writes the aws sdk logs as plugin debug logs. they are written
+// whatever SQS_OUT_LOG_LEVEL is, since SdkLogLevel asks for them explicitly
+var sdkLogger = logging.LoggerFunc(func(classification logging.Classification, format string, v ...interface{}) {
+	writeLog("debug", strings.TrimSuffix(fmt.Sprintf(format, v...), "\n"), nil)
+})
@@ -0,0 +1,42 @@
+package sqsout
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go/logging"
+)
+
+func TestParseSdkLogLevel(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    aws.ClientLogMode
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"off", 0, false},
+		{"debug", aws.LogRequest | aws.LogResponse, false},
+		{"DEBUG_WITH_HTTP_BODY", aws.LogRequestWithBody | aws.LogResponseWithBody, false},
+		{"debug_with_signing", aws.LogSigning | aws.LogRequest | aws.LogResponse, false},
+		{"trace", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSdkLogLevel(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseSdkLogLevel(%q) = %v, %v, want %v, wantErr %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSdkLogger(t *testing.T) {
+	resetGlobals()
+	sqsOutLogLevel = 2
+
+	output := captureStdout(func() { sdkLogger.Logf(logging.Debug, "Request\n%s", "POST / HTTP/1.1") })
+
+	if !strings.Contains(output, "[ debug] [sqs-out] Request\nPOST / HTTP/1.1\n") {
+		t.Errorf("expected the sdk log whatever the plugin log level, got %q", output)
+	}
+}
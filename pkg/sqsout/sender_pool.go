@@ -0,0 +1,168 @@
+package sqsout
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// senderPool is a pool of goroutines sending batches concurrently, so the
+// flush throughput isn't capped by serial round trips to SQS. the flush
+// callback only hands the batches over, send errors are logged and counted.
+// the batches of a FIFO message group are sent one after the other, in the
+// order they were handed over
+type senderPool struct {
+	sqsConf       *sqsConfig
+	batches       chan pooledBatch
+	inFlight      chan struct{}
+	groups        *groupOrder
+	submitMu      sync.Mutex
+	wg            sync.WaitGroup
+	stopOnce      sync.Once
+	failureCount  atomic.Int64
+	retriedChunks atomic.Int64
+}
+
+// senderPools holds the running pools so Shutdown can drain them
+var (
+	senderPools   []*senderPool
+	senderPoolsMu sync.Mutex
+)
+
+// pooledBatch is a batch handed over to the workers
+type pooledBatch struct {
+	records []*types.SendMessageBatchRequestEntry
+	ticket  groupTicket
+}
+
+func parseWorkers(workersString string) (int, error) {
+	if workersString == "" {
+		return 0, nil
+	}
+
+	workers, err := strconv.Atoi(workersString)
+	if err != nil || workers < 0 || workers > 64 {
+		return 0, errors.New("Workers should be integer value between 0 and 64")
+	}
+
+	return workers, nil
+}
+
+// parseMaxInFlightBatches parses the bound on batches handed over to the
+// workers and not sent yet. by default each worker can have two batches
+// queued besides the one it is sending
+func parseMaxInFlightBatches(value string, workers int) (int, error) {
+	if value == "" {
+		return workers * 3, nil
+	}
+
+	if workers == 0 {
+		return 0, errors.New("MaxInFlightBatches requires Workers to be set")
+	}
+
+	maxInFlightBatches, err := strconv.Atoi(value)
+	if err != nil || maxInFlightBatches < workers {
+		return 0, fmt.Errorf("MaxInFlightBatches should be an integer value not lower than Workers (%d)", workers)
+	}
+
+	return maxInFlightBatches, nil
+}
+
+// newSenderPool starts the given number of sender goroutines. at most
+// maxInFlightBatches batches are queued or being sent at any time
+func newSenderPool(sqsConf *sqsConfig, workers, maxInFlightBatches int) *senderPool {
+	pool := &senderPool{
+		sqsConf:  sqsConf,
+		batches:  make(chan pooledBatch, maxInFlightBatches),
+		inFlight: make(chan struct{}, maxInFlightBatches),
+		groups:   newGroupOrder(),
+	}
+
+	pool.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go pool.run()
+	}
+
+	senderPoolsMu.Lock()
+	senderPools = append(senderPools, pool)
+	senderPoolsMu.Unlock()
+
+	return pool
+}
+
+func (p *senderPool) run() {
+	defer p.wg.Done()
+
+	for batch := range p.batches {
+		records := batch.records
+		p.groups.wait(batch.ticket)
+		err := sendBatchToSqs(p.sqsConf, records)
+		p.groups.done(batch.ticket)
+		if err != nil {
+			p.failureCount.Add(int64(len(records)))
+			writeErrorLog(fmt.Errorf("async batch of %d messages failed: %v", len(records), err))
+		}
+		if p.sqsConf.memBuf != nil {
+			p.sqsConf.memBuf.release(batchBytes(records))
+		}
+		releaseEntries(records)
+		<-p.inFlight
+	}
+}
+
+// submit hands a batch over to the workers. it blocks while the maximum of
+// in flight batches is reached
+func (p *senderPool) submit(records []*types.SendMessageBatchRequestEntry) {
+	p.inFlight <- struct{}{}
+
+	// the batches are queued in the order of their tickets, a worker never
+	// waits on a batch queued after its own. the in flight slot leaves room
+	// in the queue, the send doesn't block
+	p.submitMu.Lock()
+	p.batches <- pooledBatch{records: records, ticket: p.groups.issue(records)}
+	p.submitMu.Unlock()
+}
+
+// saturated returns true when no more batch can be handed over without
+// blocking
+func (p *senderPool) saturated() bool {
+	return len(p.inFlight) == cap(p.inFlight)
+}
+
+// stop waits for the pending batches to be sent and stops the workers. it is
+// safe to call more than once
+func (p *senderPool) stop() {
+	p.stopOnce.Do(func() {
+		close(p.batches)
+	})
+	p.wg.Wait()
+}
+
+// unregisterSenderPool removes the pool of a closed instance
+func unregisterSenderPool(pool *senderPool) {
+	senderPoolsMu.Lock()
+	defer senderPoolsMu.Unlock()
+
+	for i, running := range senderPools {
+		if running == pool {
+			senderPools = append(senderPools[:i], senderPools[i+1:]...)
+			return
+		}
+	}
+}
+
+// stopSenderPools drains and stops every running sender pool
+func stopSenderPools() {
+	senderPoolsMu.Lock()
+	pools := senderPools
+	senderPools = nil
+	senderPoolsMu.Unlock()
+
+	for _, pool := range pools {
+		pool.stop()
+	}
+}
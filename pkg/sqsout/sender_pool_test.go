@@ -0,0 +1,172 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// concurrentFakeSQS implements sqsClient interface for tests sending from
+// several goroutines
+type concurrentFakeSQS struct {
+	mu          sync.Mutex
+	entries     int
+	calls       int64
+	inFlight    int64
+	maxInFlight int64
+	delay       time.Duration
+	err         error
+}
+
+func (f *concurrentFakeSQS) SendMessageBatch(ctx context.Context, input *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	current := atomic.AddInt64(&f.inFlight, 1)
+	defer atomic.AddInt64(&f.inFlight, -1)
+
+	f.mu.Lock()
+	f.calls++
+	f.entries += len(input.Entries)
+	if current > f.maxInFlight {
+		f.maxInFlight = current
+	}
+	f.mu.Unlock()
+
+	time.Sleep(f.delay)
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &sqs.SendMessageBatchOutput{}, nil
+}
+
+func testBatch(size int) []*types.SendMessageBatchRequestEntry {
+	records := make([]*types.SendMessageBatchRequestEntry, size)
+	for i := range records {
+		records[i] = &types.SendMessageBatchRequestEntry{
+			Id:          aws.String("msg"),
+			MessageBody: aws.String(`{"message":"test"}`),
+		}
+	}
+	return records
+}
+
+func TestParseWorkers(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"4", 4, false},
+		{"64", 64, false},
+		{"65", 0, true},
+		{"-1", 0, true},
+		{"many", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseWorkers(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseWorkers(%q) = %d, %v, want %d, wantErr %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseMaxInFlightBatches(t *testing.T) {
+	tests := []struct {
+		input   string
+		workers int
+		want    int
+		wantErr bool
+	}{
+		{"", 0, 0, false},
+		{"", 4, 12, false},
+		{"4", 4, 4, false},
+		{"100", 4, 100, false},
+		{"3", 4, 0, true},
+		{"8", 0, 0, true},
+		{"many", 4, 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseMaxInFlightBatches(tt.input, tt.workers)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseMaxInFlightBatches(%q, %d) = %d, %v, want %d, wantErr %v", tt.input, tt.workers, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSenderPool(t *testing.T) {
+	t.Run("sends batches concurrently", func(t *testing.T) {
+		resetGlobals()
+		fake := &concurrentFakeSQS{delay: 20 * time.Millisecond}
+		pool := newSenderPool(&sqsConfig{queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue", mySQS: fake}, 4, 12)
+
+		for i := 0; i < 8; i++ {
+			pool.submit(testBatch(10))
+		}
+		stopSenderPools()
+
+		if fake.calls != 8 || fake.entries != 80 {
+			t.Errorf("expected 8 calls and 80 entries, got %d calls and %d entries", fake.calls, fake.entries)
+		}
+		if fake.maxInFlight < 2 {
+			t.Errorf("expected concurrent sends, max in flight was %d", fake.maxInFlight)
+		}
+		if len(senderPools) != 0 {
+			t.Error("sender pools should be unregistered after stop")
+		}
+	})
+
+	t.Run("bounds the in flight batches", func(t *testing.T) {
+		resetGlobals()
+		fake := &concurrentFakeSQS{delay: 50 * time.Millisecond}
+		pool := newSenderPool(&sqsConfig{queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue", mySQS: fake}, 2, 2)
+
+		pool.submit(testBatch(1))
+		pool.submit(testBatch(1))
+
+		submitted := make(chan struct{})
+		go func() {
+			pool.submit(testBatch(1))
+			close(submitted)
+		}()
+
+		select {
+		case <-submitted:
+			t.Error("submit should block while the maximum of in flight batches is reached")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		<-submitted
+		stopSenderPools()
+
+		if fake.calls != 3 {
+			t.Errorf("expected 3 calls, got %d", fake.calls)
+		}
+	})
+
+	t.Run("counts failed messages", func(t *testing.T) {
+		resetGlobals()
+		fake := &concurrentFakeSQS{err: errors.New("SQS service error")}
+		pool := newSenderPool(&sqsConfig{queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue", mySQS: fake}, 2, 6)
+
+		captureStdout(func() {
+			pool.submit(testBatch(3))
+			pool.submit(testBatch(5))
+			pool.stop()
+		})
+
+		if pool.failureCount.Load() != 8 {
+			t.Errorf("failureCount = %d, want 8", pool.failureCount.Load())
+		}
+		stopSenderPools()
+	})
+}
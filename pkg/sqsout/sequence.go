@@ -0,0 +1,131 @@
+package sqsout
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// defaultSequenceKey is the field AddSequence writes to when SequenceKey
+	// is not set
+	defaultSequenceKey = "sequence"
+	// sequenceFile is the file of the counters in BufferPath
+	sequenceFile = "sequences"
+	// sequenceReserve is how many numbers of a tag are reserved in the file
+	// at once, a crash skips at most as many
+	sequenceReserve = 1000
+)
+
+// sequencer numbers the records of every tag from 1, so consumers can detect
+// the gaps and the reordering of the delivery. the numbers are given once
+// the records passed the processors and RequireKeys, only the records
+// dropped as unserializable or oversize leave a gap. with BufferPath the
+// counters survive the restarts, see persistIn
+type sequencer struct {
+	key  string
+	mu   sync.Mutex
+	last map[string]int64
+
+	// path is the file of the counters, empty when they are not persisted.
+	// reserved are the numbers of every tag written to it
+	path     string
+	reserved map[string]int64
+}
+
+// newSequencer returns nil when AddSequence is not enabled
+func newSequencer(addSequenceString, key string) (*sequencer, error) {
+	addSequence, err := parseBool("AddSequence", addSequenceString)
+	if err != nil {
+		return nil, err
+	}
+	if !addSequence {
+		if key != "" {
+			return nil, errors.New("SequenceKey requires AddSequence to be enabled")
+		}
+		return nil, nil
+	}
+
+	if key == "" {
+		key = defaultSequenceKey
+	}
+	return &sequencer{key: key, last: map[string]int64{}}, nil
+}
+
+// persistIn loads the counters of dir and keeps them there. the file holds
+// the numbers reserved for every tag, a tag going past its reservation
+// reserves the next sequenceReserve numbers. stop writes the last numbers
+// given, after a crash the numbers continue after the reservation
+func (s *sequencer) persistIn(dir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.path = filepath.Join(dir, sequenceFile)
+	s.reserved = map[string]int64{}
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the sequence numbers: %v", err)
+	}
+	if err := json.Unmarshal(data, &s.last); err != nil {
+		return fmt.Errorf("failed to read the sequence numbers of %s: %v", s.path, err)
+	}
+	for tag, last := range s.last {
+		s.reserved[tag] = last
+	}
+	return nil
+}
+
+// stamp adds the next sequence number of the tag to the record
+func (s *sequencer) stamp(tag string, record map[interface{}]interface{}) {
+	s.mu.Lock()
+	s.last[tag]++
+	sequence := s.last[tag]
+	if s.path != "" && sequence > s.reserved[tag] {
+		s.reserved[tag] = sequence + sequenceReserve - 1
+		if err := s.writeLocked(s.reserved); err != nil {
+			writeErrorLog(err)
+		}
+	}
+	s.mu.Unlock()
+
+	record[s.key] = sequence
+}
+
+// writeLocked replaces the file of the counters
+func (s *sequencer) writeLocked(counters map[string]int64) error {
+	data, err := json.Marshal(counters)
+	if err != nil {
+		return fmt.Errorf("failed to write the sequence numbers: %v", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write the sequence numbers: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write the sequence numbers: %v", err)
+	}
+	return nil
+}
+
+// stop writes the last numbers given, the next run continues from them
+func (s *sequencer) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.path == "" {
+		return
+	}
+	if err := s.writeLocked(s.last); err != nil {
+		writeErrorLog(err)
+		return
+	}
+	for tag, last := range s.last {
+		s.reserved[tag] = last
+	}
+}
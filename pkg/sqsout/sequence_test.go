@@ -0,0 +1,126 @@
+package sqsout
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestNewSequencer(t *testing.T) {
+	tests := []struct {
+		add     string
+		key     string
+		wantKey string
+		wantNil bool
+		wantErr bool
+	}{
+		{"", "", "", true, false},
+		{"false", "seq", "", true, true},
+		{"true", "", "sequence", false, false},
+		{"true", "seq", "seq", false, false},
+		{"2", "", "", true, true},
+	}
+
+	for _, tt := range tests {
+		got, err := newSequencer(tt.add, tt.key)
+		if (err != nil) != tt.wantErr || (got == nil) != tt.wantNil {
+			t.Fatalf("newSequencer(%q, %q) = %v, %v, wantNil %v, wantErr %v", tt.add, tt.key, got, err, tt.wantNil, tt.wantErr)
+		}
+		if got != nil && got.key != tt.wantKey {
+			t.Errorf("newSequencer(%q, %q) key = %q, want %q", tt.add, tt.key, got.key, tt.wantKey)
+		}
+	}
+}
+
+func TestFlushRecordsSequence(t *testing.T) {
+	resetGlobals()
+	fake := &recordingSQS{}
+	sequence, _ := newSequencer("true", "")
+	sqsConf := &sqsConfig{
+		queueURL:           "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS:              fake,
+		batchSize:          10,
+		pluginTagAttribute: "tag",
+		sequence:           sequence,
+		requiredKeys:       newRequiredKeys("message"),
+	}
+
+	records := messageRecords(3)
+	delete(records[1], "message")
+	for _, tag := range []string{"app.log", "app.log", "db.log"} {
+		if err := flushRecords(sqsConf, tag, sliceIterator(time.Now(), records...)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := flushPendingBatches(sqsConf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := map[string][]float64{}
+	for _, batch := range fake.batches {
+		for _, entry := range batch.Entries {
+			var body map[string]interface{}
+			if err := json.Unmarshal([]byte(aws.ToString(entry.MessageBody)), &body); err != nil {
+				t.Fatalf("invalid body: %v", err)
+			}
+			tag := aws.ToString(entry.MessageAttributes["tag"].StringValue)
+			got[tag] = append(got[tag], body["sequence"].(float64))
+		}
+	}
+	want := map[string][]float64{"app.log": {1, 2, 3, 4}, "db.log": {1, 2}}
+	for tag, sequences := range want {
+		if len(got[tag]) != len(sequences) {
+			t.Fatalf("expected sequences %v for %s, got %v", sequences, tag, got[tag])
+		}
+		for i := range sequences {
+			if got[tag][i] != sequences[i] {
+				t.Errorf("expected sequences %v for %s, got %v", sequences, tag, got[tag])
+			}
+		}
+	}
+}
+
+func TestSequencerPersisted(t *testing.T) {
+	resetGlobals()
+	dir := t.TempDir()
+	stamp := func(s *sequencer, tag string) int64 {
+		record := map[interface{}]interface{}{}
+		s.stamp(tag, record)
+		return record["sequence"].(int64)
+	}
+
+	first, _ := newSequencer("true", "")
+	if err := first.persistIn(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stamp(first, "app.log")
+	stamp(first, "app.log")
+	stamp(first, "db.log")
+	first.stop()
+
+	restarted, _ := newSequencer("true", "")
+	if err := restarted.persistIn(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := stamp(restarted, "app.log"); got != 3 {
+		t.Errorf("expected the numbers to continue after a restart, got %d", got)
+	}
+	if got := stamp(restarted, "web.log"); got != 1 {
+		t.Errorf("expected a new tag to start from 1, got %d", got)
+	}
+
+	// a crash, stop is not called
+	crashed, _ := newSequencer("true", "")
+	if err := crashed.persistIn(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := stamp(crashed, "app.log"); got != 3+sequenceReserve {
+		t.Errorf("expected the numbers to continue after the reservation, got %d", got)
+	}
+	if got := stamp(crashed, "db.log"); got != 2 {
+		t.Errorf("expected a tag without new numbers to continue, got %d", got)
+	}
+}
@@ -0,0 +1,77 @@
+package sqsout
+
+import (
+	"errors"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// shadowRoute duplicates a sample of the traffic to a secondary queue so a
+// new queue (or a new consumer) can be validated before a full cutover.
+// failures on the shadow queue never fail the flush of the primary queue
+type shadowRoute struct {
+	sideQueue
+	percent float64
+}
+
+// randFloat64 is used for percentage based sampling. tests may replace it
+var randFloat64 = rand.Float64
+
+func newShadowRoute(shadowQueueURL string, percent float64) *shadowRoute {
+	return &shadowRoute{
+		sideQueue: sideQueue{name: "shadow", queueURL: shadowQueueURL, idPrefix: "Shadow"},
+		percent:   percent,
+	}
+}
+
+func parseShadowPercent(percentString string) (float64, error) {
+	if percentString == "" {
+		return 100, nil
+	}
+
+	percent, err := strconv.ParseFloat(percentString, 64)
+	if err != nil || percent < 0 || percent > 100 {
+		return 0, errors.New("ShadowPercent should be a number between 0 and 100")
+	}
+
+	return percent, nil
+}
+
+func validateShadowConfig(shadowQueueURL, queueMessageGroupID string) error {
+	if strings.HasSuffix(shadowQueueURL, ".fifo") && queueMessageGroupID == "" {
+		return errors.New("QueueMessageGroupId configuration key is mandatory for FIFO shadow queues")
+	}
+
+	return nil
+}
+
+// sampled returns true when a record should be duplicated to the shadow queue
+func (s *shadowRoute) sampled() bool {
+	if s.percent <= 0 {
+		return false
+	}
+	if s.percent >= 100 {
+		return true
+	}
+	return randFloat64()*100 < s.percent
+}
+
+// addCopy queues a copy of the given primary entry for the shadow queue
+func (s *shadowRoute) addCopy(entry *types.SendMessageBatchRequestEntry) {
+	shadowEntry := &types.SendMessageBatchRequestEntry{
+		MessageBody:             entry.MessageBody,
+		MessageAttributes:       entry.MessageAttributes,
+		MessageSystemAttributes: entry.MessageSystemAttributes,
+		MessageGroupId:          entry.MessageGroupId,
+	}
+
+	if entry.MessageDeduplicationId != nil {
+		shadowEntry.MessageDeduplicationId = aws.String("Shadow" + *entry.MessageDeduplicationId)
+	}
+
+	s.add(shadowEntry, nil)
+}
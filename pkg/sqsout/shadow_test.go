@@ -0,0 +1,283 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestParseShadowPercent(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    float64
+		wantErr bool
+	}{
+		{"empty defaults to 100", "", 100, false},
+		{"valid: 5", "5", 5, false},
+		{"valid: 0.5", "0.5", 0.5, false},
+		{"valid: 0", "0", 0, false},
+		{"valid: 100", "100", 100, false},
+		{"invalid: negative", "-1", 0, true},
+		{"invalid: above 100", "101", 0, true},
+		{"invalid: not a number", "abc", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseShadowPercent(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseShadowPercent(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseShadowPercent(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateShadowConfig(t *testing.T) {
+	if err := validateShadowConfig("https://sqs.us-east-1.amazonaws.com/123456789/shadow", ""); err != nil {
+		t.Errorf("unexpected error for standard shadow queue: %v", err)
+	}
+	if err := validateShadowConfig("https://sqs.us-east-1.amazonaws.com/123456789/shadow.fifo", ""); err == nil {
+		t.Error("expected error for FIFO shadow queue without group id")
+	}
+	if err := validateShadowConfig("https://sqs.us-east-1.amazonaws.com/123456789/shadow.fifo", "group-1"); err != nil {
+		t.Errorf("unexpected error for FIFO shadow queue with group id: %v", err)
+	}
+}
+
+func TestShadowRouteSampled(t *testing.T) {
+	original := randFloat64
+	defer func() { randFloat64 = original }()
+
+	tests := []struct {
+		name    string
+		percent float64
+		roll    float64
+		want    bool
+	}{
+		{"zero percent never samples", 0, 0, false},
+		{"hundred percent always samples", 100, 0.99, true},
+		{"roll below percent", 5, 0.04, true},
+		{"roll above percent", 5, 0.06, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			randFloat64 = func() float64 { return tt.roll }
+			s := &shadowRoute{percent: tt.percent}
+			if got := s.sampled(); got != tt.want {
+				t.Errorf("sampled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShadowRouteFlush(t *testing.T) {
+	entry := &types.SendMessageBatchRequestEntry{
+		Id:                     aws.String("MessageNumber-1"),
+		MessageBody:            aws.String(`{"message":"test"}`),
+		MessageGroupId:         aws.String("group-1"),
+		MessageDeduplicationId: aws.String("MessageNumber-1-1"),
+	}
+
+	t.Run("successful send", func(t *testing.T) {
+		resetGlobals()
+		s := newShadowRoute("https://sqs.us-east-1.amazonaws.com/123456789/shadow.fifo", 100)
+		s.addCopy(entry)
+		s.addCopy(entry)
+
+		fake := &fakeSQS{
+			output: &sqs.SendMessageBatchOutput{
+				Successful: []types.SendMessageBatchResultEntry{{Id: aws.String("ShadowMessageNumber-1")}},
+				Failed:     []types.BatchResultErrorEntry{{Id: aws.String("ShadowMessageNumber-2")}},
+			},
+		}
+		captureStdout(func() { s.flush(fake, retryPolicy{}) })
+
+		if *fake.input.QueueUrl != s.queueURL {
+			t.Errorf("unexpected queue URL: %s", *fake.input.QueueUrl)
+		}
+		if len(fake.input.Entries) != 2 {
+			t.Fatalf("expected 2 entries, got %d", len(fake.input.Entries))
+		}
+		if *fake.input.Entries[1].Id != "ShadowMessageNumber-2" {
+			t.Errorf("unexpected entry id: %s", *fake.input.Entries[1].Id)
+		}
+		if *fake.input.Entries[0].MessageDeduplicationId != "ShadowMessageNumber-1-1" {
+			t.Errorf("unexpected deduplication id: %s", *fake.input.Entries[0].MessageDeduplicationId)
+		}
+		if s.stats.messagesSent.Load() != 1 || s.stats.messagesFailed.Load() != 1 {
+			t.Errorf("unexpected stats: success=%d failure=%d", s.stats.messagesSent.Load(), s.stats.messagesFailed.Load())
+		}
+		if len(s.records) != 0 || s.messageNumber != 0 {
+			t.Error("shadow batch was not reset after flush")
+		}
+	})
+
+	t.Run("send error counts all entries as failed", func(t *testing.T) {
+		resetGlobals()
+		s := newShadowRoute("https://sqs.us-east-1.amazonaws.com/123456789/shadow", 100)
+		s.addCopy(entry)
+		s.addCopy(entry)
+		s.addCopy(entry)
+
+		fake := &fakeSQS{err: errors.New("SQS service error")}
+		captureStdout(func() { s.flush(fake, retryPolicy{}) })
+
+		if s.stats.messagesFailed.Load() != 3 || s.stats.messagesSent.Load() != 0 {
+			t.Errorf("unexpected stats: success=%d failure=%d", s.stats.messagesSent.Load(), s.stats.messagesFailed.Load())
+		}
+	})
+
+	t.Run("empty batch is not sent", func(t *testing.T) {
+		resetGlobals()
+		s := newShadowRoute("https://sqs.us-east-1.amazonaws.com/123456789/shadow", 100)
+		fake := &fakeSQS{}
+		s.flush(fake, retryPolicy{})
+
+		if fake.input != nil {
+			t.Error("expected no request for an empty shadow batch")
+		}
+	})
+}
+
+// stalledSQS blocks the sends to the queues other than primaryURL until
+// release is closed
+type stalledSQS struct {
+	recordingSQS
+	primaryURL string
+	release    chan struct{}
+}
+
+func (f *stalledSQS) SendMessageBatch(ctx context.Context, input *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	if aws.ToString(input.QueueUrl) != f.primaryURL {
+		<-f.release
+	}
+	return f.recordingSQS.SendMessageBatch(ctx, input, optFns...)
+}
+
+func TestStalledShadowDoesNotDelayFlush(t *testing.T) {
+	resetGlobals()
+	queueURL := "https://sqs.us-east-1.amazonaws.com/123456789/test-queue"
+	fake := &stalledSQS{primaryURL: queueURL, release: make(chan struct{})}
+	sqsConf := &sqsConfig{
+		queueURL:  queueURL,
+		mySQS:     fake,
+		sideSQS:   fake,
+		batchSize: 1,
+		shadow:    newShadowRoute("https://sqs.us-east-1.amazonaws.com/123456789/shadow", 100),
+	}
+	sqsConf.shadow.sender = newSideSender(func() { sqsConf.shadow.flush(sqsConf.sideSQS, sqsConf.retry) })
+	sqsConf.shadow.sender.start(sqsConf)
+
+	flushed := make(chan error, 1)
+	go func() { flushed <- flushRecords(sqsConf, "app.log", sliceIterator(time.Now(), messageRecords(3)...)) }()
+	select {
+	case err := <-flushed:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the flush not to wait for the stalled shadow queue")
+	}
+
+	close(fake.release)
+	stopInstanceReporters(sqsConf)
+
+	shadowed := 0
+	for _, batch := range fake.batches {
+		if aws.ToString(batch.QueueUrl) == sqsConf.shadow.queueURL {
+			shadowed += len(batch.Entries)
+		}
+	}
+	if shadowed != 3 {
+		t.Errorf("expected the 3 shadow copies to be sent once the queue recovered, got %d", shadowed)
+	}
+}
+
+func TestSideQueueBatches(t *testing.T) {
+	entries := func(sizes ...int) []*types.SendMessageBatchRequestEntry {
+		var records []*types.SendMessageBatchRequestEntry
+		for _, size := range sizes {
+			records = append(records, &types.SendMessageBatchRequestEntry{MessageBody: aws.String(strings.Repeat("x", size))})
+		}
+		return records
+	}
+	repeat := func(n, size int) []int {
+		sizes := make([]int, n)
+		for i := range sizes {
+			sizes[i] = size
+		}
+		return sizes
+	}
+
+	tests := []struct {
+		name       string
+		maxEntries int
+		maxBytes   int64
+		sizes      []int
+		want       []int
+	}{
+		{"empty", 0, 0, nil, nil},
+		{"SQS limits", 0, 0, repeat(28, 10), []int{10, 10, 8}},
+		{"batch size", 4, 0, repeat(9, 10), []int{4, 4, 1}},
+		{"payload limit", 10, 2048, []int{1000, 1000, 1000, 2048, 10}, []int{2, 1, 1, 1}},
+	}
+
+	for _, tt := range tests {
+		q := &sideQueue{maxEntries: tt.maxEntries, maxBytes: tt.maxBytes}
+		var got []int
+		for _, batch := range q.batches(entries(tt.sizes...)) {
+			got = append(got, len(batch))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: batches of %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestShadowOfSeveralTagsIsSentInBatches(t *testing.T) {
+	resetGlobals()
+	fake := &recordingSQS{}
+	sqsConf := &sqsConfig{
+		queueURL:  "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS:     fake,
+		sideSQS:   fake,
+		batchSize: 10,
+		shadow:    newShadowRoute("https://sqs.us-east-1.amazonaws.com/123456789/shadow", 100),
+	}
+
+	captureStdout(func() {
+		for _, tag := range []string{"a", "b", "c"} {
+			if err := flushRecords(sqsConf, tag, sliceIterator(time.Now(), messageRecords(9)...)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if err := flushPendingBatches(sqsConf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	shadowed := 0
+	for _, batch := range fake.batches {
+		if len(batch.Entries) > 10 {
+			t.Errorf("expected at most 10 entries per request, %s got %d", aws.ToString(batch.QueueUrl), len(batch.Entries))
+		}
+		if aws.ToString(batch.QueueUrl) == sqsConf.shadow.queueURL {
+			shadowed += len(batch.Entries)
+		}
+	}
+	if shadowed != 27 {
+		t.Errorf("expected the 27 records to be shadowed, got %d", shadowed)
+	}
+}
@@ -0,0 +1,203 @@
+package sqsout
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// sideQueuePendingEntries is how many entries a side queue holds while its
+// sender is busy, the entries over it are dropped
+const sideQueuePendingEntries = 10000
+
+// sideQueue is a secondary destination with its own batch, used for traffic
+// that must not affect delivery to the main queue (shadow copies, invalid
+// records). send errors are only logged and counted in the side queue stats,
+// which are reported with the queue as a destination of its own
+type sideQueue struct {
+	name          string
+	queueURL      string
+	idPrefix      string
+	mu            sync.Mutex
+	records       []*types.SendMessageBatchRequestEntry
+	messageNumber int
+	stats         deliveryStats
+	// maxEntries and maxBytes bound the batches of the side queue, the SQS
+	// limits when zero
+	maxEntries int
+	maxBytes   int64
+	// sender sends the batches in the background, nil sends them with the
+	// batches of the main queue
+	sender *sideSender
+}
+
+// add queues an entry for the side queue and returns the number of pending
+// entries. entries over the SQS size limit are dropped. the entry gets its
+// own id since ids only have to be unique within a single batch, configure
+// (when not nil) completes the entry once it has its id. the side queues are
+// shared by the batches of every tag
+func (q *sideQueue) add(entry *types.SendMessageBatchRequestEntry, configure func(entry *types.SendMessageBatchRequestEntry)) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	size := entryBytes(entry)
+	q.stats.bytesSerialized.Add(size)
+	if size > maxMessageBytes {
+		writeWarnLog(fmt.Sprintf("message of %d bytes for %s queue %s is over the SQS limit. dropping it", size, q.name, q.queueURL))
+		q.stats.bytesRejected.Add(size)
+		q.stats.countDroppedRecord(dropOversize)
+		return len(q.records)
+	}
+	if len(q.records) >= sideQueuePendingEntries {
+		writeDebugLog(fmt.Sprintf("%s queue %s holds %d entries, dropping the new one", q.name, queueName(q.queueURL), len(q.records)))
+		q.stats.countDroppedRecord(dropSideQueueFull)
+		return len(q.records)
+	}
+
+	q.messageNumber++
+	entry.Id = aws.String(fmt.Sprintf("%sMessageNumber-%d", q.idPrefix, q.messageNumber))
+	if configure != nil {
+		configure(entry)
+	}
+	q.records = append(q.records, entry)
+	return len(q.records)
+}
+
+// flush sends the queued entries and records the outcome in the side queue
+// stats
+func (q *sideQueue) flush(client sqsClient, retry retryPolicy) {
+	for _, records := range q.batches(q.take()) {
+		q.send(client, retry, records)
+	}
+}
+
+// batches splits the queued entries into the batches of a request. the side
+// queues are shared by the batches of every tag, they may hold more entries
+// than a request takes
+func (q *sideQueue) batches(records []*types.SendMessageBatchRequestEntry) [][]*types.SendMessageBatchRequestEntry {
+	maxEntries, maxBytes := q.maxEntries, q.maxBytes
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxBatchEntries
+	}
+	if maxBytes <= 0 {
+		maxBytes = maxMessageBytes
+	}
+
+	var batches [][]*types.SendMessageBatchRequestEntry
+	start, payload := 0, int64(0)
+	for i, entry := range records {
+		size := entryBytes(entry)
+		if i > start && (i-start == maxEntries || payload+size > maxBytes) {
+			batches = append(batches, records[start:i])
+			start, payload = i, 0
+		}
+		payload += size
+	}
+	if start < len(records) {
+		batches = append(batches, records[start:])
+	}
+	return batches
+}
+
+// handOver notifies the sender of the side queue that a batch is due. it
+// returns false when the side queue has no sender, the caller sends the batch
+func (q *sideQueue) handOver() bool {
+	if q.sender == nil {
+		return false
+	}
+	q.sender.notify()
+	return true
+}
+
+// take returns the queued entries, the next ones start a new batch
+func (q *sideQueue) take() []*types.SendMessageBatchRequestEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	records := q.records
+	q.records = nil
+	q.messageNumber = 0
+	return records
+}
+
+// send sends a batch of the side queue and records the outcome in its stats,
+// the error is the one of the request
+func (q *sideQueue) send(client sqsClient, retry retryPolicy, records []*types.SendMessageBatchRequestEntry) error {
+	retry.retried = &q.stats.messagesRetried
+	start := time.Now()
+	output, err := retry.sendBatch(context.Background(), sqsSink{client: client, queueURL: q.queueURL}, q.queueURL, records)
+	q.stats.sendLatency.observe(time.Since(start))
+
+	if err != nil {
+		q.stats.recordRequestError(records, err)
+		writeErrorLog(fmt.Errorf("failed to send batch to %s queue %s: %v", q.name, q.queueURL, err))
+	} else {
+		q.stats.recordBatchResult(records, output)
+		logBatchFailures(q.queueURL, strconv.FormatInt(q.stats.batches.Add(1), 10), records, output)
+	}
+
+	writeDebugLog(fmt.Sprintf("%s queue stats of %s: %s", q.name, queueName(q.queueURL), q.stats.pluginMetrics()))
+	return err
+}
+
+// sideSender runs the flush of a side queue in the background, so the flush
+// of the main queue never waits for a slow or unavailable side queue. the
+// notifications made while a flush runs are merged into the next one
+type sideSender struct {
+	flush    func()
+	notifyCh chan struct{}
+	stopCh   chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newSideSender(flush func()) *sideSender {
+	return &sideSender{
+		flush:    flush,
+		notifyCh: make(chan struct{}, 1),
+		stopCh:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// start runs the flushes until stop is called
+func (s *sideSender) start(owner *sqsConfig) {
+	registerReporter(owner, s)
+
+	go func() {
+		defer close(s.done)
+
+		for {
+			select {
+			case <-s.notifyCh:
+				s.flush()
+			case <-s.stopCh:
+				// the entries queued since the last flush
+				s.flush()
+				return
+			}
+		}
+	}()
+}
+
+// notify asks for a flush, it never blocks
+func (s *sideSender) notify() {
+	select {
+	case s.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// stop sends the queued entries and stops the sender, it is safe to call
+// more than once
+func (s *sideSender) stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+	<-s.done
+}
@@ -0,0 +1,60 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	smithyauth "github.com/aws/smithy-go/auth"
+)
+
+// parseSigningRegion validates the SigningRegion, the region the requests are
+// signed for when a proxy or an accelerator in front of SQS expects another
+// region than QueueRegion
+func parseSigningRegion(signingRegion string) (string, error) {
+	if strings.ContainsAny(signingRegion, " \t/") {
+		return "", errors.New("SigningRegion should be a region name, e.g. us-east-1")
+	}
+	return signingRegion, nil
+}
+
+// sqsSigningRegion signs the SQS requests for its region, the endpoint is
+// still resolved from the region of the client
+type sqsSigningRegion struct {
+	sqs.AuthSchemeResolver
+	region string
+}
+
+func (r sqsSigningRegion) ResolveAuthSchemes(ctx context.Context, params *sqs.AuthResolverParameters) ([]*smithyauth.Option, error) {
+	signing := *params
+	signing.Region = r.region
+	return r.AuthSchemeResolver.ResolveAuthSchemes(ctx, &signing)
+}
+
+// withSQSSigningRegion is the SQS client option signing for region
+func withSQSSigningRegion(region string) func(*sqs.Options) {
+	return func(o *sqs.Options) {
+		o.AuthSchemeResolver = sqsSigningRegion{AuthSchemeResolver: o.AuthSchemeResolver, region: region}
+	}
+}
+
+// snsSigningRegion signs the SNS requests of the SNS mode for its region
+type snsSigningRegion struct {
+	sns.AuthSchemeResolver
+	region string
+}
+
+func (r snsSigningRegion) ResolveAuthSchemes(ctx context.Context, params *sns.AuthResolverParameters) ([]*smithyauth.Option, error) {
+	signing := *params
+	signing.Region = r.region
+	return r.AuthSchemeResolver.ResolveAuthSchemes(ctx, &signing)
+}
+
+// withSNSSigningRegion is the SNS client option signing for region
+func withSNSSigningRegion(region string) func(*sns.Options) {
+	return func(o *sns.Options) {
+		o.AuthSchemeResolver = snsSigningRegion{AuthSchemeResolver: o.AuthSchemeResolver, region: region}
+	}
+}
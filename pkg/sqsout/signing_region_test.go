@@ -0,0 +1,78 @@
+package sqsout
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSigningRegion(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"us-east-1", "us-east-1", false},
+		{"us east 1", "", true},
+		{"https://sqs.us-east-1.amazonaws.com", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSigningRegion(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseSigningRegion(%q) = %q, %v, want %q, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSigningRegion(t *testing.T) {
+	resetGlobals()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+
+	tests := []struct {
+		signingRegion string
+		wantScope     string
+	}{
+		{"", "/us-east-1/sqs/aws4_request"},
+		{"eu-west-1", "/eu-west-1/sqs/aws4_request"},
+	}
+
+	for _, tt := range tests {
+		fake := &fakeSQSEndpoint{}
+		server := httptest.NewServer(fake)
+		config := map[string]string{
+			"QueueUrl":      server.URL + "/123456789/test-queue",
+			"QueueRegion":   "us-east-1",
+			"SigningRegion": tt.signingRegion,
+			"Endpoint":      server.URL,
+			"BatchSize":     "1",
+		}
+
+		var out *Output
+		var err error
+		captureStdout(func() {
+			out, err = New(func(key string) string { return config[key] })
+			if err == nil {
+				err = out.Send("app", sliceIterator(time.Now(), map[interface{}]interface{}{"log": "signed"}))
+				out.Close()
+			}
+		})
+		server.Close()
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// the queue attributes are fetched before the batch is sent
+		if len(fake.authorizations) != 2 {
+			t.Fatalf("SigningRegion %q: expected 2 signed requests, got %v", tt.signingRegion, fake.authorizations)
+		}
+		for _, authorization := range fake.authorizations {
+			if !strings.Contains(authorization, tt.wantScope) {
+				t.Errorf("SigningRegion %q: expected a signature scoped to %s, got %s", tt.signingRegion, tt.wantScope, authorization)
+			}
+		}
+	}
+}
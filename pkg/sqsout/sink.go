@@ -0,0 +1,45 @@
+package sqsout
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// Sink is the destination of the batches of an output. the batching, retries
+// and metrics are the same for every sink. accepted and failed are the
+// entries the destination took and rejected, err is returned when the whole
+// request failed. entries use the SQS batch entry, whatever the destination
+type Sink interface {
+	SendBatch(ctx context.Context, entries []*types.SendMessageBatchRequestEntry) (accepted []types.SendMessageBatchResultEntry, failed []types.BatchResultErrorEntry, err error)
+}
+
+// sqsSink sends the batches to an SQS queue, or through another sqsClient
+// such as the SNS publisher
+type sqsSink struct {
+	client   sqsClient
+	queueURL string
+}
+
+func (s sqsSink) SendBatch(ctx context.Context, entries []*types.SendMessageBatchRequestEntry) ([]types.SendMessageBatchResultEntry, []types.BatchResultErrorEntry, error) {
+	output, err := s.client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+		Entries:  batchEntries(entries),
+		QueueUrl: aws.String(s.queueURL),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return output.Successful, output.Failed, nil
+}
+
+// mainSink is the sink of the main destination, queueURL of mySQS unless
+// the output was created with another sink. the callers pass the queue they
+// read from activeQueueURL
+func (sqsConf *sqsConfig) mainSink(queueURL string) Sink {
+	if sqsConf.sink != nil {
+		return sqsConf.sink
+	}
+	return sqsSink{client: sqsConf.mySQS, queueURL: queueURL}
+}
@@ -0,0 +1,89 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// snsClient is an interface for SNS operations to enable testing
+type snsClient interface {
+	PublishBatch(ctx context.Context, input *sns.PublishBatchInput, optFns ...func(*sns.Options)) (*sns.PublishBatchOutput, error)
+}
+
+// snsBatchPublisher lets an SNS topic act as the plugin destination. it
+// implements sqsClient by translating SQS batches to SNS PublishBatch calls,
+// so the same formatting and batching pipeline is used for both sinks. the
+// batch QueueUrl holds the topic ARN
+type snsBatchPublisher struct {
+	sns snsClient
+}
+
+func validateSnsConfig(queueURL, snsTopicArn string) error {
+	if queueURL != "" {
+		return errors.New("QueueUrl and SnsTopicArn configuration keys are mutually exclusive")
+	}
+
+	if !strings.HasPrefix(snsTopicArn, "arn:") {
+		return errors.New("SnsTopicArn should be a valid topic ARN")
+	}
+
+	return nil
+}
+
+func (p *snsBatchPublisher) SendMessageBatch(ctx context.Context, input *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	entries := make([]snstypes.PublishBatchRequestEntry, 0, len(input.Entries))
+	for _, entry := range input.Entries {
+		snsEntry := snstypes.PublishBatchRequestEntry{
+			Id:                     entry.Id,
+			Message:                entry.MessageBody,
+			MessageGroupId:         entry.MessageGroupId,
+			MessageDeduplicationId: entry.MessageDeduplicationId,
+		}
+
+		if len(entry.MessageAttributes) > 0 {
+			snsEntry.MessageAttributes = make(map[string]snstypes.MessageAttributeValue, len(entry.MessageAttributes))
+			for name, value := range entry.MessageAttributes {
+				snsEntry.MessageAttributes[name] = snstypes.MessageAttributeValue{
+					DataType:    value.DataType,
+					StringValue: value.StringValue,
+					BinaryValue: value.BinaryValue,
+				}
+			}
+		}
+
+		entries = append(entries, snsEntry)
+	}
+
+	output, err := p.sns.PublishBatch(ctx, &sns.PublishBatchInput{
+		PublishBatchRequestEntries: entries,
+		TopicArn:                   input.QueueUrl,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &sqs.SendMessageBatchOutput{}
+	for _, success := range output.Successful {
+		result.Successful = append(result.Successful, types.SendMessageBatchResultEntry{
+			Id:             success.Id,
+			MessageId:      success.MessageId,
+			SequenceNumber: success.SequenceNumber,
+		})
+	}
+	for _, failure := range output.Failed {
+		result.Failed = append(result.Failed, types.BatchResultErrorEntry{
+			Id:          failure.Id,
+			Code:        failure.Code,
+			Message:     failure.Message,
+			SenderFault: failure.SenderFault,
+		})
+	}
+
+	return result, nil
+}
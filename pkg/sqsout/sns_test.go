@@ -0,0 +1,139 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// fakeSNS implements snsClient interface for testing
+type fakeSNS struct {
+	input  *sns.PublishBatchInput
+	output *sns.PublishBatchOutput
+	err    error
+}
+
+func (f *fakeSNS) PublishBatch(ctx context.Context, input *sns.PublishBatchInput, optFns ...func(*sns.Options)) (*sns.PublishBatchOutput, error) {
+	f.input = input
+	return f.output, f.err
+}
+
+func TestValidateSnsConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		queueURL    string
+		snsTopicArn string
+		wantErr     bool
+	}{
+		{"valid topic", "", "arn:aws:sns:us-east-1:123456789:logs", false},
+		{"valid FIFO topic", "", "arn:aws:sns:us-east-1:123456789:logs.fifo", false},
+		{"both destinations", "https://sqs.us-east-1.amazonaws.com/123456789/test-queue", "arn:aws:sns:us-east-1:123456789:logs", true},
+		{"not an ARN", "", "logs", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSnsConfig(tt.queueURL, tt.snsTopicArn)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateSnsConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSnsBatchPublisherSendMessageBatch(t *testing.T) {
+	entries := []*types.SendMessageBatchRequestEntry{
+		{
+			Id:          aws.String("MessageNumber-1"),
+			MessageBody: aws.String(`{"id":1}`),
+			MessageAttributes: map[string]types.MessageAttributeValue{
+				"tag": {DataType: aws.String("String"), StringValue: aws.String("app.log")},
+			},
+			MessageGroupId:         aws.String("group-1"),
+			MessageDeduplicationId: aws.String("MessageNumber-1-1"),
+		},
+		{
+			Id:          aws.String("MessageNumber-2"),
+			MessageBody: aws.String(`{"id":2}`),
+		},
+	}
+	input := &sqs.SendMessageBatchInput{
+		QueueUrl: aws.String("arn:aws:sns:us-east-1:123456789:logs.fifo"),
+		Entries:  batchEntries(entries),
+	}
+
+	t.Run("translates the batch and the result", func(t *testing.T) {
+		fake := &fakeSNS{
+			output: &sns.PublishBatchOutput{
+				Successful: []snstypes.PublishBatchResultEntry{
+					{Id: aws.String("MessageNumber-1"), MessageId: aws.String("sns-message-1")},
+				},
+				Failed: []snstypes.BatchResultErrorEntry{
+					{Id: aws.String("MessageNumber-2"), Code: aws.String("InternalError")},
+				},
+			},
+		}
+		publisher := &snsBatchPublisher{sns: fake}
+
+		output, err := publisher.SendMessageBatch(context.Background(), input)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if *fake.input.TopicArn != "arn:aws:sns:us-east-1:123456789:logs.fifo" {
+			t.Errorf("unexpected topic ARN: %s", *fake.input.TopicArn)
+		}
+		if len(fake.input.PublishBatchRequestEntries) != 2 {
+			t.Fatalf("expected 2 entries, got %d", len(fake.input.PublishBatchRequestEntries))
+		}
+
+		first := fake.input.PublishBatchRequestEntries[0]
+		if *first.Id != "MessageNumber-1" || *first.Message != `{"id":1}` {
+			t.Errorf("unexpected entry: %v", first)
+		}
+		if *first.MessageGroupId != "group-1" || *first.MessageDeduplicationId != "MessageNumber-1-1" {
+			t.Errorf("FIFO fields not translated: %v", first)
+		}
+		if *first.MessageAttributes["tag"].StringValue != "app.log" {
+			t.Errorf("message attributes not translated: %v", first.MessageAttributes)
+		}
+		if fake.input.PublishBatchRequestEntries[1].MessageAttributes != nil {
+			t.Error("expected no message attributes on the second entry")
+		}
+
+		if len(output.Successful) != 1 || *output.Successful[0].MessageId != "sns-message-1" {
+			t.Errorf("unexpected successful entries: %v", output.Successful)
+		}
+		if len(output.Failed) != 1 || *output.Failed[0].Code != "InternalError" {
+			t.Errorf("unexpected failed entries: %v", output.Failed)
+		}
+	})
+
+	t.Run("returns SNS errors", func(t *testing.T) {
+		publisher := &snsBatchPublisher{sns: &fakeSNS{err: errors.New("SNS service error")}}
+		if _, err := publisher.SendMessageBatch(context.Background(), input); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("works with sendBatchToSqs", func(t *testing.T) {
+		fake := &fakeSNS{output: &sns.PublishBatchOutput{}}
+		sqsConf := &sqsConfig{
+			queueURL: "arn:aws:sns:us-east-1:123456789:logs",
+			mySQS:    &snsBatchPublisher{sns: fake},
+		}
+
+		if err := sendBatchToSqs(sqsConf, entries); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if *fake.input.TopicArn != "arn:aws:sns:us-east-1:123456789:logs" {
+			t.Errorf("unexpected topic ARN: %s", *fake.input.TopicArn)
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package sqsout
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+)
+
+// sourceEmitter is the emitter field AddSourceIdentity stamps on the records
+const sourceEmitter = "fluentbit-sqs-plugin"
+
+// configRecorder keeps the configuration values an instance reads, to hash
+// the active configuration
+type configRecorder struct {
+	configKey func(key string) string
+	values    map[string]string
+}
+
+func newConfigRecorder(configKey func(key string) string) *configRecorder {
+	return &configRecorder{configKey: configKey, values: map[string]string{}}
+}
+
+func (c *configRecorder) get(key string) string {
+	value := c.configKey(key)
+	c.values[key] = value
+	return value
+}
+
+// hash returns a short hash of the keys set in the configuration, the same
+// for every agent running the same configuration
+func (c *configRecorder) hash() string {
+	keys := make([]string, 0, len(c.values))
+	for key, value := range c.values {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var config strings.Builder
+	for _, key := range keys {
+		config.WriteString(key + "=" + c.values[key] + "\n")
+	}
+	sum := sha256.Sum256([]byte(config.String()))
+	return hex.EncodeToString(sum[:6])
+}
+
+// newSourceIdentityEnricher returns the processor stamping the plugin, its
+// version and the hash of the configuration on every record, nil when
+// AddSourceIdentity is not enabled. it tells which of several agents or
+// configurations produced a message
+func newSourceIdentityEnricher(addSourceIdentityString, configHash string) (Processor, error) {
+	addSourceIdentity, err := parseBool("AddSourceIdentity", addSourceIdentityString)
+	if err != nil || !addSourceIdentity {
+		return nil, err
+	}
+
+	return fieldsEnricher(map[string]string{
+		"emitter":        sourceEmitter,
+		"plugin_version": version,
+		"config_hash":    configHash,
+	}), nil
+}
@@ -0,0 +1,64 @@
+package sqsout
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfigRecorderHash(t *testing.T) {
+	hash := func(config map[string]string, keys ...string) string {
+		recorder := newConfigRecorder(func(key string) string { return config[key] })
+		for _, key := range keys {
+			recorder.get(key)
+		}
+		return recorder.hash()
+	}
+
+	config := map[string]string{"QueueUrl": "https://sqs.us-east-1.amazonaws.com/123456789/test-queue", "BatchSize": "10"}
+	base := hash(config, "QueueUrl", "BatchSize", "Format")
+	if len(base) != 12 {
+		t.Errorf("expected a 12 characters hash, got %q", base)
+	}
+
+	tests := []struct {
+		name     string
+		config   map[string]string
+		keys     []string
+		wantSame bool
+	}{
+		{"same configuration read in another order", config, []string{"Format", "BatchSize", "QueueUrl"}, true},
+		{"unset keys are ignored", config, []string{"QueueUrl", "BatchSize"}, true},
+		{"changed value", map[string]string{"QueueUrl": config["QueueUrl"], "BatchSize": "5"}, []string{"QueueUrl", "BatchSize", "Format"}, false},
+		{"added key", map[string]string{"QueueUrl": config["QueueUrl"], "BatchSize": "10", "Format": "gelf"}, []string{"QueueUrl", "BatchSize", "Format"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := hash(tt.config, tt.keys...); (got == base) != tt.wantSame {
+			t.Errorf("%s: got hash %q, base %q, wantSame %v", tt.name, got, base, tt.wantSame)
+		}
+	}
+}
+
+func TestNewSourceIdentityEnricher(t *testing.T) {
+	defer func(v string) { version = v }(version)
+	version = "v1.2.3"
+
+	if enricher, err := newSourceIdentityEnricher("", "abc"); enricher != nil || err != nil {
+		t.Errorf("expected no enricher when disabled, got %v, %v", enricher, err)
+	}
+	if _, err := newSourceIdentityEnricher("sure", "abc"); err == nil {
+		t.Error("expected an error for an invalid value")
+	}
+
+	enricher, err := newSourceIdentityEnricher("true", "0a1b2c3d4e5f")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	record := map[interface{}]interface{}{"log": "hello", "emitter": "app"}
+	enricher.Process(&Record{Fields: record})
+
+	want := map[interface{}]interface{}{"log": "hello", "emitter": "app", "plugin_version": "v1.2.3", "config_hash": "0a1b2c3d4e5f"}
+	if !reflect.DeepEqual(record, want) {
+		t.Errorf("expected %v, got %v", want, record)
+	}
+}
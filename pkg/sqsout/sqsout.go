@@ -0,0 +1,1503 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+	"unsafe"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+import (
+	"strings"
+)
+
+// integer representation for this plugin log level
+// 0 - debug
+// 1 - info
+// 2 - error
+var sqsOutLogLevel int
+
+// sqsClient is an interface for SQS operations to enable testing
+type sqsClient interface {
+	SendMessageBatch(ctx context.Context, input *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error)
+}
+
+type sqsConfig struct {
+	queueURL            string
+	queueMessageGroupID string
+	messageGroupIDKey   string
+	balanceGroups       bool
+	mySQS               sqsClient
+	sink                Sink
+	sideSQS             sqsClient
+	pluginTagAttribute  string
+	versionAttribute    string
+	correlationIDKey    string
+	sequence            *sequencer
+	proxyURL            string
+	batchSize           int
+	maxBatchBytes       int64
+	shadow              *shadowRoute
+	mirror              *mirrorRoute
+	queueSelector       *queueSelector
+	failover            *queueFailover
+	tagFilter           *tagFilter
+	processors          processorChain
+	requiredKeys        *requiredKeys
+	recordLimits        *recordLimits
+	transforms          transforms
+	invalidRecords      *invalidRecordRoute
+	senders             *senderPool
+	memBuf              *memBufLimit
+	retry               retryPolicy
+	deferToEngine       bool
+	entryID             entryIDGenerator
+	adaptiveBatch       *adaptiveBatch
+	throttle            *throttleGovernor
+	queueAttributes     *queueAttributes
+	statsd              *statsdEmitter
+	messageIDLog        *messageIDLog
+	auditLog            *auditLog
+	debugDump           *debugDump
+	diskBuffer          *diskBuffer
+	dedupWindow         *dedupWindow
+	batches             batchSet
+	encoder             recordEncoder
+	formatter           Formatter
+	systemAttributes    systemAttributes
+	stats               deliveryStats
+}
+
+// initPlugin creates a plugin instance from its configuration, configKey
+// returns the value of a configuration key
+func initPlugin(configKey func(key string) string) (_ *sqsConfig, err error) {
+	activeConfig := newConfigRecorder(configKey)
+	configKey = activeConfig.get
+
+	queueURL := configKey("QueueUrl")
+	queueRegion := configKey("QueueRegion")
+	signingRegionString := configKey("SigningRegion")
+	queueMessageGroupID := configKey("QueueMessageGroupId")
+	messageGroupIDKey := configKey("MessageGroupIdKey")
+	fifoQueueString := configKey("FifoQueue")
+	maxRequestsPerSecondString := configKey("MaxRequestsPerSecond")
+	tagQueueOnInit := configKey("TagQueueOnInit")
+	compatibilityModeString := configKey("CompatibilityMode")
+	balanceMessageGroupsString := configKey("BalanceMessageGroups")
+	pluginTagAttribute := configKey("PluginTagAttribute")
+	versionAttribute := configKey("VersionAttribute")
+	proxyURL := configKey("ProxyUrl")
+	batchSizeString := configKey("BatchSize")
+	maxBatchEntriesString := configKey("MaxBatchEntries")
+	maxBatchPayloadBytesString := configKey("MaxBatchPayloadBytes")
+	endpoint := configKey("Endpoint")
+	endpointResolverString := configKey("EndpointResolver")
+	shadowQueueURL := configKey("ShadowQueueUrl")
+	shadowPercentString := configKey("ShadowPercent")
+	mirrorQueueURL := configKey("MirrorQueueUrl")
+	mirrorRegion := configKey("MirrorRegion")
+	equivalentQueueURLsString := configKey("EquivalentQueueUrls")
+	latencyProbeSecondsString := configKey("LatencyProbeSeconds")
+	failoverQueueURL := configKey("FailoverQueueUrl")
+	healthProbeSecondsString := configKey("HealthProbeSeconds")
+	failoverThresholdString := configKey("FailoverThreshold")
+	failbackThresholdString := configKey("FailbackThreshold")
+	includeTags := configKey("IncludeTags")
+	excludeTags := configKey("ExcludeTags")
+	samplePercentString := configKey("SamplePercent")
+	sampleKey := configKey("SampleKey")
+	filterKey := configKey("FilterKey")
+	filterRegex := configKey("FilterRegex")
+	excludeRegex := configKey("ExcludeRegex")
+	requireKeys := configKey("RequireKeys")
+	maxRecordDepth := configKey("MaxRecordDepth")
+	maxRecordKeys := configKey("MaxRecordKeys")
+	recordLimitAction := configKey("RecordLimitAction")
+	removeKeyPrefix := configKey("RemoveKeyPrefix")
+	keyCase := configKey("KeyCase")
+	nullValuePolicy := configKey("NullValuePolicy")
+	stringifyValuesString := configKey("StringifyValues")
+	joinLinesKeys := configKey("JoinLinesKeys")
+	joinLinesSeparator := configKey("JoinLinesSeparator")
+	maxFieldLength := configKey("MaxFieldLength")
+	normalizeUnicodeString := configKey("NormalizeUnicode")
+	invalidRecordQueueURL := configKey("InvalidRecordQueueUrl")
+	snsTopicArn := configKey("SnsTopicArn")
+	eventBusName := configKey("EventBusName")
+	eventSource := configKey("EventSource")
+	eventDetailType := configKey("EventDetailType")
+	workersString := configKey("Workers")
+	maxInFlightBatchesString := configKey("MaxInFlightBatches")
+	maxIdleConnsPerHostString := configKey("MaxIdleConnsPerHost")
+	jsonEncoder := configKey("JsonEncoder")
+	jsonEscapeHTMLString := configKey("JsonEscapeHtml")
+	format := configKey("Format")
+	memBufLimitString := configKey("MemBufLimit")
+	memBufOverflow := configKey("MemBufOverflow")
+	sendRetriesString := configKey("SendRetries")
+	entryIDMode := configKey("EntryIdMode")
+	adaptiveBatchSize := configKey("AdaptiveBatchSize")
+	throttleSlowdownString := configKey("ThrottleSlowdown")
+	adaptiveLatency := configKey("AdaptiveLatency")
+	emfInterval := configKey("EmfInterval")
+	emfNamespace := configKey("EmfNamespace")
+	emfLogGroup := configKey("EmfLogGroup")
+	statsdAddress := configKey("StatsdAddress")
+	statsdPrefix := configKey("StatsdPrefix")
+	statsdTags := configKey("StatsdTags")
+	statsdInterval := configKey("StatsdInterval")
+	metricsListen := configKey("MetricsListen")
+	logOutputString := configKey("LogOutput")
+	summaryInterval := configKey("SummaryInterval")
+	dropWarningIntervalString := configKey("DropWarningInterval")
+	otelEndpoint := configKey("OtelEndpoint")
+	otelServiceName := configKey("OtelServiceName")
+	xrayTracingString := configKey("XrayTracing")
+	xrayDaemonAddress := configKey("XrayDaemonAddress")
+	sdkLogLevelString := configKey("SdkLogLevel")
+	retryModeString := configKey("RetryMode")
+	sendTimeoutString := configKey("SendTimeout")
+	backoffMultiplierString := configKey("BackoffMultiplier")
+	backoffMaxSecondsString := configKey("BackoffMaxSeconds")
+	retryableErrors := configKey("RetryableErrors")
+	terminalErrors := configKey("TerminalErrors")
+	deferToEngineRetriesString := configKey("DeferToEngineRetries")
+	messageIDLogKey := configKey("MessageIdLogKey")
+	systemAttributesString := configKey("MessageSystemAttributes")
+	addHostnameString := configKey("AddHostname")
+	hostnameKey := configKey("HostnameKey")
+	addKubernetesString := configKey("AddKubernetesMetadata")
+	addEc2MetadataString := configKey("AddEc2Metadata")
+	addEcsMetadataString := configKey("AddEcsMetadata")
+	addLocalityString := configKey("AddLocality")
+	addEnvFields := configKey("AddEnvFields")
+	addCorrelationIDString := configKey("AddCorrelationId")
+	correlationIDKey := configKey("CorrelationIdKey")
+	addIngestLatencyString := configKey("AddIngestLatency")
+	ingestLatencyKey := configKey("IngestLatencyKey")
+	addSequenceString := configKey("AddSequence")
+	sequenceKey := configKey("SequenceKey")
+	addSourceIdentityString := configKey("AddSourceIdentity")
+	heartbeatIntervalString := configKey("HeartbeatIntervalSeconds")
+	queueAttributesRefreshString := configKey("QueueAttributesRefreshMinutes")
+	requireDlqString := configKey("RequireDlq")
+	auditLogFile := configKey("AuditLogFile")
+	debugDumpDir := configKey("DebugDumpDir")
+	debugDumpPercent := configKey("DebugDumpPercent")
+	debugDumpMaxFiles := configKey("DebugDumpMaxFiles")
+	bufferPath := configKey("BufferPath")
+	bufferMaxSizeString := configKey("BufferMaxSize")
+	bufferMaxAgeString := configKey("BufferMaxAge")
+	bufferReplayOrderString := configKey("BufferReplayOrder")
+	bufferSyncString := configKey("BufferSync")
+	avoidDuplicatesOnReplayString := configKey("AvoidDuplicatesOnReplay")
+	chaosLatency := configKey("ChaosLatency")
+	chaosThrottlePercent := configKey("ChaosThrottlePercent")
+	chaosFailurePercent := configKey("ChaosFailurePercent")
+
+	// the log output is set first so the configuration logs already go there
+	if err := setLogOutput(logOutputString); err != nil {
+		return nil, err
+	}
+
+	writeInfoLog(fmt.Sprintf("fluentBit-sqs-plugin %s", versionString()))
+	writeInfoLog(fmt.Sprintf("QueueUrl is: %s", queueURL))
+	writeInfoLog(fmt.Sprintf("QueueRegion is: %s", queueRegion))
+	writeInfoLog(fmt.Sprintf("SigningRegion is: %s", signingRegionString))
+	writeInfoLog(fmt.Sprintf("QueueMessageGroupId is: %s", queueMessageGroupID))
+	writeInfoLog(fmt.Sprintf("MessageGroupIdKey is: %s", messageGroupIDKey))
+	writeInfoLog(fmt.Sprintf("FifoQueue is: %s", fifoQueueString))
+	writeInfoLog(fmt.Sprintf("MaxRequestsPerSecond is: %s", maxRequestsPerSecondString))
+	writeInfoLog(fmt.Sprintf("TagQueueOnInit is: %s", tagQueueOnInit))
+	writeInfoLog(fmt.Sprintf("CompatibilityMode is: %s", compatibilityModeString))
+	writeInfoLog(fmt.Sprintf("BalanceMessageGroups is: %s", balanceMessageGroupsString))
+	writeInfoLog(fmt.Sprintf("pluginTagAttribute is: %s", pluginTagAttribute))
+	writeInfoLog(fmt.Sprintf("VersionAttribute is: %s", versionAttribute))
+	writeInfoLog(fmt.Sprintf("ProxyUrl is: %s", proxyURL))
+	writeInfoLog(fmt.Sprintf("BatchSize is: %s", batchSizeString))
+	writeInfoLog(fmt.Sprintf("MaxBatchEntries is: %s", maxBatchEntriesString))
+	writeInfoLog(fmt.Sprintf("MaxBatchPayloadBytes is: %s", maxBatchPayloadBytesString))
+	writeInfoLog(fmt.Sprintf("Endpoint is: %s", endpoint))
+	writeInfoLog(fmt.Sprintf("EndpointResolver is: %s", endpointResolverString))
+	writeInfoLog(fmt.Sprintf("ShadowQueueUrl is: %s", shadowQueueURL))
+	writeInfoLog(fmt.Sprintf("ShadowPercent is: %s", shadowPercentString))
+	writeInfoLog(fmt.Sprintf("MirrorQueueUrl is: %s", mirrorQueueURL))
+	writeInfoLog(fmt.Sprintf("MirrorRegion is: %s", mirrorRegion))
+	writeInfoLog(fmt.Sprintf("EquivalentQueueUrls is: %s", equivalentQueueURLsString))
+	writeInfoLog(fmt.Sprintf("LatencyProbeSeconds is: %s", latencyProbeSecondsString))
+	writeInfoLog(fmt.Sprintf("FailoverQueueUrl is: %s", failoverQueueURL))
+	writeInfoLog(fmt.Sprintf("HealthProbeSeconds is: %s", healthProbeSecondsString))
+	writeInfoLog(fmt.Sprintf("FailoverThreshold is: %s", failoverThresholdString))
+	writeInfoLog(fmt.Sprintf("FailbackThreshold is: %s", failbackThresholdString))
+	writeInfoLog(fmt.Sprintf("IncludeTags is: %s", includeTags))
+	writeInfoLog(fmt.Sprintf("ExcludeTags is: %s", excludeTags))
+	writeInfoLog(fmt.Sprintf("SamplePercent is: %s", samplePercentString))
+	writeInfoLog(fmt.Sprintf("SampleKey is: %s", sampleKey))
+	writeInfoLog(fmt.Sprintf("FilterKey is: %s", filterKey))
+	writeInfoLog(fmt.Sprintf("FilterRegex is: %s", filterRegex))
+	writeInfoLog(fmt.Sprintf("ExcludeRegex is: %s", excludeRegex))
+	writeInfoLog(fmt.Sprintf("RequireKeys is: %s", requireKeys))
+	writeInfoLog(fmt.Sprintf("MaxRecordDepth is: %s", maxRecordDepth))
+	writeInfoLog(fmt.Sprintf("MaxRecordKeys is: %s", maxRecordKeys))
+	writeInfoLog(fmt.Sprintf("RecordLimitAction is: %s", recordLimitAction))
+	writeInfoLog(fmt.Sprintf("RemoveKeyPrefix is: %s", removeKeyPrefix))
+	writeInfoLog(fmt.Sprintf("KeyCase is: %s", keyCase))
+	writeInfoLog(fmt.Sprintf("NullValuePolicy is: %s", nullValuePolicy))
+	writeInfoLog(fmt.Sprintf("StringifyValues is: %s", stringifyValuesString))
+	writeInfoLog(fmt.Sprintf("JoinLinesKeys is: %s", joinLinesKeys))
+	writeInfoLog(fmt.Sprintf("JoinLinesSeparator is: %q", joinLinesSeparator))
+	writeInfoLog(fmt.Sprintf("MaxFieldLength is: %s", maxFieldLength))
+	writeInfoLog(fmt.Sprintf("NormalizeUnicode is: %s", normalizeUnicodeString))
+	writeInfoLog(fmt.Sprintf("InvalidRecordQueueUrl is: %s", invalidRecordQueueURL))
+	writeInfoLog(fmt.Sprintf("SnsTopicArn is: %s", snsTopicArn))
+	writeInfoLog(fmt.Sprintf("EventBusName is: %s", eventBusName))
+	writeInfoLog(fmt.Sprintf("EventSource is: %s", eventSource))
+	writeInfoLog(fmt.Sprintf("EventDetailType is: %s", eventDetailType))
+	writeInfoLog(fmt.Sprintf("Workers is: %s", workersString))
+	writeInfoLog(fmt.Sprintf("MaxInFlightBatches is: %s", maxInFlightBatchesString))
+	writeInfoLog(fmt.Sprintf("MaxIdleConnsPerHost is: %s", maxIdleConnsPerHostString))
+	writeInfoLog(fmt.Sprintf("JsonEncoder is: %s", jsonEncoder))
+	writeInfoLog(fmt.Sprintf("JsonEscapeHtml is: %s", jsonEscapeHTMLString))
+	writeInfoLog(fmt.Sprintf("Format is: %s", format))
+	writeInfoLog(fmt.Sprintf("MemBufLimit is: %s", memBufLimitString))
+	writeInfoLog(fmt.Sprintf("MemBufOverflow is: %s", memBufOverflow))
+	writeInfoLog(fmt.Sprintf("SendRetries is: %s", sendRetriesString))
+	writeInfoLog(fmt.Sprintf("EntryIdMode is: %s", entryIDMode))
+	writeInfoLog(fmt.Sprintf("AdaptiveBatchSize is: %s", adaptiveBatchSize))
+	writeInfoLog(fmt.Sprintf("ThrottleSlowdown is: %s", throttleSlowdownString))
+	writeInfoLog(fmt.Sprintf("AdaptiveLatency is: %s", adaptiveLatency))
+	writeInfoLog(fmt.Sprintf("EmfInterval is: %s", emfInterval))
+	writeInfoLog(fmt.Sprintf("EmfNamespace is: %s", emfNamespace))
+	writeInfoLog(fmt.Sprintf("EmfLogGroup is: %s", emfLogGroup))
+	writeInfoLog(fmt.Sprintf("StatsdAddress is: %s", statsdAddress))
+	writeInfoLog(fmt.Sprintf("StatsdPrefix is: %s", statsdPrefix))
+	writeInfoLog(fmt.Sprintf("StatsdTags is: %s", statsdTags))
+	writeInfoLog(fmt.Sprintf("StatsdInterval is: %s", statsdInterval))
+	writeInfoLog(fmt.Sprintf("MetricsListen is: %s", metricsListen))
+	writeInfoLog(fmt.Sprintf("LogOutput is: %s", logOutputString))
+	writeInfoLog(fmt.Sprintf("SummaryInterval is: %s", summaryInterval))
+	writeInfoLog(fmt.Sprintf("DropWarningInterval is: %s", dropWarningIntervalString))
+	writeInfoLog(fmt.Sprintf("OtelEndpoint is: %s", otelEndpoint))
+	writeInfoLog(fmt.Sprintf("OtelServiceName is: %s", otelServiceName))
+	writeInfoLog(fmt.Sprintf("XrayTracing is: %s", xrayTracingString))
+	writeInfoLog(fmt.Sprintf("XrayDaemonAddress is: %s", xrayDaemonAddress))
+	writeInfoLog(fmt.Sprintf("SdkLogLevel is: %s", sdkLogLevelString))
+	writeInfoLog(fmt.Sprintf("RetryMode is: %s", retryModeString))
+	writeInfoLog(fmt.Sprintf("SendTimeout is: %s", sendTimeoutString))
+	writeInfoLog(fmt.Sprintf("BackoffMultiplier is: %s", backoffMultiplierString))
+	writeInfoLog(fmt.Sprintf("BackoffMaxSeconds is: %s", backoffMaxSecondsString))
+	writeInfoLog(fmt.Sprintf("RetryableErrors is: %s", retryableErrors))
+	writeInfoLog(fmt.Sprintf("TerminalErrors is: %s", terminalErrors))
+	writeInfoLog(fmt.Sprintf("DeferToEngineRetries is: %s", deferToEngineRetriesString))
+	writeInfoLog(fmt.Sprintf("MessageIdLogKey is: %s", messageIDLogKey))
+	writeInfoLog(fmt.Sprintf("MessageSystemAttributes is: %s", systemAttributesString))
+	writeInfoLog(fmt.Sprintf("AddHostname is: %s", addHostnameString))
+	writeInfoLog(fmt.Sprintf("HostnameKey is: %s", hostnameKey))
+	writeInfoLog(fmt.Sprintf("AddKubernetesMetadata is: %s", addKubernetesString))
+	writeInfoLog(fmt.Sprintf("AddEc2Metadata is: %s", addEc2MetadataString))
+	writeInfoLog(fmt.Sprintf("AddEcsMetadata is: %s", addEcsMetadataString))
+	writeInfoLog(fmt.Sprintf("AddLocality is: %s", addLocalityString))
+	writeInfoLog(fmt.Sprintf("AddEnvFields is: %s", addEnvFields))
+	writeInfoLog(fmt.Sprintf("AddCorrelationId is: %s", addCorrelationIDString))
+	writeInfoLog(fmt.Sprintf("CorrelationIdKey is: %s", correlationIDKey))
+	writeInfoLog(fmt.Sprintf("AddIngestLatency is: %s", addIngestLatencyString))
+	writeInfoLog(fmt.Sprintf("IngestLatencyKey is: %s", ingestLatencyKey))
+	writeInfoLog(fmt.Sprintf("AddSequence is: %s", addSequenceString))
+	writeInfoLog(fmt.Sprintf("SequenceKey is: %s", sequenceKey))
+	writeInfoLog(fmt.Sprintf("AddSourceIdentity is: %s", addSourceIdentityString))
+	writeInfoLog(fmt.Sprintf("HeartbeatIntervalSeconds is: %s", heartbeatIntervalString))
+	writeInfoLog(fmt.Sprintf("QueueAttributesRefreshMinutes is: %s", queueAttributesRefreshString))
+	writeInfoLog(fmt.Sprintf("RequireDlq is: %s", requireDlqString))
+	writeInfoLog(fmt.Sprintf("AuditLogFile is: %s", auditLogFile))
+	writeInfoLog(fmt.Sprintf("DebugDumpDir is: %s", debugDumpDir))
+	writeInfoLog(fmt.Sprintf("DebugDumpPercent is: %s", debugDumpPercent))
+	writeInfoLog(fmt.Sprintf("DebugDumpMaxFiles is: %s", debugDumpMaxFiles))
+	writeInfoLog(fmt.Sprintf("BufferPath is: %s", bufferPath))
+	writeInfoLog(fmt.Sprintf("BufferMaxSize is: %s", bufferMaxSizeString))
+	writeInfoLog(fmt.Sprintf("BufferMaxAge is: %s", bufferMaxAgeString))
+	writeInfoLog(fmt.Sprintf("BufferReplayOrder is: %s", bufferReplayOrderString))
+	writeInfoLog(fmt.Sprintf("BufferSync is: %s", bufferSyncString))
+	writeInfoLog(fmt.Sprintf("AvoidDuplicatesOnReplay is: %s", avoidDuplicatesOnReplayString))
+	writeInfoLog(fmt.Sprintf("ChaosLatency is: %s", chaosLatency))
+	writeInfoLog(fmt.Sprintf("ChaosThrottlePercent is: %s", chaosThrottlePercent))
+	writeInfoLog(fmt.Sprintf("ChaosFailurePercent is: %s", chaosFailurePercent))
+
+	// in SNS mode the topic ARN takes the place of the queue url as the
+	// destination of the batches
+	if snsTopicArn != "" {
+		if err := validateSnsConfig(queueURL, snsTopicArn); err != nil {
+			return nil, err
+		}
+		queueURL = snsTopicArn
+	}
+
+	// the same goes for the bus name in EventBridge mode
+	if eventBusName != "" {
+		if err := validateEventBridgeConfig(queueURL, snsTopicArn, format); err != nil {
+			return nil, err
+		}
+		queueURL = eventBusName
+	} else if eventSource != "" || eventDetailType != "" {
+		return nil, errors.New("EventSource and EventDetailType require EventBusName to be set")
+	}
+
+	if queueURL == "" {
+		return nil, errors.New("QueueUrl (or SnsTopicArn or EventBusName) configuration key is mandatory")
+	}
+
+	compatibility, err := parseCompatibilityMode(compatibilityModeString)
+	if err != nil {
+		return nil, err
+	}
+	if compatibility.emulator() {
+		writeInfoLog(fmt.Sprintf("running in %s compatibility mode, the checks failing on the emulator are relaxed", compatibility))
+		if queueRegion == "" {
+			queueRegion = emulatorRegion
+		}
+	}
+
+	if queueRegion == "" {
+		return nil, errors.New("QueueRegion configuration key is mandatory")
+	}
+
+	if snsTopicArn == "" && eventBusName == "" {
+		if err := compatibility.validateQueueURL("QueueUrl", queueURL); err != nil {
+			return nil, err
+		}
+	}
+	if shadowQueueURL != "" {
+		if err := compatibility.validateQueueURL("ShadowQueueUrl", shadowQueueURL); err != nil {
+			return nil, err
+		}
+	}
+	if invalidRecordQueueURL != "" {
+		if err := compatibility.validateQueueURL("InvalidRecordQueueUrl", invalidRecordQueueURL); err != nil {
+			return nil, err
+		}
+	}
+	if mirrorQueueURL != "" {
+		if err := compatibility.validateQueueURL("MirrorQueueUrl", mirrorQueueURL); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateMirrorConfig(mirrorQueueURL, mirrorRegion, queueURL, queueMessageGroupID, compatibility.emulator()); err != nil {
+		return nil, err
+	}
+
+	equivalentQueueURLs, err := parseEquivalentQueueURLs(equivalentQueueURLsString, queueURL, compatibility)
+	if err != nil {
+		return nil, err
+	}
+
+	latencyProbeInterval, err := parseLatencyProbeInterval(latencyProbeSecondsString)
+	if err != nil {
+		return nil, err
+	}
+
+	if failoverQueueURL != "" {
+		if err := compatibility.validateQueueURL("FailoverQueueUrl", failoverQueueURL); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateFailoverQueue(failoverQueueURL, queueURL, equivalentQueueURLsString); err != nil {
+		return nil, err
+	}
+
+	healthProbeInterval, err := parseHealthProbeInterval(healthProbeSecondsString)
+	if err != nil {
+		return nil, err
+	}
+
+	failoverThreshold, err := parseProbeThreshold("FailoverThreshold", failoverThresholdString, defaultFailoverThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	failbackThreshold, err := parseProbeThreshold("FailbackThreshold", failbackThresholdString, defaultFailbackThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	// the queue type is known once the queue attributes are fetched, the
+	// .fifo suffix is only the fallback
+	fifo, fifoSet, err := parseFifoQueue(fifoQueueString)
+	if err != nil {
+		return nil, err
+	}
+	if !fifoSet {
+		fifo = strings.HasSuffix(queueURL, ".fifo")
+	}
+
+	maxRequestsPerSecond, maxRequestsSet, err := parseMaxRequestsPerSecond(maxRequestsPerSecondString)
+	if err != nil {
+		return nil, err
+	}
+
+	queueTags, err := parseQueueTags(tagQueueOnInit)
+	if err != nil {
+		return nil, err
+	}
+	if queueTags != nil && (snsTopicArn != "" || eventBusName != "") {
+		return nil, errors.New("TagQueueOnInit is only supported with QueueUrl")
+	}
+
+	if messageGroupIDKey != "" && queueMessageGroupID == "" {
+		return nil, errors.New("MessageGroupIdKey requires QueueMessageGroupId, the group of the records without the key")
+	}
+
+	balanceGroups, err := parseBool("BalanceMessageGroups", balanceMessageGroupsString)
+	if err != nil {
+		return nil, err
+	}
+	if balanceGroups && messageGroupIDKey == "" {
+		return nil, errors.New("BalanceMessageGroups requires MessageGroupIdKey")
+	}
+
+	if versionAttribute != "" && versionAttribute == pluginTagAttribute {
+		return nil, errors.New("VersionAttribute and PluginTagAttribute should be different attributes")
+	}
+
+	correlationIDKey, err = parseCorrelationIDKey(addCorrelationIDString, correlationIDKey)
+	if err != nil {
+		return nil, err
+	}
+	if correlationIDKey != "" && (correlationIDKey == pluginTagAttribute || correlationIDKey == versionAttribute) {
+		return nil, errors.New("CorrelationIdKey should be different from PluginTagAttribute and VersionAttribute")
+	}
+
+	maxBatchEntries, err := parseMaxBatchEntries(maxBatchEntriesString)
+	if err != nil {
+		return nil, err
+	}
+	if maxBatchEntries > defaultMaxBatchEntries && (snsTopicArn != "" || eventBusName != "") {
+		return nil, errors.New("MaxBatchEntries can't be over 10 with SnsTopicArn or EventBusName")
+	}
+
+	if !validateBatchSize(batchSizeString, maxBatchEntries) {
+		return nil, fmt.Errorf("BatchSize should be integer value between 1 and %d", maxBatchEntries)
+	}
+	batchSize, _ := strconv.Atoi(batchSizeString)
+
+	maxBatchBytes, err := parseMaxBatchPayloadBytes(maxBatchPayloadBytesString)
+	if err != nil {
+		return nil, err
+	}
+
+	systemAttributes, err := parseSystemAttributes(systemAttributesString)
+	if err != nil {
+		return nil, err
+	}
+	if systemAttributes != nil && snsTopicArn != "" {
+		return nil, errors.New("MessageSystemAttributes is not supported with SnsTopicArn")
+	}
+	if systemAttributes != nil && !compatibility.supportsSystemAttributes() {
+		writeWarnLog(fmt.Sprintf("MessageSystemAttributes is not supported by %s, the attributes are not sent", compatibility))
+		systemAttributes = nil
+	}
+
+	signingRegion, err := parseSigningRegion(signingRegionString)
+	if err != nil {
+		return nil, err
+	}
+
+	resolver, err := parseEndpointResolver(endpointResolverString, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if resolver != nil {
+		endpoint, err = resolveEndpoint(resolver, queueURL, queueRegion)
+		if err != nil {
+			return nil, err
+		}
+		writeInfoLog(fmt.Sprintf("EndpointResolver resolved the endpoint of %s to %s", queueRegion, endpoint))
+	}
+
+	var shadow *shadowRoute
+	if shadowQueueURL != "" {
+		if err := validateShadowConfig(shadowQueueURL, queueMessageGroupID); err != nil {
+			return nil, err
+		}
+
+		shadowPercent, err := parseShadowPercent(shadowPercentString)
+		if err != nil {
+			return nil, err
+		}
+
+		shadow = newShadowRoute(shadowQueueURL, shadowPercent)
+	}
+
+	tagsFilter, err := newTagFilter(includeTags, excludeTags)
+	if err != nil {
+		return nil, err
+	}
+
+	sampler, err := newRecordSampler(samplePercentString, sampleKey)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := newRecordFilter(filterKey, filterRegex, excludeRegex)
+	if err != nil {
+		return nil, err
+	}
+
+	processors := newProcessorChain(sampler, filter)
+
+	hostname, err := newHostnameEnricher(addHostnameString, hostnameKey)
+	if err != nil {
+		return nil, err
+	}
+	if hostname != nil {
+		processors = processors.add(hostname)
+	}
+
+	kubernetes, err := newKubernetesEnricher(addKubernetesString, os.Getenv)
+	if err != nil {
+		return nil, err
+	}
+	if kubernetes != nil {
+		processors = processors.add(kubernetes)
+	}
+
+	envFields, err := newEnvFieldsEnricher(addEnvFields, os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	if envFields != nil {
+		processors = processors.add(envFields)
+	}
+
+	if correlationIDKey != "" {
+		processors = processors.add(correlationIDEnricher(correlationIDKey))
+	}
+
+	ingestLatency, err := newIngestLatencyEnricher(addIngestLatencyString, ingestLatencyKey, time.Now)
+	if err != nil {
+		return nil, err
+	}
+	if ingestLatency != nil {
+		processors = processors.add(ingestLatency)
+	}
+
+	sourceIdentity, err := newSourceIdentityEnricher(addSourceIdentityString, activeConfig.hash())
+	if err != nil {
+		return nil, err
+	}
+	if sourceIdentity != nil {
+		processors = processors.add(sourceIdentity)
+	}
+
+	sequence, err := newSequencer(addSequenceString, sequenceKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyCaseTransform, err := newKeyCaseTransform(keyCase)
+	if err != nil {
+		return nil, err
+	}
+	nullValueTransform, err := newNullValueTransform(nullValuePolicy)
+	if err != nil {
+		return nil, err
+	}
+	linesJoiner, err := newLinesJoiner(joinLinesKeys, joinLinesSeparator)
+	if err != nil {
+		return nil, err
+	}
+	lengths, err := parseFieldLengths(maxFieldLength)
+	if err != nil {
+		return nil, err
+	}
+	normalize, err := parseBool("NormalizeUnicode", normalizeUnicodeString)
+	if err != nil {
+		return nil, err
+	}
+	var unicodeTransform recordTransform
+	if normalize {
+		// before MaxFieldLength, the normalization can change the lengths
+		unicodeTransform = normalizeUnicode
+	}
+	recordTransforms := transforms(nil).add(newKeyPrefixRemover(removeKeyPrefix), linesJoiner, unicodeTransform, lengths.transform(), keyCaseTransform, nullValueTransform)
+	stringify, err := parseBool("StringifyValues", stringifyValuesString)
+	if err != nil {
+		return nil, err
+	}
+	if stringify {
+		recordTransforms = recordTransforms.add(stringifyValues)
+	}
+
+	addEc2Metadata, err := parseBool("AddEc2Metadata", addEc2MetadataString)
+	if err != nil {
+		return nil, err
+	}
+
+	addLocality, err := parseBool("AddLocality", addLocalityString)
+	if err != nil {
+		return nil, err
+	}
+
+	addEcsMetadata, err := parseBool("AddEcsMetadata", addEcsMetadataString)
+	if err != nil {
+		return nil, err
+	}
+	if addEcsMetadata {
+		// the same configuration can run outside of ECS, e.g. on the EC2 hosts
+		if uri := ecsMetadataURI(os.Getenv); uri != "" {
+			ecs, err := newEcsEnricher(uri, http.DefaultClient)
+			if err != nil {
+				return nil, err
+			}
+			processors = processors.add(ecs)
+		} else {
+			writeWarnLog("AddEcsMetadata is enabled but the ECS task metadata endpoint is not available, the records are not enriched")
+		}
+	}
+
+	var invalidRecords *invalidRecordRoute
+	if invalidRecordQueueURL != "" {
+		if err := validateInvalidRecordConfig(invalidRecordQueueURL, queueMessageGroupID); err != nil {
+			return nil, err
+		}
+
+		invalidRecords = newInvalidRecordRoute(invalidRecordQueueURL, queueMessageGroupID)
+	}
+
+	limits, err := newRecordLimits(maxRecordDepth, maxRecordKeys, recordLimitAction, invalidRecords != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	workers, err := parseWorkers(workersString)
+	if err != nil {
+		return nil, err
+	}
+
+	maxInFlightBatches, err := parseMaxInFlightBatches(maxInFlightBatchesString, workers)
+	if err != nil {
+		return nil, err
+	}
+
+	maxIdleConnsPerHost, err := parseMaxIdleConnsPerHost(maxIdleConnsPerHostString)
+	if err != nil {
+		return nil, err
+	}
+
+	memBuf, err := newMemBufLimit(memBufLimitString, memBufOverflow)
+	if err != nil {
+		return nil, err
+	}
+
+	retry, err := newRetryPolicy(sendRetriesString)
+	if err != nil {
+		return nil, err
+	}
+
+	if retry.timeout, err = parseSendTimeout(sendTimeoutString); err != nil {
+		return nil, err
+	}
+
+	if retry.multiplier, err = parseBackoffMultiplier(backoffMultiplierString); err != nil {
+		return nil, err
+	}
+
+	if retry.maxBackoff, err = parseBackoffMaxSeconds(backoffMaxSecondsString); err != nil {
+		return nil, err
+	}
+
+	if retry.classes, err = parseErrorClassifier(retryableErrors, terminalErrors); err != nil {
+		return nil, err
+	}
+
+	entryID, err := newEntryIDGenerator(entryIDMode)
+	if err != nil {
+		return nil, err
+	}
+
+	throttle, err := newThrottleGovernor(throttleSlowdownString, workers)
+	if err != nil {
+		return nil, err
+	}
+
+	adaptive, err := newAdaptiveBatch(adaptiveBatchSize, adaptiveLatency, batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateEmfConfig(emfInterval, emfNamespace, emfLogGroup); err != nil {
+		return nil, err
+	}
+
+	if err := validateStatsdConfig(statsdAddress, statsdPrefix, statsdTags, statsdInterval); err != nil {
+		return nil, err
+	}
+
+	if err := validateOtelConfig(otelEndpoint, otelServiceName); err != nil {
+		return nil, err
+	}
+
+	xrayTracing, err := parseXrayTracing(xrayTracingString, xrayDaemonAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	sdkLogLevel, err := parseSdkLogLevel(sdkLogLevelString)
+	if err != nil {
+		return nil, err
+	}
+
+	retryMode, err := parseRetryMode(retryModeString)
+	if err != nil {
+		return nil, err
+	}
+
+	deferToEngine, err := parseBool("DeferToEngineRetries", deferToEngineRetriesString)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateDeferToEngine(deferToEngine, retry, bufferPath); err != nil {
+		return nil, err
+	}
+	writeInfoLog(describeRetries(deferToEngine, retry, retryMode))
+
+	// encoding/json escapes HTML by default
+	jsonEscapeHTML := true
+	if jsonEscapeHTMLString != "" {
+		if jsonEscapeHTML, err = parseBool("JsonEscapeHtml", jsonEscapeHTMLString); err != nil {
+			return nil, err
+		}
+	}
+
+	encoder, err := newRecordEncoder(jsonEncoder, jsonEscapeHTML)
+	if err != nil {
+		return nil, err
+	}
+
+	formatter, err := newFormatter(format, encoder)
+	if err != nil {
+		return nil, err
+	}
+
+	if proxyURL != "" {
+		writeInfoLog("sending aws requests through the configured proxy url")
+	}
+	httpClient, err := newHTTPClient(proxyURL, maxIdleConnsPerHost)
+	if err != nil {
+		return nil, err
+	}
+
+	awsOptions := []func(*config.LoadOptions) error{
+		config.WithRegion(queueRegion),
+		config.WithHTTPClient(httpClient),
+		config.WithRetryMode(retryMode),
+	}
+	if deferToEngine {
+		awsOptions = append(awsOptions, config.WithRetryMaxAttempts(1))
+	}
+	if sdkLogLevel != 0 {
+		writeInfoLog("logging the aws sdk requests and responses")
+		awsOptions = append(awsOptions, config.WithClientLogMode(sdkLogLevel), config.WithLogger(sdkLogger))
+	}
+
+	// the credentials come from the default chain, environment variables first
+	awsConfig, err := config.LoadDefaultConfig(context.Background(), awsOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the aws configuration: %v", err)
+	}
+
+	// Set custom endpoint if provided (useful for testing with LocalStack)
+	if endpoint != "" {
+		writeInfoLog(fmt.Sprintf("using custom endpoint: %s", endpoint))
+		awsConfig.BaseEndpoint = aws.String(endpoint)
+	}
+
+	if addEc2Metadata {
+		ec2, err := newEc2Enricher(imds.NewFromConfig(awsConfig))
+		if err != nil {
+			return nil, err
+		}
+		processors = processors.add(ec2)
+	}
+
+	if addLocality {
+		locality, err := newLocalityEnricher(imds.NewFromConfig(awsConfig), os.Getenv)
+		if err != nil {
+			return nil, err
+		}
+		processors = processors.add(locality)
+	}
+
+	// side queues (shadow, invalid records) are always SQS queues
+	sqsOptions := compatibility.sqsOptions()
+	var snsOptions []func(*sns.Options)
+	if signingRegion != "" && signingRegion != queueRegion {
+		writeInfoLog(fmt.Sprintf("signing the requests for region %s", signingRegion))
+		sqsOptions = append(sqsOptions, withSQSSigningRegion(signingRegion))
+		snsOptions = append(snsOptions, withSNSSigningRegion(signingRegion))
+	}
+
+	sqsAPI := sqs.NewFromConfig(awsConfig, sqsOptions...)
+	var sqsService sqsClient = sqsAPI
+	if xrayTracing {
+		if err := configureXray(xrayDaemonAddress); err != nil {
+			return nil, err
+		}
+		writeInfoLog("tracing the SQS calls with X-Ray")
+		sqsService = newXraySQS(awsConfig, sqsOptions...)
+	}
+	// the mirror has a client of its own region, a custom endpoint is the one
+	// of the main region unless it is an emulator
+	var mirror *mirrorRoute
+	if mirrorQueueURL != "" {
+		mirrorConfig := awsConfig.Copy()
+		if mirrorRegion != "" {
+			mirrorConfig.Region = mirrorRegion
+		}
+		if !compatibility.emulator() {
+			mirrorConfig.BaseEndpoint = nil
+		}
+		writeInfoLog(fmt.Sprintf("mirroring every record to %s in %s", queueName(mirrorQueueURL), mirrorConfig.Region))
+		mirror = newMirrorRoute(mirrorQueueURL, sqs.NewFromConfig(mirrorConfig, compatibility.sqsOptions()...))
+	}
+
+	var destination sqsClient = sqsService
+	if snsTopicArn != "" {
+		writeInfoLog("publishing batches to SNS topic instead of SQS queue")
+		destination = &snsBatchPublisher{sns: sns.NewFromConfig(awsConfig, snsOptions...)}
+	}
+
+	chaos, err := newChaosFaults(chaosLatency, chaosThrottlePercent, chaosFailurePercent)
+	if err != nil {
+		return nil, err
+	}
+	if chaos != nil {
+		if eventBusName != "" {
+			return nil, errors.New("the chaos mode is not supported with EventBusName")
+		}
+		writeWarnLog(fmt.Sprintf("chaos mode is enabled, injecting faults into every send: %s", chaos))
+		destination = newFaultySQS(destination, chaos)
+		sqsService = newFaultySQS(sqsService, chaos)
+	}
+
+	sqsConf := &sqsConfig{
+		queueURL:            queueURL,
+		queueMessageGroupID: queueMessageGroupID,
+		messageGroupIDKey:   messageGroupIDKey,
+		balanceGroups:       balanceGroups,
+		mySQS:               destination,
+		sideSQS:             sqsService,
+		pluginTagAttribute:  pluginTagAttribute,
+		versionAttribute:    versionAttribute,
+		correlationIDKey:    correlationIDKey,
+		sequence:            sequence,
+		proxyURL:            proxyURL,
+		batchSize:           batchSize,
+		maxBatchBytes:       maxBatchBytes,
+		shadow:              shadow,
+		mirror:              mirror,
+		tagFilter:           tagsFilter,
+		processors:          processors,
+		requiredKeys:        newRequiredKeys(requireKeys),
+		transforms:          recordTransforms,
+		invalidRecords:      invalidRecords,
+		recordLimits:        limits,
+		memBuf:              memBuf,
+		retry:               retry,
+		deferToEngine:       deferToEngine,
+		entryID:             entryID,
+		adaptiveBatch:       adaptive,
+		throttle:            throttle,
+		encoder:             encoder,
+		formatter:           formatter,
+		messageIDLog:        newMessageIDLog(messageIDLogKey),
+		systemAttributes:    systemAttributes,
+	}
+
+	if eventBusName != "" {
+		writeInfoLog("putting the batches on the EventBridge bus instead of an SQS queue")
+		sqsConf.sink = newEventBridgeSink(eventbridge.NewFromConfig(awsConfig), eventBusName, eventSource, eventDetailType)
+	}
+
+	sqsConf.retry.retried = &sqsConf.stats.messagesRetried
+
+	// the reporters and pacing started before a failing key don't outlive the
+	// instance, fluent bit may initialize it again on reload
+	defer func() {
+		if err != nil {
+			stopInstanceReporters(sqsConf)
+			unpaceQueues(sqsConf)
+		}
+	}()
+
+	// the side queues send in the background, a slow side queue doesn't
+	// delay the batches of the main queue. their batches have the limits of
+	// the main queue
+	if shadow != nil {
+		shadow.maxEntries, shadow.maxBytes = batchSize, sqsConf.batchPayloadLimit()
+		shadow.sender = newSideSender(func() { shadow.flush(sqsConf.sideSQS, sqsConf.retry) })
+		shadow.sender.start(sqsConf)
+	}
+	if invalidRecords != nil {
+		invalidRecords.maxEntries, invalidRecords.maxBytes = batchSize, sqsConf.batchPayloadLimit()
+		invalidRecords.sender = newSideSender(func() { invalidRecords.flush(sqsConf.sideSQS, sqsConf.retry) })
+		invalidRecords.sender.start(sqsConf)
+	}
+	if mirror != nil {
+		mirror.maxEntries, mirror.maxBytes = batchSize, sqsConf.batchPayloadLimit()
+		mirror.sender = newSideSender(func() { mirror.flush(sqsConf.retry) })
+		mirror.sender.start(sqsConf)
+	}
+
+	// the attributes of a queue only, the SNS topics and EventBridge buses
+	// have none of them
+	if snsTopicArn == "" && eventBusName == "" {
+		queueAttributesRefresh, err := parseQueueAttributesRefresh(queueAttributesRefreshString)
+		if err != nil {
+			return nil, err
+		}
+
+		requireDlq, err := parseBool("RequireDlq", requireDlqString)
+		if err != nil {
+			return nil, err
+		}
+
+		sqsConf.queueAttributes = newQueueAttributes(sqsAPI, queueURL, queueAttributesRefresh)
+		fetchErr := sqsConf.queueAttributes.fetch()
+		if fetchErr != nil {
+			writeWarnLog(fmt.Sprintf("%v. using the SQS defaults until the next refresh", fetchErr))
+		}
+		if err := sqsConf.queueAttributes.checkDeadLetterQueue(requireDlq, fetchErr); err != nil {
+			return nil, err
+		}
+		if fetchErr == nil && !fifoSet {
+			fifo = sqsConf.queueAttributes.fifo.Load()
+		}
+		if queueAttributesRefresh > 0 {
+			sqsConf.queueAttributes.start(sqsConf)
+		}
+
+		if queueTags != nil {
+			if err := tagQueue(sqsAPI, queueURL, queueTags); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if fifo && queueMessageGroupID == "" {
+		return nil, errors.New("QueueMessageGroupId configuration key is mandatory for FIFO queues: https://docs.aws.amazon.com/AWSSimpleQueueService/latest/APIReference/API_SendMessage.html")
+	}
+
+	if len(equivalentQueueURLs) > 0 {
+		switch {
+		case snsTopicArn != "" || eventBusName != "":
+			return nil, errors.New("EquivalentQueueUrls is only supported with an SQS queue")
+		case fifo:
+			return nil, errors.New("EquivalentQueueUrls is not supported with a FIFO queue, the order of a message group can't span queues")
+		}
+
+		sqsConf.queueSelector = newQueueSelector(sqsAPI, queueURL, equivalentQueueURLs, latencyProbeInterval)
+		sqsConf.queueSelector.probe()
+		writeInfoLog(fmt.Sprintf("sending to the fastest of %d equivalent queues, probed every %s. selected %s", len(sqsConf.queueSelector.queues), latencyProbeInterval, queueName(sqsConf.queueSelector.queueURL())))
+		sqsConf.queueSelector.start(sqsConf)
+	}
+
+	if failoverQueueURL != "" {
+		switch {
+		case snsTopicArn != "" || eventBusName != "":
+			return nil, errors.New("FailoverQueueUrl is only supported with an SQS queue")
+		case fifo:
+			return nil, errors.New("FailoverQueueUrl is not supported with a FIFO queue, the order of a message group can't span queues")
+		}
+
+		sqsConf.failover = newQueueFailover(sqsAPI, queueURL, failoverQueueURL, healthProbeInterval, failoverThreshold, failbackThreshold)
+		writeInfoLog(fmt.Sprintf("probing %s every %s, failing over to %s after %d failed probes and back after %d healthy ones", queueName(queueURL), healthProbeInterval, queueName(failoverQueueURL), failoverThreshold, failbackThreshold))
+		sqsConf.failover.start(sqsConf)
+	}
+
+	// the SNS topics and EventBridge buses have no per queue quotas, the side
+	// queues are always queues
+	if snsTopicArn == "" && eventBusName == "" {
+		paceQueue(sqsConf, queueURL, queueRequestsPerSecond(maxRequestsPerSecond, maxRequestsSet, fifo))
+	}
+	if failoverQueueURL != "" {
+		paceQueue(sqsConf, failoverQueueURL, queueRequestsPerSecond(maxRequestsPerSecond, maxRequestsSet, fifo))
+	}
+	for _, sideQueueURL := range []string{shadowQueueURL, invalidRecordQueueURL, mirrorQueueURL} {
+		if sideQueueURL != "" {
+			paceQueue(sqsConf, sideQueueURL, queueRequestsPerSecond(maxRequestsPerSecond, maxRequestsSet, strings.HasSuffix(sideQueueURL, ".fifo")))
+		}
+	}
+	for _, equivalentQueueURL := range equivalentQueueURLs {
+		paceQueue(sqsConf, equivalentQueueURL, queueRequestsPerSecond(maxRequestsPerSecond, maxRequestsSet, false))
+	}
+
+	if emfInterval != "" {
+		var writer emfWriter = stdoutEmfWriter{}
+		if emfLogGroup != "" {
+			writer = newLogGroupEmfWriter(cloudwatchlogs.NewFromConfig(awsConfig), emfLogGroup)
+		}
+
+		emitter, err := newEmfEmitter(sqsConf, emfInterval, emfNamespace, writer)
+		if err != nil {
+			return nil, err
+		}
+
+		writeInfoLog(fmt.Sprintf("emitting EMF metrics every %s", emfInterval))
+		emitter.start()
+	}
+
+	if statsdAddress != "" {
+		statsd, err := newStatsdEmitter(sqsConf, statsdAddress, statsdPrefix, statsdTags, statsdInterval)
+		if err != nil {
+			return nil, err
+		}
+
+		writeInfoLog(fmt.Sprintf("pushing StatsD metrics to %s every %s", statsdAddress, statsd.interval))
+		sqsConf.statsd = statsd
+		statsd.start()
+	}
+
+	if metricsListen != "" {
+		server, err := newMetricsServer(sqsConf, metricsListen)
+		if err != nil {
+			return nil, err
+		}
+
+		writeInfoLog(fmt.Sprintf("serving the plugin metrics on http://%s/api/v1/metrics", server.listener.Addr()))
+		server.start()
+	}
+
+	if otelEndpoint != "" {
+		if err := startTracing(otelEndpoint, otelServiceName); err != nil {
+			return nil, err
+		}
+		writeInfoLog(fmt.Sprintf("exporting OpenTelemetry spans to %s", otelEndpoint))
+	}
+
+	if summaryInterval != "" {
+		summary, err := newSummaryReporter(sqsConf, summaryInterval)
+		if err != nil {
+			return nil, err
+		}
+
+		writeInfoLog(fmt.Sprintf("logging a delivery summary every %s", summaryInterval))
+		summary.start()
+	}
+
+	dropWarningInterval, err := parseDropWarningInterval(dropWarningIntervalString)
+	if err != nil {
+		return nil, err
+	}
+
+	if dropWarningInterval > 0 {
+		newDropWarner(sqsConf, dropWarningInterval).start()
+	}
+
+	if auditLogFile != "" {
+		audit, err := openAuditLog(auditLogFile)
+		if err != nil {
+			return nil, err
+		}
+		sqsConf.auditLog = audit
+		registerReporter(sqsConf, audit)
+	}
+
+	dump, err := newDebugDump(debugDumpDir, debugDumpPercent, debugDumpMaxFiles)
+	if err != nil {
+		return nil, err
+	}
+	if dump != nil {
+		writeWarnLog(fmt.Sprintf("writing the message bodies to %s, they may hold sensitive data", debugDumpDir))
+		sqsConf.debugDump = dump
+	}
+
+	bufferMaxSize, err := parseBufferMaxSize(bufferMaxSizeString)
+	if err != nil {
+		return nil, err
+	}
+
+	bufferMaxAge, err := parseBufferMaxAge(bufferMaxAgeString)
+	if err != nil {
+		return nil, err
+	}
+
+	bufferReplayOrder, err := parseBufferReplayOrder(bufferReplayOrderString)
+	if err != nil {
+		return nil, err
+	}
+
+	bufferSync, err := parseBufferSync(bufferSyncString)
+	if err != nil {
+		return nil, err
+	}
+
+	avoidDuplicatesOnReplay, err := parseBool("AvoidDuplicatesOnReplay", avoidDuplicatesOnReplayString)
+	if err != nil {
+		return nil, err
+	}
+	if avoidDuplicatesOnReplay && bufferPath == "" {
+		return nil, errors.New("AvoidDuplicatesOnReplay requires BufferPath, the deduplication window is kept there")
+	}
+
+	if avoidDuplicatesOnReplay && fifo {
+		writeInfoLog("the FIFO queue deduplicates the messages sent again, AvoidDuplicatesOnReplay is ignored")
+	} else if avoidDuplicatesOnReplay {
+		window, err := openDedupWindow(bufferPath, dedupWindowBatches)
+		if err != nil {
+			return nil, err
+		}
+		writeInfoLog(fmt.Sprintf("skipping the replays of the disk buffer batches delivered among the last %d", dedupWindowBatches))
+		sqsConf.dedupWindow = window
+		registerReporter(sqsConf, window)
+	}
+
+	if bufferPath != "" {
+		buffer, err := newDiskBuffer(sqsConf, bufferPath, bufferMaxSize, bufferMaxAge, bufferSync)
+		if err != nil {
+			return nil, err
+		}
+		writeInfoLog(fmt.Sprintf("writing the batches to the disk buffer %s ahead of their send, up to %d bytes", bufferPath, bufferMaxSize))
+		sqsConf.diskBuffer = buffer
+		if sqsConf.sequence != nil {
+			if err := sqsConf.sequence.persistIn(bufferPath); err != nil {
+				return nil, err
+			}
+			registerReporter(sqsConf, sqsConf.sequence)
+		}
+		if bufferReplayOrder == replayFirst {
+			buffer.replay()
+		}
+		buffer.start(diskBufferRetryInterval)
+	}
+
+	heartbeatInterval, err := parseHeartbeatInterval(heartbeatIntervalString)
+	if err != nil {
+		return nil, err
+	}
+
+	if heartbeatInterval > 0 {
+		writeInfoLog(fmt.Sprintf("sending a heartbeat message every %s", heartbeatInterval))
+		newHeartbeat(sqsConf, heartbeatInterval).start()
+	}
+
+	if workers > 0 {
+		writeInfoLog(fmt.Sprintf("starting %d sender workers with at most %d in flight batches", workers, maxInFlightBatches))
+		sqsConf.senders = newSenderPool(sqsConf, workers, maxInFlightBatches)
+	}
+
+	registerInstance(sqsConf)
+
+	return sqsConf, nil
+}
+
+// flushChunk sends the records of a msgpack chunk of fluent bit
+func flushChunk(sqsConf *sqsConfig, tagStr string, chunk []byte) FlushStatus {
+	if sqsConf.tagFilter != nil && !sqsConf.tagFilter.allowed(tagStr) {
+		writeDebugLog(fmt.Sprintf("tag %s is filtered out by IncludeTags/ExcludeTags. skipping chunk", tagStr))
+		sqsConf.stats.countDroppedChunk(dropExcludedTag)
+		return FlushOK
+	}
+
+	switch checkAdmission(sqsConf, tagStr) {
+	case dropChunk:
+		return FlushOK
+	case retryChunk:
+		return FlushRetry
+	}
+
+	next := newChunkIterator(chunk)
+
+	if err := flushRecords(sqsConf, tagStr, next); err != nil {
+		if sqsConf.deferToEngine && sqsConf.retry.classes.retryableError(err) {
+			writeWarnLog(fmt.Sprintf("%v. handing the chunk back to fluent bit to retry", err))
+			sqsConf.stats.chunksRetried.Add(1)
+			return FlushRetry
+		}
+		writeErrorLog(err)
+		sqsConf.stats.chunksFailed.Add(1)
+		return FlushError
+	}
+
+	return FlushOK
+}
+
+func sendBatchToSqs(sqsConf *sqsConfig, sqsRecords []*types.SendMessageBatchRequestEntry) error {
+	if sqsConf.diskBuffer != nil {
+		return sqsConf.diskBuffer.send(sqsRecords)
+	}
+	return deliverBatch(sqsConf, sqsRecords)
+}
+
+// deliverBatch sends a batch to the destination
+func deliverBatch(sqsConf *sqsConfig, sqsRecords []*types.SendMessageBatchRequestEntry) error {
+	_, err := deliverEntries(sqsConf, sqsRecords)
+	return err
+}
+
+// deliverEntries sends a batch to the destination and returns its entries
+// which failed with a retryable error, the error is the one of the batch.
+// the queue is read once, the logs and metrics of the batch name the queue it
+// was sent to even when the selection changes meanwhile
+func deliverEntries(sqsConf *sqsConfig, sqsRecords []*types.SendMessageBatchRequestEntry) ([]*types.SendMessageBatchRequestEntry, error) {
+	queueURL := sqsConf.activeQueueURL()
+	batchID := strconv.FormatInt(sqsConf.stats.batches.Add(1), 10)
+
+	if sqsConf.debugDump != nil {
+		sqsConf.debugDump.write(time.Now(), queueURL, batchID, sqsRecords)
+	}
+
+	if sqsConf.messageIDLog != nil {
+		defer sqsConf.messageIDLog.forget(sqsRecords)
+	}
+
+	if sqsConf.throttle != nil {
+		sqsConf.throttle.acquire()
+	}
+	span := startSendSpan(queueURL, len(sqsRecords))
+	start := time.Now()
+	output, err := sqsConf.retry.sendBatch(context.Background(), sqsConf.mainSink(queueURL), queueURL, sqsRecords)
+	latency := time.Since(start)
+	if sqsConf.throttle != nil {
+		sqsConf.throttle.release(throttledSend(output, err))
+	}
+	endSendSpan(span, output, err)
+	sqsConf.stats.sendLatency.observe(latency)
+
+	if sqsConf.adaptiveBatch != nil {
+		sqsConf.adaptiveBatch.observe(latency, err != nil || len(output.Failed) > 0)
+	}
+
+	if sqsConf.statsd != nil {
+		sqsConf.statsd.timing("send_latency", queueURL, latency)
+	}
+
+	if sqsConf.auditLog != nil {
+		sqsConf.auditLog.record(time.Now(), queueURL, batchID, sqsRecords, output, err)
+	}
+
+	if err != nil {
+		sqsConf.stats.recordRequestError(sqsRecords, err)
+		return nil, &batchError{queueURL: queueURL, batchID: batchID, err: err}
+	}
+
+	sqsConf.stats.recordBatchResult(sqsRecords, output)
+
+	if sqsOutLogLevel == 0 {
+		logAcceptedMessages(sqsConf, queueURL, batchID, sqsRecords, output)
+	}
+
+	logBatchFailures(queueURL, batchID, sqsRecords, output)
+
+	retry, _ := sqsConf.retry.classes.split(output.Failed)
+	if len(retry) == 0 {
+		return nil, nil
+	}
+	failed := failedEntries(sqsRecords, retry)
+
+	// the failed messages are sent again with the chunk, unless they can't
+	// succeed
+	if sqsConf.deferToEngine {
+		return failed, &batchError{queueURL: queueURL, batchID: batchID, err: fmt.Errorf("%d of %d messages of batch %s failed", len(retry), len(sqsRecords), batchID)}
+	}
+
+	return failed, nil
+}
+
+func createRecordString(timestamp time.Time, tag string, record map[interface{}]interface{}) (string, error) {
+	return encodeRecord(encodeStandard, timestamp, tag, record)
+}
+
+// encodeRecord serializes the record with the given encoder, the default
+// streaming encoder is used when encoder is nil
+func encodeRecord(encoder recordEncoder, timestamp time.Time, tag string, record map[interface{}]interface{}) (string, error) {
+	if encoder == nil {
+		encoder = encodeFast
+	}
+
+	// convert timestamp to RFC3339Nano
+	js, err := encoder(timestamp.UTC().Format(time.RFC3339Nano), record)
+	if err != nil {
+		writeErrorLog(fmt.Errorf("error creating message for sqs. tag: %s. error: %v", tag, err))
+		return "", err
+	}
+
+	return js, nil
+}
+
+// copyRecordFields copies the record fields into m, converting byte slices to
+// strings to prevent encoding them to base64. keys which are not strings, as
+// msgpack allows, are formatted like the fast encoder does
+func copyRecordFields(m map[string]interface{}, record map[interface{}]interface{}) {
+	for k, v := range record {
+		switch t := v.(type) {
+		case []byte:
+			m[fieldString(k)] = bytesToString(t)
+		default:
+			m[fieldString(k)] = v
+		}
+	}
+}
+
+// recordField looks up a field of a decoded record. dotted keys such as
+// "kubernetes.namespace_name" are resolved through nested maps when the record
+// has no top level key with that exact name
+func recordField(record map[interface{}]interface{}, key string) (interface{}, bool) {
+	if value, ok := record[key]; ok {
+		return value, true
+	}
+
+	parts := strings.Split(key, ".")
+	if len(parts) == 1 {
+		return nil, false
+	}
+
+	var current interface{} = record
+	for _, part := range parts {
+		nested, ok := current.(map[interface{}]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = nested[part]; !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// fieldString returns the textual representation of a record value. byte
+// slices are converted as is instead of being formatted as a list of numbers
+func fieldString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return bytesToString(v)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// copyFieldString returns the string of a record field as a copy, for the
+// values kept while the record waits in a batch or a log. the byte fields
+// share the memory of the whole chunk, see bytesToString, and a small value
+// kept uncopied would hold the chunk in memory with it
+func copyFieldString(value interface{}) string {
+	return strings.Clone(fieldString(value))
+}
+
+// bytesToString converts a byte slice to a string without copying it. the
+// byte fields of decoded records point into the chunk copied out of fluent
+// bit memory, which is never modified, so the strings stay valid for as long
+// as they are referenced. they keep the whole chunk from being collected
+// meanwhile, the values kept past the record use copyFieldString
+func bytesToString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return unsafe.String(unsafe.SliceData(b), len(b))
+}
+
+func writeDebugLog(message string) {
+	if sqsOutLogLevel == 0 {
+		writeLog("debug", message, nil)
+	}
+}
+
+func writeInfoLog(message string) {
+	if sqsOutLogLevel <= 1 {
+		writeLog("info", message, nil)
+	}
+}
+
+func writeWarnLog(message string) {
+	if sqsOutLogLevel <= 1 {
+		writeLog("warn", message, nil)
+	}
+}
+
+func writeErrorLog(err error) {
+	if sqsOutLogLevel <= 2 {
+		writeLog("error", err.Error(), err)
+	}
+}
+
+func setLogLevel() {
+	logEnv := os.Getenv("SQS_OUT_LOG_LEVEL")
+
+	switch strings.ToLower(logEnv) {
+	case "debug":
+		sqsOutLogLevel = 0
+	case "info":
+		sqsOutLogLevel = 1
+	case "error":
+		sqsOutLogLevel = 2
+	default:
+		sqsOutLogLevel = 1 // info
+	}
+}
+
+// validateBatchSize checks BatchSize against the MaxBatchEntries ceiling
+func validateBatchSize(batchSizeString string, maxEntries int) bool {
+	batchSize, err := strconv.Atoi(batchSizeString)
+	if err != nil || batchSize < 1 || batchSize > maxEntries {
+		return false
+	}
+	return true
+}
+
+func validateQueueConfig(queueURL, queueRegion, queueMessageGroupID string) error {
+	if queueURL == "" {
+		return errors.New("QueueUrl configuration key is mandatory")
+	}
+
+	if queueRegion == "" {
+		return errors.New("QueueRegion configuration key is mandatory")
+	}
+
+	if strings.HasSuffix(queueURL, ".fifo") {
+		if queueMessageGroupID == "" {
+			return errors.New("QueueMessageGroupId configuration key is mandatory for FIFO queues")
+		}
+	}
+
+	return nil
+}
+
+// parseBool parses a true/false configuration value, empty is false
+func parseBool(key, value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "", "false", "off":
+		return false, nil
+	case "true", "on":
+		return true, nil
+	default:
+		return false, fmt.Errorf("%s should be true or false. got %q", key, value)
+	}
+}
+
+// splitConfigList splits a comma separated configuration value into its
+// trimmed, non empty items
+func splitConfigList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -1,7 +1,8 @@
-package main
+package sqsout
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
@@ -9,9 +10,11 @@ import (
 	"strings"
 	"testing"
 	"time"
+	"unsafe"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 )
 
 // fakeSQS implements sqsClient interface for testing
@@ -21,16 +24,17 @@ type fakeSQS struct {
 	err    error
 }
 
-func (f *fakeSQS) SendMessageBatch(input *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+func (f *fakeSQS) SendMessageBatch(ctx context.Context, input *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
 	f.input = input
 	return f.output, f.err
 }
 
 // resetGlobals resets package-level globals between tests
 func resetGlobals() {
-	MessageCounter = 0
-	SqsRecords = nil
 	sqsOutLogLevel = 1 // default to info
+	sqsOutLogJSON = false
+	logOutput = nil
+	closeLogFile()
 }
 
 // captureStdout captures stdout output during test execution
@@ -330,7 +334,7 @@ func TestSendBatchToSqs(t *testing.T) {
 	tests := []struct {
 		name          string
 		config        *sqsConfig
-		records       []*sqs.SendMessageBatchRequestEntry
+		records       []*types.SendMessageBatchRequestEntry
 		mockOutput    *sqs.SendMessageBatchOutput
 		mockErr       error
 		wantErr       bool
@@ -341,14 +345,14 @@ func TestSendBatchToSqs(t *testing.T) {
 			config: &sqsConfig{
 				queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
 			},
-			records: []*sqs.SendMessageBatchRequestEntry{
+			records: []*types.SendMessageBatchRequestEntry{
 				{
 					Id:          aws.String("msg-1"),
 					MessageBody: aws.String(`{"message":"test"}`),
 				},
 			},
 			mockOutput: &sqs.SendMessageBatchOutput{
-				Successful: []*sqs.SendMessageBatchResultEntry{
+				Successful: []types.SendMessageBatchResultEntry{
 					{Id: aws.String("msg-1")},
 				},
 			},
@@ -368,13 +372,13 @@ func TestSendBatchToSqs(t *testing.T) {
 			config: &sqsConfig{
 				queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
 			},
-			records: []*sqs.SendMessageBatchRequestEntry{
+			records: []*types.SendMessageBatchRequestEntry{
 				{Id: aws.String("msg-1"), MessageBody: aws.String(`{"id":1}`)},
 				{Id: aws.String("msg-2"), MessageBody: aws.String(`{"id":2}`)},
 				{Id: aws.String("msg-3"), MessageBody: aws.String(`{"id":3}`)},
 			},
 			mockOutput: &sqs.SendMessageBatchOutput{
-				Successful: []*sqs.SendMessageBatchResultEntry{
+				Successful: []types.SendMessageBatchResultEntry{
 					{Id: aws.String("msg-1")},
 					{Id: aws.String("msg-2")},
 					{Id: aws.String("msg-3")},
@@ -393,7 +397,7 @@ func TestSendBatchToSqs(t *testing.T) {
 			config: &sqsConfig{
 				queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
 			},
-			records: []*sqs.SendMessageBatchRequestEntry{
+			records: []*types.SendMessageBatchRequestEntry{
 				{Id: aws.String("msg-1"), MessageBody: aws.String(`{"message":"test"}`)},
 			},
 			mockOutput: nil,
@@ -405,15 +409,15 @@ func TestSendBatchToSqs(t *testing.T) {
 			config: &sqsConfig{
 				queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
 			},
-			records: []*sqs.SendMessageBatchRequestEntry{
+			records: []*types.SendMessageBatchRequestEntry{
 				{Id: aws.String("msg-1"), MessageBody: aws.String(`{"id":1}`)},
 				{Id: aws.String("msg-2"), MessageBody: aws.String(`{"id":2}`)},
 			},
 			mockOutput: &sqs.SendMessageBatchOutput{
-				Successful: []*sqs.SendMessageBatchResultEntry{
+				Successful: []types.SendMessageBatchResultEntry{
 					{Id: aws.String("msg-1")},
 				},
-				Failed: []*sqs.BatchResultErrorEntry{
+				Failed: []types.BatchResultErrorEntry{
 					{Id: aws.String("msg-2"), Code: aws.String("InternalError")},
 				},
 			},
@@ -467,7 +471,7 @@ func TestValidateBatchSize(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			resetGlobals()
 
-			isValid := validateBatchSize(tt.input)
+			isValid := validateBatchSize(tt.input, 10)
 			if isValid != tt.isValid {
 				t.Errorf("validateBatchSize(%q) = %v, want %v", tt.input, isValid, tt.isValid)
 			}
@@ -563,34 +567,142 @@ func TestSqsConfigFields(t *testing.T) {
 	}
 }
 
-func TestMessageCounterAndSqsRecords(t *testing.T) {
-	resetGlobals()
+func TestSplitConfigList(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"", nil},
+		{"a", []string{"a"}},
+		{"a, b ,c", []string{"a", "b", "c"}},
+		{" , a,,", []string{"a"}},
+	}
+
+	for _, tt := range tests {
+		got := splitConfigList(tt.input)
+		if len(got) != len(tt.want) {
+			t.Errorf("splitConfigList(%q) = %v, want %v", tt.input, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("splitConfigList(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		}
+	}
+}
 
-	if MessageCounter != 0 {
-		t.Errorf("MessageCounter should be 0 after reset, got %d", MessageCounter)
+func TestRecordField(t *testing.T) {
+	record := map[interface{}]interface{}{
+		"message":     []byte("hello"),
+		"dotted.name": "top level",
+		"kubernetes": map[interface{}]interface{}{
+			"namespace_name": []byte("default"),
+			"labels": map[interface{}]interface{}{
+				"app": "web",
+			},
+		},
 	}
-	if SqsRecords != nil {
-		t.Errorf("SqsRecords should be nil after reset")
+
+	tests := []struct {
+		key    string
+		want   string
+		wantOk bool
+	}{
+		{"message", "hello", true},
+		{"dotted.name", "top level", true},
+		{"kubernetes.namespace_name", "default", true},
+		{"kubernetes.labels.app", "web", true},
+		{"kubernetes.missing", "", false},
+		{"message.nested", "", false},
+		{"missing", "", false},
 	}
 
-	MessageCounter = 5
-	SqsRecords = []*sqs.SendMessageBatchRequestEntry{
-		{Id: aws.String("test")},
+	for _, tt := range tests {
+		value, ok := recordField(record, tt.key)
+		if ok != tt.wantOk {
+			t.Errorf("recordField(%q) ok = %v, want %v", tt.key, ok, tt.wantOk)
+			continue
+		}
+		if ok && fieldString(value) != tt.want {
+			t.Errorf("recordField(%q) = %v, want %v", tt.key, fieldString(value), tt.want)
+		}
 	}
+}
 
-	if MessageCounter != 5 {
-		t.Errorf("MessageCounter should be 5, got %d", MessageCounter)
+func TestFieldString(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{"text", "text"},
+		{[]byte("bytes"), "bytes"},
+		{nil, ""},
+		{42, "42"},
+		{true, "true"},
+		{3.5, "3.5"},
 	}
-	if len(SqsRecords) != 1 {
-		t.Errorf("SqsRecords should have 1 entry, got %d", len(SqsRecords))
+
+	for _, tt := range tests {
+		if got := fieldString(tt.value); got != tt.want {
+			t.Errorf("fieldString(%v) = %q, want %q", tt.value, got, tt.want)
+		}
 	}
+}
 
-	resetGlobals()
+func TestBytesToString(t *testing.T) {
+	if got := bytesToString(nil); got != "" {
+		t.Errorf("expected empty string for nil, got %q", got)
+	}
+	if got := bytesToString([]byte{}); got != "" {
+		t.Errorf("expected empty string for an empty slice, got %q", got)
+	}
 
-	if MessageCounter != 0 {
-		t.Errorf("MessageCounter should be 0 after second reset, got %d", MessageCounter)
+	b := []byte(strings.Repeat("a long log line ", 1024))
+	s := bytesToString(b)
+	if s != string(b) {
+		t.Fatal("expected the string to hold the bytes")
+	}
+	if unsafe.StringData(s) != &b[0] {
+		t.Error("expected the string to share the memory of the byte slice")
 	}
-	if SqsRecords != nil {
-		t.Errorf("SqsRecords should be nil after second reset")
+
+	var field interface{} = b
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = fieldString(field)
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocation converting a byte field, got %v", allocs)
+	}
+}
+
+func TestWriteWarnLog(t *testing.T) {
+	tests := []struct {
+		name        string
+		logLevel    int
+		shouldPrint bool
+	}{
+		{"prints at debug level", 0, true},
+		{"prints at info level", 1, true},
+		{"silent at error level", 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetGlobals()
+			sqsOutLogLevel = tt.logLevel
+
+			output := captureStdout(func() {
+				writeWarnLog("test message")
+			})
+
+			hasOutput := len(output) > 0
+			if hasOutput != tt.shouldPrint {
+				t.Errorf("writeWarnLog() output = %v, shouldPrint = %v", hasOutput, tt.shouldPrint)
+			}
+			if tt.shouldPrint && !strings.Contains(output, "warn") {
+				t.Errorf("output doesn't contain 'warn': %s", output)
+			}
+		})
 	}
 }
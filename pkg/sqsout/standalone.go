@@ -0,0 +1,185 @@
+package sqsout
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// maxStandaloneLineBytes is the longest NDJSON line the standalone mode reads
+const maxStandaloneLineBytes = 1024 * 1024
+
+// configParams are the plugin configuration keys given with -p Key=Value.
+// the keys are case insensitive, like in the fluent bit configuration
+type configParams map[string]string
+
+func (p configParams) String() string {
+	params := make([]string, 0, len(p))
+	for key, value := range p {
+		params = append(params, key+"="+value)
+	}
+	return strings.Join(params, " ")
+}
+
+func (p configParams) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || strings.TrimSpace(key) == "" {
+		return fmt.Errorf("expected Key=Value, got %q", value)
+	}
+	p[strings.ToLower(strings.TrimSpace(key))] = val
+	return nil
+}
+
+func (p configParams) get(key string) string {
+	return p[strings.ToLower(key)]
+}
+
+// RunStandalone sends the NDJSON records read from stdin through the plugin
+// pipeline, configured with the same keys as in fluent bit, so a queue and
+// the message format can be tried without fluent bit. it returns the exit
+// code, non zero when a line could not be parsed or a batch failed.
+// with -replay, the message bodies captured by DebugDumpDir are re-sent
+// instead, at most -rate messages per second
+func RunStandalone(args []string, stdin io.Reader) int {
+	params := configParams{}
+	flags := flag.NewFlagSet("sqs-out", flag.ContinueOnError)
+	flags.Var(params, "p", "plugin configuration `Key=Value`, can be repeated")
+	tag := flags.String("tag", "stdin", "tag of the records")
+	replay := flags.String("replay", "", "re-send the message bodies of a DebugDumpDir `file or directory` instead of reading stdin")
+	rate := flags.Float64("rate", 0, "maximum `messages` per second sent by -replay, 0 is unlimited")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	limiter, err := newRateLimiter(*rate)
+	if err != nil {
+		writeErrorLog(err)
+		return 2
+	}
+	var files []string
+	if *replay != "" {
+		if files, err = replayFiles(*replay); err != nil {
+			writeErrorLog(fmt.Errorf("invalid -replay: %v", err))
+			return 2
+		}
+	}
+
+	setLogLevel()
+	setLogFormat()
+
+	sqsConf, err := initPlugin(params.get)
+	if err != nil {
+		writeErrorLog(err)
+		return 1
+	}
+
+	exitCode := 0
+	invalid := new(int)
+	if files != nil {
+		if *invalid, err = replayMessages(sqsConf, *tag, files, limiter); err != nil {
+			writeErrorLog(err)
+			exitCode = 1
+		}
+	} else {
+		var next RecordIterator
+		next, invalid = ndjsonIterator(stdin)
+		if err := flushRecords(sqsConf, *tag, next); err != nil {
+			writeErrorLog(err)
+			exitCode = 1
+		}
+	}
+	if err := flushPendingBatches(sqsConf); err != nil {
+		writeErrorLog(err)
+		exitCode = 1
+	}
+	if *invalid > 0 || sqsConf.stats.messagesFailed.Load() > 0 {
+		exitCode = 1
+	}
+
+	Shutdown()
+	return exitCode
+}
+
+// ndjsonIterator iterates the JSON objects of the lines of r. the lines which
+// are not JSON objects are logged and skipped, invalid counts them once the
+// iteration is over. the records are timestamped when they are read
+func ndjsonIterator(r io.Reader) (RecordIterator, *int) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStandaloneLineBytes)
+	invalid := new(int)
+	line := 0
+
+	return func() (time.Time, map[interface{}]interface{}, bool) {
+		for scanner.Scan() {
+			line++
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+
+			record, err := parseNDJSONRecord(text)
+			if err != nil {
+				writeErrorLog(fmt.Errorf("line %d: %v", line, err))
+				*invalid++
+				continue
+			}
+			return time.Now(), record, true
+		}
+
+		if err := scanner.Err(); err != nil {
+			writeErrorLog(fmt.Errorf("failed to read the input after line %d: %v", line, err))
+			*invalid++
+		}
+		return time.Time{}, nil, false
+	}, invalid
+}
+
+// parseNDJSONRecord converts a JSON object to a record with the types the
+// chunk decoder produces: integers as int64 and other numbers as float64
+func parseNDJSONRecord(text string) (map[interface{}]interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+
+	var object interface{}
+	if err := decoder.Decode(&object); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if decoder.More() {
+		return nil, errors.New("more than one JSON value on the line")
+	}
+
+	record, ok := convertJSONValue(object).(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("the line is not a JSON object")
+	}
+	return record, nil
+}
+
+func convertJSONValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for key, item := range v {
+			m[key] = convertJSONValue(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = convertJSONValue(item)
+		}
+		return v
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	default:
+		return v
+	}
+}
@@ -0,0 +1,189 @@
+package sqsout
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConfigParams(t *testing.T) {
+	params := configParams{}
+	for _, value := range []string{"QueueUrl=https://sqs.us-east-1.amazonaws.com/123456789/test-queue", " BatchSize =2", "Empty="} {
+		if err := params.Set(value); err != nil {
+			t.Fatalf("Set(%q) unexpected error: %v", value, err)
+		}
+	}
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"QueueUrl", "https://sqs.us-east-1.amazonaws.com/123456789/test-queue"},
+		{"queueurl", "https://sqs.us-east-1.amazonaws.com/123456789/test-queue"},
+		{"BatchSize", "2"},
+		{"Empty", ""},
+		{"Missing", ""},
+	}
+	for _, tt := range tests {
+		if got := params.get(tt.key); got != tt.want {
+			t.Errorf("get(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+
+	for _, value := range []string{"NoValue", "=value"} {
+		if err := params.Set(value); err == nil {
+			t.Errorf("Set(%q) expected an error", value)
+		}
+	}
+}
+
+func TestParseNDJSONRecord(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    map[interface{}]interface{}
+		wantErr bool
+	}{
+		{
+			line: `{"log":"hello","count":3,"ratio":0.5,"ok":true,"none":null}`,
+			want: map[interface{}]interface{}{"log": "hello", "count": int64(3), "ratio": 0.5, "ok": true, "none": nil},
+		},
+		{
+			line: `{"kubernetes":{"pod":"web-1"},"tags":["a",1]}`,
+			want: map[interface{}]interface{}{
+				"kubernetes": map[interface{}]interface{}{"pod": "web-1"},
+				"tags":       []interface{}{"a", int64(1)},
+			},
+		},
+		{line: `["not","an","object"]`, wantErr: true},
+		{line: `{"log":`, wantErr: true},
+		{line: `{"a":1} {"b":2}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseNDJSONRecord(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseNDJSONRecord(%q) error = %v, wantErr %v", tt.line, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseNDJSONRecord(%q) = %#v, want %#v", tt.line, got, tt.want)
+		}
+	}
+}
+
+// fakeSQSEndpoint answers SendMessageBatch requests of the SQS JSON protocol,
+// accepting every entry
+type fakeSQSEndpoint struct {
+	mu     sync.Mutex
+	bodies []string
+	// authorizations are the Authorization headers of the requests
+	authorizations []string
+	// attributes are the queue attributes returned by GetQueueAttributes
+	attributes map[string]string
+}
+
+func (f *fakeSQSEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Amz-Target") == "AmazonSQS.GetQueueAttributes" {
+		f.mu.Lock()
+		f.authorizations = append(f.authorizations, r.Header.Get("Authorization"))
+		f.mu.Unlock()
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		json.NewEncoder(w).Encode(map[string]interface{}{"Attributes": f.attributes})
+		return
+	}
+
+	var input struct {
+		Entries []struct {
+			Id          string
+			MessageBody string
+		}
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type resultEntry struct {
+		Id               string
+		MessageId        string
+		MD5OfMessageBody string
+	}
+	var successful []resultEntry
+
+	f.mu.Lock()
+	f.authorizations = append(f.authorizations, r.Header.Get("Authorization"))
+	for _, entry := range input.Entries {
+		f.bodies = append(f.bodies, entry.MessageBody)
+		sum := md5.Sum([]byte(entry.MessageBody))
+		successful = append(successful, resultEntry{Id: entry.Id, MessageId: "id-" + entry.Id, MD5OfMessageBody: hex.EncodeToString(sum[:])})
+	}
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	json.NewEncoder(w).Encode(map[string]interface{}{"Successful": successful, "Failed": []interface{}{}})
+}
+
+func TestRunStandalone(t *testing.T) {
+	resetGlobals()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	fake := &fakeSQSEndpoint{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	args := []string{
+		"-p", "QueueUrl=" + server.URL + "/123456789/test-queue",
+		"-p", "QueueRegion=us-east-1",
+		"-p", "Endpoint=" + server.URL,
+		"-p", "BatchSize=2",
+		"-tag", "smoke",
+		"-p", "PluginTagAttribute=tag",
+	}
+
+	t.Run("every record is sent", func(t *testing.T) {
+		input := "{\"log\":\"first\"}\n\n{\"log\":\"second\"}\n{\"log\":\"third\"}\n"
+		var code int
+		captureStdout(func() { code = RunStandalone(args, strings.NewReader(input)) })
+
+		if code != 0 {
+			t.Errorf("expected exit code 0, got %d", code)
+		}
+		if len(fake.bodies) != 3 {
+			t.Fatalf("expected 3 messages, the last batch partial, got %v", fake.bodies)
+		}
+		for i, log := range []string{"first", "second", "third"} {
+			if !strings.Contains(fake.bodies[i], `"log":"`+log+`"`) {
+				t.Errorf("unexpected message %d: %s", i, fake.bodies[i])
+			}
+		}
+	})
+
+	t.Run("invalid lines fail the run", func(t *testing.T) {
+		var code int
+		output := captureStdout(func() { code = RunStandalone(args, strings.NewReader("not json\n{\"log\":\"ok\"}\n")) })
+
+		if code != 1 {
+			t.Errorf("expected exit code 1, got %d", code)
+		}
+		if !strings.Contains(output, "line 1: invalid JSON") {
+			t.Errorf("expected the invalid line to be logged, got %q", output)
+		}
+		if last := fake.bodies[len(fake.bodies)-1]; !strings.Contains(last, `"log":"ok"`) {
+			t.Errorf("expected the valid line to be sent, got %s", last)
+		}
+	})
+
+	t.Run("invalid configuration", func(t *testing.T) {
+		var code int
+		captureStdout(func() { code = RunStandalone([]string{"-p", "QueueRegion=us-east-1"}, strings.NewReader("")) })
+		if code != 1 {
+			t.Errorf("expected exit code 1, got %d", code)
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package sqsout
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+)
+
+// deliveryStats are the delivery counters of a plugin instance. they are
+// atomic so they stay correct under concurrent flush callbacks and senders
+// and can be read at any time by the metrics and log reporting
+type deliveryStats struct {
+	recordsIn       atomic.Int64
+	messagesSent    atomic.Int64
+	messagesFailed  atomic.Int64
+	bytesSerialized atomic.Int64
+	bytesSent       atomic.Int64
+	bytesFailed     atomic.Int64
+	// bytesRejected are the bytes of the messages over the SQS size limit,
+	// which are dropped before they are batched
+	bytesRejected atomic.Int64
+	// bytesEvicted are the bytes of the batches evicted from the disk buffer
+	bytesEvicted      atomic.Int64
+	recordsDropped    atomic.Int64
+	messagesRetried   atomic.Int64
+	chunksRetried     atomic.Int64
+	chunksFailed      atomic.Int64
+	chunksDropped     atomic.Int64
+	messagesThrottled atomic.Int64
+	// batches numbers the batch sends, the number is the batch id in the logs
+	batches atomic.Int64
+	// sendLatency is the histogram of the SendMessageBatch round trips
+	sendLatency latencyHistogram
+	// failureCodes counts the rejected batch entries by error code
+	failureCodes failureCounts
+	// drops counts the discarded records and chunks by reason
+	drops dropCounts
+}
+
+// statsSnapshot are the counters the periodic metric reporters send, as
+// deltas since their previous report
+type statsSnapshot struct {
+	recordsIn       int64
+	sent            int64
+	failed          int64
+	throttled       int64
+	retried         int64
+	dropped         int64
+	bytesSerialized int64
+	bytesSent       int64
+	bytesFailed     int64
+	bytesRejected   int64
+	bytesEvicted    int64
+	latency         histogramSnapshot
+}
+
+func (s *deliveryStats) snapshot() statsSnapshot {
+	return statsSnapshot{
+		recordsIn:       s.recordsIn.Load(),
+		sent:            s.messagesSent.Load(),
+		failed:          s.messagesFailed.Load(),
+		throttled:       s.messagesThrottled.Load(),
+		retried:         s.messagesRetried.Load(),
+		dropped:         s.recordsDropped.Load(),
+		bytesSerialized: s.bytesSerialized.Load(),
+		bytesSent:       s.bytesSent.Load(),
+		bytesFailed:     s.bytesFailed.Load(),
+		bytesRejected:   s.bytesRejected.Load(),
+		bytesEvicted:    s.bytesEvicted.Load(),
+		latency:         s.sendLatency.snapshot(),
+	}
+}
+
+// recordBatchResult accounts the outcome of a batch send. when the request
+// itself failed output is nil and every entry counts as failed
+func (s *deliveryStats) recordBatchResult(records []*types.SendMessageBatchRequestEntry, output *sqs.SendMessageBatchOutput) {
+	total := batchBytes(records)
+
+	if output == nil {
+		s.messagesFailed.Add(int64(len(records)))
+		s.bytesFailed.Add(total)
+		return
+	}
+
+	for _, failed := range output.Failed {
+		s.failureCodes.add(aws.ToString(failed.Code))
+		if failed.Code != nil && isThrottlingCode(*failed.Code) {
+			s.messagesThrottled.Add(1)
+		}
+	}
+
+	var failedBytes int64
+	if len(output.Failed) > 0 {
+		failedIDs := make(map[string]bool, len(output.Failed))
+		for _, failed := range output.Failed {
+			if failed.Id != nil {
+				failedIDs[*failed.Id] = true
+			}
+		}
+		for _, entry := range records {
+			if entry.Id != nil && failedIDs[*entry.Id] {
+				failedBytes += entryBytes(entry)
+			}
+		}
+	}
+
+	s.messagesSent.Add(int64(len(output.Successful)))
+	s.messagesFailed.Add(int64(len(output.Failed)))
+	s.bytesSent.Add(total - failedBytes)
+	s.bytesFailed.Add(failedBytes)
+}
+
+// recordRequestError accounts a failed request. throttled requests are
+// counted apart so throttling can be told from other failures
+func (s *deliveryStats) recordRequestError(records []*types.SendMessageBatchRequestEntry, err error) {
+	s.recordBatchResult(records, nil)
+
+	if isThrottlingCode(awsErrorCode(err)) {
+		s.messagesThrottled.Add(int64(len(records)))
+	}
+}
+
+// isThrottlingCode returns true for the error codes aws services use when a
+// request is throttled
+func isThrottlingCode(code string) bool {
+	if code == "" {
+		return false
+	}
+	_, throttle := retry.DefaultThrottleErrorCodes[code]
+	return throttle || strings.Contains(code, "Throttl")
+}
+
+// awsErrorCode returns the error code of an aws service error, or "" for
+// other errors such as network failures
+func awsErrorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+// entryBytes is the size of the message body of a batch entry
+func entryBytes(entry *types.SendMessageBatchRequestEntry) int64 {
+	if entry.MessageBody == nil {
+		return 0
+	}
+	return int64(len(*entry.MessageBody))
+}
+
+// batchBytes is the total size of the message bodies of a batch
+func batchBytes(records []*types.SendMessageBatchRequestEntry) int64 {
+	var total int64
+	for _, entry := range records {
+		total += entryBytes(entry)
+	}
+	return total
+}
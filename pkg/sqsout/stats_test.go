@@ -0,0 +1,102 @@
+package sqsout
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestDeliveryStatsRecordBatchResult(t *testing.T) {
+	records := []*types.SendMessageBatchRequestEntry{
+		{Id: aws.String("msg-1"), MessageBody: aws.String("12345")},
+		{Id: aws.String("msg-2"), MessageBody: aws.String("123")},
+		{Id: aws.String("msg-3"), MessageBody: aws.String("1")},
+	}
+
+	t.Run("partial failure", func(t *testing.T) {
+		var stats deliveryStats
+		stats.recordBatchResult(records, &sqs.SendMessageBatchOutput{
+			Successful: []types.SendMessageBatchResultEntry{{Id: aws.String("msg-1")}, {Id: aws.String("msg-3")}},
+			Failed:     []types.BatchResultErrorEntry{{Id: aws.String("msg-2")}},
+		})
+
+		if stats.messagesSent.Load() != 2 || stats.messagesFailed.Load() != 1 {
+			t.Errorf("unexpected message counters: sent=%d failed=%d", stats.messagesSent.Load(), stats.messagesFailed.Load())
+		}
+		if stats.bytesSent.Load() != 6 || stats.bytesFailed.Load() != 3 {
+			t.Errorf("unexpected byte counters: sent=%d failed=%d", stats.bytesSent.Load(), stats.bytesFailed.Load())
+		}
+	})
+
+	t.Run("request error", func(t *testing.T) {
+		var stats deliveryStats
+		stats.recordBatchResult(records, nil)
+
+		if stats.messagesSent.Load() != 0 || stats.messagesFailed.Load() != 3 || stats.bytesFailed.Load() != 9 {
+			t.Errorf("unexpected counters: sent=%d failed=%d bytesFailed=%d", stats.messagesSent.Load(), stats.messagesFailed.Load(), stats.bytesFailed.Load())
+		}
+	})
+
+	t.Run("concurrent updates", func(t *testing.T) {
+		var stats deliveryStats
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				stats.recordBatchResult(records, &sqs.SendMessageBatchOutput{
+					Successful: make([]types.SendMessageBatchResultEntry, 3),
+				})
+			}()
+		}
+		wg.Wait()
+
+		if stats.messagesSent.Load() != 150 || stats.bytesSent.Load() != 450 {
+			t.Errorf("unexpected counters: sent=%d bytesSent=%d", stats.messagesSent.Load(), stats.bytesSent.Load())
+		}
+	})
+}
+
+func TestDeliveryStatsThrottling(t *testing.T) {
+	records := []*types.SendMessageBatchRequestEntry{
+		{Id: aws.String("msg-1"), MessageBody: aws.String("1")},
+		{Id: aws.String("msg-2"), MessageBody: aws.String("2")},
+	}
+
+	t.Run("throttled request", func(t *testing.T) {
+		var stats deliveryStats
+		stats.recordRequestError(records, &smithy.GenericAPIError{Code: "ThrottlingException", Message: "rate exceeded"})
+
+		if stats.messagesFailed.Load() != 2 || stats.messagesThrottled.Load() != 2 {
+			t.Errorf("unexpected counters: failed=%d throttled=%d", stats.messagesFailed.Load(), stats.messagesThrottled.Load())
+		}
+	})
+
+	t.Run("other request errors", func(t *testing.T) {
+		var stats deliveryStats
+		stats.recordRequestError(records, errors.New("connection reset"))
+
+		if stats.messagesFailed.Load() != 2 || stats.messagesThrottled.Load() != 0 {
+			t.Errorf("unexpected counters: failed=%d throttled=%d", stats.messagesFailed.Load(), stats.messagesThrottled.Load())
+		}
+	})
+
+	t.Run("throttled entries", func(t *testing.T) {
+		var stats deliveryStats
+		stats.recordBatchResult(records, &sqs.SendMessageBatchOutput{
+			Failed: []types.BatchResultErrorEntry{
+				{Id: aws.String("msg-1"), Code: aws.String("RequestThrottled")},
+				{Id: aws.String("msg-2"), Code: aws.String("InternalError")},
+			},
+		})
+
+		if stats.messagesThrottled.Load() != 1 {
+			t.Errorf("expected 1 throttled message, got %d", stats.messagesThrottled.Load())
+		}
+	})
+}
@@ -0,0 +1,183 @@
+package sqsout
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultStatsdPrefix is the prefix of the StatsD metric names when
+// StatsdPrefix is not set
+const defaultStatsdPrefix = "fluentbit.sqs"
+
+// defaultStatsdInterval is how often the counters are pushed when
+// StatsdInterval is not set
+const defaultStatsdInterval = 10 * time.Second
+
+// statsdEmitter pushes the delivery counters at every interval and the send
+// latencies as they happen to a StatsD or DogStatsD agent over UDP
+type statsdEmitter struct {
+	sqsConf  *sqsConfig
+	conn     net.Conn
+	prefix   string
+	tags     string
+	interval time.Duration
+	// last holds the counters of the previous flush of each destination
+	last     map[string]statsSnapshot
+	stopCh   chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func validateStatsdConfig(address, prefix, tags, interval string) error {
+	if address == "" && (prefix != "" || tags != "" || interval != "") {
+		return errors.New("StatsdPrefix, StatsdTags and StatsdInterval require StatsdAddress to be set")
+	}
+	return nil
+}
+
+func newStatsdEmitter(sqsConf *sqsConfig, address, prefix, tagsString, intervalString string) (*statsdEmitter, error) {
+	interval := defaultStatsdInterval
+	if intervalString != "" {
+		var err error
+		interval, err = time.ParseDuration(intervalString)
+		if err != nil || interval <= 0 {
+			return nil, errors.New("StatsdInterval should be a positive duration, e.g. 10s")
+		}
+	}
+
+	tags, err := parseStatsdTags(tagsString)
+	if err != nil {
+		return nil, err
+	}
+
+	if prefix == "" {
+		prefix = defaultStatsdPrefix
+	}
+
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid StatsdAddress %s: %v", address, err)
+	}
+
+	return &statsdEmitter{
+		sqsConf:  sqsConf,
+		conn:     conn,
+		prefix:   strings.TrimSuffix(prefix, "."),
+		tags:     tags,
+		interval: interval,
+		last:     map[string]statsSnapshot{},
+		stopCh:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// parseStatsdTags parses the comma separated DogStatsD tags, they are added
+// to the queue tag of every metric line
+func parseStatsdTags(tagsString string) (string, error) {
+	if tagsString == "" {
+		return "", nil
+	}
+
+	var tags []string
+	for _, tag := range strings.Split(tagsString, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || strings.ContainsAny(tag, "|#\n") {
+			return "", fmt.Errorf("invalid StatsdTags entry %q, expected a comma separated list like env:prod,team:core", tag)
+		}
+		tags = append(tags, tag)
+	}
+	return "," + strings.Join(tags, ","), nil
+}
+
+// start pushes the counters every interval until stop is called
+func (s *statsdEmitter) start() {
+	registerReporter(s.sqsConf, s)
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.flush()
+			case <-s.stopCh:
+				s.flush()
+				return
+			}
+		}
+	}()
+}
+
+// stop pushes the last counters and closes the connection. it is safe to
+// call more than once
+func (s *statsdEmitter) stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+		<-s.done
+		s.conn.Close()
+	})
+}
+
+// flush sends the counters of every destination since the previous flush in
+// a single packet
+func (s *statsdEmitter) flush() {
+	destinations := s.sqsConf.destinations()
+	current := make([]statsSnapshot, len(destinations))
+
+	var lines []string
+	for i, d := range destinations {
+		current[i] = d.stats.snapshot()
+		last := s.last[d.queueURL]
+		counters := []struct {
+			name  string
+			value int64
+		}{
+			{"messages_sent", current[i].sent - last.sent},
+			{"messages_failed", current[i].failed - last.failed},
+			{"messages_throttled", current[i].throttled - last.throttled},
+			{"messages_retried", current[i].retried - last.retried},
+			{"records_dropped", current[i].dropped - last.dropped},
+			{"bytes_serialized", current[i].bytesSerialized - last.bytesSerialized},
+			{"bytes_sent", current[i].bytesSent - last.bytesSent},
+			{"bytes_failed", current[i].bytesFailed - last.bytesFailed},
+			{"bytes_rejected", current[i].bytesRejected - last.bytesRejected},
+			{"bytes_evicted", current[i].bytesEvicted - last.bytesEvicted},
+		}
+
+		for _, counter := range counters {
+			lines = append(lines, s.line(counter.name, counter.value, "c", d.queueURL))
+		}
+	}
+
+	if err := s.send(strings.Join(lines, "\n")); err != nil {
+		return
+	}
+	for i, d := range destinations {
+		s.last[d.queueURL] = current[i]
+	}
+}
+
+// timing sends a send latency of a queue in milliseconds
+func (s *statsdEmitter) timing(name, queueURL string, latency time.Duration) {
+	s.send(s.line(name, latency.Milliseconds(), "ms", queueURL))
+}
+
+// line formats a metric line tagged with the queue name
+func (s *statsdEmitter) line(name string, value int64, metricType, queueURL string) string {
+	return fmt.Sprintf("%s.%s:%d|%s|#queue:%s%s", s.prefix, name, value, metricType, queueName(queueURL), s.tags)
+}
+
+func (s *statsdEmitter) send(packet string) error {
+	if _, err := s.conn.Write([]byte(packet)); err != nil {
+		writeErrorLog(fmt.Errorf("failed to send StatsD metrics: %v", err))
+		return err
+	}
+	return nil
+}
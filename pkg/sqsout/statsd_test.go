@@ -0,0 +1,176 @@
+package sqsout
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenStatsd returns a local UDP listener standing in for the StatsD agent
+func listenStatsd(t *testing.T) net.PacketConn {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readPacket(t *testing.T, conn net.PacketConn) string {
+	t.Helper()
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("failed to read packet: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestValidateStatsdConfig(t *testing.T) {
+	tests := []struct {
+		address  string
+		prefix   string
+		tags     string
+		interval string
+		wantErr  bool
+	}{
+		{"", "", "", "", false},
+		{"127.0.0.1:8125", "", "", "", false},
+		{"127.0.0.1:8125", "app", "env:prod", "5s", false},
+		{"", "app", "", "", true},
+		{"", "", "env:prod", "", true},
+		{"", "", "", "5s", true},
+	}
+
+	for _, tt := range tests {
+		err := validateStatsdConfig(tt.address, tt.prefix, tt.tags, tt.interval)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateStatsdConfig(%q, %q, %q, %q) error = %v, wantErr %v", tt.address, tt.prefix, tt.tags, tt.interval, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseStatsdTags(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"env:prod", ",env:prod", false},
+		{"env:prod, team:core", ",env:prod,team:core", false},
+		{"env:prod,,team:core", "", true},
+		{"env:prod|c", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseStatsdTags(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseStatsdTags(%q) = %q, %v, want %q, wantErr %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNewStatsdEmitter(t *testing.T) {
+	tests := []struct {
+		address      string
+		prefix       string
+		interval     string
+		wantPrefix   string
+		wantInterval time.Duration
+		wantErr      bool
+	}{
+		{"127.0.0.1:8125", "", "", defaultStatsdPrefix, defaultStatsdInterval, false},
+		{"127.0.0.1:8125", "app.sqs.", "1m", "app.sqs", time.Minute, false},
+		{"127.0.0.1:8125", "", "0s", "", 0, true},
+		{"127.0.0.1:8125", "", "often", "", 0, true},
+		{"no-port", "", "", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := newStatsdEmitter(&sqsConfig{}, tt.address, tt.prefix, "", tt.interval)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newStatsdEmitter(%q, %q, %q) error = %v, wantErr %v", tt.address, tt.prefix, tt.interval, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got.prefix != tt.wantPrefix || got.interval != tt.wantInterval {
+			t.Errorf("newStatsdEmitter(%q, %q, %q) = prefix %q interval %s, want %q %s", tt.address, tt.prefix, tt.interval, got.prefix, got.interval, tt.wantPrefix, tt.wantInterval)
+		}
+		got.conn.Close()
+	}
+}
+
+func TestStatsdEmitter(t *testing.T) {
+	agent := listenStatsd(t)
+	sqsConf := &sqsConfig{
+		queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		shadow:   newShadowRoute("https://sqs.us-east-1.amazonaws.com/123456789/shadow-queue", 100),
+	}
+	statsd, err := newStatsdEmitter(sqsConf, agent.LocalAddr().String(), "", "env:prod", "1h")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer statsd.conn.Close()
+
+	t.Run("counters are sent as deltas", func(t *testing.T) {
+		sqsConf.stats.messagesSent.Add(7)
+		sqsConf.stats.messagesThrottled.Add(1)
+		statsd.flush()
+		first := readPacket(t, agent)
+
+		sqsConf.stats.messagesSent.Add(2)
+		statsd.flush()
+		second := readPacket(t, agent)
+
+		if !strings.Contains(first, "fluentbit.sqs.messages_sent:7|c|#queue:test-queue,env:prod\n") || !strings.Contains(first, "fluentbit.sqs.messages_throttled:1|c|#queue:test-queue,env:prod") {
+			t.Errorf("unexpected first packet: %q", first)
+		}
+		if !strings.Contains(second, "fluentbit.sqs.messages_sent:2|c|#queue:test-queue,env:prod\n") || !strings.Contains(second, "fluentbit.sqs.messages_throttled:0|c|#queue:test-queue,env:prod") {
+			t.Errorf("unexpected second packet: %q", second)
+		}
+		if lines := strings.Split(second, "\n"); len(lines) != 20 {
+			t.Errorf("expected 10 counters per queue in one packet, got %d", len(lines))
+		}
+	})
+
+	t.Run("side queues are labeled with their own name", func(t *testing.T) {
+		sqsConf.shadow.stats.messagesFailed.Add(5)
+		statsd.flush()
+		packet := readPacket(t, agent)
+
+		if !strings.Contains(packet, "fluentbit.sqs.messages_failed:5|c|#queue:shadow-queue,env:prod") || !strings.Contains(packet, "fluentbit.sqs.messages_failed:0|c|#queue:test-queue,env:prod") {
+			t.Errorf("unexpected packet: %q", packet)
+		}
+	})
+
+	t.Run("latencies are sent as timings", func(t *testing.T) {
+		statsd.timing("send_latency", sqsConf.queueURL, 42*time.Millisecond)
+		if got := readPacket(t, agent); got != "fluentbit.sqs.send_latency:42|ms|#queue:test-queue,env:prod" {
+			t.Errorf("unexpected timing packet: %q", got)
+		}
+	})
+}
+
+func TestStatsdEmitterStop(t *testing.T) {
+	agent := listenStatsd(t)
+	sqsConf := &sqsConfig{queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue"}
+	statsd, err := newStatsdEmitter(sqsConf, agent.LocalAddr().String(), "", "", "1h")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	statsd.start()
+	sqsConf.stats.recordsDropped.Add(3)
+	stopReporters()
+	statsd.stop()
+
+	if got := readPacket(t, agent); !strings.Contains(got, "fluentbit.sqs.records_dropped:3|c|#queue:test-queue") {
+		t.Errorf("expected the counters to be pushed on stop, got %q", got)
+	}
+}
@@ -0,0 +1,93 @@
+package sqsout
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// summaryReporter logs an info line with the delivery counters of every
+// destination at each interval, for operators who watch the logs only
+type summaryReporter struct {
+	sqsConf  *sqsConfig
+	interval time.Duration
+	// last holds the counters of the previous summary of each destination
+	last     map[string]statsSnapshot
+	stopCh   chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newSummaryReporter(sqsConf *sqsConfig, intervalString string) (*summaryReporter, error) {
+	interval, err := time.ParseDuration(intervalString)
+	if err != nil || interval <= 0 {
+		return nil, errors.New("SummaryInterval should be a positive duration, e.g. 60s")
+	}
+
+	return &summaryReporter{
+		sqsConf:  sqsConf,
+		interval: interval,
+		last:     map[string]statsSnapshot{},
+		stopCh:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// start logs a summary every interval until stop is called
+func (r *summaryReporter) start() {
+	registerReporter(r.sqsConf, r)
+
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.log()
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// stop stops the reporter. the totals are logged by Shutdown, so no
+// last summary is written. it is safe to call more than once
+func (r *summaryReporter) stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopCh)
+	})
+	<-r.done
+}
+
+// log writes the summary line of every destination
+func (r *summaryReporter) log() {
+	for i, d := range r.sqsConf.destinations() {
+		current := d.stats.snapshot()
+		last := r.last[d.queueURL]
+		r.last[d.queueURL] = current
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "summary of %s for the last %s:", d.name(), r.interval)
+		if i == 0 {
+			// records come in through the main queue only
+			fmt.Fprintf(&b, " records_in=%d", current.recordsIn-last.recordsIn)
+		}
+		fmt.Fprintf(&b, " sent=%d failed=%d retried=%d dropped=%d",
+			current.sent-last.sent, current.failed-last.failed, current.retried-last.retried, current.dropped-last.dropped)
+		if i == 0 {
+			fmt.Fprintf(&b, " buffered=%d", r.sqsConf.batches.pending())
+			if r.sqsConf.memBuf != nil {
+				fmt.Fprintf(&b, " buffered_bytes=%d", r.sqsConf.memBuf.pending.Load())
+			}
+		}
+		fmt.Fprintf(&b, " avg_latency=%s", current.latency.sub(last.latency).mean().Round(time.Millisecond))
+
+		writeInfoLog(b.String())
+	}
+}
@@ -0,0 +1,68 @@
+package sqsout
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewSummaryReporter(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"60s", time.Minute, false},
+		{"5m", 5 * time.Minute, false},
+		{"0s", 0, true},
+		{"hourly", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := newSummaryReporter(&sqsConfig{}, tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newSummaryReporter(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got.interval != tt.want {
+			t.Errorf("newSummaryReporter(%q) interval = %s, want %s", tt.input, got.interval, tt.want)
+		}
+	}
+}
+
+func TestSummaryReporterLog(t *testing.T) {
+	resetGlobals()
+	sqsConf := &sqsConfig{
+		queueURL: "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		memBuf:   &memBufLimit{limit: 1000},
+		shadow:   newShadowRoute("https://sqs.us-east-1.amazonaws.com/123456789/shadow-queue", 100),
+	}
+	reporter, err := newSummaryReporter(sqsConf, "30s")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sqsConf.stats.recordsIn.Add(12)
+	sqsConf.stats.messagesSent.Add(10)
+	sqsConf.stats.messagesFailed.Add(1)
+	sqsConf.stats.sendLatency.observe(10 * time.Millisecond)
+	sqsConf.stats.sendLatency.observe(30 * time.Millisecond)
+	sqsConf.memBuf.add(42)
+	batch := sqsConf.batches.get("app.log")
+	batch.records = testBatch(1)
+
+	first := captureStdout(reporter.log)
+	sqsConf.stats.messagesSent.Add(2)
+	second := captureStdout(reporter.log)
+
+	want := "summary of test-queue for the last 30s: records_in=12 sent=10 failed=1 retried=0 dropped=0 buffered=1 buffered_bytes=42 avg_latency=20ms"
+	if !strings.Contains(first, want) {
+		t.Errorf("expected %q in %q", want, first)
+	}
+	if !strings.Contains(first, "summary of shadow-queue for the last 30s: sent=0 failed=0 retried=0 dropped=0 avg_latency=0s") {
+		t.Errorf("expected a shadow queue summary in %q", first)
+	}
+	if !strings.Contains(second, "records_in=0 sent=2 failed=0") {
+		t.Errorf("expected the counters since the previous summary in %q", second)
+	}
+}
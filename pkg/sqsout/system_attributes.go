@@ -0,0 +1,79 @@
+package sqsout
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// systemAttribute sets a message system attribute, such as AWSTraceHeader,
+// from a record field
+type systemAttribute struct {
+	name string
+	key  string
+}
+
+// systemAttributes are the MessageSystemAttributes of the messages
+type systemAttributes []systemAttribute
+
+// allowedSystemAttributes returns the system attributes SQS accepts on send,
+// as known by the sdk the plugin is built with
+func allowedSystemAttributes() []string {
+	var names []string
+	for _, name := range types.MessageSystemAttributeNameForSends("").Values() {
+		names = append(names, string(name))
+	}
+	return names
+}
+
+// parseSystemAttributes parses the comma separated <name>=<record key> pairs
+// of MessageSystemAttributes
+func parseSystemAttributes(value string) (systemAttributes, error) {
+	allowed := allowedSystemAttributes()
+
+	var attributes systemAttributes
+	for _, item := range splitConfigList(value) {
+		name, key, ok := strings.Cut(item, "=")
+		name, key = strings.TrimSpace(name), strings.TrimSpace(key)
+		if !ok || name == "" || key == "" {
+			return nil, fmt.Errorf("invalid MessageSystemAttributes entry %q, expected <name>=<record key>", item)
+		}
+		if !slices.Contains(allowed, name) {
+			return nil, fmt.Errorf("MessageSystemAttributes %s is not a system attribute SQS accepts, allowed: %s", name, strings.Join(allowed, ","))
+		}
+		attributes = append(attributes, systemAttribute{name: name, key: key})
+	}
+	return attributes, nil
+}
+
+// values returns the system attribute values of a record, nil when the record
+// has none of the fields. they are kept with copyFieldString
+func (a systemAttributes) values(record map[interface{}]interface{}) map[string]string {
+	var values map[string]string
+	for _, attribute := range a {
+		value, ok := recordField(record, attribute.key)
+		if !ok || value == nil {
+			continue
+		}
+		if values == nil {
+			values = make(map[string]string, len(a))
+		}
+		values[attribute.name] = copyFieldString(value)
+	}
+	return values
+}
+
+// messageSystemAttributes converts the values to the attributes of an entry
+func messageSystemAttributes(values map[string]string) map[string]types.MessageSystemAttributeValue {
+	attributes := make(map[string]types.MessageSystemAttributeValue, len(values))
+	for name, value := range values {
+		attributes[name] = types.MessageSystemAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(value),
+		}
+	}
+	return attributes
+}
@@ -0,0 +1,88 @@
+package sqsout
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestParseSystemAttributes(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    systemAttributes
+		wantErr string
+	}{
+		{"", nil, ""},
+		{"AWSTraceHeader=trace_header", systemAttributes{{name: "AWSTraceHeader", key: "trace_header"}}, ""},
+		{" AWSTraceHeader = aws.trace ", systemAttributes{{name: "AWSTraceHeader", key: "aws.trace"}}, ""},
+		{"AWSTraceHeader", nil, "expected <name>=<record key>"},
+		{"AWSTraceHeader=", nil, "expected <name>=<record key>"},
+		{"SenderId=sender", nil, "allowed: AWSTraceHeader"},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSystemAttributes(tt.value)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseSystemAttributes(%q) expected an error with %q, got %v", tt.value, tt.wantErr, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSystemAttributes(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestSystemAttributesValues(t *testing.T) {
+	attributes := systemAttributes{{name: "AWSTraceHeader", key: "trace.header"}}
+
+	tests := []struct {
+		name   string
+		record map[interface{}]interface{}
+		want   map[string]string
+	}{
+		{"nested field", map[interface{}]interface{}{"trace": map[interface{}]interface{}{"header": []byte("Root=1-5759e988-bd862e3fe1be46a994272793")}}, map[string]string{"AWSTraceHeader": "Root=1-5759e988-bd862e3fe1be46a994272793"}},
+		{"missing field", map[interface{}]interface{}{"log": "hello"}, nil},
+		{"null field", map[interface{}]interface{}{"trace.header": nil}, nil},
+	}
+
+	for _, tt := range tests {
+		if got := attributes.values(tt.record); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: values() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFlushRecordsSystemAttributes(t *testing.T) {
+	resetGlobals()
+	fake := &recordingSQS{}
+	sqsConf := &sqsConfig{
+		queueURL:         "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS:            fake,
+		batchSize:        2,
+		systemAttributes: systemAttributes{{name: "AWSTraceHeader", key: "trace_header"}},
+	}
+
+	err := flushRecords(sqsConf, "app", sliceIterator(time.Now(),
+		map[interface{}]interface{}{"log": "traced", "trace_header": []byte("Root=1-5759e988-bd862e3fe1be46a994272793")},
+		map[interface{}]interface{}{"log": "untraced"},
+	))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(fake.batches) != 1 {
+		t.Fatalf("expected 1 batch, got %d", len(fake.batches))
+	}
+	traced, untraced := fake.batches[0].Entries[0], fake.batches[0].Entries[1]
+	if got := aws.ToString(traced.MessageSystemAttributes["AWSTraceHeader"].StringValue); got != "Root=1-5759e988-bd862e3fe1be46a994272793" {
+		t.Errorf("expected the trace header system attribute, got %q", got)
+	}
+	if untraced.MessageSystemAttributes != nil {
+		t.Errorf("expected no system attributes without the field, got %v", untraced.MessageSystemAttributes)
+	}
+}
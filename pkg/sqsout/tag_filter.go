@@ -0,0 +1,64 @@
+package sqsout
+
+import (
+	"fmt"
+	"path"
+)
+
+// tagFilter is a last-mile tag filter evaluated in the output itself, for
+// cases the Fluent Bit match expression can't express. patterns are globs
+// (e.g. "app.*", "kube.*.prod") and exclusion always wins over inclusion
+type tagFilter struct {
+	include []string
+	exclude []string
+}
+
+// newTagFilter returns nil when no pattern is configured so the flush path
+// can skip filtering entirely
+func newTagFilter(includeTags, excludeTags string) (*tagFilter, error) {
+	include, err := parseTagPatterns("IncludeTags", includeTags)
+	if err != nil {
+		return nil, err
+	}
+
+	exclude, err := parseTagPatterns("ExcludeTags", excludeTags)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+
+	return &tagFilter{include: include, exclude: exclude}, nil
+}
+
+func parseTagPatterns(key, value string) ([]string, error) {
+	patterns := splitConfigList(value)
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%s contains an invalid pattern %q: %v", key, pattern, err)
+		}
+	}
+	return patterns, nil
+}
+
+func (f *tagFilter) allowed(tag string) bool {
+	for _, pattern := range f.exclude {
+		if matched, _ := path.Match(pattern, tag); matched {
+			return false
+		}
+	}
+
+	if len(f.include) == 0 {
+		return true
+	}
+
+	for _, pattern := range f.include {
+		if matched, _ := path.Match(pattern, tag); matched {
+			return true
+		}
+	}
+
+	return false
+}
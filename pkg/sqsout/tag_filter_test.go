@@ -0,0 +1,59 @@
+package sqsout
+
+import (
+	"testing"
+)
+
+func TestNewTagFilter(t *testing.T) {
+	filter, err := newTagFilter("", "")
+	if err != nil || filter != nil {
+		t.Errorf("expected nil filter without patterns, got %v, %v", filter, err)
+	}
+
+	filter, err = newTagFilter("app.*, kube.* ,", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(filter.include) != 2 || filter.include[1] != "kube.*" {
+		t.Errorf("unexpected include patterns: %v", filter.include)
+	}
+
+	if _, err := newTagFilter("app.[", ""); err == nil {
+		t.Error("expected error for invalid include pattern")
+	}
+	if _, err := newTagFilter("", "app.["); err == nil {
+		t.Error("expected error for invalid exclude pattern")
+	}
+}
+
+func TestTagFilterAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		include string
+		exclude string
+		tag     string
+		want    bool
+	}{
+		{"include match", "app.*", "", "app.web", true},
+		{"include no match", "app.*", "", "kube.web", false},
+		{"any of several includes", "app.*,kube.*", "", "kube.web", true},
+		{"exclude match", "", "debug.*", "debug.web", false},
+		{"exclude no match", "", "debug.*", "app.web", true},
+		{"exclude wins over include", "app.*", "app.debug", "app.debug", false},
+		{"include with exclude not matching", "app.*", "app.debug", "app.web", true},
+		{"single character wildcard", "app.?", "", "app.1", true},
+		{"exact tag", "dummy.log", "", "dummy.log", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := newTagFilter(tt.include, tt.exclude)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := filter.allowed(tt.tag); got != tt.want {
+				t.Errorf("allowed(%q) = %v, want %v", tt.tag, got, tt.want)
+			}
+		})
+	}
+}
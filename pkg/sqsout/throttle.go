@@ -0,0 +1,118 @@
+package sqsout
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+const (
+	// throttlePauseStep is the pause between sends after the first
+	// throttled send, and how much it shrinks with every healthy send
+	throttlePauseStep = 100 * time.Millisecond
+	// maxThrottlePause bounds the pause between sends, it doubles with every
+	// throttled send
+	maxThrottlePause = 5 * time.Second
+)
+
+// throttleGovernor slows the sends down while the destination throttles
+// them, instead of the retries hammering it at full speed: every throttled
+// send halves the concurrent sends and doubles a pause between the sends,
+// every healthy send allows one more concurrent send and shortens the pause
+// by a step, until the sends are back to full speed (AIMD)
+type throttleGovernor struct {
+	// maxConcurrency is the number of Workers, 1 without workers
+	maxConcurrency int
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	concurrency int
+	inFlight    int
+	pause       time.Duration
+	pauseUntil  time.Time
+
+	slowdownCount atomic.Int64
+}
+
+// newThrottleGovernor returns nil when ThrottleSlowdown is not enabled
+func newThrottleGovernor(enabledString string, workers int) (*throttleGovernor, error) {
+	enabled, err := parseBool("ThrottleSlowdown", enabledString)
+	if err != nil || !enabled {
+		return nil, err
+	}
+
+	g := &throttleGovernor{maxConcurrency: max(workers, 1)}
+	g.concurrency = g.maxConcurrency
+	g.cond = sync.NewCond(&g.mu)
+	return g, nil
+}
+
+// acquire waits for the pause to be over and for a send slot while the
+// concurrency is reduced
+func (g *throttleGovernor) acquire() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for {
+		if wait := time.Until(g.pauseUntil); wait > 0 {
+			g.mu.Unlock()
+			time.Sleep(wait)
+			g.mu.Lock()
+			continue
+		}
+		// at full speed the sends are only bounded by the workers
+		if g.concurrency == g.maxConcurrency || g.inFlight < g.concurrency {
+			break
+		}
+		g.cond.Wait()
+	}
+	g.inFlight++
+}
+
+// release frees the send slot and adapts the speed to the outcome of the
+// send
+func (g *throttleGovernor) release(throttled bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.inFlight--
+	if throttled {
+		wasHealthy := g.pause == 0
+		g.concurrency = max(g.concurrency/2, 1)
+		g.pause = min(max(g.pause*2, throttlePauseStep), maxThrottlePause)
+		g.pauseUntil = time.Now().Add(g.pause)
+		g.slowdownCount.Add(1)
+
+		message := fmt.Sprintf("sends are throttled, slowing down to %d concurrent sends with a pause of %v", g.concurrency, g.pause)
+		if wasHealthy {
+			writeWarnLog(message)
+		} else {
+			writeDebugLog(message)
+		}
+	} else if g.pause > 0 || g.concurrency < g.maxConcurrency {
+		g.concurrency = min(g.concurrency+1, g.maxConcurrency)
+		g.pause = max(g.pause-throttlePauseStep, 0)
+		if g.pause == 0 && g.concurrency == g.maxConcurrency {
+			writeInfoLog("sends are no longer throttled, back to full speed")
+		}
+	}
+	g.cond.Broadcast()
+}
+
+// throttledSend returns true when the request or any of the entries of a
+// send was throttled
+func throttledSend(output *sqs.SendMessageBatchOutput, err error) bool {
+	if err != nil {
+		return isThrottlingCode(awsErrorCode(err))
+	}
+	for _, failed := range output.Failed {
+		if isThrottlingCode(aws.ToString(failed.Code)) {
+			return true
+		}
+	}
+	return false
+}
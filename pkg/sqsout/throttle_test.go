@@ -0,0 +1,159 @@
+package sqsout
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestNewThrottleGovernor(t *testing.T) {
+	tests := []struct {
+		value   string
+		workers int
+		wantMax int
+		wantNil bool
+		wantErr bool
+	}{
+		{"", 4, 0, true, false},
+		{"false", 4, 0, true, false},
+		{"true", 4, 4, false, false},
+		{"true", 0, 1, false, false},
+		{"slow", 4, 0, true, true},
+	}
+
+	for _, tt := range tests {
+		got, err := newThrottleGovernor(tt.value, tt.workers)
+		if (err != nil) != tt.wantErr || (got == nil) != tt.wantNil {
+			t.Fatalf("newThrottleGovernor(%q, %d) = %v, %v, wantNil %v, wantErr %v", tt.value, tt.workers, got, err, tt.wantNil, tt.wantErr)
+		}
+		if got != nil && (got.maxConcurrency != tt.wantMax || got.concurrency != tt.wantMax) {
+			t.Errorf("newThrottleGovernor(%q, %d) concurrency = %d/%d, want %d", tt.value, tt.workers, got.concurrency, got.maxConcurrency, tt.wantMax)
+		}
+	}
+}
+
+func TestThrottleGovernorAIMD(t *testing.T) {
+	g, _ := newThrottleGovernor("true", 8)
+
+	type state struct {
+		concurrency int
+		pause       time.Duration
+	}
+	steps := []struct {
+		throttled bool
+		want      state
+	}{
+		{true, state{4, 100 * time.Millisecond}},
+		{true, state{2, 200 * time.Millisecond}},
+		{true, state{1, 400 * time.Millisecond}},
+		{true, state{1, 800 * time.Millisecond}},
+		{false, state{2, 700 * time.Millisecond}},
+		{false, state{3, 600 * time.Millisecond}},
+	}
+
+	for i, step := range steps {
+		g.inFlight = 1
+		g.release(step.throttled)
+		if got := (state{g.concurrency, g.pause}); got != step.want {
+			t.Fatalf("step %d: got %+v, want %+v", i, got, step.want)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		g.inFlight = 1
+		g.release(false)
+	}
+	if g.concurrency != 8 || g.pause != 0 {
+		t.Errorf("expected the governor back to full speed, got %d concurrent sends and a pause of %v", g.concurrency, g.pause)
+	}
+	if got := g.slowdownCount.Load(); got != 4 {
+		t.Errorf("expected 4 slowdowns, got %d", got)
+	}
+
+	for i := 0; i < 10; i++ {
+		g.inFlight = 1
+		g.release(true)
+	}
+	if g.pause != maxThrottlePause {
+		t.Errorf("expected the pause to be capped at %v, got %v", maxThrottlePause, g.pause)
+	}
+}
+
+func TestThrottleGovernorAcquire(t *testing.T) {
+	t.Run("waits for the pause", func(t *testing.T) {
+		g, _ := newThrottleGovernor("true", 2)
+		g.pauseUntil = time.Now().Add(50 * time.Millisecond)
+
+		start := time.Now()
+		g.acquire()
+		if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+			t.Errorf("expected acquire to wait for the pause, returned after %v", elapsed)
+		}
+	})
+
+	t.Run("waits for a slot while slowed down", func(t *testing.T) {
+		g, _ := newThrottleGovernor("true", 4)
+		g.concurrency = 1
+		g.acquire()
+
+		acquired := make(chan struct{})
+		go func() {
+			g.acquire()
+			close(acquired)
+		}()
+
+		select {
+		case <-acquired:
+			t.Fatal("expected the second send to wait for the first one")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		g.release(false)
+		select {
+		case <-acquired:
+		case <-time.After(time.Second):
+			t.Fatal("expected the second send to start once the first one is done")
+		}
+	})
+
+	t.Run("full speed is bounded by the workers only", func(t *testing.T) {
+		g, _ := newThrottleGovernor("true", 1)
+		g.acquire()
+		done := make(chan struct{})
+		go func() {
+			g.acquire()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("expected concurrent flushes to send at full speed")
+		}
+	})
+}
+
+func TestThrottledSend(t *testing.T) {
+	tests := []struct {
+		name   string
+		output *sqs.SendMessageBatchOutput
+		err    error
+		want   bool
+	}{
+		{"throttled request", nil, &smithy.GenericAPIError{Code: "RequestThrottled"}, true},
+		{"failed request", nil, errors.New("connection reset"), false},
+		{"throttled entry", &sqs.SendMessageBatchOutput{Failed: []types.BatchResultErrorEntry{{Code: aws.String("ThrottlingException")}}}, nil, true},
+		{"failed entry", &sqs.SendMessageBatchOutput{Failed: []types.BatchResultErrorEntry{{Code: aws.String("InternalError")}}}, nil, false},
+		{"healthy", &sqs.SendMessageBatchOutput{}, nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := throttledSend(tt.output, tt.err); got != tt.want {
+			t.Errorf("%s: throttledSend() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
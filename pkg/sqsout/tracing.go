@@ -0,0 +1,142 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName is the instrumentation scope of the plugin spans
+const tracerName = "github.com/PayU/fluentBit-sqs-plugin"
+
+// defaultOtelServiceName is the service.name of the spans when
+// OtelServiceName is not set
+const defaultOtelServiceName = "fluent-bit-sqs"
+
+// tracer creates the flush and send spans. it doesn't record anything unless
+// OtelEndpoint is set, the tracer is shared by every instance of the plugin
+var tracer trace.Tracer = noop.NewTracerProvider().Tracer(tracerName)
+
+// tracingEndpoint is the OtelEndpoint of the running tracer provider, empty
+// when no provider runs
+var tracingEndpoint string
+
+// tracingShutdown flushes the pending spans when fluent bit stops
+type tracingShutdown struct {
+	provider *sdktrace.TracerProvider
+}
+
+func (t tracingShutdown) stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := t.provider.Shutdown(ctx); err != nil {
+		writeErrorLog(fmt.Errorf("failed to flush the OpenTelemetry spans: %v", err))
+	}
+	// the instances created after a reload don't record to the stopped provider
+	tracer = noop.NewTracerProvider().Tracer(tracerName)
+	tracingEndpoint = ""
+}
+
+func validateOtelConfig(endpoint, serviceName string) error {
+	if endpoint == "" && serviceName != "" {
+		return errors.New("OtelServiceName requires OtelEndpoint to be set")
+	}
+	return nil
+}
+
+// startTracing exports the spans to the OTLP/HTTP endpoint, e.g.
+// http://localhost:4318
+func startTracing(endpoint, serviceName string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("OtelEndpoint should be an http or https url, e.g. http://localhost:4318")
+	}
+
+	// the provider is shared by the instances, only the first one starts it
+	if tracingEndpoint != "" {
+		if tracingEndpoint != endpoint {
+			writeWarnLog(fmt.Sprintf("the spans are already exported to %s, ignoring OtelEndpoint %s", tracingEndpoint, endpoint))
+		}
+		return nil
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return fmt.Errorf("failed to create the OTLP exporter: %v", err)
+	}
+
+	if serviceName == "" {
+		serviceName = defaultOtelServiceName
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+	tracer = provider.Tracer(tracerName)
+	tracingEndpoint = endpoint
+	// the tracer provider is process wide, it is stopped with the last instance
+	registerReporter(nil, tracingShutdown{provider: provider})
+
+	return nil
+}
+
+// startFlushSpan starts the span of a flush callback
+func startFlushSpan(tag string) trace.Span {
+	_, span := tracer.Start(context.Background(), "flush", trace.WithAttributes(
+		attribute.String("fluentbit.tag", tag),
+	))
+	return span
+}
+
+// endFlushSpan ends the span of a flush callback
+func endFlushSpan(span trace.Span, records int, err error) {
+	span.SetAttributes(attribute.Int("fluentbit.records", records))
+	endSpan(span, err)
+}
+
+// startSendSpan starts the span of a SendMessageBatch call, retries included
+func startSendSpan(queueURL string, messages int) trace.Span {
+	_, span := tracer.Start(context.Background(), "SendMessageBatch",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "aws_sqs"),
+			attribute.String("messaging.destination.name", queueName(queueURL)),
+			attribute.Int("messaging.batch.message_count", messages),
+		))
+	return span
+}
+
+// endSendSpan ends the span of a SendMessageBatch call with its result
+func endSendSpan(span trace.Span, output *sqs.SendMessageBatchOutput, err error) {
+	if output != nil {
+		span.SetAttributes(
+			attribute.Int("sqs.messages.successful", len(output.Successful)),
+			attribute.Int("sqs.messages.failed", len(output.Failed)),
+		)
+		if len(output.Failed) > 0 && err == nil {
+			span.SetStatus(codes.Error, fmt.Sprintf("%d messages failed", len(output.Failed)))
+		}
+	}
+	endSpan(span, err)
+}
+
+// endSpan ends a span, marking it as failed when err is not nil
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,169 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans makes the plugin tracer record its spans until the test ends
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	saved := tracer
+	tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(tracerName)
+	t.Cleanup(func() { tracer = saved })
+	return recorder
+}
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key string) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestValidateOtelConfig(t *testing.T) {
+	tests := []struct {
+		endpoint    string
+		serviceName string
+		wantErr     bool
+	}{
+		{"", "", false},
+		{"http://localhost:4318", "", false},
+		{"http://localhost:4318", "logs", false},
+		{"", "logs", true},
+	}
+
+	for _, tt := range tests {
+		err := validateOtelConfig(tt.endpoint, tt.serviceName)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateOtelConfig(%q, %q) error = %v, wantErr %v", tt.endpoint, tt.serviceName, err, tt.wantErr)
+		}
+	}
+}
+
+func TestStartTracingRejectsInvalidEndpoints(t *testing.T) {
+	for _, endpoint := range []string{"localhost:4318", "ftp://collector:4318", "http://"} {
+		if err := startTracing(endpoint, ""); err == nil {
+			t.Errorf("startTracing(%q) expected an error", endpoint)
+		}
+	}
+}
+
+func TestStartTracingOnce(t *testing.T) {
+	resetGlobals()
+	reportersMu.Lock()
+	saved := reporters
+	reporters = nil
+	reportersMu.Unlock()
+	defer func() {
+		reportersMu.Lock()
+		reporters = saved
+		reportersMu.Unlock()
+	}()
+
+	// a second instance, or the same one after a reload, reuses the provider
+	captureStdout(func() {
+		for i := 0; i < 2; i++ {
+			if err := startTracing("http://127.0.0.1:4318", ""); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	})
+	if len(reporters) != 1 || tracingEndpoint != "http://127.0.0.1:4318" {
+		t.Fatalf("expected a single tracer provider, got %d reporters for %q", len(reporters), tracingEndpoint)
+	}
+
+	captureStdout(func() { stopInstanceReporters(nil) })
+	if _, span := tracer.Start(context.Background(), "flush"); span.IsRecording() || tracingEndpoint != "" {
+		t.Errorf("expected the stopped provider to be replaced by a noop tracer, got endpoint %q", tracingEndpoint)
+	}
+}
+
+func TestSendSpans(t *testing.T) {
+	queueURL := "https://sqs.us-east-1.amazonaws.com/123456789/test-queue"
+	records := []*types.SendMessageBatchRequestEntry{
+		{Id: aws.String("msg-1"), MessageBody: aws.String("1")},
+		{Id: aws.String("msg-2"), MessageBody: aws.String("2")},
+	}
+
+	t.Run("partial failure", func(t *testing.T) {
+		resetGlobals()
+		recorder := recordSpans(t)
+		sqsConf := &sqsConfig{queueURL: queueURL, mySQS: &fakeSQS{output: &sqs.SendMessageBatchOutput{
+			Successful: []types.SendMessageBatchResultEntry{{Id: aws.String("msg-1")}},
+			Failed:     []types.BatchResultErrorEntry{{Id: aws.String("msg-2"), Code: aws.String("InternalError")}},
+		}}}
+
+		captureStdout(func() { _ = sendBatchToSqs(sqsConf, records) })
+
+		spans := recorder.Ended()
+		if len(spans) != 1 || spans[0].Name() != "SendMessageBatch" {
+			t.Fatalf("expected a SendMessageBatch span, got %v", spans)
+		}
+		span := spans[0]
+		if spanAttribute(span, "messaging.destination.name").AsString() != "test-queue" || spanAttribute(span, "messaging.batch.message_count").AsInt64() != 2 {
+			t.Errorf("unexpected attributes: %v", span.Attributes())
+		}
+		if spanAttribute(span, "sqs.messages.failed").AsInt64() != 1 || span.Status().Code != codes.Error {
+			t.Errorf("expected the partial failure on the span, got %v %v", span.Attributes(), span.Status())
+		}
+	})
+
+	t.Run("request error", func(t *testing.T) {
+		resetGlobals()
+		recorder := recordSpans(t)
+		sqsConf := &sqsConfig{queueURL: queueURL, mySQS: &fakeSQS{err: errors.New("timeout")}}
+
+		_ = sendBatchToSqs(sqsConf, records)
+
+		span := recorder.Ended()[0]
+		if span.Status().Code != codes.Error || len(span.Events()) != 1 {
+			t.Errorf("expected the error to be recorded, got status %v and events %v", span.Status(), span.Events())
+		}
+	})
+}
+
+func TestFlushSpans(t *testing.T) {
+	resetGlobals()
+	recorder := recordSpans(t)
+	sqsConf := &sqsConfig{
+		queueURL:  "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+		mySQS:     &recordingSQS{},
+		batchSize: 2,
+	}
+
+	timestamp := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	if err := flushRecords(sqsConf, "app.log", sliceIterator(timestamp, messageRecords(3)...)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var flush sdktrace.ReadOnlySpan
+	sends := 0
+	for _, span := range recorder.Ended() {
+		switch span.Name() {
+		case "flush":
+			flush = span
+		case "SendMessageBatch":
+			sends++
+		}
+	}
+	if flush == nil || sends != 1 {
+		t.Fatalf("expected a flush span and one send span, got %d sends", sends)
+	}
+	if spanAttribute(flush, "fluentbit.tag").AsString() != "app.log" || spanAttribute(flush, "fluentbit.records").AsInt64() != 3 {
+		t.Errorf("unexpected flush attributes: %v", flush.Attributes())
+	}
+}
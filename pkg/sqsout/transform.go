@@ -0,0 +1,440 @@
+package sqsout
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// recordTransform rewrites a record right before it is formatted, e.g. its
+// keys or its values. it may change the record in place or return a new one
+type recordTransform func(record map[interface{}]interface{}) map[interface{}]interface{}
+
+// transforms are the record transforms of an output, applied in order once
+// the record passed the processors and RequireKeys
+type transforms []recordTransform
+
+// add appends the transforms which are configured, the nil ones are skipped
+func (t transforms) add(transforms ...recordTransform) transforms {
+	for _, transform := range transforms {
+		if transform != nil {
+			t = append(t, transform)
+		}
+	}
+	return t
+}
+
+func (t transforms) apply(record map[interface{}]interface{}) map[interface{}]interface{} {
+	for _, transform := range t {
+		record = transform(record)
+	}
+	return record
+}
+
+// newKeyPrefixRemover returns the transform removing RemoveKeyPrefix from
+// the top level keys of the records, nil when it is not set. a key already
+// in the record is kept over the one losing its prefix
+func newKeyPrefixRemover(prefix string) recordTransform {
+	if prefix == "" {
+		return nil
+	}
+
+	return func(record map[interface{}]interface{}) map[interface{}]interface{} {
+		var stripped map[interface{}]interface{}
+		for key, value := range record {
+			name, ok := key.(string)
+			if !ok || !strings.HasPrefix(name, prefix) || name == prefix {
+				continue
+			}
+			if stripped == nil {
+				stripped = make(map[interface{}]interface{}, len(record))
+			}
+			stripped[strings.TrimPrefix(name, prefix)] = value
+			delete(record, key)
+		}
+		for key, value := range stripped {
+			if _, ok := record[key]; !ok {
+				record[key] = value
+			}
+		}
+		return record
+	}
+}
+
+// newKeyCaseTransform returns the transform converting the keys of the
+// records, nested ones included, to the KeyCase: lower, snake (user_id) or
+// camel (userId). nil when KeyCase is not set. when several keys convert to
+// the same one, the key already in that case wins, else the first in
+// alphabetical order
+func newKeyCaseTransform(keyCase string) (recordTransform, error) {
+	var convert func(string) string
+	switch strings.ToLower(keyCase) {
+	case "":
+		return nil, nil
+	case "lower":
+		convert = strings.ToLower
+	case "snake":
+		convert = snakeCase
+	case "camel":
+		convert = camelCase
+	default:
+		return nil, fmt.Errorf("KeyCase should be one of: lower, snake, camel. got %q", keyCase)
+	}
+
+	var convertValue func(value interface{}) interface{}
+	convertMap := func(record map[interface{}]interface{}) map[interface{}]interface{} {
+		converted := make(map[interface{}]interface{}, len(record))
+		origins := make(map[string]string, len(record))
+		for key, value := range record {
+			name, ok := key.(string)
+			if !ok {
+				converted[key] = convertValue(value)
+				continue
+			}
+			newName := convert(name)
+			if origin, ok := origins[newName]; ok && (origin == newName || name != newName && origin < name) {
+				continue
+			}
+			origins[newName] = name
+			converted[newName] = convertValue(value)
+		}
+		return converted
+	}
+	convertValue = func(value interface{}) interface{} {
+		switch v := value.(type) {
+		case map[interface{}]interface{}:
+			return convertMap(v)
+		case []interface{}:
+			for i := range v {
+				v[i] = convertValue(v[i])
+			}
+		}
+		return value
+	}
+	return convertMap, nil
+}
+
+// keyWords splits a key into its words, at the separators (_, - and spaces)
+// and at the case changes: userID and user_id are both user and id
+func keyWords(key string) []string {
+	var words []string
+	runes := []rune(key)
+	start := 0
+	for i := 0; i <= len(runes); i++ {
+		if i == len(runes) || runes[i] == '_' || runes[i] == '-' || runes[i] == ' ' {
+			if i > start {
+				words = append(words, string(runes[start:i]))
+			}
+			start = i + 1
+			continue
+		}
+		if i > start && unicode.IsUpper(runes[i]) {
+			// HTTPStatus splits before the last capital of HTTP
+			previousLower := unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if previousLower || unicode.IsUpper(runes[i-1]) && nextLower {
+				words = append(words, string(runes[start:i]))
+				start = i
+			}
+		}
+	}
+	return words
+}
+
+func snakeCase(key string) string {
+	words := keyWords(key)
+	if len(words) == 0 {
+		return key
+	}
+	return strings.ToLower(strings.Join(words, "_"))
+}
+
+func camelCase(key string) string {
+	words := keyWords(key)
+	if len(words) == 0 {
+		return key
+	}
+	var b strings.Builder
+	for i, word := range words {
+		word = strings.ToLower(word)
+		if i > 0 {
+			r, size := utf8.DecodeRuneInString(word)
+			b.WriteRune(unicode.ToUpper(r))
+			word = word[size:]
+		}
+		b.WriteString(word)
+	}
+	return b.String()
+}
+
+// newNullValueTransform returns the transform applying the NullValuePolicy
+// to the records, nested ones included: keep (the default) writes the null
+// values as they are, drop removes the null and empty string values and
+// empty-string writes the null values as empty strings, for the json schema
+// validators rejecting nulls
+func newNullValueTransform(policy string) (recordTransform, error) {
+	var replace func(value interface{}) (interface{}, bool)
+	switch strings.ToLower(policy) {
+	case "", "keep":
+		return nil, nil
+	case "drop":
+		replace = func(value interface{}) (interface{}, bool) {
+			switch v := value.(type) {
+			case nil:
+				return nil, false
+			case string:
+				return v, v != ""
+			case []byte:
+				return v, len(v) > 0
+			}
+			return value, true
+		}
+	case "empty-string":
+		replace = func(value interface{}) (interface{}, bool) {
+			if value == nil {
+				return "", true
+			}
+			return value, true
+		}
+	default:
+		return nil, fmt.Errorf("NullValuePolicy should be one of: keep, drop, empty-string. got %q", policy)
+	}
+
+	var transform func(value interface{}) (interface{}, bool)
+	transform = func(value interface{}) (interface{}, bool) {
+		switch v := value.(type) {
+		case map[interface{}]interface{}:
+			for key, nested := range v {
+				if nested, ok := transform(nested); ok {
+					v[key] = nested
+				} else {
+					delete(v, key)
+				}
+			}
+		case []interface{}:
+			kept := v[:0]
+			for _, nested := range v {
+				if nested, ok := transform(nested); ok {
+					kept = append(kept, nested)
+				}
+			}
+			return kept, true
+		}
+		return replace(value)
+	}
+	return func(record map[interface{}]interface{}) map[interface{}]interface{} {
+		transform(record)
+		return record
+	}, nil
+}
+
+// stringifyValues writes the numbers and booleans of the records, nested
+// ones included, as strings, for the consumers requiring string values. the
+// text is the one of the json number, NaN and infinities are written as
+// well since json has no number for them
+func stringifyValues(record map[interface{}]interface{}) map[interface{}]interface{} {
+	for key, value := range record {
+		record[key] = stringifyValue(value)
+	}
+	return record
+}
+
+func stringifyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		return stringifyValues(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = stringifyValue(v[i])
+		}
+		return v
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		text, _ := appendJSONValue(nil, v)
+		return string(text)
+	case float32:
+		return stringifyFloat(float64(v), 32)
+	case float64:
+		return stringifyFloat(v, 64)
+	}
+	return value
+}
+
+func stringifyFloat(f float64, bits int) string {
+	text, err := appendJSONFloat(nil, f, bits)
+	if err != nil {
+		return strconv.FormatFloat(f, 'g', -1, bits)
+	}
+	return string(text)
+}
+
+// newLinesJoiner returns the transform joining the fields of JoinLinesKeys
+// holding a list of lines, e.g. a stack trace, into a single string with the
+// separator, a newline by default. nil when JoinLinesKeys is not set
+func newLinesJoiner(keysString, separator string) (recordTransform, error) {
+	keys := splitConfigList(keysString)
+	if len(keys) == 0 {
+		if separator != "" {
+			return nil, errors.New("JoinLinesSeparator requires JoinLinesKeys to be set")
+		}
+		return nil, nil
+	}
+	if separator == "" {
+		separator = "\n"
+	} else {
+		// the configuration can't hold a newline or a tab as is
+		separator = strings.NewReplacer(`\n`, "\n", `\t`, "\t").Replace(separator)
+	}
+
+	return func(record map[interface{}]interface{}) map[interface{}]interface{} {
+		for _, key := range keys {
+			lines, ok := record[key].([]interface{})
+			if !ok {
+				continue
+			}
+			var joined strings.Builder
+			for i, line := range lines {
+				if i > 0 {
+					joined.WriteString(separator)
+				}
+				joined.WriteString(fieldString(line))
+			}
+			record[key] = joined.String()
+		}
+		return record
+	}, nil
+}
+
+// fieldLengths are the longest values of MaxFieldLength, per top level key
+// and for the other values, nested ones included, with the * key
+type fieldLengths map[string]int
+
+// parseFieldLengths parses MaxFieldLength, either a length for every value or
+// comma separated <key>:<length> pairs. nil when it is not set
+func parseFieldLengths(value string) (fieldLengths, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parse := func(s string) (int, error) {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid MaxFieldLength %q, the lengths should be positive integers", value)
+		}
+		return n, nil
+	}
+
+	if !strings.Contains(value, ":") {
+		n, err := parse(value)
+		if err != nil {
+			return nil, err
+		}
+		return fieldLengths{"*": n}, nil
+	}
+
+	lengths := fieldLengths{}
+	for _, item := range splitConfigList(value) {
+		key, length, ok := strings.Cut(item, ":")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid MaxFieldLength entry %q, expected <key>:<length>", item)
+		}
+		n, err := parse(length)
+		if err != nil {
+			return nil, err
+		}
+		lengths[key] = n
+	}
+	return lengths, nil
+}
+
+// transform returns the transform truncating the string values over their
+// length, with a marker of the bytes removed, so a single giant field
+// doesn't make the whole message too large
+func (f fieldLengths) transform() recordTransform {
+	if f == nil {
+		return nil
+	}
+	defaultLength := f["*"]
+
+	var truncate func(value interface{}, max int) interface{}
+	truncate = func(value interface{}, max int) interface{} {
+		switch v := value.(type) {
+		case string:
+			return truncateField(v, max)
+		case []byte:
+			if len(v) > max && max > 0 {
+				return truncateField(bytesToString(v), max)
+			}
+		case map[interface{}]interface{}:
+			for key, nested := range v {
+				v[key] = truncate(nested, defaultLength)
+			}
+		case []interface{}:
+			for i := range v {
+				v[i] = truncate(v[i], defaultLength)
+			}
+		}
+		return value
+	}
+
+	return func(record map[interface{}]interface{}) map[interface{}]interface{} {
+		for key, value := range record {
+			max := defaultLength
+			if name, ok := key.(string); ok {
+				if length, ok := f[name]; ok {
+					max = length
+				}
+			}
+			record[key] = truncate(value, max)
+		}
+		return record
+	}
+}
+
+// truncateField cuts s to max bytes, without splitting a character, and
+// appends the truncation marker. 0 is no limit
+func truncateField(s string, max int) interface{} {
+	if max == 0 || len(s) <= max {
+		return s
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s...[truncated %d bytes]", s[:cut], len(s)-cut)
+}
+
+// normalizeUnicode writes the string values of the records, nested ones
+// included, in the NFC form, so consumers matching them exactly see the same
+// bytes for the same text from every producer
+func normalizeUnicode(record map[interface{}]interface{}) map[interface{}]interface{} {
+	for key, value := range record {
+		record[key] = normalizeValue(value)
+	}
+	return record
+}
+
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if !norm.NFC.IsNormalString(v) {
+			return norm.NFC.String(v)
+		}
+	case []byte:
+		// the byte slices point into the chunk, a new slice is returned
+		if !norm.NFC.IsNormal(v) {
+			return norm.NFC.Bytes(v)
+		}
+	case map[interface{}]interface{}:
+		return normalizeUnicode(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = normalizeValue(v[i])
+		}
+	}
+	return value
+}
@@ -0,0 +1,377 @@
+package sqsout
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestKeyPrefixRemover(t *testing.T) {
+	if newKeyPrefixRemover("") != nil {
+		t.Error("expected no transform without RemoveKeyPrefix")
+	}
+
+	tests := []struct {
+		name   string
+		record map[interface{}]interface{}
+		want   map[interface{}]interface{}
+	}{
+		{
+			"prefixed keys",
+			map[interface{}]interface{}{"log_processed.level": "info", "log_processed.msg": "hello", "stream": "stdout"},
+			map[interface{}]interface{}{"level": "info", "msg": "hello", "stream": "stdout"},
+		},
+		{
+			"existing key is kept",
+			map[interface{}]interface{}{"log_processed.level": "info", "level": "warn"},
+			map[interface{}]interface{}{"level": "warn"},
+		},
+		{
+			"prefix only and nested keys are not changed",
+			map[interface{}]interface{}{"log_processed.": "raw", "kubernetes": map[interface{}]interface{}{"log_processed.pod": "api"}},
+			map[interface{}]interface{}{"log_processed.": "raw", "kubernetes": map[interface{}]interface{}{"log_processed.pod": "api"}},
+		},
+	}
+
+	transform := newKeyPrefixRemover("log_processed.")
+	for _, tt := range tests {
+		if got := transform(tt.record); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTransformsAdd(t *testing.T) {
+	var calls []string
+	step := func(name string) recordTransform {
+		return func(record map[interface{}]interface{}) map[interface{}]interface{} {
+			calls = append(calls, name)
+			return record
+		}
+	}
+
+	chain := transforms(nil).add(step("first"), nil, step("second"))
+	chain.apply(map[interface{}]interface{}{})
+	if !reflect.DeepEqual(calls, []string{"first", "second"}) {
+		t.Errorf("expected the configured transforms to run in order, got %v", calls)
+	}
+}
+
+func TestKeyWords(t *testing.T) {
+	tests := []struct {
+		key  string
+		want []string
+	}{
+		{"user_id", []string{"user", "id"}},
+		{"userId", []string{"user", "Id"}},
+		{"UserID", []string{"User", "ID"}},
+		{"HTTPStatus", []string{"HTTP", "Status"}},
+		{"request-path 2", []string{"request", "path", "2"}},
+		{"log2Level", []string{"log2", "Level"}},
+		{"__", nil},
+	}
+
+	for _, tt := range tests {
+		if got := keyWords(tt.key); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("keyWords(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestKeyCaseTransform(t *testing.T) {
+	record := func() map[interface{}]interface{} {
+		return map[interface{}]interface{}{
+			"UserID":     "42",
+			"HTTPStatus": 200,
+			"request": map[interface{}]interface{}{
+				"Remote-Addr": "10.0.0.1",
+			},
+			"Items": []interface{}{map[interface{}]interface{}{"Item_Name": "tea"}},
+			1:       "int key",
+		}
+	}
+
+	tests := []struct {
+		keyCase string
+		want    map[interface{}]interface{}
+		wantErr bool
+	}{
+		{"lower", map[interface{}]interface{}{
+			"userid": "42", "httpstatus": 200,
+			"request": map[interface{}]interface{}{"remote-addr": "10.0.0.1"},
+			"items":   []interface{}{map[interface{}]interface{}{"item_name": "tea"}},
+			1:         "int key",
+		}, false},
+		{"snake", map[interface{}]interface{}{
+			"user_id": "42", "http_status": 200,
+			"request": map[interface{}]interface{}{"remote_addr": "10.0.0.1"},
+			"items":   []interface{}{map[interface{}]interface{}{"item_name": "tea"}},
+			1:         "int key",
+		}, false},
+		{"Camel", map[interface{}]interface{}{
+			"userId": "42", "httpStatus": 200,
+			"request": map[interface{}]interface{}{"remoteAddr": "10.0.0.1"},
+			"items":   []interface{}{map[interface{}]interface{}{"itemName": "tea"}},
+			1:         "int key",
+		}, false},
+		{"kebab", nil, true},
+	}
+
+	for _, tt := range tests {
+		transform, err := newKeyCaseTransform(tt.keyCase)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("newKeyCaseTransform(%q) error = %v, wantErr %v", tt.keyCase, err, tt.wantErr)
+		}
+		if transform == nil {
+			continue
+		}
+		if got := transform(record()); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.keyCase, got, tt.want)
+		}
+	}
+}
+
+func TestKeyCaseTransformCollisions(t *testing.T) {
+	transform, _ := newKeyCaseTransform("snake")
+
+	tests := []struct {
+		name   string
+		record map[interface{}]interface{}
+		want   map[interface{}]interface{}
+	}{
+		{"key in the case wins", map[interface{}]interface{}{"userId": "a", "user_id": "b", "UserID": "c"}, map[interface{}]interface{}{"user_id": "b"}},
+		{"first in alphabetical order", map[interface{}]interface{}{"userId": "a", "UserID": "c"}, map[interface{}]interface{}{"user_id": "c"}},
+	}
+
+	for _, tt := range tests {
+		// the map order is random, the result must not depend on it
+		for i := 0; i < 20; i++ {
+			record := make(map[interface{}]interface{}, len(tt.record))
+			for key, value := range tt.record {
+				record[key] = value
+			}
+			if got := transform(record); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("%s: got %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestNullValueTransform(t *testing.T) {
+	record := func() map[interface{}]interface{} {
+		return map[interface{}]interface{}{
+			"user":    nil,
+			"message": []byte("hello"),
+			"trace":   []byte{},
+			"path":    "",
+			"count":   0,
+			"request": map[interface{}]interface{}{"referer": nil, "method": "GET"},
+			"tags":    []interface{}{"a", nil, ""},
+		}
+	}
+
+	tests := []struct {
+		policy  string
+		want    map[interface{}]interface{}
+		wantNil bool
+		wantErr bool
+	}{
+		{"", nil, true, false},
+		{"keep", nil, true, false},
+		{"drop", map[interface{}]interface{}{
+			"message": []byte("hello"),
+			"count":   0,
+			"request": map[interface{}]interface{}{"method": "GET"},
+			"tags":    []interface{}{"a"},
+		}, false, false},
+		{"empty-string", map[interface{}]interface{}{
+			"user":    "",
+			"message": []byte("hello"),
+			"trace":   []byte{},
+			"path":    "",
+			"count":   0,
+			"request": map[interface{}]interface{}{"referer": "", "method": "GET"},
+			"tags":    []interface{}{"a", "", ""},
+		}, false, false},
+		{"omit", nil, true, true},
+	}
+
+	for _, tt := range tests {
+		transform, err := newNullValueTransform(tt.policy)
+		if (err != nil) != tt.wantErr || (transform == nil) != tt.wantNil {
+			t.Fatalf("newNullValueTransform(%q) = %v, wantNil %v, wantErr %v", tt.policy, err, tt.wantNil, tt.wantErr)
+		}
+		if transform == nil {
+			continue
+		}
+		if got := transform(record()); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.policy, got, tt.want)
+		}
+	}
+}
+
+func TestStringifyValues(t *testing.T) {
+	record := map[interface{}]interface{}{
+		"status":  int64(200),
+		"latency": 0.25,
+		"ratio":   float32(1.5),
+		"big":     1e21,
+		"nan":     math.NaN(),
+		"ok":      true,
+		"user":    nil,
+		"message": []byte("hello"),
+		"request": map[interface{}]interface{}{"port": uint16(443)},
+		"codes":   []interface{}{1, "a", false},
+	}
+
+	want := map[interface{}]interface{}{
+		"status":  "200",
+		"latency": "0.25",
+		"ratio":   "1.5",
+		"big":     "1e+21",
+		"nan":     "NaN",
+		"ok":      "true",
+		"user":    nil,
+		"message": []byte("hello"),
+		"request": map[interface{}]interface{}{"port": "443"},
+		"codes":   []interface{}{"1", "a", "false"},
+	}
+	if got := stringifyValues(record); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLinesJoiner(t *testing.T) {
+	record := func() map[interface{}]interface{} {
+		return map[interface{}]interface{}{
+			"stack":   []interface{}{[]byte("panic: boom"), "goroutine 1 [running]:", "main.main()"},
+			"lines":   []interface{}{"a", nil, 1},
+			"message": "not a list",
+		}
+	}
+
+	tests := []struct {
+		name      string
+		keys      string
+		separator string
+		want      map[interface{}]interface{}
+		wantNil   bool
+		wantErr   bool
+	}{
+		{"not set", "", "", nil, true, false},
+		{"separator without keys", "", " | ", nil, true, true},
+		{"newlines", "stack, message", "", map[interface{}]interface{}{
+			"stack":   "panic: boom\ngoroutine 1 [running]:\nmain.main()",
+			"lines":   []interface{}{"a", nil, 1},
+			"message": "not a list",
+		}, false, false},
+		{"custom separator", "lines", `\t`, map[interface{}]interface{}{
+			"stack":   []interface{}{[]byte("panic: boom"), "goroutine 1 [running]:", "main.main()"},
+			"lines":   "a\t\t1",
+			"message": "not a list",
+		}, false, false},
+	}
+
+	for _, tt := range tests {
+		transform, err := newLinesJoiner(tt.keys, tt.separator)
+		if (err != nil) != tt.wantErr || (transform == nil) != tt.wantNil {
+			t.Fatalf("%s: newLinesJoiner() = %v, wantNil %v, wantErr %v", tt.name, err, tt.wantNil, tt.wantErr)
+		}
+		if transform == nil {
+			continue
+		}
+		if got := transform(record()); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseFieldLengths(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    fieldLengths
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"1024", fieldLengths{"*": 1024}, false},
+		{"log:65536, stack:16384, *:1024", fieldLengths{"log": 65536, "stack": 16384, "*": 1024}, false},
+		{"0", nil, true},
+		{"log:big", nil, true},
+		{":10", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseFieldLengths(tt.value)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseFieldLengths(%q) = %v, %v, want %v, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFieldLengthsTransform(t *testing.T) {
+	record := func() map[interface{}]interface{} {
+		return map[interface{}]interface{}{
+			"log":     []byte("0123456789"),
+			"stack":   "0123456789",
+			"short":   "abc",
+			"unicode": "héllo",
+			"count":   1234567890,
+			"nested":  map[interface{}]interface{}{"text": "0123456789"},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		lengths fieldLengths
+		want    map[interface{}]interface{}
+	}{
+		{"every value", fieldLengths{"*": 4}, map[interface{}]interface{}{
+			"log":     "0123...[truncated 6 bytes]",
+			"stack":   "0123...[truncated 6 bytes]",
+			"short":   "abc",
+			"unicode": "hél...[truncated 2 bytes]",
+			"count":   1234567890,
+			"nested":  map[interface{}]interface{}{"text": "0123...[truncated 6 bytes]"},
+		}},
+		{"per key", fieldLengths{"stack": 8, "unicode": 2}, map[interface{}]interface{}{
+			"log":     []byte("0123456789"),
+			"stack":   "01234567...[truncated 2 bytes]",
+			"short":   "abc",
+			"unicode": "h...[truncated 5 bytes]",
+			"count":   1234567890,
+			"nested":  map[interface{}]interface{}{"text": "0123456789"},
+		}},
+	}
+
+	for _, tt := range tests {
+		if got := tt.lengths.transform()(record()); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if fieldLengths(nil).transform() != nil {
+		t.Error("expected no transform without MaxFieldLength")
+	}
+}
+
+func TestNormalizeUnicode(t *testing.T) {
+	// é as e and a combining acute accent, and as a single character
+	decomposed, composed := "café", "café"
+
+	record := map[interface{}]interface{}{
+		"name":   decomposed,
+		"bytes":  []byte(decomposed),
+		"nested": map[interface{}]interface{}{"list": []interface{}{decomposed, 1}},
+		"plain":  composed,
+	}
+	want := map[interface{}]interface{}{
+		"name":   composed,
+		"bytes":  []byte(composed),
+		"nested": map[interface{}]interface{}{"list": []interface{}{composed, 1}},
+		"plain":  composed,
+	}
+
+	if got := normalizeUnicode(record); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,46 @@
+package sqsout
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// the build of the plugin, set with
+//
+//	go build -ldflags "-X github.com/PayU/fluentBit-sqs-plugin/pkg/sqsout.version=v1.2.3 -X github.com/PayU/fluentBit-sqs-plugin/pkg/sqsout.commit=abc1234"
+//
+// without them the commit and date come from the vcs stamp of the go build,
+// when there is one
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// buildInfo returns the version, commit and build date of the plugin
+func buildInfo() (string, string, string) {
+	buildCommit, date := commit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && buildCommit == "":
+				buildCommit = setting.Value
+			case setting.Key == "vcs.time" && date == "":
+				date = setting.Value
+			}
+		}
+	}
+	if buildCommit == "" {
+		buildCommit = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+	return version, buildCommit, date
+}
+
+// versionString formats the build of the plugin for the logs
+func versionString() string {
+	v, c, d := buildInfo()
+	return fmt.Sprintf("version=%s commit=%s build_date=%s", v, c, d)
+}
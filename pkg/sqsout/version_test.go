@@ -0,0 +1,67 @@
+package sqsout
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestVersionString(t *testing.T) {
+	defer func(v, c, d string) { version, commit, buildDate = v, c, d }(version, commit, buildDate)
+
+	version, commit, buildDate = "v1.2.3", "abc1234", "2024-01-02T15:04:05Z"
+	if got, want := versionString(), "version=v1.2.3 commit=abc1234 build_date=2024-01-02T15:04:05Z"; got != want {
+		t.Errorf("versionString() = %q, want %q", got, want)
+	}
+
+	// test binaries carry no vcs stamp
+	version, commit, buildDate = "dev", "", ""
+	if got, want := versionString(), "version=dev commit=unknown build_date=unknown"; got != want {
+		t.Errorf("versionString() = %q, want %q", got, want)
+	}
+}
+
+func TestVersionAttribute(t *testing.T) {
+	defer func(v string) { version = v }(version)
+	version = "v1.2.3"
+
+	tests := []struct {
+		name               string
+		pluginTagAttribute string
+		versionAttribute   string
+		want               map[string]string
+	}{
+		{"no attributes", "", "", map[string]string{}},
+		{"version only", "", "plugin_version", map[string]string{"plugin_version": "v1.2.3"}},
+		{"tag and version", "tag", "plugin_version", map[string]string{"tag": "app.log", "plugin_version": "v1.2.3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetGlobals()
+			fake := &recordingSQS{}
+			sqsConf := &sqsConfig{
+				queueURL:           "https://sqs.us-east-1.amazonaws.com/123456789/test-queue",
+				mySQS:              fake,
+				batchSize:          1,
+				pluginTagAttribute: tt.pluginTagAttribute,
+				versionAttribute:   tt.versionAttribute,
+			}
+
+			if err := flushRecords(sqsConf, "app.log", sliceIterator(time.Now(), messageRecords(1)...)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			attributes := fake.batches[0].Entries[0].MessageAttributes
+			if len(attributes) != len(tt.want) {
+				t.Fatalf("expected %d attributes, got %v", len(tt.want), attributes)
+			}
+			for name, value := range tt.want {
+				if got, ok := attributes[name]; !ok || aws.ToString(got.StringValue) != value {
+					t.Errorf("expected attribute %s=%s, got %v", name, value, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,557 @@
+package sqsout
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// the write ahead log stores the batches of the disk buffer in segment
+// files. an entry is
+//
+//	length uint32 | crc32c uint32 | type byte | payload
+//
+// in little endian, the crc covering the type and the payload. a batch entry
+// holds the sequence number of the batch and its entries as JSON, an ack
+// entry the sequence number of a delivered batch. the entries are only
+// appended, a partial write of a crash fails its crc and the rest of its
+// segment is skipped when the segment is read
+
+const (
+	// walSegmentBytes is the size after which a new segment is started
+	walSegmentBytes = 8 << 20
+	walHeaderBytes  = 9
+	// walMaxEntryBytes bounds the length of an entry, a larger one is a
+	// corrupt length
+	walMaxEntryBytes = 64 << 20
+)
+
+type walEntryType byte
+
+const (
+	walBatchEntry walEntryType = 1
+	walAckEntry   walEntryType = 2
+)
+
+var walCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	// errCorruptWALEntry is a partial or corrupt entry
+	errCorruptWALEntry = errors.New("corrupt write ahead log entry")
+	// errDiskBufferFull is a batch which doesn't fit in the size cap of the
+	// log
+	errDiskBufferFull = errors.New("the batch doesn't fit in BufferMaxSize")
+)
+
+func walSegmentName(id uint64) string {
+	return fmt.Sprintf("wal-%016d.log", id)
+}
+
+// walSegments returns the ids of the segments of dir in order
+func walSegments(dir string) ([]uint64, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint64
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasPrefix(name, "wal-") || !strings.HasSuffix(name, ".log") {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, "wal-"), ".log"), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func encodeWALEntry(entryType walEntryType, payload []byte) []byte {
+	entry := make([]byte, walHeaderBytes+len(payload))
+	binary.LittleEndian.PutUint32(entry[0:4], uint32(len(payload)))
+	entry[8] = byte(entryType)
+	copy(entry[walHeaderBytes:], payload)
+	binary.LittleEndian.PutUint32(entry[4:8], crc32.Checksum(entry[8:], walCRCTable))
+	return entry
+}
+
+// readWALEntry reads the next entry, io.EOF at the end of a segment
+func readWALEntry(r io.Reader) (walEntryType, []byte, error) {
+	var header [walHeaderBytes]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			return 0, nil, io.EOF
+		}
+		return 0, nil, errCorruptWALEntry
+	}
+
+	length := binary.LittleEndian.Uint32(header[0:4])
+	if length > walMaxEntryBytes {
+		return 0, nil, errCorruptWALEntry
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, errCorruptWALEntry
+	}
+
+	crc := crc32.Update(crc32.Checksum(header[8:9], walCRCTable), walCRCTable, payload)
+	if crc != binary.LittleEndian.Uint32(header[4:8]) {
+		return 0, nil, errCorruptWALEntry
+	}
+	return walEntryType(header[8]), payload, nil
+}
+
+// scanWALSegment calls fn with the entries of a segment and their offset. the
+// entries after a corrupt one can't be found again, the rest of the segment
+// is skipped and its size returned
+func scanWALSegment(path string, fn func(offset int64, entryType walEntryType, payload []byte)) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		entryType, payload, err := readWALEntry(r)
+		if err == io.EOF {
+			return 0, nil
+		}
+		if err != nil {
+			return info.Size() - offset, nil
+		}
+		fn(offset, entryType, payload)
+		offset += int64(walHeaderBytes + len(payload))
+	}
+}
+
+// walPosition is where a batch entry is in the log, its size, its number of
+// records and when it was written
+type walPosition struct {
+	segment uint64
+	offset  int64
+	bytes   int64
+	records int
+	written time.Time
+}
+
+// walBatchRecords counts the records of the payload of a batch entry, 0 when
+// it can't be decoded
+func walBatchRecords(payload []byte) int {
+	var records []json.RawMessage
+	if len(payload) < 8 || json.Unmarshal(payload[8:], &records) != nil {
+		return 0
+	}
+	return len(records)
+}
+
+// writeAheadLog appends the batches and their acks to the segments of a
+// directory. a segment is removed once its batches and the ones of the older
+// segments are acknowledged, the acks are never in a segment older than
+// their batch
+type writeAheadLog struct {
+	dir string
+
+	mu       sync.Mutex
+	file     *os.File
+	segment  uint64
+	size     int64
+	segments []uint64
+	nextSeq  uint64
+	// pending are the batches not acknowledged yet
+	pending        map[uint64]walPosition
+	segmentPending map[uint64]int
+	segmentBytes   map[uint64]int64
+
+	// maxBytes and maxAge are the limits of the log, 0 is unlimited. the
+	// batches evicted to stay in them are passed to onEvict with their
+	// records and bytes
+	maxBytes int64
+	maxAge   time.Duration
+	onEvict  func(seqs []uint64, records int, bytes int64)
+
+	// syncBatches syncs every batch once written, the acks are not synced:
+	// a lost ack only sends a batch again
+	syncBatches bool
+}
+
+// openWriteAheadLog recovers the segments already in dir and starts a new
+// segment after them
+func openWriteAheadLog(dir string) (*writeAheadLog, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create the buffer directory %s: %v", dir, err)
+	}
+	existing, err := walSegments(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the buffer directory %s: %v", dir, err)
+	}
+
+	w := &writeAheadLog{
+		dir:            dir,
+		pending:        make(map[uint64]walPosition),
+		segmentPending: make(map[uint64]int),
+		segmentBytes:   make(map[uint64]int64),
+	}
+
+	w.recover(existing)
+
+	var last uint64
+	if len(existing) > 0 {
+		last = existing[len(existing)-1]
+	}
+	if err := w.rotateLocked(last + 1); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// recover reads the segments of a previous run, their batches without an ack
+// are pending again, written when their segment was last modified. a segment
+// which can't be read is left in place
+func (w *writeAheadLog) recover(ids []uint64) {
+	for _, id := range ids {
+		name := walSegmentName(id)
+		info, err := os.Stat(filepath.Join(w.dir, name))
+		if err != nil {
+			writeErrorLog(fmt.Errorf("failed to recover the buffer segment %s: %v", name, err))
+			continue
+		}
+
+		skipped, err := scanWALSegment(filepath.Join(w.dir, name), func(offset int64, entryType walEntryType, payload []byte) {
+			if len(payload) < 8 {
+				return
+			}
+			seq := binary.LittleEndian.Uint64(payload)
+			if seq > w.nextSeq {
+				w.nextSeq = seq
+			}
+
+			switch entryType {
+			case walBatchEntry:
+				w.pending[seq] = walPosition{segment: id, offset: offset, bytes: int64(walHeaderBytes + len(payload)), records: walBatchRecords(payload), written: info.ModTime()}
+				w.segmentPending[id]++
+			case walAckEntry:
+				if position, ok := w.pending[seq]; ok {
+					delete(w.pending, seq)
+					w.segmentPending[position.segment]--
+				}
+			}
+		})
+		if err != nil {
+			writeErrorLog(fmt.Errorf("failed to recover the buffer segment %s: %v", name, err))
+			continue
+		}
+		if skipped > 0 {
+			writeWarnLog(fmt.Sprintf("skipped the last %d bytes of the buffer segment %s after a partial or corrupt entry", skipped, name))
+		}
+		w.segments = append(w.segments, id)
+		w.segmentBytes[id] = info.Size()
+	}
+}
+
+// numberAfter numbers the next batches after seq
+func (w *writeAheadLog) numberAfter(seq uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.nextSeq = max(w.nextSeq, seq)
+}
+
+// pendingBatches returns the batches not acknowledged yet, oldest first
+func (w *writeAheadLog) pendingBatches() []uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	seqs := make([]uint64, 0, len(w.pending))
+	for seq := range w.pending {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs
+}
+
+// rotateLocked closes the current segment and starts the segment id
+func (w *writeAheadLog) rotateLocked(id uint64) error {
+	if w.file != nil {
+		_ = w.file.Sync()
+		_ = w.file.Close()
+		w.file = nil
+	}
+
+	file, err := os.OpenFile(filepath.Join(w.dir, walSegmentName(id)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create the buffer segment: %v", err)
+	}
+	w.file, w.segment, w.size = file, id, 0
+	w.segments = append(w.segments, id)
+	w.segmentBytes[id] = 0
+	w.compactLocked()
+	return nil
+}
+
+// appendLocked appends an entry to the current segment. after a failed
+// write the log goes on in a new segment, the entries after the partial one
+// would be skipped with it
+func (w *writeAheadLog) appendLocked(entryType walEntryType, payload []byte) (walPosition, error) {
+	if w.file == nil {
+		return walPosition{}, errors.New("the disk buffer is closed")
+	}
+
+	entry := encodeWALEntry(entryType, payload)
+	if w.size > 0 && w.size+int64(len(entry)) > walSegmentBytes {
+		if err := w.rotateLocked(w.segment + 1); err != nil {
+			return walPosition{}, err
+		}
+	}
+
+	position := walPosition{segment: w.segment, offset: w.size, bytes: int64(len(entry)), written: time.Now()}
+	n, err := w.file.Write(entry)
+	w.size += int64(n)
+	w.segmentBytes[w.segment] += int64(n)
+	if err != nil {
+		if rotateErr := w.rotateLocked(w.segment + 1); rotateErr != nil {
+			writeErrorLog(rotateErr)
+		}
+		return walPosition{}, fmt.Errorf("failed to write to the buffer segment: %v", err)
+	}
+	return position, nil
+}
+
+// appendBatch writes a batch ahead of its send and returns its sequence
+// number. the oldest batches are evicted when the batch doesn't fit in
+// maxBytes
+func (w *writeAheadLog) appendBatch(records []*types.SendMessageBatchRequestEntry) (uint64, error) {
+	body, err := json.Marshal(records)
+	if err != nil {
+		return 0, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.maxBytes > 0 {
+		entryBytes := int64(walHeaderBytes + 8 + len(body))
+		if entryBytes > w.maxBytes {
+			return 0, errDiskBufferFull
+		}
+		if w.sizeLocked()+entryBytes > w.maxBytes {
+			w.evictLocked(time.Now(), entryBytes)
+		}
+	}
+
+	w.nextSeq++
+	seq := w.nextSeq
+	payload := make([]byte, 8+len(body))
+	binary.LittleEndian.PutUint64(payload, seq)
+	copy(payload[8:], body)
+
+	position, err := w.appendLocked(walBatchEntry, payload)
+	if err != nil {
+		return 0, err
+	}
+	if w.syncBatches {
+		if err := w.file.Sync(); err != nil {
+			writeErrorLog(fmt.Errorf("failed to sync the buffer segment: %v", err))
+		}
+	}
+	position.records = len(records)
+	w.pending[seq] = position
+	w.segmentPending[position.segment]++
+	return seq, nil
+}
+
+// ack marks a batch as delivered
+func (w *writeAheadLog) ack(seq uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.ackLocked(seq)
+}
+
+func (w *writeAheadLog) ackLocked(seq uint64) {
+	position, ok := w.pending[seq]
+	if !ok {
+		return
+	}
+
+	var payload [8]byte
+	binary.LittleEndian.PutUint64(payload[:], seq)
+	if _, err := w.appendLocked(walAckEntry, payload[:]); err != nil {
+		writeErrorLog(fmt.Errorf("failed to acknowledge a buffered batch, it may be sent again after a restart: %v", err))
+	}
+
+	delete(w.pending, seq)
+	w.segmentPending[position.segment]--
+	w.compactLocked()
+}
+
+// compactLocked removes the oldest segments while all their batches are
+// acknowledged
+func (w *writeAheadLog) compactLocked() {
+	for len(w.segments) > 0 && w.segments[0] != w.segment && w.segmentPending[w.segments[0]] == 0 {
+		oldest := w.segments[0]
+		if err := os.Remove(filepath.Join(w.dir, walSegmentName(oldest))); err != nil && !os.IsNotExist(err) {
+			writeErrorLog(fmt.Errorf("failed to remove the buffer segment %s: %v", walSegmentName(oldest), err))
+			return
+		}
+		delete(w.segmentPending, oldest)
+		delete(w.segmentBytes, oldest)
+		w.segments = w.segments[1:]
+	}
+}
+
+// sizeLocked returns the size of the segments
+func (w *writeAheadLog) sizeLocked() int64 {
+	var size int64
+	for _, bytes := range w.segmentBytes {
+		size += bytes
+	}
+	return size
+}
+
+// evict drops the batches over the limits of the log
+func (w *writeAheadLog) evict(now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.evictLocked(now, 0)
+}
+
+// evictLocked drops the pending batches older than maxAge, then the ones of
+// the oldest segments until reserve more bytes fit in maxBytes
+func (w *writeAheadLog) evictLocked(now time.Time, reserve int64) {
+	var evicted []uint64
+	var evictedRecords int
+	var evictedBytes int64
+	drop := func(seq uint64) {
+		evicted = append(evicted, seq)
+		evictedRecords += w.pending[seq].records
+		evictedBytes += w.pending[seq].bytes
+		w.ackLocked(seq)
+	}
+
+	if w.maxAge > 0 {
+		for seq, position := range w.pending {
+			if now.Sub(position.written) > w.maxAge {
+				drop(seq)
+			}
+		}
+	}
+
+	for w.maxBytes > 0 && len(w.segments) > 0 && w.sizeLocked()+reserve > w.maxBytes {
+		oldest := w.segments[0]
+		if oldest == w.segment {
+			// the current segment is evicted once it is rotated
+			if w.size == 0 {
+				break
+			}
+			if err := w.rotateLocked(w.segment + 1); err != nil {
+				writeErrorLog(err)
+				break
+			}
+			continue
+		}
+
+		for seq, position := range w.pending {
+			if position.segment == oldest {
+				drop(seq)
+			}
+		}
+		w.compactLocked()
+		if len(w.segments) > 0 && w.segments[0] == oldest {
+			// it couldn't be removed
+			break
+		}
+	}
+
+	if len(evicted) > 0 && w.onEvict != nil {
+		sort.Slice(evicted, func(i, j int) bool { return evicted[i] < evicted[j] })
+		w.onEvict(evicted, evictedRecords, evictedBytes)
+	}
+}
+
+// batchRecords returns the number of records of a pending batch, 0 when it
+// is unknown
+func (w *writeAheadLog) batchRecords(seq uint64) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.pending[seq].records
+}
+
+// readBatch reads the entries of a pending batch back
+func (w *writeAheadLog) readBatch(seq uint64) ([]*types.SendMessageBatchRequestEntry, error) {
+	w.mu.Lock()
+	position, ok := w.pending[seq]
+	w.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("batch %d is not in the disk buffer", seq)
+	}
+
+	f, err := os.Open(filepath.Join(w.dir, walSegmentName(position.segment)))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(position.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	entryType, payload, err := readWALEntry(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("batch %d of %s: %v", seq, walSegmentName(position.segment), err)
+	}
+	if entryType != walBatchEntry || len(payload) < 8 || binary.LittleEndian.Uint64(payload) != seq {
+		return nil, fmt.Errorf("batch %d of %s: %v", seq, walSegmentName(position.segment), errCorruptWALEntry)
+	}
+
+	var records []*types.SendMessageBatchRequestEntry
+	if err := json.Unmarshal(payload[8:], &records); err != nil {
+		return nil, fmt.Errorf("batch %d of %s: %v", seq, walSegmentName(position.segment), err)
+	}
+	return records, nil
+}
+
+// sync syncs the current segment to the disk
+func (w *writeAheadLog) sync() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return
+	}
+	if err := w.file.Sync(); err != nil {
+		writeErrorLog(fmt.Errorf("failed to sync the buffer segment: %v", err))
+	}
+}
+
+// close syncs and closes the current segment
+func (w *writeAheadLog) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file != nil {
+		_ = w.file.Sync()
+		_ = w.file.Close()
+		w.file = nil
+	}
+}
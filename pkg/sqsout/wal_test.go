@@ -0,0 +1,281 @@
+package sqsout
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// walEntryTypes returns the types of the entries of a segment and the bytes
+// skipped after a corrupt entry
+func walEntryTypes(t *testing.T, path string) ([]walEntryType, int64) {
+	t.Helper()
+	var entryTypes []walEntryType
+	skipped, err := scanWALSegment(path, func(offset int64, entryType walEntryType, payload []byte) {
+		entryTypes = append(entryTypes, entryType)
+	})
+	if err != nil {
+		t.Fatalf("failed to scan %s: %v", path, err)
+	}
+	return entryTypes, skipped
+}
+
+func TestWriteAheadLog(t *testing.T) {
+	dir := t.TempDir()
+	// a segment of a previous run without pending batches
+	if err := os.WriteFile(filepath.Join(dir, walSegmentName(4)), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := openWriteAheadLog(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer w.close()
+
+	if w.segment != 5 {
+		t.Errorf("expected the log to go on after the existing segments, got segment %d", w.segment)
+	}
+
+	first, err := w.appendBatch(testBatch(2))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, err := w.appendBatch(testBatch(3))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w.ack(first)
+	w.ack(first)
+
+	records, err := w.readBatch(second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 3 || aws.ToString(records[0].MessageBody) != `{"message":"test"}` {
+		t.Errorf("expected the batch to be read back, got %+v", records)
+	}
+	if _, err := w.readBatch(first); err == nil {
+		t.Error("expected an acknowledged batch not to be read")
+	}
+
+	entryTypes, skipped := walEntryTypes(t, filepath.Join(dir, walSegmentName(5)))
+	if want := []walEntryType{walBatchEntry, walBatchEntry, walAckEntry}; !reflect.DeepEqual(entryTypes, want) || skipped != 0 {
+		t.Errorf("expected entries %v, got %v with %d bytes skipped", want, entryTypes, skipped)
+	}
+	if _, err := os.Stat(filepath.Join(dir, walSegmentName(4))); !os.IsNotExist(err) {
+		t.Errorf("expected the delivered previous segment to be removed, got %v", err)
+	}
+}
+
+func TestWriteAheadLogCompaction(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWriteAheadLog(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer w.close()
+
+	first, _ := w.appendBatch(testBatch(1))
+	w.mu.Lock()
+	_ = w.rotateLocked(2)
+	w.mu.Unlock()
+	second, _ := w.appendBatch(testBatch(1))
+	w.mu.Lock()
+	_ = w.rotateLocked(3)
+	w.mu.Unlock()
+
+	// segment 2 holds the ack of the batch of segment 1, it is kept as long
+	// as segment 1 is
+	w.ack(second)
+	if segments, _ := walSegments(dir); !reflect.DeepEqual(segments, []uint64{1, 2, 3}) {
+		t.Errorf("expected the segments after a pending one to be kept, got %v", segments)
+	}
+
+	w.ack(first)
+	if segments, _ := walSegments(dir); !reflect.DeepEqual(segments, []uint64{3}) {
+		t.Errorf("expected the acknowledged segments to be removed, got %v", segments)
+	}
+}
+
+func TestScanWALSegmentCorruption(t *testing.T) {
+	first := encodeWALEntry(walBatchEntry, []byte("first batch"))
+	second := encodeWALEntry(walBatchEntry, []byte("second batch"))
+	third := encodeWALEntry(walAckEntry, []byte("ack"))
+
+	flipped := append([]byte(nil), second...)
+	flipped[walHeaderBytes+2] ^= 0xff
+
+	tests := []struct {
+		name        string
+		content     [][]byte
+		wantEntries int
+		wantSkipped int
+	}{
+		{"intact", [][]byte{first, second, third}, 3, 0},
+		{"partial last entry", [][]byte{first, second, third[:len(third)-1]}, 2, len(third) - 1},
+		{"partial header", [][]byte{first, second[:4]}, 1, 4},
+		{"corrupt payload", [][]byte{first, flipped, third}, 1, len(flipped) + len(third)},
+		{"empty", nil, 0, 0},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), walSegmentName(1))
+		var content []byte
+		for _, part := range tt.content {
+			content = append(content, part...)
+		}
+		if err := os.WriteFile(path, content, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		entryTypes, skipped := walEntryTypes(t, path)
+		if len(entryTypes) != tt.wantEntries || skipped != int64(tt.wantSkipped) {
+			t.Errorf("%s: got %d entries and %d bytes skipped, want %d and %d", tt.name, len(entryTypes), skipped, tt.wantEntries, tt.wantSkipped)
+		}
+	}
+}
+
+func TestWriteAheadLogRecovery(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWriteAheadLog(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	first, _ := w.appendBatch(testBatch(1))
+	second, _ := w.appendBatch(testBatch(2))
+	third, _ := w.appendBatch(testBatch(3))
+	w.ack(second)
+	w.close()
+
+	// a crash in the middle of an entry
+	f, err := os.OpenFile(filepath.Join(dir, walSegmentName(1)), os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write(encodeWALEntry(walAckEntry, []byte{byte(first), 0, 0, 0, 0, 0, 0, 0})[:5])
+	f.Close()
+
+	var recovered *writeAheadLog
+	output := captureStdout(func() {
+		recovered, err = openWriteAheadLog(dir)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer recovered.close()
+
+	if got := recovered.pendingBatches(); !reflect.DeepEqual(got, []uint64{first, third}) {
+		t.Errorf("expected the batches without an ack to be pending, got %v", got)
+	}
+	if !strings.Contains(output, "skipped the last 5 bytes") {
+		t.Errorf("expected the partial entry to be reported, got %q", output)
+	}
+	records, err := recovered.readBatch(third)
+	if err != nil || len(records) != 3 {
+		t.Errorf("expected the recovered batch to be read back, got %d records, %v", len(records), err)
+	}
+
+	next, _ := recovered.appendBatch(testBatch(1))
+	if next != third+1 {
+		t.Errorf("expected the sequence numbers to go on after the recovered ones, got %d", next)
+	}
+
+	recovered.ack(first)
+	recovered.ack(third)
+	recovered.ack(next)
+	if segments, _ := walSegments(dir); !reflect.DeepEqual(segments, []uint64{2}) {
+		t.Errorf("expected the recovered segment to be removed once delivered, got %v", segments)
+	}
+}
+
+// walBatchBytes returns the size of the entry of a test batch
+func walBatchBytes(t *testing.T, size int) int64 {
+	t.Helper()
+	body, err := json.Marshal(testBatch(size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int64(walHeaderBytes + 8 + len(body))
+}
+
+func TestWriteAheadLogEviction(t *testing.T) {
+	var evicted []uint64
+	var evictedRecords int
+	var evictedBytes int64
+	open := func(t *testing.T, maxBytes int64, maxAge time.Duration) *writeAheadLog {
+		t.Helper()
+		evicted, evictedRecords, evictedBytes = nil, 0, 0
+		w, err := openWriteAheadLog(t.TempDir())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		t.Cleanup(w.close)
+		w.maxBytes, w.maxAge = maxBytes, maxAge
+		w.onEvict = func(seqs []uint64, records int, bytes int64) {
+			evicted = append(evicted, seqs...)
+			evictedRecords += records
+			evictedBytes += bytes
+		}
+		return w
+	}
+
+	t.Run("size cap evicts the oldest segments", func(t *testing.T) {
+		batchBytes := walBatchBytes(t, 1)
+		w := open(t, 3*batchBytes, 0)
+
+		first, _ := w.appendBatch(testBatch(1))
+		second, _ := w.appendBatch(testBatch(1))
+		third, _ := w.appendBatch(testBatch(1))
+		fourth, err := w.appendBatch(testBatch(1))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !reflect.DeepEqual(evicted, []uint64{first, second, third}) || evictedRecords != 3 || evictedBytes != 3*batchBytes {
+			t.Errorf("expected the batches of the full segment to be evicted, got %v (%d records, %d bytes)", evicted, evictedRecords, evictedBytes)
+		}
+		if got := w.pendingBatches(); !reflect.DeepEqual(got, []uint64{fourth}) {
+			t.Errorf("expected the new batch to be kept, got %v", got)
+		}
+		w.mu.Lock()
+		size := w.sizeLocked()
+		w.mu.Unlock()
+		if size > w.maxBytes {
+			t.Errorf("expected the log to stay below %d bytes, got %d", w.maxBytes, size)
+		}
+
+		if _, err := w.appendBatch(testBatch(10)); err != errDiskBufferFull {
+			t.Errorf("expected a batch larger than the cap to be refused, got %v", err)
+		}
+		if len(evicted) != 3 {
+			t.Errorf("expected nothing evicted for a batch which can't fit, got %v", evicted)
+		}
+	})
+
+	t.Run("max age evicts the old batches", func(t *testing.T) {
+		w := open(t, 0, time.Hour)
+
+		old, _ := w.appendBatch(testBatch(1))
+		w.mu.Lock()
+		position := w.pending[old]
+		position.written = position.written.Add(-2 * time.Hour)
+		w.pending[old] = position
+		w.mu.Unlock()
+		recent, _ := w.appendBatch(testBatch(1))
+
+		w.evict(time.Now())
+		if !reflect.DeepEqual(evicted, []uint64{old}) {
+			t.Errorf("expected the old batch to be evicted, got %v", evicted)
+		}
+		if got := w.pendingBatches(); !reflect.DeepEqual(got, []uint64{recent}) {
+			t.Errorf("expected the recent batch to be kept, got %v", got)
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-xray-sdk-go/instrumentation/awsv2"
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+// xraySegmentName is the name of the segments of the plugin in X-Ray
+const xraySegmentName = "fluent-bit-sqs"
+
+// xraySQS implements sqsClient interface and sends every batch in a segment
+// of its own. the middleware installed by the X-Ray instrumentor records the
+// SendMessageBatch call, retries of the SDK included, as a subsegment of it
+type xraySQS struct {
+	client sqsClient
+}
+
+// parseXrayTracing parses XrayTracing, XrayDaemonAddress is only valid with
+// the tracing enabled
+func parseXrayTracing(enabledString, daemonAddress string) (bool, error) {
+	switch strings.ToLower(enabledString) {
+	case "", "false", "off":
+		if daemonAddress != "" {
+			return false, errors.New("XrayDaemonAddress requires XrayTracing to be enabled")
+		}
+		return false, nil
+	case "true", "on":
+		return true, nil
+	default:
+		return false, fmt.Errorf("XrayTracing should be true or false. got %q", enabledString)
+	}
+}
+
+// configureXray sets the daemon the segments are sent to. without an address
+// the SDK uses AWS_XRAY_DAEMON_ADDRESS or 127.0.0.1:2000
+func configureXray(daemonAddress string) error {
+	if daemonAddress == "" {
+		return nil
+	}
+	if err := xray.Configure(xray.Config{DaemonAddr: daemonAddress}); err != nil {
+		return fmt.Errorf("invalid XrayDaemonAddress %s: %v", daemonAddress, err)
+	}
+	return nil
+}
+
+// newXraySQS creates an SQS client instrumented with X-Ray
+func newXraySQS(awsConfig aws.Config, optFns ...func(*sqs.Options)) *xraySQS {
+	optFns = append(optFns, func(o *sqs.Options) {
+		awsv2.AWSV2Instrumentor(&o.APIOptions)
+	})
+	return &xraySQS{client: sqs.NewFromConfig(awsConfig, optFns...)}
+}
+
+func (c *xraySQS) SendMessageBatch(ctx context.Context, input *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	ctx, segment := xray.BeginSegment(ctx, xraySegmentName)
+	output, err := c.client.SendMessageBatch(ctx, input, optFns...)
+	segment.Close(err)
+	return output, err
+}
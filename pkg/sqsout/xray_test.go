@@ -0,0 +1,90 @@
+package sqsout
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+// segmentSQS implements sqsClient interface and keeps the X-Ray
+// segment found in the context of every call
+type segmentSQS struct {
+	segments []*xray.Segment
+	err      error
+}
+
+func (f *segmentSQS) SendMessageBatch(ctx context.Context, input *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	f.segments = append(f.segments, xray.GetSegment(ctx))
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &sqs.SendMessageBatchOutput{}, nil
+}
+
+// memoryXrayEmitter implements xray.Emitter interface and keeps the emitted
+// segments instead of sending them to the daemon
+type memoryXrayEmitter struct {
+	mu       sync.Mutex
+	segments []*xray.Segment
+}
+
+func (e *memoryXrayEmitter) Emit(segment *xray.Segment) {
+	e.mu.Lock()
+	e.segments = append(e.segments, segment)
+	e.mu.Unlock()
+}
+
+func (e *memoryXrayEmitter) RefreshEmitterWithAddress(*net.UDPAddr) {}
+
+func TestParseXrayTracing(t *testing.T) {
+	tests := []struct {
+		enabled       string
+		daemonAddress string
+		want          bool
+		wantErr       bool
+	}{
+		{"", "", false, false},
+		{"false", "", false, false},
+		{"On", "", true, false},
+		{"true", "127.0.0.1:3000", true, false},
+		{"", "127.0.0.1:3000", false, true},
+		{"yes", "", false, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseXrayTracing(tt.enabled, tt.daemonAddress)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseXrayTracing(%q, %q) = %v, %v, want %v, wantErr %v", tt.enabled, tt.daemonAddress, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestXraySQS(t *testing.T) {
+	emitter := &memoryXrayEmitter{}
+	if err := xray.Configure(xray.Config{Emitter: emitter}); err != nil {
+		t.Fatalf("failed to configure X-Ray: %v", err)
+	}
+
+	fake := &segmentSQS{err: errors.New("timeout")}
+	client := &xraySQS{client: fake}
+
+	if _, err := client.SendMessageBatch(context.Background(), &sqs.SendMessageBatchInput{QueueUrl: aws.String("https://sqs.us-east-1.amazonaws.com/123456789/test-queue")}); err == nil {
+		t.Fatal("expected the error of the client")
+	}
+
+	if len(fake.segments) != 1 || fake.segments[0] == nil || fake.segments[0].Name != xraySegmentName {
+		t.Fatalf("expected the call to run in a %s segment, got %v", xraySegmentName, fake.segments)
+	}
+	segment := fake.segments[0]
+	segment.RLock()
+	defer segment.RUnlock()
+	if segment.InProgress || !segment.Fault {
+		t.Errorf("expected a closed segment with a fault, got in progress=%v fault=%v", segment.InProgress, segment.Fault)
+	}
+}
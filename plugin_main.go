@@ -0,0 +1,8 @@
+//go:build !sqscli
+
+package main
+
+// main is required by -buildmode=c-shared, fluent bit calls the exported
+// functions of the plugin instead
+func main() {
+}